@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joamaki/gosvcd/pkg/ctlproto"
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/tlsconfig"
+)
+
+// gosvcdctl manages a running daemon over its control socket.
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: gosvcdctl [-socket path | -addr host:port -tls-cert file -tls-key file
+                 -tls-ca file [-tls-policy policy]] <command> [args]
+
+commands:
+  list                   List the services in dependency order
+  graph                  Show the dependencies of each service
+  emit <type> [<data>]   Emit an event. The data is parsed as JSON, or
+                         used as a string if it is not valid JSON.
+  restart <id>           Restart a service
+  pause                  Pause the dispatch of events
+  resume                 Resume the dispatch of events
+  pause-service <id> [drop]
+                         Pause the delivery of events to a service,
+                         holding them, or dropping them with 'drop'
+  resume-service <id>    Resume the delivery of events to a service
+  start-group <name>     Start the services of a group
+  stop-group <name>      Stop the services of a group
+  restart-group <name>   Restart the services of a group
+  checkpoint <name>      Write the checkpoint of the daemon to a file in
+                         its checkpoint directory
+  shutdown               Shut down the daemon
+`)
+	os.Exit(2)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "gosvcdctl: %s\n", err)
+	os.Exit(1)
+}
+
+func main() {
+	socket := flag.String("socket", gosvcd.DefaultControlSocket, "path to the control socket")
+	addr := flag.String("addr", "", "TCP address of the control protocol served with TLS")
+	var opts tlsconfig.Options
+	flag.StringVar(&opts.CertFile, "tls-cert", "", "client certificate file")
+	flag.StringVar(&opts.KeyFile, "tls-key", "", "client key file")
+	flag.StringVar(&opts.CAFile, "tls-ca", "", "CA certificates file verifying the daemon")
+	flag.StringVar(&opts.ServerName, "tls-server-name", "", "name in the certificate of the daemon")
+	policy := flag.String("tls-policy", tlsconfig.Intermediate.String(), "cipher policy: modern, intermediate or compatible")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+	}
+	args := flag.Args()
+
+	var client *ctlproto.Client
+	var err error
+	if *addr != "" {
+		if opts.Policy, err = tlsconfig.ParseCipherPolicy(*policy); err != nil {
+			fatal(err)
+		}
+		client, err = ctlproto.DialTLSOptions(*addr, opts)
+	} else {
+		client, err = ctlproto.Dial(*socket)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	defer client.Close()
+
+	switch args[0] {
+	case "list":
+		svcs, err := client.Services()
+		if err != nil {
+			fatal(err)
+		}
+		for _, svc := range svcs {
+			if len(svc.Degraded) > 0 {
+				fmt.Printf("%d\t%s\tdegraded %v\n", svc.ID, svc.Name, svc.Degraded)
+				continue
+			}
+			fmt.Printf("%d\t%s\n", svc.ID, svc.Name)
+		}
+
+	case "graph":
+		svcs, err := client.Services()
+		if err != nil {
+			fatal(err)
+		}
+		for _, svc := range svcs {
+			deps := make([]string, len(svc.Dependencies))
+			for i, dep := range svc.Dependencies {
+				deps[i] = strconv.FormatInt(dep, 10)
+			}
+			fmt.Printf("%d -> [%s]\n", svc.ID, strings.Join(deps, " "))
+		}
+
+	case "emit":
+		if len(args) < 2 {
+			usage()
+		}
+		var data interface{}
+		if len(args) > 2 {
+			raw := strings.Join(args[2:], " ")
+			if err := json.Unmarshal([]byte(raw), &data); err != nil {
+				data = raw
+			}
+		}
+		if err := client.Emit(args[1], data); err != nil {
+			fatal(err)
+		}
+
+	case "restart":
+		if len(args) != 2 {
+			usage()
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fatal(fmt.Errorf("invalid service id %q", args[1]))
+		}
+		if err := client.Restart(id); err != nil {
+			fatal(err)
+		}
+
+	case "pause":
+		if err := client.Pause(); err != nil {
+			fatal(err)
+		}
+
+	case "resume":
+		if err := client.Resume(); err != nil {
+			fatal(err)
+		}
+
+	case "pause-service":
+		if len(args) < 2 || len(args) > 3 || (len(args) == 3 && args[2] != "drop") {
+			usage()
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fatal(fmt.Errorf("invalid service id %q", args[1]))
+		}
+		if err := client.PauseService(id, len(args) == 3); err != nil {
+			fatal(err)
+		}
+
+	case "resume-service":
+		if len(args) != 2 {
+			usage()
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fatal(fmt.Errorf("invalid service id %q", args[1]))
+		}
+		if err := client.ResumeService(id); err != nil {
+			fatal(err)
+		}
+
+	case "start-group", "stop-group", "restart-group":
+		if len(args) != 2 {
+			usage()
+		}
+		var err error
+		switch args[0] {
+		case "start-group":
+			err = client.StartGroup(args[1])
+		case "stop-group":
+			err = client.StopGroup(args[1])
+		default:
+			err = client.RestartGroup(args[1])
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+	case "checkpoint":
+		if len(args) != 2 {
+			usage()
+		}
+		if err := client.Checkpoint(args[1]); err != nil {
+			fatal(err)
+		}
+
+	case "shutdown":
+		if err := client.Shutdown(); err != nil {
+			fatal(err)
+		}
+
+	default:
+		usage()
+	}
+}
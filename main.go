@@ -1,9 +1,13 @@
 package main
 
 import (
+	"flag"
+
 	"github.com/joamaki/gosvcd/pkg/gosvcd"
 )
 
 func main() {
-	gosvcd.RunExample()
+	checkConfig := flag.Bool("check-config", false, "validate the configuration and exit")
+	flag.Parse()
+	gosvcd.RunExample(*checkConfig)
 }
@@ -0,0 +1,661 @@
+// Package cluster federates gosvcd daemons into one logical service mesh.
+//
+// Each daemon runs a Node service. The nodes connect to the peers in the
+// static list of the configuration and learn of the other nodes from the
+// member lists they periodically gossip to each other, which carry the
+// catalogs of the nodes: their services and the event types the services
+// subscribe to. A node forwards the local events of the configured types
+// to the nodes with subscribers of the type, where they are emitted as
+// local events.
+//
+// The nodes form a full mesh: each pair of nodes is connected by one TCP
+// connection, dialed by the node with the lower name. A node from which no
+// newer heartbeat has been gossiped within the failure timeout is removed.
+// The heartbeats of a node are ordered by its incarnation, the time it
+// started, so that a restarted node is not ignored until it has caught up
+// with the heartbeat of its previous incarnation.
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// Defaults
+const (
+	DefaultGossipInterval = time.Second
+	DefaultFailureTimeout = 5 * time.Second
+	DefaultDialTimeout    = 5 * time.Second
+)
+
+// writeTimeout limits the time to write a message to a peer.
+const writeTimeout = 5 * time.Second
+
+// helloTimeout limits the time to wait for the hello of a peer.
+const helloTimeout = 5 * time.Second
+
+// Config of the node.
+type Config struct {
+	// Name identifies the node in the cluster. Defaults to
+	// hostname:pid.
+	Name string
+
+	// Addr is the TCP address on which the node accepts the
+	// connections of the other nodes. If empty, the node only dials
+	// the other nodes.
+	Addr string
+
+	// AdvertiseAddr is the address at which the other nodes reach this
+	// node. Defaults to the address of the listener.
+	AdvertiseAddr string
+
+	// Listen listens on Addr, e.g. with a listener handed over on a
+	// live upgrade. Defaults to net.Listen.
+	Listen func(network, addr string) (net.Listener, error)
+
+	// Peers are the addresses of the nodes to join. The rest of the
+	// nodes are discovered from their gossip.
+	Peers []string
+
+	// Forward are the local event types forwarded to the nodes with
+	// subscribers of the type.
+	Forward []gosvcd.EventType
+
+	// GossipInterval is the interval of gossiping the member list.
+	// Defaults to DefaultGossipInterval.
+	GossipInterval time.Duration
+
+	// FailureTimeout is the time after which a node without a newer
+	// heartbeat is removed. Defaults to DefaultFailureTimeout.
+	FailureTimeout time.Duration
+
+	// DialTimeout limits the time to connect to a node. Defaults to
+	// DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// TLS secures the connections between the nodes, e.g. with the
+	// configuration of package tlsconfig verifying the certificates of
+	// both ends. Optional.
+	TLS *tls.Config
+
+	// OnError is called with the errors of the connections and of
+	// encoding and decoding the events. Optional.
+	OnError func(error)
+}
+
+// ServiceInfo describes a service in the catalog of a node.
+type ServiceInfo struct {
+	ID            gosvcd.ServiceId   `json:"id"`
+	Name          string             `json:"name"`
+	Subscriptions []gosvcd.EventType `json:"subscriptions,omitempty"`
+}
+
+// Member is a node of the cluster.
+type Member struct {
+	Name     string        `json:"name"`
+	Addr     string        `json:"addr,omitempty"`
+	Services []ServiceInfo `json:"services,omitempty"`
+
+	// Incarnation is the time the node started, in nanoseconds since
+	// the Unix epoch.
+	Incarnation int64 `json:"incarnation"`
+
+	// Heartbeat is incremented by the node each time it gossips.
+	Heartbeat uint64 `json:"heartbeat"`
+}
+
+// newer returns true if the member is a later state of the node than 'old'.
+func (m *Member) newer(old *Member) bool {
+	if m.Incarnation != old.Incarnation {
+		return m.Incarnation > old.Incarnation
+	}
+	return m.Heartbeat > old.Heartbeat
+}
+
+// subscribes returns true if a service of the member subscribes to the
+// event type.
+func (m *Member) subscribes(typ gosvcd.EventType) bool {
+	for _, svc := range m.Services {
+		for _, sub := range svc.Subscriptions {
+			if sub == typ {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+// Events
+//
+
+var MemberJoined_Type = gosvcd.EventType("MemberJoined")
+
+// MemberJoined is emitted when a node joins the cluster.
+type MemberJoined struct {
+	Node string
+	Addr string
+}
+
+var MemberLeft_Type = gosvcd.EventType("MemberLeft")
+
+// MemberLeft is emitted when a node has been removed from the cluster.
+type MemberLeft struct {
+	Node string
+}
+
+//
+// Protocol
+//
+
+// The nodes exchange a stream of JSON messages. The first message on a
+// connection is a hello from each side.
+const (
+	msgHello   = "hello"
+	msgGossip  = "gossip"
+	msgEvent   = "event"
+	msgAcquire = "acquire"
+	msgRelease = "release"
+	msgReply   = "reply"
+)
+
+type message struct {
+	Kind    string   `json:"kind"`
+	Node    *Member  `json:"node,omitempty"`
+	Members []Member `json:"members,omitempty"`
+
+	// Event is the event encoded with wire.Encode.
+	Event []byte `json:"event,omitempty"`
+
+	// ID matches the reply to the acquire or release request.
+	ID     uint64  `json:"id,omitempty"`
+	Permit *permit `json:"permit,omitempty"`
+	OK     bool    `json:"ok,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+type peer struct {
+	name   string
+	conn   net.Conn
+	dialed bool
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (p *peer) send(m *message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return p.enc.Encode(m)
+}
+
+type member struct {
+	Member
+	seen time.Time
+}
+
+// Node is the service connecting the daemon to the cluster.
+type Node struct {
+	id  gosvcd.ServiceId
+	cfg Config
+
+	mu       sync.Mutex
+	handle   gosvcd.ServiceHandle
+	listener net.Listener
+	self     Member
+	members  map[string]*member
+	peers    map[string]*peer
+	dialing  map[string]bool
+	conns    map[net.Conn]bool
+	running  bool
+
+	// permits are the permits held by the nodes when the node is the
+	// coordinator, by semaphore and holder.
+	permits map[string]map[string]string
+
+	// calls are the requests to the coordinator waiting for a reply.
+	calls  map[uint64]chan *message
+	callID uint64
+}
+
+// New returns the node service with the given identifier.
+func New(id gosvcd.ServiceId, cfg Config) *Node {
+	if cfg.Name == "" {
+		cfg.Name = wire.DefaultOrigin()
+	}
+	if cfg.Listen == nil {
+		cfg.Listen = net.Listen
+	}
+	if cfg.GossipInterval <= 0 {
+		cfg.GossipInterval = DefaultGossipInterval
+	}
+	if cfg.FailureTimeout <= 0 {
+		cfg.FailureTimeout = DefaultFailureTimeout
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	return &Node{id: id, cfg: cfg}
+}
+
+func (n *Node) ID() gosvcd.ServiceId              { return n.id }
+func (n *Node) Name() string                      { return "cluster-node" }
+func (n *Node) Dependencies() []gosvcd.ServiceId  { return nil }
+func (n *Node) Subscriptions() []gosvcd.EventType { return n.cfg.Forward }
+
+// Init starts listening for the other nodes and joins the peers. Fails the
+// service if listening fails.
+func (n *Node) Init(handle gosvcd.ServiceHandle) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handle = handle
+	n.self = Member{
+		Name:        n.cfg.Name,
+		Addr:        n.cfg.AdvertiseAddr,
+		Incarnation: time.Now().UnixNano(),
+	}
+	n.members = make(map[string]*member)
+	n.peers = make(map[string]*peer)
+	n.dialing = make(map[string]bool)
+	n.conns = make(map[net.Conn]bool)
+	n.permits = make(map[string]map[string]string)
+	n.calls = make(map[uint64]chan *message)
+	n.running = true
+	if n.cfg.Addr != "" {
+		l, err := n.cfg.Listen("tcp", n.cfg.Addr)
+		if err != nil {
+			panic(err)
+		}
+		if n.cfg.TLS != nil {
+			l = tls.NewListener(l, n.cfg.TLS)
+		}
+		n.listener = l
+		if n.self.Addr == "" {
+			n.self.Addr = l.Addr().String()
+		}
+		handle.Go(func(context.Context) { n.accept(l) })
+	}
+	n.self.Services = n.catalog()
+	handle.Go(n.gossipLoop)
+}
+
+// Shutdown leaves the cluster, closing the connections to the other nodes.
+func (n *Node) Shutdown() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.running = false
+	if n.listener != nil {
+		n.listener.Close()
+		n.listener = nil
+	}
+	for conn := range n.conns {
+		conn.Close()
+	}
+	n.handle = nil
+}
+
+// HandleEvent forwards the event to the nodes with subscribers of its
+// type. Events emitted by the node itself, i.e. received from other nodes,
+// are not forwarded.
+func (n *Node) HandleEvent(ev gosvcd.Event) {
+	if ev.ServiceId() == n.id {
+		return
+	}
+	var targets []*peer
+	n.mu.Lock()
+	for name, p := range n.peers {
+		if m, ok := n.members[name]; ok && m.subscribes(ev.EventType()) {
+			targets = append(targets, p)
+		}
+	}
+	n.mu.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+	data, err := wire.Encode(n.cfg.Name, ev)
+	if err != nil {
+		n.error(fmt.Errorf("encode %s: %w", ev.EventType(), err))
+		return
+	}
+	msg := &message{Kind: msgEvent, Event: data}
+	for _, p := range targets {
+		if err := p.send(msg); err != nil {
+			n.error(fmt.Errorf("send %s to %s: %w", ev.EventType(), p.name, err))
+			p.conn.Close()
+		}
+	}
+}
+
+// Members returns the other nodes of the cluster ordered by name.
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	members := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		members = append(members, m.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// catalog returns the local services other than the node.
+func (n *Node) catalog() []ServiceInfo {
+	c, ok := n.handle.(interface{ Services() []gosvcd.Service })
+	if !ok {
+		return nil
+	}
+	var infos []ServiceInfo
+	for _, svc := range c.Services() {
+		if svc.ID() == n.id {
+			continue
+		}
+		infos = append(infos, ServiceInfo{
+			ID:            svc.ID(),
+			Name:          svc.Name(),
+			Subscriptions: svc.Subscriptions(),
+		})
+	}
+	return infos
+}
+
+func (n *Node) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		n.serve(conn, false)
+	}
+}
+
+// dial connects to the node at the address.
+func (n *Node) dial(addr string) {
+	n.mu.Lock()
+	if !n.running || n.dialing[addr] {
+		n.mu.Unlock()
+		return
+	}
+	n.dialing[addr] = true
+	n.mu.Unlock()
+	var conn net.Conn
+	var err error
+	if n.cfg.TLS != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: n.cfg.DialTimeout}, "tcp", addr, n.cfg.TLS)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, n.cfg.DialTimeout)
+	}
+	n.mu.Lock()
+	delete(n.dialing, addr)
+	n.mu.Unlock()
+	if err != nil {
+		n.error(fmt.Errorf("connect to %s: %w", addr, err))
+		return
+	}
+	n.serve(conn, true)
+}
+
+// serve starts handling the connection to another node.
+func (n *Node) serve(conn net.Conn, dialed bool) {
+	n.mu.Lock()
+	if !n.running {
+		n.mu.Unlock()
+		conn.Close()
+		return
+	}
+	n.conns[conn] = true
+	handle := n.handle
+	n.mu.Unlock()
+	handle.Go(func(context.Context) {
+		err := n.handleConn(conn, dialed)
+		conn.Close()
+		n.mu.Lock()
+		delete(n.conns, conn)
+		running := n.running
+		n.mu.Unlock()
+		if err != nil && running {
+			n.error(err)
+		}
+	})
+}
+
+func (n *Node) handleConn(conn net.Conn, dialed bool) error {
+	p := &peer{conn: conn, dialed: dialed, enc: json.NewEncoder(conn)}
+	n.mu.Lock()
+	self := n.self
+	n.mu.Unlock()
+	if err := p.send(&message{Kind: msgHello, Node: &self}); err != nil {
+		return fmt.Errorf("hello to %s: %w", conn.RemoteAddr(), err)
+	}
+	dec := json.NewDecoder(conn)
+	var hello message
+	conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	if err := dec.Decode(&hello); err != nil {
+		return fmt.Errorf("hello from %s: %w", conn.RemoteAddr(), err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if hello.Kind != msgHello || hello.Node == nil {
+		return fmt.Errorf("unexpected %q message from %s", hello.Kind, conn.RemoteAddr())
+	}
+	p.name = hello.Node.Name
+	if !n.addPeer(p, *hello.Node) {
+		return nil
+	}
+	defer n.removePeer(p)
+
+	for {
+		var msg message
+		if err := dec.Decode(&msg); err != nil {
+			if n.isPeer(p) {
+				return fmt.Errorf("connection to %s: %w", p.name, err)
+			}
+			return nil
+		}
+		switch msg.Kind {
+		case msgGossip:
+			n.merge(msg.Members)
+		case msgEvent:
+			n.receive(p, msg.Event)
+		case msgAcquire, msgRelease:
+			n.coordinate(p, &msg)
+		case msgReply:
+			n.reply(&msg)
+		}
+	}
+}
+
+// addPeer registers the connection to the node. Of two connections
+// between a pair of nodes, the one dialed by the node with the lower name
+// is kept. Returns false if the connection is not kept.
+func (n *Node) addPeer(p *peer, m Member) bool {
+	n.mu.Lock()
+	if !n.running || p.name == n.self.Name {
+		n.mu.Unlock()
+		return false
+	}
+	if other, ok := n.peers[p.name]; ok {
+		keepDialed := n.self.Name < p.name
+		if p.dialed != keepDialed {
+			n.mu.Unlock()
+			return false
+		}
+		other.conn.Close()
+	}
+	n.peers[p.name] = p
+	joined := n.update(m)
+	n.mu.Unlock()
+	if joined {
+		n.joined(m)
+	}
+	return true
+}
+
+func (n *Node) removePeer(p *peer) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.peers[p.name] == p {
+		delete(n.peers, p.name)
+	}
+}
+
+func (n *Node) isPeer(p *peer) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.peers[p.name] == p
+}
+
+// update records the member if its incarnation or heartbeat is newer.
+// Returns true if the member is new. Must be called with 'mu' held.
+func (n *Node) update(m Member) bool {
+	if m.Name == n.self.Name {
+		return false
+	}
+	old, ok := n.members[m.Name]
+	if ok && !m.newer(&old.Member) {
+		return false
+	}
+	n.members[m.Name] = &member{Member: m, seen: time.Now()}
+	return !ok
+}
+
+// merge merges the gossiped member list.
+func (n *Node) merge(members []Member) {
+	var joined []Member
+	n.mu.Lock()
+	for _, m := range members {
+		if n.update(m) {
+			joined = append(joined, m)
+		}
+	}
+	n.mu.Unlock()
+	for _, m := range joined {
+		n.joined(m)
+	}
+}
+
+func (n *Node) joined(m Member) {
+	if handle := n.getHandle(); handle != nil {
+		handle.EmitEvent(MemberJoined_Type, &MemberJoined{Node: m.Name, Addr: m.Addr})
+	}
+}
+
+// receive emits the event received from the node.
+func (n *Node) receive(p *peer, data []byte) {
+	env, err := wire.Decode(data)
+	if err != nil {
+		n.error(fmt.Errorf("decode event from %s: %w", p.name, err))
+		return
+	}
+	v, err := env.Value()
+	if err != nil {
+		n.error(fmt.Errorf("decode %s data from %s: %w", env.Type, p.name, err))
+		return
+	}
+	if handle := n.getHandle(); handle != nil {
+		handle.EmitEventContext(env.Context(context.Background()), env.Type, v)
+	}
+}
+
+func (n *Node) getHandle() gosvcd.ServiceHandle {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.handle
+}
+
+// gossipLoop periodically gossips the member list, connects to the nodes
+// not connected to and removes the failed nodes.
+func (n *Node) gossipLoop(ctx context.Context) {
+	ticker := time.NewTicker(n.cfg.GossipInterval)
+	defer ticker.Stop()
+	for {
+		n.gossip()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *Node) gossip() {
+	var (
+		left    []string
+		dial    []string
+		targets []*peer
+	)
+	n.mu.Lock()
+	if !n.running {
+		n.mu.Unlock()
+		return
+	}
+	n.self.Heartbeat++
+	n.self.Services = n.catalog()
+	members := []Member{n.self}
+	now := time.Now()
+	for name, m := range n.members {
+		if now.Sub(m.seen) > n.cfg.FailureTimeout {
+			delete(n.members, name)
+			if p, ok := n.peers[name]; ok {
+				p.conn.Close()
+			}
+			left = append(left, name)
+			continue
+		}
+		members = append(members, m.Member)
+		if _, ok := n.peers[name]; !ok && n.self.Name < name && m.Addr != "" {
+			dial = append(dial, m.Addr)
+		}
+	}
+	connected := make(map[string]bool, len(n.peers))
+	for _, p := range n.peers {
+		targets = append(targets, p)
+		connected[p.conn.RemoteAddr().String()] = true
+	}
+	for _, m := range n.members {
+		if _, ok := n.peers[m.Name]; ok {
+			connected[m.Addr] = true
+		}
+	}
+	for _, addr := range n.cfg.Peers {
+		if !connected[addr] {
+			dial = append(dial, addr)
+		}
+	}
+	handle := n.handle
+	n.mu.Unlock()
+
+	for _, name := range left {
+		n.releaseNode(name)
+		handle.EmitEvent(MemberLeft_Type, &MemberLeft{Node: name})
+	}
+	for _, addr := range dial {
+		addr := addr
+		handle.Go(func(context.Context) { n.dial(addr) })
+	}
+	msg := &message{Kind: msgGossip, Members: members}
+	for _, p := range targets {
+		if err := p.send(msg); err != nil {
+			n.error(fmt.Errorf("gossip to %s: %w", p.name, err))
+			p.conn.Close()
+		}
+	}
+}
+
+func (n *Node) error(err error) {
+	if errors.Is(err, net.ErrClosed) {
+		return
+	}
+	if n.cfg.OnError != nil {
+		n.cfg.OnError(err)
+	}
+}
@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// ErrNotRunning is returned by the coordination store when the node is not
+// running.
+var ErrNotRunning = errors.New("cluster node not running")
+
+// callTimeout limits the time to wait for the reply of the coordinator.
+const callTimeout = 5 * time.Second
+
+type permit struct {
+	Name   string `json:"name"`
+	Holder string `json:"holder"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// Coordination returns a coordination store shared by the nodes of the
+// cluster, for the builder's SetCoordination. The permits are held by the
+// coordinator, the connected node with the lowest name. The permits held
+// by the services of a node are released when it is removed from the
+// cluster. The permits held with a coordinator that leaves the cluster
+// are lost, and the nodes of a partitioned cluster each have their own
+// coordinator.
+func (n *Node) Coordination() gosvcd.CoordinationStore {
+	return &coordination{n: n}
+}
+
+type coordination struct {
+	n *Node
+}
+
+func (c *coordination) TryAcquire(ctx context.Context, name, holder string, limit int) (bool, error) {
+	return c.n.call(ctx, msgAcquire, &permit{Name: name, Holder: holder, Limit: limit})
+}
+
+func (c *coordination) Release(ctx context.Context, name, holder string) error {
+	_, err := c.n.call(ctx, msgRelease, &permit{Name: name, Holder: holder})
+	return err
+}
+
+// coordinator returns the connection to the coordinator, or nil if the
+// node is the coordinator. Must be called with 'mu' held.
+func (n *Node) coordinator() *peer {
+	var coord *peer
+	for name, p := range n.peers {
+		if name < n.self.Name && (coord == nil || name < coord.name) {
+			coord = p
+		}
+	}
+	return coord
+}
+
+// call requests the coordinator to acquire or release the permit.
+func (n *Node) call(ctx context.Context, kind string, pm *permit) (bool, error) {
+	n.mu.Lock()
+	if !n.running {
+		n.mu.Unlock()
+		return false, ErrNotRunning
+	}
+	coord := n.coordinator()
+	if coord == nil {
+		ok := n.apply(kind, n.self.Name, pm)
+		n.mu.Unlock()
+		return ok, nil
+	}
+	n.callID++
+	id := n.callID
+	ch := make(chan *message, 1)
+	n.calls[id] = ch
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.calls, id)
+		n.mu.Unlock()
+	}()
+
+	if err := coord.send(&message{Kind: kind, ID: id, Permit: pm}); err != nil {
+		return false, fmt.Errorf("%s %q with %s: %w", kind, pm.Name, coord.name, err)
+	}
+	timer := time.NewTimer(callTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-timer.C:
+		return false, fmt.Errorf("%s %q with %s: timed out", kind, pm.Name, coord.name)
+	case reply := <-ch:
+		if reply.Error != "" {
+			return false, errors.New(reply.Error)
+		}
+		return reply.OK, nil
+	}
+}
+
+// coordinate handles the acquire or release request from the node.
+func (n *Node) coordinate(p *peer, msg *message) {
+	reply := &message{Kind: msgReply, ID: msg.ID}
+	n.mu.Lock()
+	switch {
+	case msg.Permit == nil:
+		reply.Error = "missing permit"
+	case n.coordinator() != nil:
+		// The node has not yet learned of a node with a lower name.
+		reply.Error = fmt.Sprintf("%s is not the coordinator", n.self.Name)
+	default:
+		reply.OK = n.apply(msg.Kind, p.name, msg.Permit)
+	}
+	n.mu.Unlock()
+	if err := p.send(reply); err != nil {
+		n.error(fmt.Errorf("reply to %s: %w", p.name, err))
+	}
+}
+
+// apply acquires or releases the permit for the holder on the node. Must
+// be called with 'mu' held.
+func (n *Node) apply(kind, node string, pm *permit) bool {
+	holder := node + "/" + pm.Holder
+	holders := n.permits[pm.Name]
+	if kind == msgRelease {
+		delete(holders, holder)
+		if len(holders) == 0 {
+			delete(n.permits, pm.Name)
+		}
+		return true
+	}
+	if _, ok := holders[holder]; ok {
+		return true
+	}
+	if len(holders) >= pm.Limit {
+		return false
+	}
+	if holders == nil {
+		holders = make(map[string]string)
+		n.permits[pm.Name] = holders
+	}
+	holders[holder] = node
+	return true
+}
+
+// reply passes the reply to the waiting request.
+func (n *Node) reply(msg *message) {
+	n.mu.Lock()
+	ch, ok := n.calls[msg.ID]
+	n.mu.Unlock()
+	if ok {
+		ch <- msg
+	}
+}
+
+// releaseNode releases the permits held by the node removed from the
+// cluster.
+func (n *Node) releaseNode(node string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for name, holders := range n.permits {
+		for holder, owner := range holders {
+			if owner == node {
+				delete(holders, holder)
+			}
+		}
+		if len(holders) == 0 {
+			delete(n.permits, name)
+		}
+	}
+}
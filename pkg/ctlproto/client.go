@@ -0,0 +1,158 @@
+package ctlproto
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/joamaki/gosvcd/pkg/tlsconfig"
+)
+
+// Client is a connection to the control socket of a daemon. It is safe
+// for concurrent use.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+// Dial connects to the control socket at the given path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// DialTLS connects to the control protocol served with TLS at the TCP
+// address, see gosvcd.ListenControlTLS. The configuration should carry the
+// client certificate, as the daemon should require one.
+func DialTLS(addr string, cfg *tls.Config) (*Client, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// DialTLSOptions connects to the control protocol served with TLS at the
+// TCP address, with the client configuration of package tlsconfig.
+func DialTLSOptions(addr string, opts tlsconfig.Options) (*Client, error) {
+	cfg, err := tlsconfig.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	return DialTLS(addr, cfg)
+}
+
+// NewClient returns the client speaking the control protocol on the
+// connection, e.g. one dialed through a proxy.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(bufio.NewReader(conn)),
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call performs a request and decodes its result into 'result', unless
+// it is nil.
+func (c *Client) Call(op string, args interface{}, result interface{}) error {
+	req := Request{Version: Version, Op: op}
+	if args != nil {
+		raw, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		req.Args = raw
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(&req); err != nil {
+		return err
+	}
+	var resp Response
+	if err := c.dec.Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Version != Version {
+		return fmt.Errorf("unsupported protocol version %d", resp.Version)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result != nil && resp.Result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+func (c *Client) Services() ([]ServiceInfo, error) {
+	var svcs []ServiceInfo
+	err := c.Call(OpServices, nil, &svcs)
+	return svcs, err
+}
+
+// Emit emits an event with the given payload, which is encoded as JSON.
+func (c *Client) Emit(typ string, data interface{}) error {
+	args := EmitArgs{Type: typ}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		args.Data = raw
+	}
+	return c.Call(OpEmit, &args, nil)
+}
+
+func (c *Client) Restart(id int64) error {
+	return c.Call(OpRestart, &RestartArgs{id}, nil)
+}
+
+func (c *Client) Pause() error {
+	return c.Call(OpPause, nil, nil)
+}
+
+func (c *Client) Resume() error {
+	return c.Call(OpResume, nil, nil)
+}
+
+func (c *Client) PauseService(id int64, drop bool) error {
+	return c.Call(OpPauseService, &PauseServiceArgs{ID: id, Drop: drop}, nil)
+}
+
+func (c *Client) ResumeService(id int64) error {
+	return c.Call(OpResumeService, &ResumeServiceArgs{id}, nil)
+}
+
+func (c *Client) StartGroup(name string) error {
+	return c.Call(OpStartGroup, &GroupArgs{name}, nil)
+}
+
+func (c *Client) StopGroup(name string) error {
+	return c.Call(OpStopGroup, &GroupArgs{name}, nil)
+}
+
+func (c *Client) RestartGroup(name string) error {
+	return c.Call(OpRestartGroup, &GroupArgs{name}, nil)
+}
+
+func (c *Client) Checkpoint(name string) error {
+	return c.Call(OpCheckpoint, &CheckpointArgs{name}, nil)
+}
+
+func (c *Client) Shutdown() error {
+	return c.Call(OpShutdown, nil, nil)
+}
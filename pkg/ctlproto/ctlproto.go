@@ -0,0 +1,116 @@
+// Package ctlproto defines the protocol for managing a running gosvcd
+// daemon over its control socket.
+//
+// The protocol consists of newline-delimited JSON messages over a Unix
+// socket, see Dial, or over a TCP connection secured with TLS for managing
+// the daemon from another host, see DialTLS. The client sends a Request and
+// the server replies with exactly one Response, after which further
+// requests may be sent on the same connection. Every message carries the protocol version, and a server
+// rejects requests with a version it does not support.
+package ctlproto
+
+import "encoding/json"
+
+// Version is the current version of the control protocol.
+const Version = 1
+
+// Operations
+const (
+	// OpServices lists the services in dependency order.
+	// Result: []ServiceInfo
+	OpServices = "services"
+
+	// OpEmit emits an event on behalf of the daemon.
+	// Args: EmitArgs
+	OpEmit = "emit"
+
+	// OpRestart restarts a service.
+	// Args: RestartArgs
+	OpRestart = "restart"
+
+	// OpPause pauses the dispatch of events.
+	OpPause = "pause"
+
+	// OpResume resumes the dispatch of events.
+	OpResume = "resume"
+
+	// OpPauseService pauses the delivery of events to a service.
+	// Args: PauseServiceArgs
+	OpPauseService = "pause-service"
+
+	// OpResumeService resumes the delivery of events to a service.
+	// Args: ResumeServiceArgs
+	OpResumeService = "resume-service"
+
+	// OpStartGroup starts the services of a group.
+	// Args: GroupArgs
+	OpStartGroup = "start-group"
+
+	// OpStopGroup stops the services of a group.
+	// Args: GroupArgs
+	OpStopGroup = "stop-group"
+
+	// OpRestartGroup restarts the services of a group.
+	// Args: GroupArgs
+	OpRestartGroup = "restart-group"
+
+	// OpCheckpoint writes the checkpoint of the daemon to a file in the
+	// daemon's checkpoint directory.
+	// Args: CheckpointArgs
+	OpCheckpoint = "checkpoint"
+
+	// OpShutdown shuts down the daemon. The response is sent before the
+	// shutdown completes.
+	OpShutdown = "shutdown"
+)
+
+type Request struct {
+	Version int             `json:"version"`
+	Op      string          `json:"op"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+type Response struct {
+	Version int             `json:"version"`
+	Error   string          `json:"error,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+type ServiceInfo struct {
+	ID            int64    `json:"id"`
+	Name          string   `json:"name"`
+	Dependencies  []int64  `json:"dependencies"`
+	Subscriptions []string `json:"subscriptions"`
+
+	// Degraded are the missing optional dependencies of the service.
+	Degraded []int64 `json:"degraded,omitempty"`
+}
+
+type EmitArgs struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type RestartArgs struct {
+	ID int64 `json:"id"`
+}
+
+type PauseServiceArgs struct {
+	ID int64 `json:"id"`
+
+	// Drop drops the events of the service instead of holding them.
+	Drop bool `json:"drop,omitempty"`
+}
+
+type ResumeServiceArgs struct {
+	ID int64 `json:"id"`
+}
+
+type GroupArgs struct {
+	Name string `json:"name"`
+}
+
+type CheckpointArgs struct {
+	// Name is the name of the file in the checkpoint directory.
+	Name string `json:"name"`
+}
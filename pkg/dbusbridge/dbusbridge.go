@@ -0,0 +1,208 @@
+// Package dbusbridge bridges a gosvcd daemon to D-Bus: it exports an object
+// for inspecting and controlling the daemon and translates D-Bus signals
+// into events.
+//
+// The bridge does not implement the D-Bus protocol itself. The exports and
+// the signal match rules go through Conn, whose methods follow the
+// conventions of github.com/godbus/dbus/v5, so that a *dbus.Conn is adapted
+// in a few lines. Requesting a well-known bus name is left to the caller.
+package dbusbridge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// Default object path and interface of the exported daemon object.
+const (
+	DefaultPath      = "/io/github/joamaki/gosvcd"
+	DefaultInterface = "io.github.joamaki.gosvcd.Daemon"
+)
+
+// ErrControlDisabled is returned by the control methods of the exported
+// object unless Config.AllowControl is set.
+var ErrControlDisabled = errors.New("control operations are disabled")
+
+// Signal is a D-Bus signal received from the bus.
+type Signal struct {
+	Sender string
+	Path   string
+	// Name is the signal name qualified with the interface, e.g.
+	// "org.freedesktop.login1.Manager.PrepareForSleep".
+	Name string
+	Body []interface{}
+}
+
+// Conn is the D-Bus connection used by the bridge.
+type Conn interface {
+	// Export exports the methods of 'obj' on the object path and
+	// interface. The methods follow the godbus conventions: the
+	// arguments and the return values are marshalled, apart from the
+	// last return value which is an error. A nil 'obj' removes the
+	// export.
+	Export(obj interface{}, path, iface string) error
+
+	// Subscribe adds a match rule for the signals with the given
+	// interface and member and returns the channel on which they are
+	// delivered. The returned function removes the match rule and
+	// closes the channel.
+	Subscribe(iface, member string) (<-chan *Signal, func(), error)
+}
+
+// SignalRoute translates the signals with the interface and member to
+// events of type EventType, with the *Signal as the event data.
+type SignalRoute struct {
+	Interface string
+	Member    string
+	EventType gosvcd.EventType
+}
+
+// Config selects what the bridge exposes.
+type Config struct {
+	// Path and Interface of the exported daemon object. Defaults to
+	// DefaultPath and DefaultInterface.
+	Path      string
+	Interface string
+
+	// AllowControl enables the Emit, Restart and Shutdown methods.
+	// Otherwise only the introspection methods are usable.
+	AllowControl bool
+
+	// Signals to translate into events.
+	Signals []SignalRoute
+}
+
+// Bridge is a running D-Bus bridge.
+type Bridge struct {
+	conn   Conn
+	cfg    Config
+	unsubs []func()
+	wg     sync.WaitGroup
+}
+
+// Serve exports the daemon object on the connection and starts translating
+// the configured signals into events. The bridge is stopped with Close.
+func Serve(d gosvcd.ServiceDaemon, conn Conn, cfg Config) (*Bridge, error) {
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath
+	}
+	if cfg.Interface == "" {
+		cfg.Interface = DefaultInterface
+	}
+	b := &Bridge{conn: conn, cfg: cfg}
+	obj := &Object{d: d, allowControl: cfg.AllowControl}
+	if err := conn.Export(obj, cfg.Path, cfg.Interface); err != nil {
+		return nil, err
+	}
+	for _, route := range cfg.Signals {
+		route := route
+		signals, unsub, err := conn.Subscribe(route.Interface, route.Member)
+		if err != nil {
+			b.Close()
+			return nil, fmt.Errorf("subscribe %s.%s: %w", route.Interface, route.Member, err)
+		}
+		b.unsubs = append(b.unsubs, unsub)
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			for sig := range signals {
+				if err := d.EmitEvent(route.EventType, sig); errors.Is(err, gosvcd.ErrDaemonStopped) {
+					return
+				}
+			}
+		}()
+	}
+	return b, nil
+}
+
+// Close removes the exported object and the signal subscriptions.
+func (b *Bridge) Close() error {
+	err := b.conn.Export(nil, b.cfg.Path, b.cfg.Interface)
+	for _, unsub := range b.unsubs {
+		unsub()
+	}
+	b.wg.Wait()
+	return err
+}
+
+// ServiceInfo describes a service. Marshalled as the D-Bus struct
+// (xss) of the id, name and state.
+type ServiceInfo struct {
+	ID    int64
+	Name  string
+	State string
+}
+
+// Object is the daemon object exported on the bus.
+type Object struct {
+	d            gosvcd.ServiceDaemon
+	allowControl bool
+}
+
+// ListServices returns the registered services.
+func (o *Object) ListServices() ([]ServiceInfo, error) {
+	infos := []ServiceInfo{}
+	for _, svc := range o.d.Services() {
+		state, _ := o.d.State(svc.ID())
+		infos = append(infos, ServiceInfo{
+			ID:    int64(svc.ID()),
+			Name:  svc.Name(),
+			State: state.String(),
+		})
+	}
+	return infos, nil
+}
+
+// Health returns the problems reported by ServiceDaemon.Health, or an
+// empty string if the daemon is healthy.
+func (o *Object) Health() (string, error) {
+	if err := o.d.Health(); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+// Readiness returns the problems reported by ServiceDaemon.Readiness, or
+// an empty string if the daemon is ready.
+func (o *Object) Readiness() (string, error) {
+	if err := o.d.Readiness(); err != nil {
+		return err.Error(), nil
+	}
+	return "", nil
+}
+
+// Emit emits an event with the JSON encoded data. Empty data emits an event
+// with nil data.
+func (o *Object) Emit(eventType, data string) error {
+	if !o.allowControl {
+		return ErrControlDisabled
+	}
+	var v interface{}
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			return fmt.Errorf("invalid event data: %w", err)
+		}
+	}
+	return o.d.EmitEvent(gosvcd.EventType(eventType), v)
+}
+
+// Restart restarts the service.
+func (o *Object) Restart(id int64) error {
+	if !o.allowControl {
+		return ErrControlDisabled
+	}
+	return o.d.Restart(gosvcd.ServiceId(id))
+}
+
+// Shutdown starts shutting down the daemon.
+func (o *Object) Shutdown() error {
+	if !o.allowControl {
+		return ErrControlDisabled
+	}
+	go o.d.Shutdown()
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package goplugin loads services from Go plugins, so that services can be
+// added to a deployment without rebuilding the daemon. A plugin is a main
+// package built with -buildmode=plugin exporting a NewService function:
+//
+//	package main
+//
+//	func NewService() gosvcd.Service { return &MyService{} }
+//
+// The plugins must be built with the same Go toolchain and the same version
+// of the gosvcd packages as the daemon. Loaded plugins cannot be unloaded.
+// Go plugins are supported on Linux, FreeBSD and macOS with cgo; elsewhere
+// loading fails.
+package goplugin
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// NewServiceSymbol is the name of the function exported by the plugins.
+const NewServiceSymbol = "NewService"
+
+// Ext is the extension of the plugin files loaded by LoadDir.
+const Ext = ".so"
+
+// Load opens the plugin and returns the service created by its NewService
+// function.
+func Load(path string) (gosvcd.Service, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open plugin %s: %w", path, err)
+	}
+	sym, err := p.Lookup(NewServiceSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	var newService func() gosvcd.Service
+	switch f := sym.(type) {
+	case func() gosvcd.Service:
+		newService = f
+	case *func() gosvcd.Service:
+		newService = *f
+	default:
+		return nil, fmt.Errorf("plugin %s: %s is %T, expected func() gosvcd.Service", path, NewServiceSymbol, sym)
+	}
+	svc := newService()
+	if svc == nil {
+		return nil, fmt.Errorf("plugin %s: %s returned nil", path, NewServiceSymbol)
+	}
+	return svc, nil
+}
+
+// Reload loads a new version of the plugin at the path. As the plugins
+// are cached by their path, Load returns the first version loaded from a
+// path; Reload instead copies the file to a file named after its content
+// in a private temporary directory of the process, and loads the copy. The
+// plugin must be built from changed sources, as a plugin cannot be loaded
+// twice. The copies are left in place, as the loaded plugins stay mapped.
+func Reload(path string) (gosvcd.Service, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	dir, err := reloadDir()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), Ext)
+	copied := filepath.Join(dir, fmt.Sprintf("%s-%x%s", name, sum, Ext))
+	if _, err := os.Stat(copied); err != nil {
+		tmp := copied + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0700); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(tmp, copied); err != nil {
+			os.Remove(tmp)
+			return nil, err
+		}
+	}
+	svc, err := Load(copied)
+	if err != nil {
+		return nil, fmt.Errorf("reload %s: %w", path, err)
+	}
+	return svc, nil
+}
+
+var (
+	reloadOnce   sync.Once
+	reloadTmpDir string
+	reloadErr    error
+)
+
+// reloadDir returns the directory of the copies made by Reload, creating
+// it on first use. The directory is private to the process, so that the
+// copies cannot be replaced by other users before they are loaded.
+func reloadDir() (string, error) {
+	reloadOnce.Do(func() {
+		reloadTmpDir, reloadErr = ioutil.TempDir("", "gosvcd-plugins-")
+	})
+	return reloadTmpDir, reloadErr
+}
+
+// LoadDir loads the plugins in the directory, in the order of their file
+// names, and registers their services with the builder. Nothing is
+// registered if loading any of the plugins fails.
+func LoadDir(b *gosvcd.ExampleServiceDaemonBuilder, dir string) ([]gosvcd.Service, error) {
+	paths, err := Plugins(dir)
+	if err != nil {
+		return nil, err
+	}
+	svcs := make([]gosvcd.Service, 0, len(paths))
+	for _, path := range paths {
+		svc, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		svcs = append(svcs, svc)
+	}
+	for _, svc := range svcs {
+		b.Register(svc)
+	}
+	return svcs, nil
+}
+
+// Plugins returns the paths of the plugin files in the directory, sorted.
+func Plugins(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), Ext) {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -0,0 +1,184 @@
+package gosvcd
+
+import (
+	"sync"
+	"time"
+)
+
+// AckHandler is implemented by services that acknowledge the events of
+// the types delivered at-least-once (see SetAckTimeout) explicitly.
+// The event is redelivered to the service if 'ack' is not called within
+// the ack timeout. 'ack' may be called after HandleEventAck has returned,
+// from any goroutine, and calling it more than once is harmless.
+//
+// Services not implementing AckHandler acknowledge the event implicitly
+// by returning from HandleEvent without panicking.
+type AckHandler interface {
+	HandleEventAck(event Event, ack func())
+}
+
+// ackRecord is an unacknowledged delivery of an event to a service.
+type ackRecord struct {
+	h        *ExampleServiceHandle
+	ev       *ExampleEvent
+	timeout  time.Duration
+	deadline time.Time
+	attempts int
+}
+
+// ackTracker tracks the unacknowledged deliveries.
+type ackTracker struct {
+	mu      sync.Mutex
+	records map[*ackRecord]struct{}
+}
+
+func (t *ackTracker) track(h *ExampleServiceHandle, ev *ExampleEvent, timeout time.Duration) *ackRecord {
+	rec := &ackRecord{
+		h:        h,
+		ev:       ev,
+		timeout:  timeout,
+		deadline: time.Now().Add(timeout),
+		attempts: 1,
+	}
+	t.mu.Lock()
+	if t.records == nil {
+		t.records = make(map[*ackRecord]struct{})
+	}
+	t.records[rec] = struct{}{}
+	t.mu.Unlock()
+	return rec
+}
+
+func (t *ackTracker) ack(rec *ackRecord) {
+	t.mu.Lock()
+	delete(t.records, rec)
+	t.mu.Unlock()
+}
+
+// expired returns the records past their deadline and extends their
+// deadline for the redelivery.
+func (t *ackTracker) expired(now time.Time) []*ackRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var recs []*ackRecord
+	for rec := range t.records {
+		if now.Before(rec.deadline) {
+			continue
+		}
+		rec.attempts++
+		rec.deadline = now.Add(rec.timeout)
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// minSeq returns the lowest journal sequence number of the unacknowledged
+// events, or zero if there are none.
+func (t *ackTracker) minSeq() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var min uint64
+	for rec := range t.records {
+		if seq := rec.ev.seq; seq != 0 && (min == 0 || seq < min) {
+			min = seq
+		}
+	}
+	return min
+}
+
+// forget drops the records of the service.
+func (t *ackTracker) forget(h *ExampleServiceHandle) {
+	t.mu.Lock()
+	for rec := range t.records {
+		if rec.h == h {
+			delete(t.records, rec)
+		}
+	}
+	t.mu.Unlock()
+}
+
+// handleEvent invokes the handler of the service for an event, tracking
+// its acknowledgement if 'rec' is non-nil. Returns the error returned by
+// a RetryHandler.
+func (d *ExampleServiceDaemon) handleEvent(h *ExampleServiceHandle, event Event, rec *ackRecord) error {
+	if rec != nil {
+		if ah, ok := h.Service.(AckHandler); ok {
+			ah.HandleEventAck(event, func() { d.acks.ack(rec) })
+			return nil
+		}
+	}
+	if rh, ok := h.Service.(RetryHandler); ok {
+		if err := rh.TryHandleEvent(event); err != nil {
+			return err
+		}
+	} else {
+		h.Service.HandleEvent(event)
+	}
+	if rec != nil {
+		d.acks.ack(rec)
+	}
+	return nil
+}
+
+// ackTimeout returns the ack timeout of the event's type, or zero if its
+// delivery is not acknowledged.
+func (d *ExampleServiceDaemon) ackTimeout(ev *ExampleEvent) time.Duration {
+	if ev.info != nil {
+		return ev.info.ackTimeout
+	}
+	return d.ackTimeouts[ev.eventType]
+}
+
+// minRedeliverTick is the shortest interval of checking for the expired
+// acknowledgements.
+const minRedeliverTick = time.Millisecond
+
+// redeliverLoop redelivers the events that have not been acknowledged
+// within their timeout. Events are redelivered for as long as the daemon
+// runs; the deliveries to stopped services are retried once they are
+// restarted.
+func (d *ExampleServiceDaemon) redeliverLoop() {
+	interval := time.Duration(0)
+	for _, timeout := range d.ackTimeouts {
+		if interval == 0 || timeout < interval {
+			interval = timeout
+		}
+	}
+	tick := interval / 2
+	if tick < minRedeliverTick {
+		tick = minRedeliverTick
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-ticker.C:
+			for _, rec := range d.acks.expired(now) {
+				if _, ok := d.handle(rec.h.ID()); !ok {
+					d.acks.forget(rec.h)
+					continue
+				}
+				if rec.h.getState() != ServiceRunning || d.pause.isPaused() {
+					continue
+				}
+				d.log.Warn("Redelivering unacknowledged event",
+					"service", rec.h.Name(),
+					"event_type", rec.ev.eventType,
+					"attempt", rec.attempts)
+				d.invoke(rec.h, rec.ev, rec.ev, rec)
+			}
+		}
+	}
+}
+
+// checkpointSeq returns the sequence number up to which the journal can
+// be checkpointed: the events after the oldest unacknowledged event are
+// kept for replay.
+func (d *ExampleServiceDaemon) checkpointSeq(seq uint64) uint64 {
+	if min := d.acks.minSeq(); min != 0 && min <= seq {
+		return min - 1
+	}
+	return seq
+}
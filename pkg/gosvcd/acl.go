@@ -0,0 +1,58 @@
+package gosvcd
+
+// SetSubscriptionACL restricts the subscribers of the event type to the
+// given services. The subscriptions of other services to the type are
+// denied when the daemon starts: the service is started without them and
+// an error is logged. Calling it again adds to the allowed services.
+//
+// The events of a restricted type are also not mirrored to the taps, see
+// Tap, as a tap is not a service that could be allowed.
+func (b *ExampleServiceDaemonBuilder) SetSubscriptionACL(typ EventType, allowed ...ServiceId) {
+	if b.acls == nil {
+		b.acls = make(map[EventType]map[ServiceId]bool)
+	}
+	acl := b.acls[typ]
+	if acl == nil {
+		acl = make(map[ServiceId]bool)
+		b.acls[typ] = acl
+	}
+	for _, id := range allowed {
+		acl[id] = true
+	}
+}
+
+// applyACLs removes the subscriptions denied by the access control lists
+// from the subscribers of each event type.
+func applyACLs(log Logger, subs map[EventType][]Service, acls map[EventType]map[ServiceId]bool) {
+	for typ, acl := range acls {
+		svcs, ok := subs[typ]
+		if !ok {
+			continue
+		}
+		allowed := svcs[:0]
+		for _, svc := range svcs {
+			if acl[svc.ID()] {
+				allowed = append(allowed, svc)
+				continue
+			}
+			log.Error("Denied subscription to restricted event type", "service", svc.Name(), "id", svc.ID(), "event_type", typ)
+		}
+		if len(allowed) == 0 {
+			delete(subs, typ)
+		} else {
+			subs[typ] = allowed
+		}
+	}
+}
+
+// restrictedTypes returns the event types with an access control list.
+func (d *ExampleServiceDaemon) restrictedTypes() map[EventType]bool {
+	if len(d.acls) == 0 {
+		return nil
+	}
+	types := make(map[EventType]bool, len(d.acls))
+	for typ := range d.acls {
+		types[typ] = true
+	}
+	return types
+}
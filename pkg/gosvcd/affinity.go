@@ -0,0 +1,96 @@
+package gosvcd
+
+import (
+	"runtime"
+	"sync"
+)
+
+// ThreadAffinity configures the locking of the daemon's long-lived
+// goroutines to OS threads, for latency-sensitive deployments.
+type ThreadAffinity struct {
+	// LockDispatch locks the dispatch goroutines, i.e. the per-type
+	// dispatchers or the workers of the scheduler, to OS threads.
+	LockDispatch bool
+
+	// LockServices are the replicated services whose replicas are run on
+	// goroutines locked to OS threads.
+	LockServices []ServiceId
+
+	// ReserveProcs is the number of GOMAXPROCS left for the unlocked
+	// goroutines. The goroutines beyond GOMAXPROCS-ReserveProcs are not
+	// locked.
+	ReserveProcs int
+
+	// CPUs are the CPUs the locked threads are pinned to, in turn. Pinning
+	// is only supported on Linux. The threads pinned to CPUs are discarded
+	// when the goroutines exit.
+	CPUs []int
+}
+
+// threadLocker locks goroutines to OS threads as configured by the
+// ThreadAffinity.
+type threadLocker struct {
+	mu       sync.Mutex
+	cfg      ThreadAffinity
+	services map[ServiceId]bool
+	locked   int
+	next     int
+}
+
+func newThreadLocker(cfg ThreadAffinity) *threadLocker {
+	l := &threadLocker{cfg: cfg, services: make(map[ServiceId]bool)}
+	for _, id := range cfg.LockServices {
+		l.services[id] = true
+	}
+	return l
+}
+
+// lock locks the calling goroutine to its thread, if allowed by the limit,
+// and returns the function for unlocking it.
+func (d *ExampleServiceDaemon) lockThread(owner string) func() {
+	l := d.threads
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locked >= runtime.GOMAXPROCS(0)-l.cfg.ReserveProcs {
+		d.log.Warn("Not locking goroutine to thread, limit reached", "owner", owner, "locked", l.locked)
+		return func() {}
+	}
+	runtime.LockOSThread()
+	l.locked++
+	pinned := false
+	if len(l.cfg.CPUs) > 0 {
+		cpu := l.cfg.CPUs[l.next%len(l.cfg.CPUs)]
+		l.next++
+		if err := setThreadAffinity(cpu); err != nil {
+			d.log.Warn("Failed to pin thread to CPU", "owner", owner, "cpu", cpu, "error", err)
+		} else {
+			pinned = true
+		}
+	}
+	return func() {
+		l.mu.Lock()
+		l.locked--
+		l.mu.Unlock()
+		// A pinned thread is not returned to the runtime: it exits with
+		// the goroutine.
+		if !pinned {
+			runtime.UnlockOSThread()
+		}
+	}
+}
+
+// lockDispatch locks a dispatch goroutine if configured.
+func (d *ExampleServiceDaemon) lockDispatch(owner string) func() {
+	if d.threads == nil || !d.threads.cfg.LockDispatch {
+		return func() {}
+	}
+	return d.lockThread(owner)
+}
+
+// lockService locks a goroutine of the service if configured.
+func (d *ExampleServiceDaemon) lockService(h *ExampleServiceHandle) func() {
+	if d.threads == nil || !d.threads.services[h.ID()] {
+		return func() {}
+	}
+	return d.lockThread(h.Name())
+}
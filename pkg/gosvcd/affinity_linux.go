@@ -0,0 +1,20 @@
+package gosvcd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// setThreadAffinity pins the calling thread to the CPU.
+func setThreadAffinity(cpu int) error {
+	var mask [1024 / 64]uint64
+	if cpu < 0 || cpu >= len(mask)*64 {
+		return syscall.EINVAL
+	}
+	mask[cpu/64] |= 1 << (uint(cpu) % 64)
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
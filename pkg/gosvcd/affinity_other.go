@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package gosvcd
+
+import "errors"
+
+// setThreadAffinity pins the calling thread to the CPU.
+func setThreadAffinity(cpu int) error {
+	return errors.New("CPU affinity not supported on this platform")
+}
@@ -0,0 +1,109 @@
+package gosvcd
+
+import "sync/atomic"
+
+// DeliveryMode selects the subscribers an event of a type is delivered to,
+// set with the builder's SetDeliveryMode.
+type DeliveryMode int
+
+const (
+	// DeliveryBroadcast delivers each event to all the subscribers. The
+	// default.
+	DeliveryBroadcast DeliveryMode = iota
+
+	// DeliveryRoundRobin delivers each event to one subscriber, taking
+	// turns, turning the event type into a work queue.
+	DeliveryRoundRobin
+
+	// DeliveryLeastLoaded delivers each event to the subscriber with the
+	// fewest events in progress, buffered or held for it, taking turns
+	// among the equally loaded ones. The load only varies for a
+	// ConcurrentHandler, a subscription buffer or a paused service, as
+	// the events of a type are otherwise handled one at a time.
+	DeliveryLeastLoaded
+)
+
+func (m DeliveryMode) String() string {
+	switch m {
+	case DeliveryBroadcast:
+		return "broadcast"
+	case DeliveryRoundRobin:
+		return "round-robin"
+	case DeliveryLeastLoaded:
+		return "least-loaded"
+	}
+	return "unknown"
+}
+
+// SetDeliveryMode sets the delivery mode of the event type. With an
+// anycast mode, DeliveryRoundRobin or DeliveryLeastLoaded, each event is
+// delivered to one of the subscribers that are running or lazy, and not
+// being drained. Directed events are delivered to their target regardless
+// of the mode.
+func (b *ExampleServiceDaemonBuilder) SetDeliveryMode(typ EventType, mode DeliveryMode) {
+	if mode == DeliveryBroadcast {
+		delete(b.delivery, typ)
+		return
+	}
+	if b.delivery == nil {
+		b.delivery = make(map[EventType]DeliveryMode)
+	}
+	b.delivery[typ] = mode
+}
+
+// pick chooses the subscriber of an anycast event. Returns nil if the
+// event is delivered to all the subscribers or none is eligible, in which
+// case the event is offered to all of them.
+func (q *dispatcher) pick(subs []*ExampleServiceHandle, ev *ExampleEvent) *ExampleServiceHandle {
+	if q.delivery == DeliveryBroadcast || ev.directed || len(subs) == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint64(&q.turn, 1) % uint64(len(subs)))
+	var (
+		best *ExampleServiceHandle
+		load int
+	)
+	for i := range subs {
+		h := subs[(start+i)%len(subs)]
+		if !h.eligible(ev) {
+			continue
+		}
+		if q.delivery == DeliveryRoundRobin {
+			return h
+		}
+		if l := h.load() + q.buffered(h.ID()); best == nil || l < load {
+			best, load = h, l
+		}
+	}
+	return best
+}
+
+// eligible returns true if the service can take an anycast event.
+func (h *ExampleServiceHandle) eligible(ev *ExampleEvent) bool {
+	if cutoff := atomic.LoadUint64(&h.drainAfter); cutoff != 0 && ev.routed > cutoff {
+		return false
+	}
+	if atomic.LoadInt32(&h.quarantine.paused) != 0 {
+		h.bookMu.Lock()
+		drop := h.quarantine.mode == PauseDrop
+		h.bookMu.Unlock()
+		if drop {
+			return false
+		}
+	}
+	return h.getState() == ServiceRunning || h.isDormant()
+}
+
+// load returns the number of events in progress or held for the service.
+func (h *ExampleServiceHandle) load() int {
+	n := 0
+	if h.workers != nil {
+		n += len(h.workers)
+	}
+	if atomic.LoadInt32(&h.quarantine.paused) != 0 {
+		h.bookMu.Lock()
+		n += len(h.quarantine.held)
+		h.bookMu.Unlock()
+	}
+	return n
+}
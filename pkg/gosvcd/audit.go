@@ -0,0 +1,135 @@
+package gosvcd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditSummaryLen is the maximum length of the payload summary in an
+// audit record.
+const auditSummaryLen = 128
+
+// AuditLog is an append-only log of the dispatched events. Each event is
+// written as a line of JSON with the event type, source, timestamp and a
+// summary and hash of the payload. When the log grows beyond its maximum
+// size it is rotated: the current file is renamed with a ".1" suffix, the
+// previous ".1" becomes ".2" and so on, keeping at most 'maxBackups' old
+// files.
+type AuditLog struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Seq     uint64    `json:"seq"`
+	Type    EventType `json:"type"`
+	Source  ServiceId `json:"source"`
+	Summary string    `json:"summary"`
+	SHA256  string    `json:"sha256"`
+}
+
+// OpenAuditLog opens the audit log at the given path for appending. If
+// 'maxSize' is zero the log is never rotated.
+func OpenAuditLog(path string, maxSize int64, maxBackups int) (*AuditLog, error) {
+	a := &AuditLog{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f = f
+	a.size = info.Size()
+	return nil
+}
+
+// Record appends the event to the log.
+func (a *AuditLog) Record(ev Event) error {
+	rec := auditRecord{
+		Time:   ev.Timestamp(),
+		Seq:    ev.Sequence(),
+		Type:   ev.EventType(),
+		Source: ev.ServiceId(),
+	}
+	payload, err := json.Marshal(ev.Data())
+	if err != nil {
+		payload = []byte(fmt.Sprintf("%#v", ev.Data()))
+	}
+	sum := sha256.Sum256(payload)
+	rec.SHA256 = hex.EncodeToString(sum[:])
+	rec.Summary = string(payload)
+	if len(rec.Summary) > auditSummaryLen {
+		rec.Summary = rec.Summary[:auditSummaryLen] + "..."
+	}
+	line, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return fmt.Errorf("audit log closed")
+	}
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+func (a *AuditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	a.f = nil
+	if a.maxBackups > 0 {
+		for i := a.maxBackups - 1; i > 0; i-- {
+			err := os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(a.path); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
@@ -0,0 +1,102 @@
+package gosvcd
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCircuitOpen is the error for a delivery to a service whose circuit
+// breaker is open. The deliveries to a RetryHandler are retried as if
+// the handler had failed.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitBreakerPolicy configures the per-service circuit breakers. After
+// Failures consecutive failed handler invocations (panics or errors from
+// a RetryHandler), the events are not delivered to the service for the
+// CoolDown period. The first delivery after the cool-down is a trial:
+// its success closes the circuit and its failure opens it again.
+type CircuitBreakerPolicy struct {
+	Failures int
+	CoolDown time.Duration
+}
+
+// circuitBreaker is the state of the circuit breaker of a service,
+// protected by the service's 'bookMu'.
+type circuitBreaker struct {
+	// failures is the number of consecutive failures.
+	failures int
+
+	// openUntil is the end of the cool-down when the circuit is open,
+	// or zero if the circuit is closed.
+	openUntil time.Time
+}
+
+// allow returns true if the event can be delivered.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	return b.openUntil.IsZero() || !now.Before(b.openUntil)
+}
+
+// record records the outcome of a delivery and returns whether the
+// circuit opened or closed.
+func (b *circuitBreaker) record(p CircuitBreakerPolicy, failed bool, now time.Time) (opened, closed bool) {
+	if !failed {
+		closed = !b.openUntil.IsZero()
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return false, closed
+	}
+	b.failures++
+	if !b.openUntil.IsZero() || b.failures >= p.Failures {
+		b.openUntil = now.Add(p.CoolDown)
+		return true, false
+	}
+	return false, false
+}
+
+//
+// Circuit open
+//
+
+var CircuitOpen_Type = EventType("CircuitOpen")
+
+// CircuitOpen is emitted when the circuit breaker of a service opens.
+// The events of the service's subscriptions are not delivered to it until
+// the cool-down has passed.
+type CircuitOpen struct {
+	Service  ServiceId
+	Failures int
+	Until    time.Time
+}
+
+// checkCircuit returns true if the circuit breaker of the service allows
+// the delivery of the event. Must be called with 'bookMu' held.
+func (d *ExampleServiceDaemon) checkCircuit(h *ExampleServiceHandle, typ EventType) bool {
+	if d.breaker.Failures <= 0 || h.breaker.allow(d.clock.Now()) {
+		return true
+	}
+	d.metrics.eventDropped(DropCircuitOpen, typ)
+	return false
+}
+
+// recordCircuit updates the circuit breaker of the service with the
+// outcome of a delivery. Must be called with 'bookMu' held.
+func (d *ExampleServiceDaemon) recordCircuit(h *ExampleServiceHandle, failed bool) {
+	if d.breaker.Failures <= 0 {
+		return
+	}
+	opened, closed := h.breaker.record(d.breaker, failed, d.clock.Now())
+	switch {
+	case opened:
+		d.log.Warn("Circuit breaker opened",
+			"service", h.Name(),
+			"failures", h.breaker.failures,
+			"until", h.breaker.openUntil)
+		d.emitAsync(CircuitOpen_Type, &CircuitOpen{
+			Service:  h.ID(),
+			Failures: h.breaker.failures,
+			Until:    h.breaker.openUntil,
+		})
+	case closed:
+		d.log.Info("Circuit breaker closed", "service", h.Name())
+	}
+}
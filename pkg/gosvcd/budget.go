@@ -0,0 +1,221 @@
+package gosvcd
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBudgetInterval is the default interval of checking the resource
+// budgets.
+const DefaultBudgetInterval = time.Second
+
+// Resources limited by a ResourceBudget.
+const (
+	BudgetGoroutines   = "goroutines"
+	BudgetQueuedEvents = "queued_events"
+	BudgetCPUTime      = "cpu_time"
+)
+
+// ResourceBudget limits the resources used by a service. The zero limits
+// are not enforced.
+type ResourceBudget struct {
+	// Goroutines limits the goroutines started with the handle's Go
+	// that run at once. Go does not start a goroutine beyond the limit.
+	Goroutines int
+
+	// QueuedEvents limits the events queued for the service: the events
+	// held while it is paused and the events waiting in the dispatch
+	// queues and subscription buffers of the types it subscribes to.
+	QueuedEvents int
+
+	// CPUTime limits the CPU time of the event handlers of the service
+	// in each Interval. The CPU time is measured on Linux; elsewhere
+	// the running time of the handlers is counted instead.
+	CPUTime time.Duration
+
+	// Interval is the interval of checking the queued events and the
+	// CPU time. Defaults to DefaultBudgetInterval.
+	Interval time.Duration
+
+	// Quarantine pauses the service with Mode when it exceeds the
+	// budget, until resumed with ResumeService.
+	Quarantine bool
+	Mode       PauseMode
+}
+
+// budgetState is the resource usage of a service with a budget.
+type budgetState struct {
+	cfg ResourceBudget
+
+	// goroutines is the number of running goroutines started with Go.
+	goroutines int32
+
+	// cpu is the CPU time of the handlers in nanoseconds in the current
+	// interval.
+	cpu int64
+
+	// goOver and queueOver are one while the goroutines and the queued
+	// events are over the budget, to report it once.
+	goOver    int32
+	queueOver int32
+}
+
+//
+// Budget exceeded
+//
+
+var BudgetExceeded_Type = EventType("BudgetExceeded")
+
+// BudgetExceeded is emitted when a service exceeds its resource budget.
+// Usage and Limit are in the unit of the Resource: a count, or
+// nanoseconds of CPU time in an interval.
+type BudgetExceeded struct {
+	Service  ServiceId
+	Resource string
+	Usage    int64
+	Limit    int64
+}
+
+// SetResourceBudget sets the resource budget of the service.
+func (b *ExampleServiceDaemonBuilder) SetResourceBudget(id ServiceId, budget ResourceBudget) {
+	if budget.Interval <= 0 {
+		budget.Interval = DefaultBudgetInterval
+	}
+	if b.budgets == nil {
+		b.budgets = make(map[ServiceId]ResourceBudget)
+	}
+	b.budgets[id] = budget
+}
+
+// newBudgetState returns the usage state of the service's budget, or nil
+// if the service has no budget.
+func (d *ExampleServiceDaemon) newBudgetState(id ServiceId) *budgetState {
+	cfg, ok := d.budgets[id]
+	if !ok {
+		return nil
+	}
+	return &budgetState{cfg: cfg}
+}
+
+// startGoroutine accounts for a goroutine started with the handle's Go.
+// Returns false if the goroutine would exceed the budget.
+func (d *ExampleServiceDaemon) startGoroutine(h *ExampleServiceHandle) bool {
+	b := h.budget
+	if b == nil || b.cfg.Goroutines <= 0 {
+		return true
+	}
+	n := atomic.AddInt32(&b.goroutines, 1)
+	if int(n) <= b.cfg.Goroutines {
+		atomic.StoreInt32(&b.goOver, 0)
+		return true
+	}
+	atomic.AddInt32(&b.goroutines, -1)
+	if atomic.CompareAndSwapInt32(&b.goOver, 0, 1) {
+		d.log.Error("Goroutine not started, budget exceeded", "service", h.Name(), "limit", b.cfg.Goroutines)
+		d.exceeded(h, BudgetGoroutines, int64(n), int64(b.cfg.Goroutines))
+	}
+	return false
+}
+
+// goroutineDone accounts for the exit of a goroutine started with Go.
+func (h *ExampleServiceHandle) goroutineDone() {
+	if b := h.budget; b != nil && b.cfg.Goroutines > 0 {
+		atomic.AddInt32(&b.goroutines, -1)
+	}
+}
+
+// measuresCPU returns true if the CPU time of the handlers of the service
+// is measured.
+func (h *ExampleServiceHandle) measuresCPU() bool {
+	return h.budget != nil && h.budget.cfg.CPUTime > 0
+}
+
+// measureCPU starts measuring the CPU time of a handler on the calling
+// goroutine. The returned function adds it to the usage of the service.
+func (h *ExampleServiceHandle) measureCPU() func() {
+	runtime.LockOSThread()
+	t0, ok := threadCPUTime()
+	start := time.Now()
+	return func() {
+		var used time.Duration
+		if t1, ok1 := threadCPUTime(); ok && ok1 {
+			used = t1 - t0
+		} else {
+			used = time.Since(start)
+		}
+		runtime.UnlockOSThread()
+		atomic.AddInt64(&h.budget.cpu, int64(used))
+	}
+}
+
+// startBudgets starts checking the queued events and the CPU time of the
+// services with a budget.
+func (d *ExampleServiceDaemon) startBudgets() {
+	for id, cfg := range d.budgets {
+		if cfg.QueuedEvents <= 0 && cfg.CPUTime <= 0 {
+			continue
+		}
+		id, interval := id, cfg.Interval
+		d.spawn(func() { d.checkBudgetLoop(id, interval) })
+	}
+}
+
+func (d *ExampleServiceDaemon) checkBudgetLoop(id ServiceId, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		if h, ok := d.handle(id); ok && h.budget != nil {
+			d.checkBudget(h)
+		}
+	}
+}
+
+// checkBudget checks the queued events and the CPU time of the interval
+// against the budget of the service.
+func (d *ExampleServiceDaemon) checkBudget(h *ExampleServiceHandle) {
+	b := h.budget
+	if limit := b.cfg.CPUTime; limit > 0 {
+		used := time.Duration(atomic.SwapInt64(&b.cpu, 0))
+		if used > limit {
+			d.log.Warn("CPU time budget exceeded", "service", h.Name(), "cpu_time", used, "limit", limit)
+			d.exceeded(h, BudgetCPUTime, int64(used), int64(limit))
+		}
+	}
+	if limit := b.cfg.QueuedEvents; limit > 0 {
+		queued := 0
+		for _, typ := range h.Subscriptions() {
+			queued += d.queueDepth(typ) + d.subscriptionDepth(h.ID(), typ)
+		}
+		h.bookMu.Lock()
+		queued += len(h.quarantine.held)
+		h.bookMu.Unlock()
+		if queued <= limit {
+			atomic.StoreInt32(&b.queueOver, 0)
+		} else if atomic.CompareAndSwapInt32(&b.queueOver, 0, 1) {
+			d.log.Warn("Queued events budget exceeded", "service", h.Name(), "queued", queued, "limit", limit)
+			d.exceeded(h, BudgetQueuedEvents, int64(queued), int64(limit))
+		}
+	}
+}
+
+// exceeded reports the service exceeding its budget, and quarantines it
+// if configured.
+func (d *ExampleServiceDaemon) exceeded(h *ExampleServiceHandle, resource string, usage, limit int64) {
+	d.emitAsync(BudgetExceeded_Type, &BudgetExceeded{
+		Service:  h.ID(),
+		Resource: resource,
+		Usage:    usage,
+		Limit:    limit,
+	})
+	if h.budget.cfg.Quarantine {
+		h.bookMu.Lock()
+		d.pauseService(h, h.budget.cfg.Mode, 0)
+		h.bookMu.Unlock()
+	}
+}
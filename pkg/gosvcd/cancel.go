@@ -0,0 +1,120 @@
+package gosvcd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// EventId identifies an event emitted with a context from WithEventId, for
+// cancelling it with Cancel.
+type EventId uint64
+
+var lastEventId uint64
+
+type eventIdCtx struct{}
+
+// WithEventId returns a context for emitting a cancellable event, and the
+// id for cancelling it. The id is not inherited by the events emitted with
+// the context of the event.
+func WithEventId(ctx context.Context) (context.Context, EventId) {
+	id := EventId(atomic.AddUint64(&lastEventId, 1))
+	return context.WithValue(ctx, eventIdCtx{}, id), id
+}
+
+// eventId returns the event id in the context and the context without it.
+func eventId(ctx context.Context) (EventId, context.Context) {
+	if ctx == nil {
+		return 0, ctx
+	}
+	id, _ := ctx.Value(eventIdCtx{}).(EventId)
+	if id == 0 {
+		return 0, ctx
+	}
+	return id, context.WithValue(ctx, eventIdCtx{}, EventId(0))
+}
+
+// cancelToken is shared by a cancellable event and its converted copies.
+type cancelToken struct {
+	id EventId
+
+	// refs counts the router and the queues holding the event. The token
+	// is forgotten when it drops to zero.
+	refs int32
+
+	cancelled int32
+}
+
+func (t *cancelToken) isCancelled() bool {
+	return t != nil && atomic.LoadInt32(&t.cancelled) != 0
+}
+
+// cancelSet holds the tokens of the cancellable events that have not been
+// dispatched.
+type cancelSet struct {
+	mu     sync.Mutex
+	tokens map[EventId]*cancelToken
+}
+
+func (s *cancelSet) add(id EventId) *cancelToken {
+	t := &cancelToken{id: id, refs: 1}
+	s.mu.Lock()
+	if s.tokens == nil {
+		s.tokens = make(map[EventId]*cancelToken)
+	}
+	s.tokens[id] = t
+	s.mu.Unlock()
+	return t
+}
+
+func (s *cancelSet) retain(t *cancelToken) {
+	if t != nil {
+		atomic.AddInt32(&t.refs, 1)
+	}
+}
+
+func (s *cancelSet) release(t *cancelToken) {
+	if t == nil || atomic.AddInt32(&t.refs, -1) != 0 {
+		return
+	}
+	s.mu.Lock()
+	delete(s.tokens, t.id)
+	s.mu.Unlock()
+}
+
+// cancel marks the event as cancelled. Returns false if the event is
+// unknown or has already been dispatched.
+func (s *cancelSet) cancel(id EventId) bool {
+	s.mu.Lock()
+	t, ok := s.tokens[id]
+	delete(s.tokens, id)
+	s.mu.Unlock()
+	return ok && atomic.CompareAndSwapInt32(&t.cancelled, 0, 1)
+}
+
+// Cancel cancels an event emitted with a context from WithEventId. The
+// event is not delivered to the subscribers it has not yet been
+// dispatched to, and is purged from the queues as they reach it. Returns
+// false if the event has already been dispatched, or the id is unknown.
+func (d *ExampleServiceDaemon) Cancel(id EventId) bool {
+	if !d.cancels.cancel(id) {
+		return false
+	}
+	d.log.Debug("Event cancelled", "id", id)
+	return true
+}
+
+// Cancel cancels an event emitted by the service. See
+// ExampleServiceDaemon.Cancel.
+func (h *ExampleServiceHandle) Cancel(id EventId) bool {
+	return h.d.Cancel(id)
+}
+
+// cancelled returns true if the event has been cancelled, dropping it.
+func (d *ExampleServiceDaemon) cancelled(ev *ExampleEvent) bool {
+	if !ev.cancel.isCancelled() {
+		return false
+	}
+	d.metrics.eventDropped(DropCancelled, ev.eventType)
+	return true
+}
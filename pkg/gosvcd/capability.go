@@ -0,0 +1,57 @@
+package gosvcd
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotPermitted is matched by the errors returned from emitting an event
+// of a type the service is not permitted to emit.
+var ErrNotPermitted = errors.New("emit not permitted")
+
+// PermissionError is returned when a service emits an event of a type
+// outside of its emit capabilities.
+type PermissionError struct {
+	Source    ServiceId
+	EventType EventType
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("%s: service %d: %s", ErrNotPermitted, e.Source, e.EventType)
+}
+
+func (e *PermissionError) Is(target error) bool { return target == ErrNotPermitted }
+
+// SetEmitCapabilities restricts the event types the service may emit to
+// the given types. Emitting an event of another type fails with a
+// *PermissionError and the event is dropped. Calling it again adds to the
+// types. Services without capabilities may emit any type.
+//
+// The capabilities are checked for the events emitted with the handle of
+// the service, and for the events emitted with DaemonServiceId as the
+// source if set for it.
+func (b *ExampleServiceDaemonBuilder) SetEmitCapabilities(id ServiceId, types ...EventType) {
+	if b.emitCaps == nil {
+		b.emitCaps = make(map[ServiceId]map[EventType]bool)
+	}
+	caps := b.emitCaps[id]
+	if caps == nil {
+		caps = make(map[EventType]bool)
+		b.emitCaps[id] = caps
+	}
+	for _, typ := range types {
+		caps[typ] = true
+	}
+}
+
+// checkEmit checks the event type against the emit capabilities of the
+// source.
+func (d *ExampleServiceDaemon) checkEmit(source ServiceId, eventType EventType) error {
+	caps, ok := d.emitCaps[source]
+	if !ok || caps[eventType] {
+		return nil
+	}
+	d.metrics.eventDropped(DropNotPermitted, eventType)
+	d.log.Warn("Rejected event outside of emit capabilities", "source", d.serviceName(source), "event_type", eventType)
+	return &PermissionError{Source: source, EventType: eventType}
+}
@@ -0,0 +1,175 @@
+package gosvcd
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultChaosMaxDelay is the default maximum delay of the delayed and
+// reordered events.
+const DefaultChaosMaxDelay = 10 * time.Millisecond
+
+// ChaosConfig configures the injection of faults into the delivery of
+// events, for testing that the services cope with them. Each probability
+// is between 0 and 1 and is drawn for each delivery to a selected
+// service.
+type ChaosConfig struct {
+	// Seed seeds the random number generator. The same seed makes the
+	// same decisions for the same sequence of deliveries, which is
+	// deterministic with a single dispatcher, e.g. with one subscribed
+	// event type. Zero picks a seed, which is logged so that the run can
+	// be repeated.
+	Seed int64
+
+	// Services are the services whose deliveries are affected. All
+	// services are affected if empty.
+	Services []ServiceId
+
+	// Delay delays the delivery by up to MaxDelay.
+	Delay float64
+
+	// Drop drops the event.
+	Drop float64
+
+	// Duplicate delivers the event twice.
+	Duplicate float64
+
+	// Reorder delivers the event after the next event to the service,
+	// or after MaxDelay if there is none.
+	Reorder float64
+
+	// Panic makes the handler panic instead of handling the event.
+	Panic float64
+
+	// MaxDelay defaults to DefaultChaosMaxDelay.
+	MaxDelay time.Duration
+}
+
+// ChaosPanic is the value of the panics injected into the handlers.
+type ChaosPanic struct {
+	Service   ServiceId
+	EventType EventType
+}
+
+func (p ChaosPanic) String() string {
+	return fmt.Sprintf("chaos: injected panic in service %d handling %s", p.Service, p.EventType)
+}
+
+// SetChaos enables the injection of faults into the delivery of events.
+// Meant for tests only.
+func (b *ExampleServiceDaemonBuilder) SetChaos(cfg ChaosConfig) {
+	b.chaos = &cfg
+}
+
+// chaos injects the faults configured by ChaosConfig.
+type chaos struct {
+	cfg      ChaosConfig
+	services map[ServiceId]bool
+
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	// reordered is the event held back for each service.
+	reordered map[*ExampleServiceHandle]*ExampleEvent
+}
+
+func newChaos(log Logger, cfg ChaosConfig) *chaos {
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultChaosMaxDelay
+	}
+	log.Warn("Chaos mode enabled", "seed", cfg.Seed)
+	c := &chaos{
+		cfg:       cfg,
+		rng:       rand.New(rand.NewSource(cfg.Seed)),
+		reordered: make(map[*ExampleServiceHandle]*ExampleEvent),
+	}
+	if len(cfg.Services) > 0 {
+		c.services = make(map[ServiceId]bool, len(cfg.Services))
+		for _, id := range cfg.Services {
+			c.services[id] = true
+		}
+	}
+	return c
+}
+
+func (c *chaos) selected(h *ExampleServiceHandle) bool {
+	return c.services == nil || c.services[h.ID()]
+}
+
+// chance draws whether a fault with the probability happens.
+func (c *chaos) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64() < p
+}
+
+func (c *chaos) delay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Duration(c.rng.Int63n(int64(c.cfg.MaxDelay)) + 1)
+}
+
+// deliver delivers the event to the service with the faults drawn for
+// the delivery.
+func (c *chaos) deliver(d *ExampleServiceDaemon, h *ExampleServiceHandle, ev *ExampleEvent) {
+	if c.chance(c.cfg.Drop) {
+		d.metrics.eventDropped(DropChaos, ev.eventType)
+		return
+	}
+	if c.chance(c.cfg.Delay) {
+		time.Sleep(c.delay())
+	}
+	if c.chance(c.cfg.Reorder) && c.holdBack(d, h, ev) {
+		return
+	}
+	d.deliverEvent(h, ev)
+	if c.chance(c.cfg.Duplicate) {
+		d.deliverEvent(h, ev)
+	}
+	c.flush(d, h)
+}
+
+// holdBack holds the event to deliver it after the next one. Returns false
+// if an event is already held for the service.
+func (c *chaos) holdBack(d *ExampleServiceDaemon, h *ExampleServiceHandle, ev *ExampleEvent) bool {
+	c.mu.Lock()
+	if _, ok := c.reordered[h]; ok {
+		c.mu.Unlock()
+		return false
+	}
+	atomic.AddInt64(&d.pending, 1)
+	ev.retain()
+	d.cancels.retain(ev.cancel)
+	c.reordered[h] = ev
+	c.mu.Unlock()
+	time.AfterFunc(c.cfg.MaxDelay, func() { c.flush(d, h) })
+	return true
+}
+
+// flush delivers the event held back for the service, if any.
+func (c *chaos) flush(d *ExampleServiceDaemon, h *ExampleServiceHandle) {
+	c.mu.Lock()
+	ev, ok := c.reordered[h]
+	delete(c.reordered, h)
+	c.mu.Unlock()
+	if ok {
+		d.deliverEvent(h, ev)
+		d.unhold(ev)
+	}
+}
+
+// injectPanic panics in the handler of the service if drawn.
+func (c *chaos) injectPanic(h *ExampleServiceHandle, ev *ExampleEvent) {
+	if c.selected(h) && c.chance(c.cfg.Panic) {
+		panic(ChaosPanic{Service: h.ID(), EventType: ev.eventType})
+	}
+}
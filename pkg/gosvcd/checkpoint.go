@@ -0,0 +1,237 @@
+package gosvcd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointVersion is the version of the checkpoint format.
+const checkpointVersion = 1
+
+// Checkpoint is the state of a daemon, written with
+// ExampleServiceDaemon.Checkpoint, from which a new process can resume
+// with SetRestore: the registered services, the snapshots of the services
+// implementing Snapshotter and the events that had not been handled.
+//
+// The events are read from the journal, so without one the checkpoint
+// has none. As with the replay of the journal, the events are delivered
+// at least once: an event handled after its snapshot was taken but before
+// the journal was checkpointed is delivered again after the restore.
+type Checkpoint struct {
+	Version  int                 `json:"version"`
+	Time     time.Time           `json:"time"`
+	Services []CheckpointService `json:"services"`
+
+	// JournalSeq is the sequence number of the last journaled event.
+	JournalSeq uint64 `json:"journal_seq,omitempty"`
+
+	// Events are the unhandled events encoded with MarshalEvent.
+	Events []json.RawMessage `json:"events,omitempty"`
+}
+
+// CheckpointService is the registration of a service in a checkpoint.
+type CheckpointService struct {
+	ID            ServiceId   `json:"id"`
+	Name          string      `json:"name"`
+	Dependencies  []ServiceId `json:"dependencies,omitempty"`
+	Subscriptions []EventType `json:"subscriptions,omitempty"`
+	State         string      `json:"state"`
+	Snapshot      []byte      `json:"snapshot,omitempty"`
+}
+
+// ReadCheckpoint decodes a checkpoint written with
+// ExampleServiceDaemon.Checkpoint.
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	var cp Checkpoint
+	if err := json.NewDecoder(r).Decode(&cp); err != nil {
+		return nil, fmt.Errorf("decode checkpoint: %w", err)
+	}
+	if cp.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", cp.Version)
+	}
+	return &cp, nil
+}
+
+// LoadCheckpoint reads the checkpoint from the file.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCheckpoint(f)
+}
+
+// SetRestore makes the daemon resume from the checkpoint: the snapshots
+// of the checkpoint are restored in place of the ones in the snapshot
+// store when the services are first initialized, and the events of the
+// checkpoint are emitted once the services have been initialized. The
+// services must be registered as they were when the checkpoint was
+// taken; the differences are logged. The daemon should not be given the
+// journal of the checkpointed daemon, as its events would be replayed
+// twice.
+func (b *ExampleServiceDaemonBuilder) SetRestore(cp *Checkpoint) {
+	b.restore = cp
+}
+
+// SetCheckpointDir sets the directory in which CheckpointNamed writes the
+// checkpoints, e.g. when requested over the control socket. Without one,
+// the daemon cannot be checkpointed by name.
+func (b *ExampleServiceDaemonBuilder) SetCheckpointDir(dir string) {
+	b.checkpointDir = dir
+}
+
+// Checkpoint writes the state of the daemon to 'w', see Checkpoint. The
+// snapshots are taken as by the periodic snapshots.
+func (d *ExampleServiceDaemon) Checkpoint(w io.Writer) error {
+	if d.isStopping() {
+		return ErrDaemonStopped
+	}
+	cp := &Checkpoint{Version: checkpointVersion, Time: d.clock.Now()}
+	for _, h := range d.orderedHandles() {
+		svc := CheckpointService{
+			ID:            h.ID(),
+			Name:          h.Name(),
+			Dependencies:  h.Dependencies(),
+			Subscriptions: h.Subscriptions(),
+		}
+		h.mu.Lock()
+		svc.State = h.getState().String()
+		if _, ok := h.Service.(Snapshotter); ok && h.getState() == ServiceRunning {
+			data, err := h.snapshot()
+			if err != nil {
+				h.mu.Unlock()
+				return fmt.Errorf("snapshot of service %s: %w", h.Name(), err)
+			}
+			svc.Snapshot = data
+		}
+		h.mu.Unlock()
+		cp.Services = append(cp.Services, svc)
+	}
+	if d.journal != nil {
+		cp.JournalSeq = d.journal.LastSeq()
+		err := d.journal.Replay(d.journal.Checkpointed(), func(seq uint64, ev Event) error {
+			if seq > cp.JournalSeq {
+				return nil
+			}
+			data, err := MarshalEvent(ev)
+			if err == nil {
+				cp.Events = append(cp.Events, data)
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("read journal: %w", err)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(cp); err != nil {
+		return err
+	}
+	d.log.Info("Checkpointed daemon", "services", len(cp.Services), "events", len(cp.Events))
+	return nil
+}
+
+// CheckpointFile writes the checkpoint of the daemon to a temporary file
+// and renames it to 'path', so that a crash does not leave a partial
+// checkpoint.
+func (d *ExampleServiceDaemon) CheckpointFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".checkpoint")
+	if err != nil {
+		return err
+	}
+	err = d.Checkpoint(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// CheckpointNamed writes the checkpoint of the daemon to the file of the
+// name in the checkpoint directory, see SetCheckpointDir. The name must
+// not contain a directory.
+func (d *ExampleServiceDaemon) CheckpointNamed(name string) error {
+	if d.checkpointDir == "" {
+		return errors.New("no checkpoint directory")
+	}
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return fmt.Errorf("invalid checkpoint name %q", name)
+	}
+	return d.CheckpointFile(filepath.Join(d.checkpointDir, name))
+}
+
+// checkRestore logs the differences between the registered services and
+// the services of the restored checkpoint.
+func (d *ExampleServiceDaemon) checkRestore() {
+	cp := d.restore
+	d.log.Info("Restoring checkpoint", "time", cp.Time, "services", len(cp.Services), "events", len(cp.Events))
+	registered := make(map[ServiceId]bool, len(cp.Services))
+	for _, svc := range cp.Services {
+		h, ok := d.handle(svc.ID)
+		if !ok {
+			d.log.Warn("Checkpointed service not registered", "service", svc.Name, "id", svc.ID)
+			continue
+		}
+		registered[svc.ID] = true
+		if !sameTypes(svc.Subscriptions, h.Subscriptions()) {
+			d.log.Warn("Checkpointed service has different subscriptions", "service", svc.Name, "id", svc.ID)
+		}
+	}
+	for _, h := range d.orderedHandles() {
+		if !registered[h.ID()] {
+			d.log.Warn("Registered service not in checkpoint", "service", h.Name(), "id", h.ID())
+		}
+	}
+}
+
+// restoredSnapshot returns the checkpointed snapshot of the service, once.
+func (d *ExampleServiceDaemon) restoredSnapshot(id ServiceId) ([]byte, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.restore == nil {
+		return nil, false
+	}
+	for i := range d.restore.Services {
+		svc := &d.restore.Services[i]
+		if svc.ID == id && svc.Snapshot != nil {
+			data := svc.Snapshot
+			svc.Snapshot = nil
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// restoreEvents emits the events of the restored checkpoint.
+func (d *ExampleServiceDaemon) restoreEvents() {
+	n := 0
+	for _, data := range d.restore.Events {
+		ev, err := UnmarshalEvent(data)
+		if err != nil {
+			d.log.Error("Failed to decode checkpointed event", "error", err)
+			continue
+		}
+		d.route(ev.(*ExampleEvent))
+		n++
+	}
+	if n > 0 {
+		d.log.Info("Restored checkpointed events", "events", n)
+	}
+	d.mu.Lock()
+	d.restore.Events = nil
+	d.mu.Unlock()
+}
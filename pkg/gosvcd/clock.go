@@ -0,0 +1,18 @@
+package gosvcd
+
+import "time"
+
+// Clock is the source of the time of the daemon: the timestamps of the
+// events, their expiry, the deduplication windows, the circuit breakers,
+// the restart intensities of the supervisors and the handler latencies.
+// The periodic checks, such as the stall detection, run on the real time.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the real time, the default clock.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
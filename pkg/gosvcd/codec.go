@@ -0,0 +1,301 @@
+package gosvcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Codec encodes and decodes event payloads for the bridges and the
+// persistence layers.
+type Codec interface {
+	// Name identifies the encoding, e.g. "json" or "protobuf". It is
+	// carried alongside the encoded payload.
+	Name() string
+
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes the data into the value pointed to by 'v'.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes payloads with encoding/json. It is used for the event
+// types that have not been registered with another codec.
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return "json" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// ProtobufCodec encodes payloads that are protobuf messages with Marshal and
+// Unmarshal methods, as generated by e.g. gogoproto. The messages generated
+// by google.golang.org/protobuf have no such methods: use the codec of
+// package protocodec for them.
+var ProtobufCodec = NewProtobufCodec(
+	func(v interface{}) ([]byte, error) {
+		m, ok := v.(interface{ Marshal() ([]byte, error) })
+		if !ok {
+			return nil, fmt.Errorf("%T is not a protobuf message", v)
+		}
+		return m.Marshal()
+	},
+	func(data []byte, v interface{}) error {
+		m, ok := v.(interface{ Unmarshal([]byte) error })
+		if !ok {
+			return fmt.Errorf("%T is not a protobuf message", v)
+		}
+		return m.Unmarshal(data)
+	},
+)
+
+// NewProtobufCodec returns the "protobuf" codec using the given functions
+// to marshal and unmarshal the messages.
+func NewProtobufCodec(marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Codec {
+	return &funcCodec{name: "protobuf", marshal: marshal, unmarshal: unmarshal}
+}
+
+type funcCodec struct {
+	name      string
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+func (c *funcCodec) Name() string                               { return c.name }
+func (c *funcCodec) Marshal(v interface{}) ([]byte, error)      { return c.marshal(v) }
+func (c *funcCodec) Unmarshal(data []byte, v interface{}) error { return c.unmarshal(data, v) }
+
+// ErrUnknownEncoding is returned when decoding a payload with an encoding
+// for which no codec has been registered.
+var ErrUnknownEncoding = errors.New("unknown payload encoding")
+
+// PayloadRegistry maps event types to their payload types and codecs, so
+// that the payloads can be encoded with a stable wire format and decoded
+// back into typed values.
+type PayloadRegistry struct {
+	mu     sync.RWMutex
+	types  map[EventType]payloadType
+	codecs map[string]Codec
+	def    Codec
+}
+
+type payloadType struct {
+	typ   reflect.Type
+	codec Codec
+}
+
+// NewPayloadRegistry returns an empty registry.
+func NewPayloadRegistry() *PayloadRegistry {
+	return &PayloadRegistry{
+		types:  map[EventType]payloadType{},
+		codecs: map[string]Codec{JSONCodec.Name(): JSONCodec},
+		def:    JSONCodec,
+	}
+}
+
+// Payloads is the default registry used by the bridges and the
+// persistence layers.
+var Payloads = NewPayloadRegistry()
+
+// RegisterPayload registers the payload type and codec of the event type
+// in the default registry.
+func RegisterPayload(typ EventType, prototype interface{}, codec Codec) {
+	Payloads.Register(typ, prototype, codec)
+}
+
+// SetDefault sets the codec used for the event types registered without
+// a codec and for the unregistered ones, JSONCodec by default. Payloads
+// encoded with the previous default can still be decoded.
+func (r *PayloadRegistry) SetDefault(codec Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for typ, pt := range r.types {
+		if pt.codec == r.def {
+			r.types[typ] = payloadType{pt.typ, codec}
+		}
+	}
+	r.def = codec
+	r.codecs[codec.Name()] = codec
+}
+
+// Register registers the payload type of the event type, given as an
+// example value, e.g. &ExSomeEvent{}, and the codec for it. A nil codec
+// registers the type with the default codec.
+func (r *PayloadRegistry) Register(typ EventType, prototype interface{}, codec Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if codec == nil {
+		codec = r.def
+	}
+	r.types[typ] = payloadType{reflect.TypeOf(prototype), codec}
+	r.codecs[codec.Name()] = codec
+}
+
+// Registered returns true if the payload type of the event type is
+// registered.
+func (r *PayloadRegistry) Registered(typ EventType) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.types[typ]
+	return ok
+}
+
+// Codec returns the codec of the event type.
+func (r *PayloadRegistry) Codec(typ EventType) Codec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if pt, ok := r.types[typ]; ok {
+		return pt.codec
+	}
+	return r.def
+}
+
+// Encode encodes the payload with the codec of the event type and returns
+// the name of the encoding with the data.
+func (r *PayloadRegistry) Encode(typ EventType, v interface{}) (string, []byte, error) {
+	codec := r.Codec(typ)
+	data, err := codec.Marshal(v)
+	return codec.Name(), data, err
+}
+
+// Decode decodes the payload of the event type. If the event type has been
+// registered, the payload is decoded into a new value of the registered
+// type. Otherwise the payload is decoded into a generic value, e.g.
+// map[string]interface{}, if the codec supports it. An empty encoding
+// stands for the codec of the event type.
+func (r *PayloadRegistry) Decode(typ EventType, encoding string, data []byte) (interface{}, error) {
+	r.mu.RLock()
+	pt, registered := r.types[typ]
+	codec, ok := r.codecs[encoding]
+	r.mu.RUnlock()
+	if encoding == "" {
+		codec, ok = r.Codec(typ), true
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w %q for %s", ErrUnknownEncoding, encoding, typ)
+	}
+	if !registered || pt.typ == nil {
+		var v interface{}
+		if len(data) == 0 {
+			return nil, nil
+		}
+		err := codec.Unmarshal(data, &v)
+		return v, err
+	}
+
+	// Decode into a new value of the registered type. Pointer types
+	// are allocated and returned as is.
+	if pt.typ.Kind() == reflect.Ptr {
+		v := reflect.New(pt.typ.Elem())
+		if err := codec.Unmarshal(data, v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+	}
+	v := reflect.New(pt.typ)
+	if err := codec.Unmarshal(data, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+//
+// Event JSON
+//
+
+// eventJSON is the JSON form of an event. JSON payloads are embedded as is
+// and others as base64 encoded strings with the name of the encoding.
+type eventJSON struct {
+	Source   ServiceId       `json:"source"`
+	Type     EventType       `json:"type"`
+	Time     time.Time       `json:"time"`
+	Seq      uint64          `json:"seq,omitempty"`
+	Key      string          `json:"key,omitempty"`
+	Expires  *time.Time      `json:"expires,omitempty"`
+	Clock    VectorClock     `json:"clock,omitempty"`
+	Encoding string          `json:"encoding,omitempty"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// MarshalEvent encodes the event as JSON with the payload encoded by the
+// codec registered for the event type in Payloads.
+func MarshalEvent(ev Event) ([]byte, error) {
+	encoding, data, err := Payloads.Encode(ev.EventType(), ev.Data())
+	if err != nil {
+		return nil, fmt.Errorf("encode %s payload: %w", ev.EventType(), err)
+	}
+	ej := eventJSON{
+		Source: ev.ServiceId(),
+		Type:   ev.EventType(),
+		Time:   ev.Timestamp(),
+		Seq:    ev.Sequence(),
+		Key:    IdempotencyKey(ev),
+		Clock:  EventClock(ev),
+		Data:   data,
+	}
+	if t, ok := Expires(ev); ok {
+		ej.Expires = &t
+	}
+	if encoding != JSONCodec.Name() {
+		ej.Encoding = encoding
+		if ej.Data, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(&ej)
+}
+
+// UnmarshalEvent decodes an event encoded with MarshalEvent. The payload is
+// decoded with Payloads into the registered payload type of the event type,
+// or into a generic value.
+func UnmarshalEvent(b []byte) (Event, error) {
+	ev := &ExampleEvent{}
+	if err := ev.UnmarshalJSON(b); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+func (ev *ExampleEvent) MarshalJSON() ([]byte, error) {
+	return MarshalEvent(ev)
+}
+
+func (ev *ExampleEvent) UnmarshalJSON(b []byte) error {
+	var ej eventJSON
+	if err := json.Unmarshal(b, &ej); err != nil {
+		return err
+	}
+	data := []byte(ej.Data)
+	if ej.Encoding != "" {
+		if err := json.Unmarshal(ej.Data, &data); err != nil {
+			return err
+		}
+	} else {
+		ej.Encoding = JSONCodec.Name()
+	}
+	v, err := Payloads.Decode(ej.Type, ej.Encoding, data)
+	if err != nil {
+		return fmt.Errorf("decode %s payload: %w", ej.Type, err)
+	}
+	*ev = ExampleEvent{
+		source:    ej.Source,
+		eventType: ej.Type,
+		data:      v,
+		timestamp: ej.Time,
+		routed:    ej.Seq,
+		ctx:       context.Background(),
+		key:       ej.Key,
+	}
+	if ej.Expires != nil {
+		ev.expires = *ej.Expires
+	}
+	if len(ej.Clock) > 0 {
+		ev.ctx = WithVectorClock(ev.ctx, ej.Clock)
+	}
+	return nil
+}
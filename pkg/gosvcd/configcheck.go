@@ -0,0 +1,307 @@
+package gosvcd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EventEmitter is implemented by services that declare the event types
+// they emit. The declarations are only used by Validate, to check the
+// subscriptions and the emit capabilities.
+type EventEmitter interface {
+	Emits() []EventType
+}
+
+func emittedTypes(svc Service) []EventType {
+	if e, ok := svc.(EventEmitter); ok {
+		return e.Emits()
+	}
+	return nil
+}
+
+func subscribesTo(svc Service, typ EventType) bool {
+	for _, t := range svc.Subscriptions() {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// daemonEventTypes are the event types emitted by the daemon itself.
+var daemonEventTypes = []EventType{
+	SlowConsumer_Type,
+	QueuePressure_Type,
+	ReloadRequested_Type,
+	DependencyRecovered_Type,
+	DeadLetter_Type,
+	CircuitOpen_Type,
+	SupervisorFailed_Type,
+	ServicePaused_Type,
+	BudgetExceeded_Type,
+}
+
+// ErrInvalidConfig is matched by the errors returned from Validate.
+var ErrInvalidConfig = errors.New("invalid daemon configuration")
+
+// ConfigError is returned by Validate with the problems found in the
+// configuration.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s:\n  %s", ErrInvalidConfig, strings.Join(e.Problems, "\n  "))
+}
+
+func (e *ConfigError) Is(target error) bool { return target == ErrInvalidConfig }
+
+// Validate checks the configuration of the daemon without starting it,
+// e.g. for a --check-config flag. It returns a *ConfigError listing:
+//
+//   - dependencies of the services embedding Tagged that cannot be
+//     resolved,
+//   - services registered more than once or with a reserved identifier,
+//   - dependency cycles,
+//   - dependencies and configured services that are not registered,
+//   - subscription buffers configured for types the service does not
+//     subscribe to,
+//   - subscriptions denied by an access control list, and declared
+//     emitted types outside of the emit capabilities of the service, and
+//   - subscriptions to event types that are not known: not emitted by
+//     the daemon, declared by a service with EventEmitter, permitted by
+//     emit capabilities, registered in Payloads or configured in the
+//     builder.
+func (b *ExampleServiceDaemonBuilder) Validate() error {
+	problems := b.resolveTagged()
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	ids := make([]ServiceId, 0, len(b.handles))
+	for id := range b.handles {
+		ids = append(ids, id)
+	}
+	sortServiceIds(ids)
+	name := func(id ServiceId) string {
+		return fmt.Sprintf("service %d (%s)", id, b.handles[id].Name())
+	}
+	registered := func(id ServiceId) bool {
+		_, ok := b.handles[id]
+		return ok
+	}
+
+	for _, id := range b.duplicates {
+		add("%s registered more than once", name(id))
+	}
+	for _, id := range ids {
+		if id == DaemonServiceId || id == nestedBridgeId {
+			add("%s has a reserved identifier", name(id))
+		}
+	}
+
+	for _, id := range ids {
+		svc := b.handles[id].Service
+		for _, dep := range svc.Dependencies() {
+			if !registered(dep) {
+				add("%s depends on service %d, which is not registered", name(id), dep)
+			}
+		}
+	}
+	for _, cycle := range b.dependencyCycles(ids) {
+		names := make([]string, len(cycle))
+		for i, id := range cycle {
+			names[i] = fmt.Sprint(id)
+		}
+		add("dependency cycle: %s", strings.Join(names, " -> "))
+	}
+
+	configured := func(what string, id ServiceId) {
+		if !registered(id) {
+			add("%s configured for service %d, which is not registered", what, id)
+		}
+	}
+	var capIds, aclIds, budgetIds, overrideIds, timeoutIds []ServiceId
+	for id := range b.emitCaps {
+		if id != DaemonServiceId {
+			capIds = append(capIds, id)
+		}
+	}
+	inACL := make(map[ServiceId]bool)
+	for _, acl := range b.acls {
+		for id := range acl {
+			if !inACL[id] {
+				inACL[id] = true
+				aclIds = append(aclIds, id)
+			}
+		}
+	}
+	for id := range b.budgets {
+		budgetIds = append(budgetIds, id)
+	}
+	for id := range b.overrides {
+		overrideIds = append(overrideIds, id)
+	}
+	for id := range b.handlerTimeouts {
+		timeoutIds = append(timeoutIds, id)
+	}
+	for _, id := range sortServiceIds(capIds) {
+		configured("emit capabilities", id)
+	}
+	for _, id := range sortServiceIds(aclIds) {
+		configured("subscription ACL", id)
+	}
+	for _, id := range sortServiceIds(budgetIds) {
+		configured("resource budget", id)
+	}
+	for _, id := range sortServiceIds(overrideIds) {
+		configured("override", id)
+	}
+	for _, id := range sortServiceIds(timeoutIds) {
+		configured("handler timeout", id)
+	}
+	bufKeys := make([]HandlerKey, 0, len(b.subBuffers))
+	for key := range b.subBuffers {
+		bufKeys = append(bufKeys, key)
+	}
+	sort.Slice(bufKeys, func(i, j int) bool {
+		if bufKeys[i].Service != bufKeys[j].Service {
+			return bufKeys[i].Service < bufKeys[j].Service
+		}
+		return bufKeys[i].EventType < bufKeys[j].EventType
+	})
+	for _, key := range bufKeys {
+		if !registered(key.Service) {
+			configured("subscription buffer", key.Service)
+		} else if !subscribesTo(b.handles[key.Service].Service, key.EventType) {
+			add("subscription buffer configured for %s and %s, which it does not subscribe to", name(key.Service), key.EventType)
+		}
+	}
+	groups := make([]string, 0, len(b.groups))
+	for g := range b.groups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		for _, id := range b.groups[g] {
+			configured(fmt.Sprintf("group %q", g), id)
+		}
+	}
+	for _, spec := range b.supervisors {
+		for _, id := range spec.Services {
+			configured(fmt.Sprintf("supervisor %q", spec.Name), id)
+		}
+	}
+	if b.chaos != nil {
+		for _, id := range b.chaos.Services {
+			configured("chaos", id)
+		}
+	}
+
+	known := make(map[EventType]bool)
+	for _, typ := range daemonEventTypes {
+		known[typ] = true
+	}
+	for _, id := range ids {
+		svc := b.handles[id].Service
+		caps, restricted := b.emitCaps[id]
+		for _, typ := range emittedTypes(svc) {
+			known[typ.Base()] = true
+			if restricted && !caps[typ] {
+				add("%s emits %s, which is outside of its emit capabilities", name(id), typ)
+			}
+		}
+	}
+	for _, caps := range b.emitCaps {
+		for typ := range caps {
+			known[typ.Base()] = true
+		}
+	}
+	for typ := range b.validators {
+		known[typ.Base()] = true
+	}
+	for typ := range b.ackTimeouts {
+		known[typ.Base()] = true
+	}
+	for typ := range b.ttls {
+		known[typ.Base()] = true
+	}
+	for typ := range b.qos {
+		known[typ.Base()] = true
+	}
+	for typ := range b.retention {
+		known[typ.Base()] = true
+	}
+	for typ := range b.creditWindows {
+		known[typ.Base()] = true
+	}
+	for typ := range b.delivery {
+		known[typ.Base()] = true
+	}
+	for _, id := range ids {
+		for _, typ := range b.handles[id].Subscriptions() {
+			if acl, ok := b.acls[typ]; ok && !acl[id] {
+				add("%s subscribes to %s, which its subscription ACL denies", name(id), typ)
+			}
+			if !known[typ.Base()] && !Payloads.Registered(typ) {
+				add("%s subscribes to unknown event type %s", name(id), typ)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// dependencyCycles returns the cycles of the dependency graph, each once,
+// as the identifiers along the cycle starting and ending with the same
+// service.
+func (b *ExampleServiceDaemonBuilder) dependencyCycles(ids []ServiceId) [][]ServiceId {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[ServiceId]int, len(ids))
+	var (
+		path   []ServiceId
+		cycles [][]ServiceId
+		visit  func(id ServiceId)
+	)
+	visit = func(id ServiceId) {
+		state[id] = visiting
+		path = append(path, id)
+		for _, dep := range b.handles[id].Dependencies() {
+			if _, ok := b.handles[dep]; !ok {
+				continue
+			}
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				i := len(path) - 1
+				for path[i] != dep {
+					i--
+				}
+				cycle := append([]ServiceId(nil), path[i:]...)
+				cycles = append(cycles, append(cycle, dep))
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+	}
+	for _, id := range ids {
+		if state[id] == unvisited {
+			visit(id)
+		}
+	}
+	return cycles
+}
+
+func sortServiceIds(ids []ServiceId) []ServiceId {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
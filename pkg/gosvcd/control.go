@@ -0,0 +1,260 @@
+package gosvcd
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/joamaki/gosvcd/pkg/ctlproto"
+)
+
+// The control socket allows managing a running daemon, for example from
+// the command-line with gosvcdctl. See package ctlproto for the protocol.
+
+// DefaultControlSocket is the default path of the control socket:
+// gosvcd.sock in $XDG_RUNTIME_DIR if set, and in /run/gosvcd otherwise.
+// The control protocol has no authentication, so the socket must not be
+// in a directory writable by other users.
+var DefaultControlSocket = defaultControlSocket()
+
+func defaultControlSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "gosvcd.sock")
+	}
+	return "/run/gosvcd/gosvcd.sock"
+}
+
+// ControlServer serves the control protocol of package ctlproto for a
+// daemon, on a Unix socket or on TCP with TLS.
+type ControlServer struct {
+	d        ServiceDaemon
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// ListenControl starts serving the control protocol for the daemon on the
+// Unix socket at the given path. The directory of the socket is created
+// with mode 0700 if missing, and the socket is accessible only to the
+// owner of the daemon. A stale socket file is removed.
+func ListenControl(d ServiceDaemon, path string) (*ControlServer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return ServeControl(d, l), nil
+}
+
+// ListenControlTLS starts serving the control protocol for the daemon on
+// the TCP address with TLS, for managing the daemon from another host. The
+// configuration should require and verify the client certificates, as the
+// one of package tlsconfig does, as the control protocol has no other
+// authentication.
+func ListenControlTLS(d ServiceDaemon, addr string, cfg *tls.Config) (*ControlServer, error) {
+	l, err := tls.Listen("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return ServeControl(d, l), nil
+}
+
+// ServeControl starts serving the control protocol for the daemon on the
+// listener, e.g. one inherited on a live upgrade.
+func ServeControl(d ServiceDaemon, l net.Listener) *ControlServer {
+	s := &ControlServer{d: d, listener: l, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Addr returns the address of the listener, e.g. the TCP address chosen
+// for port 0.
+func (s *ControlServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops accepting new control connections, closes the open ones and
+// waits for the requests in progress.
+func (s *ControlServer) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *ControlServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *ControlServer) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	enc := json.NewEncoder(conn)
+	for {
+		var req ctlproto.Request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp := ctlproto.Response{Version: ctlproto.Version}
+		result, err := s.execute(&req)
+		if err == nil && result != nil {
+			resp.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(&resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *ControlServer) execute(req *ctlproto.Request) (interface{}, error) {
+	if req.Version != ctlproto.Version {
+		return nil, fmt.Errorf("unsupported protocol version %d", req.Version)
+	}
+	switch req.Op {
+	case ctlproto.OpServices:
+		var infos []ctlproto.ServiceInfo
+		for _, svc := range s.d.Services() {
+			info := ctlproto.ServiceInfo{
+				ID:            int64(svc.ID()),
+				Name:          svc.Name(),
+				Dependencies:  []int64{},
+				Subscriptions: []string{},
+			}
+			for _, dep := range svc.Dependencies() {
+				info.Dependencies = append(info.Dependencies, int64(dep))
+			}
+			for _, typ := range svc.Subscriptions() {
+				info.Subscriptions = append(info.Subscriptions, string(typ))
+			}
+			for _, dep := range s.d.Degraded(svc.ID()) {
+				info.Degraded = append(info.Degraded, int64(dep))
+			}
+			infos = append(infos, info)
+		}
+		return infos, nil
+
+	case ctlproto.OpEmit:
+		var args ctlproto.EmitArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		var data interface{}
+		if len(args.Data) > 0 {
+			if err := json.Unmarshal(args.Data, &data); err != nil {
+				return nil, fmt.Errorf("invalid event data: %w", err)
+			}
+		}
+		return nil, s.d.EmitEvent(EventType(args.Type), data)
+
+	case ctlproto.OpRestart:
+		var args ctlproto.RestartArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return nil, s.d.Restart(ServiceId(args.ID))
+
+	case ctlproto.OpPause:
+		s.d.Pause()
+		return nil, nil
+
+	case ctlproto.OpResume:
+		s.d.Resume()
+		return nil, nil
+
+	case ctlproto.OpPauseService:
+		var args ctlproto.PauseServiceArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		mode := PauseHold
+		if args.Drop {
+			mode = PauseDrop
+		}
+		return nil, s.d.PauseService(ServiceId(args.ID), mode)
+
+	case ctlproto.OpResumeService:
+		var args ctlproto.ResumeServiceArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return nil, s.d.ResumeService(ServiceId(args.ID))
+
+	case ctlproto.OpStartGroup, ctlproto.OpStopGroup, ctlproto.OpRestartGroup:
+		var args ctlproto.GroupArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		switch req.Op {
+		case ctlproto.OpStartGroup:
+			return nil, s.d.StartGroup(args.Name)
+		case ctlproto.OpStopGroup:
+			return nil, s.d.StopGroup(args.Name)
+		default:
+			return nil, s.d.RestartGroup(args.Name)
+		}
+
+	case ctlproto.OpCheckpoint:
+		var args ctlproto.CheckpointArgs
+		if err := json.Unmarshal(req.Args, &args); err != nil {
+			return nil, fmt.Errorf("invalid arguments: %w", err)
+		}
+		return nil, s.d.CheckpointNamed(args.Name)
+
+	case ctlproto.OpShutdown:
+		// Respond before shutting down as the shutdown may take a while.
+		go s.d.Shutdown()
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("unknown operation %q", req.Op)
+	}
+}
@@ -0,0 +1,230 @@
+package gosvcd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoordinationStore holds the permits of the semaphores and locks of the
+// services, see ExampleServiceHandle.Semaphore. NewMemoryCoordination
+// returns the store of a single daemon, which is the default. Package
+// cluster provides one shared by the daemons of a cluster.
+type CoordinationStore interface {
+	// TryAcquire acquires a permit of the named semaphore for the
+	// holder if fewer than 'limit' permits are held. Returns false if
+	// not.
+	TryAcquire(ctx context.Context, name, holder string, limit int) (bool, error)
+
+	// Release releases the permit of the holder.
+	Release(ctx context.Context, name, holder string) error
+}
+
+// coordPollInterval is the interval of retrying to acquire a permit held
+// by a service of another daemon.
+const coordPollInterval = 100 * time.Millisecond
+
+// permitSeq numbers the permits for unique holders.
+var permitSeq uint64
+
+// SetCoordination sets the store of the semaphores and locks of the
+// services. Defaults to NewMemoryCoordination.
+func (b *ExampleServiceDaemonBuilder) SetCoordination(store CoordinationStore) {
+	b.coord = store
+}
+
+type memoryCoordination struct {
+	mu      sync.Mutex
+	holders map[string]map[string]bool
+}
+
+// NewMemoryCoordination returns a coordination store in memory, for the
+// services of a single daemon.
+func NewMemoryCoordination() CoordinationStore {
+	return &memoryCoordination{holders: make(map[string]map[string]bool)}
+}
+
+func (m *memoryCoordination) TryAcquire(ctx context.Context, name, holder string, limit int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	holders := m.holders[name]
+	if holders[holder] {
+		return true, nil
+	}
+	if len(holders) >= limit {
+		return false, nil
+	}
+	if holders == nil {
+		holders = make(map[string]bool)
+		m.holders[name] = holders
+	}
+	holders[holder] = true
+	return true, nil
+}
+
+func (m *memoryCoordination) Release(ctx context.Context, name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	holders := m.holders[name]
+	delete(holders, holder)
+	if len(holders) == 0 {
+		delete(m.holders, name)
+	}
+	return nil
+}
+
+// coordReleased returns a channel that is closed when a permit is next
+// released by a service of the daemon.
+func (d *ExampleServiceDaemon) coordReleased() <-chan struct{} {
+	d.coordMu.Lock()
+	defer d.coordMu.Unlock()
+	if d.coordCh == nil {
+		d.coordCh = make(chan struct{})
+	}
+	return d.coordCh
+}
+
+func (d *ExampleServiceDaemon) notifyReleased() {
+	d.coordMu.Lock()
+	defer d.coordMu.Unlock()
+	if d.coordCh != nil {
+		close(d.coordCh)
+		d.coordCh = nil
+	}
+}
+
+// Coordinator is implemented by the handles that provide semaphores and
+// locks, for the services to assert their handle to.
+type Coordinator interface {
+	Semaphore(name string, n int) *Semaphore
+	Lock(name string) *Semaphore
+}
+
+// Semaphore is a named counting semaphore shared by the services, and with
+// a shared CoordinationStore, by the services of other daemons.
+type Semaphore struct {
+	store  CoordinationStore
+	holder string
+	name   string
+	limit  int
+
+	// h is the handle of the service holding the permits, if any.
+	h *ExampleServiceHandle
+}
+
+// NewSemaphore returns the named semaphore with 'n' permits in the store,
+// acquired for the holder, for the handles other than
+// ExampleServiceHandle. The permits are not released when the service is
+// shut down, and Acquire polls the store for a released permit.
+func NewSemaphore(store CoordinationStore, holder, name string, n int) *Semaphore {
+	if n < 1 {
+		n = 1
+	}
+	return &Semaphore{store: store, holder: holder, name: name, limit: n}
+}
+
+// Semaphore returns the named semaphore with 'n' permits. The semaphores
+// with the same name must have the same number of permits.
+func (h *ExampleServiceHandle) Semaphore(name string, n int) *Semaphore {
+	s := NewSemaphore(h.d.coord, fmt.Sprintf("%s/%d", h.Name(), h.ID()), name, n)
+	s.h = h
+	return s
+}
+
+// Lock returns the named lock, i.e. a semaphore with one permit.
+func (h *ExampleServiceHandle) Lock(name string) *Semaphore {
+	return h.Semaphore(name, 1)
+}
+
+var _ Coordinator = &ExampleServiceHandle{}
+
+// Permit is a permit of a semaphore held by a service. The permits still
+// held when the service is shut down are released.
+type Permit struct {
+	s      *Semaphore
+	holder string
+	once   sync.Once
+}
+
+// TryAcquire acquires a permit if one is available. Returns nil if not.
+func (s *Semaphore) TryAcquire(ctx context.Context) (*Permit, error) {
+	holder := fmt.Sprintf("%s/%d", s.holder, atomic.AddUint64(&permitSeq, 1))
+	ok, err := s.store.TryAcquire(ctx, s.name, holder, s.limit)
+	if err != nil || !ok {
+		return nil, err
+	}
+	p := &Permit{s: s, holder: holder}
+	h := s.h
+	if h == nil {
+		return p, nil
+	}
+	h.bookMu.Lock()
+	if h.permits == nil {
+		h.permits = make(map[*Permit]bool)
+	}
+	h.permits[p] = true
+	h.bookMu.Unlock()
+	return p, nil
+}
+
+// Acquire acquires a permit, waiting until one is available or the
+// context is done.
+func (s *Semaphore) Acquire(ctx context.Context) (*Permit, error) {
+	for {
+		var released <-chan struct{}
+		if s.h != nil {
+			released = s.h.d.coordReleased()
+		}
+		p, err := s.TryAcquire(ctx)
+		if p != nil || err != nil {
+			return p, err
+		}
+		timer := time.NewTimer(coordPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Release releases the permit. Releasing it again does nothing.
+func (p *Permit) Release() error {
+	var err error
+	p.once.Do(func() {
+		h := p.s.h
+		if h != nil {
+			h.bookMu.Lock()
+			delete(h.permits, p)
+			h.bookMu.Unlock()
+		}
+		err = p.s.store.Release(context.Background(), p.s.name, p.holder)
+		if h != nil {
+			h.d.notifyReleased()
+		}
+	})
+	return err
+}
+
+// releasePermits releases the permits held by the service.
+func (h *ExampleServiceHandle) releasePermits() {
+	h.bookMu.Lock()
+	var permits []*Permit
+	for p := range h.permits {
+		permits = append(permits, p)
+	}
+	h.bookMu.Unlock()
+	for _, p := range permits {
+		if err := p.Release(); err != nil {
+			h.d.log.Error("Failed to release permit", "service", h.Name(), "name", p.s.name, "error", err)
+		}
+	}
+	if len(permits) > 0 {
+		h.d.log.Warn("Released permits held by stopped service", "service", h.Name(), "permits", len(permits))
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package gosvcd
+
+import "time"
+
+// threadCPUTime returns the CPU time used by the calling thread.
+func threadCPUTime() (time.Duration, bool) {
+	return 0, false
+}
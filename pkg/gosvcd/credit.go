@@ -0,0 +1,206 @@
+package gosvcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoCredit is matched by the errors returned from emitting an event of
+// a type whose credit window is exhausted.
+var ErrNoCredit = errors.New("no credit")
+
+// CreditError is returned when an event is not emitted because the events
+// of its type in flight have used up the credit window of the type.
+type CreditError struct {
+	EventType EventType
+	Window    int
+}
+
+func (e *CreditError) Error() string {
+	return fmt.Sprintf("%s: %s: %d events in flight", ErrNoCredit, e.EventType, e.Window)
+}
+
+func (e *CreditError) Is(target error) bool { return target == ErrNoCredit }
+
+// CreditGranter is implemented by services that limit the events of their
+// subscribed types in flight to them. CreditWindow returns the number of
+// events of the type the service accepts before the emitters of the type
+// are pushed back, or zero for no limit.
+type CreditGranter interface {
+	CreditWindow(typ EventType) int
+}
+
+// CreditAwaiter is implemented by the handles that wait for credits, for
+// the services to assert their handle to.
+type CreditAwaiter interface {
+	AwaitCredit(ctx context.Context, typ EventType) error
+}
+
+// SetCreditWindow sets the credit window of the event type: the number of
+// events of the type that may be in flight, from being emitted until they
+// have been delivered to all the subscribers. Emitting an event beyond the
+// window fails immediately with a *CreditError instead of blocking once
+// the dispatch queues fill up, so that the emitters can shed or defer the
+// work. A window of zero or less removes the limit.
+//
+// The subscribers implementing CreditGranter narrow the window of their
+// subscribed types, so that the slowest consumer paces the emitters. The
+// windows are fixed when the daemon is started. The events converted from
+// the emitted events to the other versions of their types, and the events
+// replayed from the journal, do not take credits.
+func (b *ExampleServiceDaemonBuilder) SetCreditWindow(typ EventType, window int) {
+	if window <= 0 {
+		delete(b.creditWindows, typ)
+		return
+	}
+	if b.creditWindows == nil {
+		b.creditWindows = make(map[EventType]int)
+	}
+	b.creditWindows[typ] = window
+}
+
+// CreditStats describes the credits of an event type.
+type CreditStats struct {
+	// Window is the number of events of the type that may be in flight.
+	Window int
+
+	// InFlight is the number of events of the type in flight.
+	InFlight int
+
+	// Exhausted counts the events not emitted for lack of credit.
+	Exhausted uint64
+}
+
+// credits are the credits of an event type.
+type credits struct {
+	window int
+
+	mu        sync.Mutex
+	inFlight  int
+	exhausted uint64
+
+	// freed is closed when a credit is returned, if waited for.
+	freed chan struct{}
+}
+
+// newCredits returns the credits of the subscribed type, or nil if the
+// type has no credit window.
+func (d *ExampleServiceDaemon) newCredits(typ EventType, subs []*ExampleServiceHandle) *credits {
+	window := d.creditWindows[typ]
+	for _, h := range subs {
+		g, ok := h.Service.(CreditGranter)
+		if !ok {
+			continue
+		}
+		if w := g.CreditWindow(typ); w > 0 && (window <= 0 || w < window) {
+			window = w
+		}
+	}
+	if window <= 0 {
+		return nil
+	}
+	return &credits{window: window}
+}
+
+// take takes a credit. Returns false if none is available.
+func (c *credits) take() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlight >= c.window {
+		c.exhausted++
+		return false
+	}
+	c.inFlight++
+	return true
+}
+
+// put returns a credit.
+func (c *credits) put() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	if c.freed != nil {
+		close(c.freed)
+		c.freed = nil
+	}
+}
+
+// wait waits until a credit is available.
+func (c *credits) wait(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.inFlight < c.window {
+			c.mu.Unlock()
+			return nil
+		}
+		if c.freed == nil {
+			c.freed = make(chan struct{})
+		}
+		freed := c.freed
+		c.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *credits) stats() CreditStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CreditStats{Window: c.window, InFlight: c.inFlight, Exhausted: c.exhausted}
+}
+
+// takeCredit takes a credit for emitting an event of the type. Returns
+// true if a credit was taken, to be returned once the event has been
+// delivered.
+func (d *ExampleServiceDaemon) takeCredit(rt ResolvedType) (bool, error) {
+	if rt.info == nil || rt.info.credits == nil {
+		return false, nil
+	}
+	c := rt.info.credits
+	if !c.take() {
+		return false, &CreditError{EventType: rt.typ, Window: c.window}
+	}
+	return true, nil
+}
+
+// returnCredit returns the credit of an emission that was not sent.
+func (e emission) returnCredit() {
+	if e.credited {
+		e.info.credits.put()
+	}
+}
+
+// AwaitCredit waits until an event of the type can be emitted without
+// exceeding its credit window, or returns the error of 'ctx' if it is done
+// first. The credit is not reserved, so emitting may still fail if other
+// emitters take it first. Returns immediately if the type has no credit
+// window.
+func (d *ExampleServiceDaemon) AwaitCredit(ctx context.Context, typ EventType) error {
+	ti := d.resolve(typ)
+	if ti == nil || ti.credits == nil {
+		return nil
+	}
+	return ti.credits.wait(ctx)
+}
+
+// AwaitCredit waits for a credit of the event type. See
+// ExampleServiceDaemon.AwaitCredit.
+func (h *ExampleServiceHandle) AwaitCredit(ctx context.Context, typ EventType) error {
+	return h.d.AwaitCredit(ctx, typ)
+}
+
+// creditStats returns the credits of the types with a credit window.
+func (d *ExampleServiceDaemon) creditStats() map[EventType]CreditStats {
+	stats := make(map[EventType]CreditStats)
+	for _, ti := range d.types {
+		if ti.credits != nil {
+			stats[ti.typ] = ti.credits.stats()
+		}
+	}
+	return stats
+}
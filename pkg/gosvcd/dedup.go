@@ -0,0 +1,135 @@
+package gosvcd
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type idempotencyKeyCtx struct{}
+
+// WithIdempotencyKey returns a context for emitting an event with an
+// idempotency key. Events of the same type with the same key are
+// delivered only once within the dedup window of the services
+// implementing Deduplicator, e.g. when a producer retries an emit or an
+// event is received twice by a bridge. The key is not inherited by the
+// events emitted with the context of the event.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyCtx{}, key)
+}
+
+// IdempotencyKey returns the idempotency key of the event, or the empty
+// string if it has none.
+func IdempotencyKey(ev Event) string {
+	if k, ok := ev.(interface{ IdempotencyKey() string }); ok {
+		return k.IdempotencyKey()
+	}
+	return ""
+}
+
+// idempotencyKey returns the key in the context and the context without
+// it.
+func idempotencyKey(ctx context.Context) (string, context.Context) {
+	if ctx == nil {
+		return "", ctx
+	}
+	key, _ := ctx.Value(idempotencyKeyCtx{}).(string)
+	if key == "" {
+		return "", ctx
+	}
+	return key, context.WithValue(ctx, idempotencyKeyCtx{}, "")
+}
+
+func (ev *ExampleEvent) IdempotencyKey() string {
+	return ev.key
+}
+
+// Deduplicator is implemented by services that opt in to the suppression
+// of duplicate events. An event with an idempotency key is not delivered
+// to the service if an event of the same type with the same key has been
+// handled successfully within the window.
+type Deduplicator interface {
+	DedupWindow() time.Duration
+}
+
+// dedupEntry is a handled idempotency key.
+type dedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// dedupSet holds the keys handled by a service within its dedup window.
+type dedupSet struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+
+	// expiry is the keys in the order they expire.
+	expiry []dedupEntry
+}
+
+func dedupKey(typ EventType, key string) string {
+	return string(typ) + "\x00" + key
+}
+
+// seen returns true if the key has been handled within the window.
+func (s *dedupSet) seen(key string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	_, ok := s.keys[key]
+	return ok
+}
+
+// add records a handled key.
+func (s *dedupSet) add(key string, now time.Time, window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	if _, ok := s.keys[key]; ok {
+		return
+	}
+	if s.keys == nil {
+		s.keys = make(map[string]time.Time)
+	}
+	expires := now.Add(window)
+	s.keys[key] = expires
+	s.expiry = append(s.expiry, dedupEntry{key, expires})
+}
+
+func (s *dedupSet) expire(now time.Time) {
+	n := 0
+	for n < len(s.expiry) && !now.Before(s.expiry[n].expires) {
+		delete(s.keys, s.expiry[n].key)
+		n++
+	}
+	if n > 0 {
+		s.expiry = append(s.expiry[:0], s.expiry[n:]...)
+	}
+}
+
+// isDuplicate returns true if the event is a duplicate of an event the
+// service has handled.
+func (d *ExampleServiceDaemon) isDuplicate(h *ExampleServiceHandle, ev *ExampleEvent) bool {
+	if ev.key == "" {
+		return false
+	}
+	if _, ok := h.Service.(Deduplicator); !ok {
+		return false
+	}
+	if !h.dedup.seen(dedupKey(ev.eventType, ev.key), d.clock.Now()) {
+		return false
+	}
+	d.log.Debug("Suppressed duplicate event", "service", h.Name(), "event_type", ev.eventType, "key", ev.key)
+	d.metrics.eventDropped(DropDuplicate, ev.eventType)
+	return true
+}
+
+// handled records the key of the event handled by the service.
+func (d *ExampleServiceDaemon) handled(h *ExampleServiceHandle, ev *ExampleEvent) {
+	if ev.key == "" {
+		return
+	}
+	if dd, ok := h.Service.(Deduplicator); ok {
+		h.dedup.add(dedupKey(ev.eventType, ev.key), d.clock.Now(), dd.DedupWindow())
+	}
+}
@@ -0,0 +1,104 @@
+package gosvcd
+
+// OptionalDependencies is implemented by services that can run without
+// some of their dependencies. The optional dependencies must also be
+// listed in Dependencies. A service whose optional dependency is not
+// running when the service is initialized runs degraded until the
+// dependency is initialized, e.g. by a restart, at which point a
+// DependencyRecovered event is emitted.
+type OptionalDependencies interface {
+	OptionalDependencies() []ServiceId
+}
+
+func isOptionalDependency(svc Service, id ServiceId) bool {
+	o, ok := svc.(OptionalDependencies)
+	if !ok {
+		return false
+	}
+	for _, dep := range o.OptionalDependencies() {
+		if dep == id {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Dependency recovered
+//
+
+var DependencyRecovered_Type = EventType("DependencyRecovered")
+
+// DependencyRecovered is emitted when the optional dependency of a
+// degraded service has been initialized.
+type DependencyRecovered struct {
+	Service    ServiceId
+	Dependency ServiceId
+
+	// Degraded is true if the service still has other optional
+	// dependencies that are not running.
+	Degraded bool
+}
+
+// checkDegraded records the optional dependencies of the service that are
+// not running. Called before the service is initialized.
+func (d *ExampleServiceDaemon) checkDegraded(h *ExampleServiceHandle) {
+	o, ok := h.Service.(OptionalDependencies)
+	if !ok {
+		return
+	}
+	var missing []ServiceId
+	for _, id := range o.OptionalDependencies() {
+		if state, _ := d.State(id); state != ServiceRunning && !d.isCompleted(id) {
+			missing = append(missing, id)
+		}
+	}
+	h.bookMu.Lock()
+	h.degraded = missing
+	h.bookMu.Unlock()
+	if len(missing) > 0 {
+		d.log.Warn("Service running degraded", "service", h.Name(), "missing", missing)
+	}
+}
+
+// recovered clears the service from the missing dependencies of the
+// degraded services. Called after the service has been initialized.
+func (d *ExampleServiceDaemon) recovered(dep *ExampleServiceHandle) {
+	for _, h := range d.orderedHandles() {
+		if !isOptionalDependency(h.Service, dep.ID()) {
+			continue
+		}
+		h.bookMu.Lock()
+		missing := removeId(h.degraded, dep.ID())
+		found := len(missing) != len(h.degraded)
+		h.degraded = missing
+		h.bookMu.Unlock()
+		if !found {
+			continue
+		}
+		d.log.Info("Dependency recovered", "service", h.Name(), "dependency", dep.Name())
+		d.emitAsync(DependencyRecovered_Type, &DependencyRecovered{
+			Service:    h.ID(),
+			Dependency: dep.ID(),
+			Degraded:   len(missing) > 0,
+		})
+	}
+}
+
+// Degraded returns the optional dependencies of the service that were not
+// running when it was initialized and have not been initialized since.
+func (d *ExampleServiceDaemon) Degraded(id ServiceId) []ServiceId {
+	h, ok := d.handle(id)
+	if !ok {
+		return nil
+	}
+	return h.Degraded()
+}
+
+// Degraded returns the missing optional dependencies of the service. See
+// ExampleServiceDaemon.Degraded.
+func (h *ExampleServiceHandle) Degraded() []ServiceId {
+	h.bookMu.Lock()
+	defer h.bookMu.Unlock()
+	return append([]ServiceId(nil), h.degraded...)
+}
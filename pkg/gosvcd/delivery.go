@@ -0,0 +1,161 @@
+package gosvcd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Delivery tracks the delivery of an emitted event to its subscribers, for
+// emitting an event and waiting for all the subscribers to have applied it.
+// Created with WithDelivery.
+//
+// The delivery is complete once the event, and the events converted from
+// it to the other versions of its type, have been dispatched to all the
+// subscribers and their handlers have returned, including the handlers of
+// a ConcurrentHandler, the events held for a paused service and the
+// retries of a RetryHandler. An AckHandler has processed the event when
+// HandleEventAck returns, whether or not it has acknowledged it. An event
+// without subscribers is delivered once routed.
+//
+// If emitting the event fails, or the event is staged in an outbox that
+// is rolled back, the delivery never completes.
+type Delivery struct {
+	// tracked is set once an event has been emitted with the delivery,
+	// and pending is the number of its events not yet delivered.
+	tracked int32
+	pending int32
+
+	handled int64
+	failed  int64
+
+	done     chan struct{}
+	doneOnce sync.Once
+	callback func(DeliveryResult)
+}
+
+// DeliveryResult counts the outcomes of delivering an event to its
+// subscribers. The deliveries of the event that were dropped, e.g. as the
+// subscriber was not running or the event expired, are not counted.
+type DeliveryResult struct {
+	// Handled is the number of handlers that processed the event.
+	Handled int
+
+	// Failed is the number of handlers that panicked, returned an error
+	// without being retried, or whose event was dead-lettered.
+	Failed int
+}
+
+type deliveryCtx struct{}
+
+// WithDelivery returns a context for emitting an event with the Delivery
+// tracking it. The delivery is not inherited by the events emitted with
+// the context of the event. Only the first event emitted with the context
+// is tracked.
+func WithDelivery(ctx context.Context) (context.Context, *Delivery) {
+	del := &Delivery{done: make(chan struct{})}
+	return context.WithValue(ctx, deliveryCtx{}, del), del
+}
+
+// WithDeliveryCallback returns a context for emitting an event with the
+// callback called once the event has been delivered to all its
+// subscribers. The callback is called from the goroutine completing the
+// delivery, which may be a dispatcher, and must not block. See
+// WithDelivery.
+func WithDeliveryCallback(ctx context.Context, fn func(DeliveryResult)) context.Context {
+	ctx, del := WithDelivery(ctx)
+	del.callback = fn
+	return ctx
+}
+
+// Done returns a channel that is closed once the event has been delivered.
+func (del *Delivery) Done() <-chan struct{} {
+	return del.done
+}
+
+// Wait waits for the event to be delivered. Returns the error of 'ctx' if
+// it is done first.
+func (del *Delivery) Wait(ctx context.Context) error {
+	select {
+	case <-del.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Result returns the outcomes of the deliveries so far. Final once Done is
+// closed.
+func (del *Delivery) Result() DeliveryResult {
+	return DeliveryResult{
+		Handled: int(atomic.LoadInt64(&del.handled)),
+		Failed:  int(atomic.LoadInt64(&del.failed)),
+	}
+}
+
+// deliveryOf returns the delivery in the context and the context without
+// it.
+func deliveryOf(ctx context.Context) (*Delivery, context.Context) {
+	if ctx == nil {
+		return nil, ctx
+	}
+	del, _ := ctx.Value(deliveryCtx{}).(*Delivery)
+	if del == nil {
+		return nil, ctx
+	}
+	return del, context.WithValue(ctx, deliveryCtx{}, (*Delivery)(nil))
+}
+
+// track makes the delivery wait for the emitted event, which takes a
+// reference to be released once it has been delivered. Returns false if
+// the delivery already tracks an event.
+func (del *Delivery) track(ev *ExampleEvent) bool {
+	if !atomic.CompareAndSwapInt32(&del.tracked, 0, 1) {
+		return false
+	}
+	del.follow(ev)
+	return true
+}
+
+// follow makes the delivery also wait for an event derived from the
+// tracked one.
+func (del *Delivery) follow(ev *ExampleEvent) {
+	atomic.AddInt32(&del.pending, 1)
+	ev.delivery = del
+	if !ev.pooled {
+		ev.refs = 1
+	}
+}
+
+// delivered is called when a tracked event has been delivered.
+func (del *Delivery) delivered() {
+	if atomic.AddInt32(&del.pending, -1) == 0 {
+		del.doneOnce.Do(func() {
+			close(del.done)
+			if del.callback != nil {
+				del.callback(del.Result())
+			}
+		})
+	}
+}
+
+func (del *Delivery) recordHandled() {
+	if del != nil && !del.closed() {
+		atomic.AddInt64(&del.handled, 1)
+	}
+}
+
+func (del *Delivery) recordFailed() {
+	if del != nil && !del.closed() {
+		atomic.AddInt64(&del.failed, 1)
+	}
+}
+
+func (del *Delivery) closed() bool {
+	select {
+	case <-del.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,75 @@
+package gosvcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotSubscribed is matched by the errors returned from emitting a
+// directed event to a service that does not subscribe to its type.
+var ErrNotSubscribed = errors.New("target does not subscribe to the event type")
+
+// TargetError is returned when the target of a directed event is not
+// registered or does not subscribe to the type of the event.
+type TargetError struct {
+	Target    ServiceId
+	EventType EventType
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("%s: service %d: %s", ErrNotSubscribed, e.Target, e.EventType)
+}
+
+func (e *TargetError) Is(target error) bool { return target == ErrNotSubscribed }
+
+// DirectEmitter is implemented by the handles that emit directed events,
+// for the services to assert their handle to.
+type DirectEmitter interface {
+	EmitTo(target ServiceId, eventType EventType, data interface{}) error
+	EmitToContext(ctx context.Context, target ServiceId, eventType EventType, data interface{}) error
+}
+
+type targetCtx struct{}
+
+// targetOf returns the target of the directed event being emitted with
+// the context, and the context without it.
+func targetOf(ctx context.Context) (ServiceId, bool, context.Context) {
+	if ctx == nil {
+		return 0, false, ctx
+	}
+	target, ok := ctx.Value(targetCtx{}).(*ServiceId)
+	if !ok || target == nil {
+		return 0, false, ctx
+	}
+	return *target, true, context.WithValue(ctx, targetCtx{}, (*ServiceId)(nil))
+}
+
+// EmitTo emits a directed event, delivered to the target service only
+// instead of to all the subscribers of its type. The target must
+// subscribe to the type, otherwise a *TargetError is returned. The
+// directed events are not journaled, stored or retained, as they would be
+// delivered to all the subscribers when replayed.
+func (h *ExampleServiceHandle) EmitTo(target ServiceId, eventType EventType, data interface{}) error {
+	return h.EmitToContext(context.Background(), target, eventType, data)
+}
+
+// EmitToContext emits a directed event as part of the operation carried by
+// the context. See EmitTo and EmitEventContext.
+func (h *ExampleServiceHandle) EmitToContext(ctx context.Context, target ServiceId, eventType EventType, data interface{}) error {
+	return h.d.emitTo(ctx, h.ID(), target, eventType, data)
+}
+
+// EmitTo emits a directed event on behalf of the daemon. See
+// ExampleServiceHandle.EmitTo.
+func (d *ExampleServiceDaemon) EmitTo(target ServiceId, eventType EventType, data interface{}) error {
+	return d.emitTo(context.Background(), DaemonServiceId, target, eventType, data)
+}
+
+func (d *ExampleServiceDaemon) emitTo(ctx context.Context, source, target ServiceId, eventType EventType, data interface{}) error {
+	h, ok := d.handle(target)
+	if !ok || !d.subscribes(h, eventType) {
+		return &TargetError{Target: target, EventType: eventType}
+	}
+	return d.emit(context.WithValue(ctx, targetCtx{}, &target), source, eventType, data)
+}
@@ -0,0 +1,165 @@
+package gosvcd
+
+import (
+	"context"
+	"runtime/pprof"
+	"sync/atomic"
+)
+
+// dispatcher delivers the events of a single type to its subscribers, in
+// the order they were emitted.
+type dispatcher struct {
+	// busy is the id of the service whose handler is being invoked, plus
+	// one, or zero when idle.
+	busy int64
+
+	// progress counts the handler invocations.
+	progress uint64
+
+	// last is the routing sequence number of the last dispatched event.
+	last uint64
+
+	// pressure is one when the queue is above the high watermark.
+	pressure int32
+
+	// scheduled is one when the dispatcher is queued to or run by a
+	// worker of the scheduler.
+	scheduled int32
+
+	// turn rotates the subscribers taking the anycast events.
+	turn uint64
+
+	typ      EventType
+	class    QoSClass
+	delivery DeliveryMode
+	ch       chan *ExampleEvent
+
+	// subs is the []*ExampleServiceHandle of the subscribers, replaced
+	// when a service is drained.
+	subs atomic.Value
+
+	// bufs are the buffers of the subscriptions with one, by the id of
+	// the subscriber. Fixed at Start.
+	bufs map[ServiceId]*subscription
+
+	// slots limits the admitted events of a bulk type to the capacity of
+	// the queue, or is nil if the events are not admitted.
+	slots chan struct{}
+}
+
+// QueueWatermarks are the thresholds on the depth of a dispatch queue for
+// emitting QueuePressure events. The low watermark is lower than the high
+// watermark to avoid flapping.
+type QueueWatermarks struct {
+	High int
+	Low  int
+}
+
+func newDispatcher(typ EventType, class QoSClass, size int, subs []*ExampleServiceHandle) *dispatcher {
+	size = class.queueSize(size)
+	q := &dispatcher{
+		typ:   typ,
+		class: class,
+		ch:    make(chan *ExampleEvent, size),
+	}
+	q.subs.Store(subs)
+	if class == QoSBulk {
+		q.slots = make(chan struct{}, size)
+	}
+	return q
+}
+
+func (q *dispatcher) subscribers() []*ExampleServiceHandle {
+	return q.subs.Load().([]*ExampleServiceHandle)
+}
+
+func (q *dispatcher) setSubscribers(subs []*ExampleServiceHandle) {
+	q.subs.Store(subs)
+}
+
+// current returns the service whose handler is being invoked, if any.
+func (q *dispatcher) current() (ServiceId, bool) {
+	busy := atomic.LoadInt64(&q.busy)
+	return ServiceId(busy - 1), busy != 0
+}
+
+func (q *dispatcher) run(d *ExampleServiceDaemon) {
+	labels := pprof.Labels("gosvcd", "dispatch", "event_type", string(q.typ))
+	pprof.Do(context.Background(), labels, func(ctx context.Context) {
+		for ev := range q.ch {
+			q.dispatch(d, ev)
+			pprof.SetGoroutineLabels(ctx)
+		}
+	})
+}
+
+// dispatch delivers the event to the subscribers.
+func (q *dispatcher) dispatch(d *ExampleServiceDaemon, ev *ExampleEvent) {
+	d.pause.wait(d.shuttingDown)
+	if ev.admitted {
+		// The event has left the queue.
+		<-q.slots
+	}
+	q.checkPressure(d)
+	subs := q.subscribers()
+	one := q.pick(subs, ev)
+	for _, h := range subs {
+		if (ev.directed && h.ID() != ev.target) || (one != nil && h != one) {
+			d.skipOrdered(h, ev)
+			continue
+		}
+		if cutoff := atomic.LoadUint64(&h.drainAfter); cutoff != 0 && ev.routed > cutoff {
+			// The service is being drained.
+			d.skipOrdered(h, ev)
+			continue
+		}
+		if s, ok := q.bufs[h.ID()]; ok {
+			s.push(d, h, ev)
+			continue
+		}
+		atomic.StoreInt64(&q.busy, int64(h.ID())+1)
+		d.deliverOrdered(h, ev)
+		atomic.StoreInt64(&q.busy, 0)
+		atomic.AddUint64(&q.progress, 1)
+	}
+	if len(ev.tickets) > 0 {
+		d.skipRemoved(ev, subs)
+	}
+	if ev.span != nil {
+		ev.span.End()
+	}
+	atomic.StoreUint64(&q.last, ev.routed)
+	atomic.AddInt64(&d.pending, -1)
+	d.cancels.release(ev.cancel)
+	ev.release()
+}
+
+// checkPressure emits a QueuePressure event if the queue depth has crossed
+// a watermark.
+func (q *dispatcher) checkPressure(d *ExampleServiceDaemon) {
+	w := d.watermarks
+	if w.High <= 0 {
+		return
+	}
+	depth := len(q.ch)
+	switch {
+	case depth >= w.High:
+		if !atomic.CompareAndSwapInt32(&q.pressure, 0, 1) {
+			return
+		}
+		d.log.Warn("Dispatch queue above high watermark", "event_type", q.typ, "depth", depth)
+	case depth <= w.Low:
+		if !atomic.CompareAndSwapInt32(&q.pressure, 1, 0) {
+			return
+		}
+		d.log.Info("Dispatch queue below low watermark", "event_type", q.typ, "depth", depth)
+	default:
+		return
+	}
+	d.emitAsync(QueuePressure_Type, &QueuePressure{
+		EventType: q.typ,
+		Depth:     depth,
+		Capacity:  cap(q.ch),
+		High:      depth >= w.High,
+	})
+}
@@ -0,0 +1,102 @@
+package gosvcd
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollInterval is the interval for checking whether the queues of a
+// draining service have been dispatched.
+const drainPollInterval = 5 * time.Millisecond
+
+// Drain removes a service from the running daemon. The events routed
+// after the call are not delivered to the service. Once the events queued
+// for it have been delivered and its handlers have returned, the service
+// is shut down and unregistered. A service that others depend on cannot
+// be drained.
+func (d *ExampleServiceDaemon) Drain(id ServiceId) error {
+	h, ok := d.handle(id)
+	if !ok {
+		return fmt.Errorf("service %d not found", id)
+	}
+	if d.isStopping() {
+		return ErrDaemonStopped
+	}
+	for _, other := range d.orderedHandles() {
+		for _, dep := range other.Dependencies() {
+			if dep == id {
+				return fmt.Errorf("service %d is a dependency of %s", id, other.Name())
+			}
+		}
+	}
+	cutoff := atomic.LoadUint64(&d.routed) + 1
+	if !atomic.CompareAndSwapUint64(&h.drainAfter, 0, cutoff) {
+		return fmt.Errorf("service %d is already draining", id)
+	}
+	d.log.Info("Draining service", "service", h.Name(), "id", id)
+
+	// The held events of a paused service are not delivered.
+	h.bookMu.Lock()
+	h.quarantine.mode = PauseDrop
+	h.bookMu.Unlock()
+	d.dropHeldOf(h)
+
+	for _, typ := range h.Subscriptions() {
+		if q, ok := d.queue(typ); ok {
+			q.waitDispatched(cutoff)
+			q.waitBuffered(id)
+		}
+	}
+
+	// Wait for the handlers in progress.
+	h.mu.Lock()
+	h.shutdownService()
+	h.mu.Unlock()
+
+	d.unregister(h)
+	d.log.Info("Service drained", "service", h.Name(), "id", id)
+	return nil
+}
+
+// unregister removes the service from the registry and the subscribers of
+// the dispatch queues.
+func (d *ExampleServiceDaemon) unregister(h *ExampleServiceHandle) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := h.ID()
+	delete(d.handles, id)
+	d.services = removeService(d.services, id)
+	for _, typ := range h.Subscriptions() {
+		d.subs[typ] = removeService(d.subs[typ], id)
+		q, ok := d.queues[typ]
+		if !ok {
+			continue
+		}
+		var subs []*ExampleServiceHandle
+		for _, sub := range q.subscribers() {
+			if sub != h {
+				subs = append(subs, sub)
+			}
+		}
+		q.setSubscribers(subs)
+	}
+}
+
+func removeService(svcs []Service, id ServiceId) []Service {
+	out := make([]Service, 0, len(svcs))
+	for _, svc := range svcs {
+		if svc.ID() != id {
+			out = append(out, svc)
+		}
+	}
+	return out
+}
+
+// waitDispatched waits until the queue has dispatched the events routed
+// up to 'seq', or has no events.
+func (q *dispatcher) waitDispatched(seq uint64) {
+	for atomic.LoadUint64(&q.last) < seq && (len(q.ch) > 0 || atomic.LoadInt64(&q.busy) != 0) {
+		time.Sleep(drainPollInterval)
+	}
+}
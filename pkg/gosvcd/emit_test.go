@@ -0,0 +1,37 @@
+package gosvcd
+
+import (
+	"testing"
+)
+
+func benchmarkEmit(b *testing.B, pooling, resolved bool) {
+	builder := NewBuilder()
+	builder.SetEventPooling(pooling)
+	builder.Register(&testService{id: 1, subs: []EventType{"a"}})
+	d := builder.Start().(*ExampleServiceDaemon)
+	defer d.Shutdown()
+	<-d.Ready()
+	rt := d.Resolve("a")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if resolved {
+			err = d.EmitResolved(rt, nil)
+		} else {
+			err = d.EmitEvent("a", nil)
+		}
+		if err != nil {
+			b.Fatalf("EmitEvent: %v", err)
+		}
+	}
+	b.StopTimer()
+}
+
+func BenchmarkEmit(b *testing.B) {
+	b.Run("default", func(b *testing.B) { benchmarkEmit(b, false, false) })
+	b.Run("pooling", func(b *testing.B) { benchmarkEmit(b, true, false) })
+	b.Run("resolved", func(b *testing.B) { benchmarkEmit(b, false, true) })
+	b.Run("pooling+resolved", func(b *testing.B) { benchmarkEmit(b, true, true) })
+}
@@ -0,0 +1,115 @@
+package gosvcd
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// Operations on services reported in ServiceError.
+const (
+	OpInit     = "init"
+	OpHandle   = "handle"
+	OpShutdown = "shutdown"
+)
+
+// ServiceError is an unrecoverable failure of a service, reported through
+// ServiceDaemon.Err().
+type ServiceError struct {
+	Service ServiceId
+	Name    string
+	Op      string
+
+	// EventType is the type of the event being handled when Op is OpHandle.
+	EventType EventType
+
+	Err error
+}
+
+func (e *ServiceError) Error() string {
+	if e.Op == OpHandle {
+		return fmt.Sprintf("service %s (%d): %s %s: %s", e.Name, e.Service, e.Op, e.EventType, e.Err)
+	}
+	return fmt.Sprintf("service %s (%d): %s: %s", e.Name, e.Service, e.Op, e.Err)
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is a recovered panic.
+type PanicError struct {
+	Value interface{}
+	Stack string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// safeCall calls 'f' and returns the panic it raised, if any.
+func safeCall(f func()) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: string(debug.Stack())}
+		}
+	}()
+	f()
+	return nil
+}
+
+// errorReporter delivers the fatal errors to ServiceDaemon.Err().
+type errorReporter struct {
+	mu     sync.Mutex
+	ch     chan error
+	closed bool
+}
+
+func newErrorReporter() *errorReporter {
+	return &errorReporter{ch: make(chan error, 16)}
+}
+
+// report sends the error without blocking and returns false if it was
+// dropped.
+func (r *errorReporter) report(err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	select {
+	case r.ch <- err:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *errorReporter) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.ch)
+	}
+}
+
+// fail reports the failure of a service.
+func (d *ExampleServiceDaemon) fail(h *ExampleServiceHandle, op string, typ EventType, err error) {
+	serr := &ServiceError{
+		Service:   h.ID(),
+		Name:      h.Name(),
+		Op:        op,
+		EventType: typ,
+		Err:       err,
+	}
+	args := []interface{}{"service", h.Name(), "op", op, "error", err}
+	if perr, ok := err.(*PanicError); ok {
+		args = append(args, "stack", perr.Stack)
+	}
+	d.log.Error("Service failed", args...)
+	if !d.errs.report(serr) {
+		d.log.Warn("Error channel full, dropped error", "error", serr)
+	}
+	d.supervise(h, op)
+}
@@ -0,0 +1,98 @@
+package gosvcd
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Recycler is implemented by payloads that can be reused once the event
+// carrying them has been delivered, e.g. by returning themselves to a
+// pool. Recycle is called when event pooling is enabled and the event is
+// returned to the pool.
+type Recycler interface {
+	Recycle()
+}
+
+var eventPool = sync.Pool{
+	New: func() interface{} { return &ExampleEvent{} },
+}
+
+// newPooledEvent returns an event from the pool, referenced by the caller.
+func newPooledEvent() *ExampleEvent {
+	ev := eventPool.Get().(*ExampleEvent)
+	ev.pooled = true
+	ev.refs = 1
+	return ev
+}
+
+// counted returns true if the references to the event are counted.
+func (ev *ExampleEvent) counted() bool {
+	return ev.pooled || ev.delivery != nil || ev.credit != nil || ev.inFlight != nil
+}
+
+// retain adds a reference to a pooled, tracked, credited or journaled
+// event.
+func (ev *ExampleEvent) retain() {
+	if ev.counted() {
+		atomic.AddInt32(&ev.refs, 1)
+	}
+}
+
+// release drops a reference to a pooled, tracked, credited or journaled
+// event. After the last one, the event is settled and returned to the pool
+// if pooled, unless it has been pinned.
+func (ev *ExampleEvent) release() {
+	if !ev.counted() || atomic.AddInt32(&ev.refs, -1) != 0 {
+		return
+	}
+	ev.settle()
+	if !ev.pooled || atomic.LoadInt32(&ev.pinned) != 0 {
+		return
+	}
+	if r, ok := ev.data.(Recycler); ok {
+		r.Recycle()
+	}
+	*ev = ExampleEvent{}
+	eventPool.Put(ev)
+}
+
+// settle counts the event as delivered and returns its credit, once. A
+// retained event may be referenced again after it has been delivered.
+func (ev *ExampleEvent) settle() {
+	if !atomic.CompareAndSwapInt32(&ev.settled, 0, 1) {
+		return
+	}
+	if ev.delivery != nil {
+		ev.delivery.delivered()
+	}
+	if ev.credit != nil {
+		ev.credit.put()
+	}
+	if ev.inFlight != nil {
+		ev.inFlight.done(ev.seq)
+	}
+}
+
+// pin keeps a pooled event from being recycled, when it is handed to a
+// party that may hold on to it.
+func (ev *ExampleEvent) pin() {
+	if ev.pooled {
+		atomic.StoreInt32(&ev.pinned, 1)
+	}
+}
+
+// eventOf returns the daemon's event behind the event passed to the
+// handlers, if any.
+func eventOf(event Event) *ExampleEvent {
+	switch ev := event.(type) {
+	case *ExampleEvent:
+		return ev
+	case *tracedEvent:
+		return ev.ExampleEvent
+	case *outboxEvent:
+		return eventOf(ev.Event)
+	case *timeoutEvent:
+		return eventOf(ev.Event)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package gosvcd
+
+import "time"
+
+// Lifecycle events emitted by the daemon. Their source is DaemonServiceId.
+
+//
+// Slow consumer
+//
+
+var SlowConsumer_Type = EventType("SlowConsumer")
+
+// Reasons for reporting a slow consumer.
+const (
+	// SlowHandler is reported when the service's handler repeatedly
+	// exceeds the latency threshold.
+	SlowHandler = "slow_handler"
+
+	// QueueGrowth is reported when the dispatch queue of an event type
+	// keeps growing while the service is handling it.
+	QueueGrowth = "queue_growth"
+)
+
+type SlowConsumer struct {
+	Service   ServiceId
+	EventType EventType
+	Reason    string
+
+	// Latency is the latency of the last handler invocation when Reason
+	// is SlowHandler.
+	Latency time.Duration
+
+	// QueueDepth is the depth of the dispatch queue of the event type.
+	QueueDepth int
+}
+
+//
+// Queue pressure
+//
+
+var QueuePressure_Type = EventType("QueuePressure")
+
+// QueuePressure is emitted when the dispatch queue of an event type crosses
+// the high watermark, and again when it drains below the low watermark.
+// Producers of the event type can use it to throttle themselves.
+type QueuePressure struct {
+	EventType EventType
+	Depth     int
+	Capacity  int
+
+	// High is true when the queue crossed the high watermark and false
+	// when it drained below the low watermark.
+	High bool
+}
+
+//
+// Reload
+//
+
+var ReloadRequested_Type = EventType("ReloadRequested")
+
+// ReloadRequested is emitted when the daemon receives SIGHUP and signal
+// handling is enabled. Services are expected to reload their configuration.
+type ReloadRequested struct {
+	Signal string
+}
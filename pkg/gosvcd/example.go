@@ -1,7 +1,13 @@
 package gosvcd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,38 +17,266 @@ import (
 // Event
 //
 type ExampleEvent struct {
-	svc       Service
+	source    ServiceId
 	eventType EventType
 	data      interface{}
+	timestamp time.Time
+
+	ctx  context.Context
+	span Span
+
+	// seq is the sequence number in the journal, or zero if the event
+	// has not been journaled.
+	seq uint64
+
+	// inFlight is the daemon's journaled events being handled if the
+	// event is one of them, so that the journal is not checkpointed past
+	// it before it has been delivered.
+	inFlight *inFlightEvents
+
+	// routed is the sequence number of the event in the order of routing.
+	routed uint64
+
+	// key is the idempotency key, if any.
+	key string
+
+	// expires is the time after which the event is dropped instead of
+	// delivered, or zero if the event does not expire.
+	expires time.Time
+
+	// cancel is the token for cancelling the event, if cancellable.
+	cancel *cancelToken
+
+	// info is the resolved event type, if resolved when emitted.
+	info *typeInfo
+
+	// target is the service a directed event is delivered to, if
+	// 'directed' is set. See EmitTo.
+	target   ServiceId
+	directed bool
+
+	// replyTo is the reply address, if any, and correlation the
+	// correlation of a reply. See ReplyTo.
+	replyTo     *ReplyAddress
+	correlation string
+
+	// admitted is true if the event holds a slot of its bulk queue.
+	admitted bool
+
+	// delivery tracks the delivery of the event, if any. See
+	// WithDelivery.
+	delivery *Delivery
+
+	// tickets order the event among the events of its source for the
+	// SourceOrdered subscribers.
+	tickets []orderTicket
+
+	// credit is the credits of the event type if the event took one, to
+	// be returned once delivered. See SetCreditWindow.
+	credit *credits
+
+	// settled is set once the event has been delivered to all the
+	// subscribers and its delivery and credit accounted for.
+	settled int32
+
+	// pooled is true if the event is from the event pool. It is returned
+	// to the pool when 'refs' drops to zero, unless 'pinned' is set. The
+	// references are counted for the pooled, tracked, credited and
+	// journaled events.
+	pooled bool
+	refs   int32
+	pinned int32
 }
 
 func (ev *ExampleEvent) ServiceId() ServiceId {
-	return ev.svc.ID()
+	return ev.source
 }
 
 func (ev *ExampleEvent) EventType() EventType {
 	return ev.eventType
 }
 
+func (ev *ExampleEvent) Timestamp() time.Time {
+	return ev.timestamp
+}
+
 func (ev *ExampleEvent) Data() interface{} {
 	return ev.data
 }
 
+func (ev *ExampleEvent) Context() context.Context {
+	return ev.ctx
+}
+
+// Sequence returns the sequence number of the event, assigned when the
+// event is routed. The numbers increase with each event routed by the
+// daemon, so the events are totally ordered and duplicates detected by
+// their numbers. The events converted to the other versions of their type
+// carry the number of the emitted event, and replayed retained events the
+// number they were first routed with.
+//
+// The numbers start from one when the daemon is started. With a journal,
+// they continue from the last journaled event, and the replayed events
+// keep the number they were journaled with.
+func (ev *ExampleEvent) Sequence() uint64 {
+	return ev.routed
+}
+
 //
 // Handle
 //
 
 type ExampleServiceHandle struct {
+	// drainAfter is the routing sequence number of the last event
+	// delivered to a draining service, or zero.
+	drainAfter uint64
+
+	// replayedUpTo is the routing sequence number of the last retained
+	// event replayed to the service when it was initialized.
+	replayedUpTo uint64
+
 	Service
-	evs chan *ExampleEvent
+	d *ExampleServiceDaemon
+
+	// mu serializes the initialization, event handling and shutdown
+	// of the service. The handlers of a ConcurrentHandler hold it for
+	// reading.
+	mu sync.RWMutex
+
+	// workers limits the concurrent handlers of a ConcurrentHandler, or
+	// is nil if the service handles one event at a time.
+	workers chan struct{}
+
+	// partitions orders the handlers of a PartitionedHandler by the
+	// partition keys of the events, or is nil.
+	partitions *partitions
+
+	// lazy is true if the service is initialized on first use.
+	lazy bool
+
+	// bookMu protects the bookkeeping of the handler invocations:
+	// 'slowCount', 'breaker', 'quarantine' and 'degraded', and
+	// 'permits'.
+	bookMu sync.Mutex
+
+	// state is the ServiceState, accessed atomically so that it can be
+	// read while the service is busy.
+	state int32
+
+	// slowCount is the number of consecutive slow handler invocations.
+	slowCount int
+
+	breaker circuitBreaker
+
+	quarantine quarantine
+
+	// degraded are the optional dependencies that were not running when
+	// the service was initialized.
+	degraded []ServiceId
+
+	dedup dedupSet
+
+	// permits are the held permits of the semaphores.
+	permits map[*Permit]bool
+
+	// budget is the resource usage of a service with a budget, or nil.
+	budget *budgetState
+
+	// abandoned is the number of handlers that timed out and have not
+	// returned yet. See SetHandlerTimeout.
+	abandoned int32
+
+	// handling is the number of handlers of the service in progress.
+	// Their emits are spilled instead of blocking on a full emit ring.
+	handling int32
+
+	// ctx is cancelled when the service is shut down.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (h *ExampleServiceHandle) setState(s ServiceState) {
+	atomic.StoreInt32(&h.state, int32(s))
+}
+
+func (h *ExampleServiceHandle) getState() ServiceState {
+	return ServiceState(atomic.LoadInt32(&h.state))
 }
 
-func (h *ExampleServiceHandle) EmitEvent(eventType EventType, data interface{}) {
-	h.evs <- &ExampleEvent{h, eventType, data}
+// initService initializes the service. Must be called with 'mu' held.
+func (h *ExampleServiceHandle) initService() {
+	h.d.log.Info("Initializing service", "service", h.Name(), "id", h.ID())
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.bookMu.Lock()
+	h.breaker = circuitBreaker{}
+	h.bookMu.Unlock()
+	h.setState(ServiceInitializing)
+	h.d.checkDegraded(h)
+	h.restoreSnapshot()
+	h.injectDependencies()
+	err := safeCall(func() {
+		pprof.Do(context.Background(), h.labels(), func(context.Context) {
+			h.Service.Init(h)
+		})
+	})
+	if err != nil {
+		h.cancel()
+		h.setState(ServiceFailed)
+		h.d.fail(h, OpInit, "", err)
+		return
+	}
+	h.setState(ServiceRunning)
+	h.d.log.Info("Service started", "service", h.Name(), "id", h.ID())
+	h.d.replayRetained(h)
+	h.d.recovered(h)
 }
 
+// shutdownService shuts down the service. Must be called with 'mu' held.
+func (h *ExampleServiceHandle) shutdownService() {
+	if h.getState() != ServiceRunning {
+		return
+	}
+	h.d.log.Info("Shutting down service", "service", h.Name(), "id", h.ID())
+	h.takeSnapshot()
+	if err := safeCall(h.Service.Shutdown); err != nil {
+		h.d.fail(h, OpShutdown, "", err)
+	}
+	h.cancel()
+	h.releasePermits()
+	h.setState(ServiceStopped)
+	h.d.log.Info("Service stopped", "service", h.Name(), "id", h.ID())
+}
+
+func (h *ExampleServiceHandle) EmitEvent(eventType EventType, data interface{}) error {
+	return h.EmitEventContext(context.Background(), eventType, data)
+}
+
+func (h *ExampleServiceHandle) EmitEventContext(ctx context.Context, eventType EventType, data interface{}) error {
+	return h.d.emit(ctx, h.ID(), eventType, data)
+}
+
+func (h *ExampleServiceHandle) Go(f func(ctx context.Context)) {
+	if !h.d.startGoroutine(h) {
+		return
+	}
+	ctx := h.ctx
+	h.d.goroutines.Go(h.Name(), func() {
+		defer h.goroutineDone()
+		pprof.Do(ctx, h.labels(), f)
+	})
+}
+
+func (h *ExampleServiceHandle) labels() pprof.LabelSet {
+	return pprof.Labels("service", h.Name())
+}
+
+// Unregister drains the service in the background. See Drain.
 func (h *ExampleServiceHandle) Unregister() {
-	panic("unimplemented")
+	h.d.spawn(func() {
+		if err := h.d.Drain(h.ID()); err != nil {
+			h.d.log.Error("Failed to unregister service", "service", h.Name(), "error", err)
+		}
+	})
 }
 
 //
@@ -51,36 +285,377 @@ func (h *ExampleServiceHandle) Unregister() {
 
 type ExampleServiceDaemonBuilder struct {
 	handles map[ServiceId]*ExampleServiceHandle
-	evs     chan *ExampleEvent
+	tracer  Tracer
+	log     Logger
+	audit   *AuditLog
+
+	slowConsumers SlowConsumerPolicy
+	watermarks    QueueWatermarks
+	stallTimeout  time.Duration
+
+	leakGracePeriod time.Duration
+
+	signals bool
+
+	validators map[EventType]Validator
+
+	emitCaps map[ServiceId]map[EventType]bool
+	acls     map[EventType]map[ServiceId]bool
+
+	budgets map[ServiceId]ResourceBudget
+
+	chaos *ChaosConfig
+
+	overrides map[ServiceId]Service
+
+	// duplicates are the identifiers registered more than once.
+	duplicates []ServiceId
+
+	clock       Clock
+	buffers     BufferSizes
+	metricsSink MetricsSink
+
+	journal *Journal
+	store   EventStore
+
+	snapshots        SnapshotStore
+	snapshotInterval time.Duration
+
+	restore       *Checkpoint
+	checkpointDir string
+
+	coord CoordinationStore
+
+	ackTimeouts map[EventType]time.Duration
+	retryPolicy RetryPolicy
+	breaker     CircuitBreakerPolicy
+	quarantine  QuarantinePolicy
+
+	dispatchMode    DispatchMode
+	dispatchWorkers int
+
+	pooling bool
+
+	affinity *ThreadAffinity
+
+	qos map[EventType]QoSClass
+
+	ttls              map[EventType]time.Duration
+	deadLetterExpired bool
+
+	retention map[EventType]int
+
+	delivery map[EventType]DeliveryMode
+
+	creditWindows map[EventType]int
+
+	subBuffer  SubscriptionBuffer
+	subBuffers map[HandlerKey]SubscriptionBuffer
+
+	handlerTimeouts map[ServiceId]HandlerTimeout
+
+	supervisors []SupervisorSpec
+
+	groups map[string][]ServiceId
 }
 
-func NewBuilder() *ExampleServiceDaemonBuilder {
-	return &ExampleServiceDaemonBuilder{
+// NewBuilder returns a builder configured with the options.
+func NewBuilder(opts ...Option) *ExampleServiceDaemonBuilder {
+	b := &ExampleServiceDaemonBuilder{
 		handles: make(map[ServiceId]*ExampleServiceHandle),
-		evs:     make(chan *ExampleEvent, 128),
+		log:     NewTextLogger(os.Stderr, LevelInfo),
+		clock:   SystemClock,
+
+		leakGracePeriod: DefaultLeakGracePeriod,
+		validators:      make(map[EventType]Validator),
+	}
+	for _, opt := range opts {
+		opt(b)
 	}
+	return b
 }
 
 func (b *ExampleServiceDaemonBuilder) Register(svc Service) {
-	h := &ExampleServiceHandle{svc, b.evs}
+	if _, ok := b.handles[svc.ID()]; ok {
+		b.duplicates = append(b.duplicates, svc.ID())
+	}
+	b.register(b.overridden(svc))
+}
+
+func (b *ExampleServiceDaemonBuilder) register(svc Service) {
+	if ts, ok := svc.(taggedService); ok {
+		ts.tagged().parseTags(svc)
+	}
+	h := &ExampleServiceHandle{Service: svc, workers: newWorkerSlots(svc), partitions: newPartitions(svc), lazy: isLazy(svc)}
 	b.handles[svc.ID()] = h
 }
 
+// SetLogger sets the logger for the daemon's internals.
+func (b *ExampleServiceDaemonBuilder) SetLogger(l Logger) {
+	b.log = l
+}
+
+// SetAuditLog sets the log to which all dispatched events are recorded.
+func (b *ExampleServiceDaemonBuilder) SetAuditLog(a *AuditLog) {
+	b.audit = a
+}
+
+// SetSlowConsumerPolicy enables the detection of slow consumers.
+func (b *ExampleServiceDaemonBuilder) SetSlowConsumerPolicy(p SlowConsumerPolicy) {
+	b.slowConsumers = p
+}
+
+// SetQueueWatermarks enables the QueuePressure events.
+func (b *ExampleServiceDaemonBuilder) SetQueueWatermarks(w QueueWatermarks) {
+	b.watermarks = w
+}
+
+// SetStallTimeout enables the detection of stalled event loops. A loop is
+// stalled when it has work pending but has not made progress within the
+// timeout.
+func (b *ExampleServiceDaemonBuilder) SetStallTimeout(timeout time.Duration) {
+	b.stallTimeout = timeout
+}
+
+// SetLeakGracePeriod sets the time to wait after shutdown for the goroutines
+// of the daemon and the services to exit before reporting them as leaked.
+// A negative period disables the check.
+func (b *ExampleServiceDaemonBuilder) SetLeakGracePeriod(grace time.Duration) {
+	b.leakGracePeriod = grace
+}
+
+// HandleSignals makes the daemon shut down on SIGINT and SIGTERM, and emit
+// a ReloadRequested event on SIGHUP.
+func (b *ExampleServiceDaemonBuilder) HandleSignals() {
+	b.signals = true
+}
+
+// SetTracer sets the tracer for the event flows.
+func (b *ExampleServiceDaemonBuilder) SetTracer(t Tracer) {
+	b.tracer = t
+}
+
+// SetJournal sets the journal to which the emitted events are written
+// before they are dispatched. The events that had not been handled when
+// the daemon last stopped are replayed once the services have been
+// initialized. The journal is not closed by the daemon.
+func (b *ExampleServiceDaemonBuilder) SetJournal(j *Journal) {
+	b.journal = j
+}
+
+// SetEventStore sets the store to which the emitted events are appended.
+func (b *ExampleServiceDaemonBuilder) SetEventStore(s EventStore) {
+	b.store = s
+}
+
+// SetSnapshots enables the snapshots of the services implementing
+// Snapshotter. The snapshots are taken at the interval, if positive, and
+// when the services are shut down.
+func (b *ExampleServiceDaemonBuilder) SetSnapshots(store SnapshotStore, interval time.Duration) {
+	b.snapshots = store
+	b.snapshotInterval = interval
+}
+
+// SetAckTimeout makes the delivery of the events of the type at-least-once:
+// the subscribers must acknowledge the events (see AckHandler), and the
+// events not acknowledged within the timeout are redelivered. With a
+// journal, the unacknowledged events are replayed after a restart, along
+// with the events journaled after them.
+func (b *ExampleServiceDaemonBuilder) SetAckTimeout(typ EventType, timeout time.Duration) {
+	if timeout <= 0 {
+		delete(b.ackTimeouts, typ)
+		return
+	}
+	if b.ackTimeouts == nil {
+		b.ackTimeouts = make(map[EventType]time.Duration)
+	}
+	b.ackTimeouts[typ] = timeout
+}
+
+// SetRetryPolicy sets the policy for retrying the failed deliveries to the
+// services implementing RetryHandler. Defaults to DefaultRetryPolicy.
+func (b *ExampleServiceDaemonBuilder) SetRetryPolicy(p RetryPolicy) {
+	b.retryPolicy = p
+}
+
+// SetCircuitBreaker enables the per-service circuit breakers.
+func (b *ExampleServiceDaemonBuilder) SetCircuitBreaker(p CircuitBreakerPolicy) {
+	b.breaker = p
+}
+
+// SetQuarantinePolicy enables the automatic pausing of the services whose
+// handlers keep failing. See PauseService.
+func (b *ExampleServiceDaemonBuilder) SetQuarantinePolicy(p QuarantinePolicy) {
+	b.quarantine = p
+}
+
+// Supervise registers a supervisor for restarting the services when they
+// fail. See SupervisorSpec.
+func (b *ExampleServiceDaemonBuilder) Supervise(spec SupervisorSpec) {
+	b.supervisors = append(b.supervisors, spec)
+}
+
+// SetDispatchMode sets how the dispatch queues are run. 'workers' is the
+// number of workers or shards for the modes using a pool of workers,
+// defaulting to GOMAXPROCS.
+func (b *ExampleServiceDaemonBuilder) SetDispatchMode(mode DispatchMode, workers int) {
+	b.dispatchMode = mode
+	b.dispatchWorkers = workers
+}
+
+// SetEventPooling enables the reuse of the daemon's events, reducing the
+// allocations of high-frequency emitters. With pooling, the handlers must
+// not hold on to the events, or their contexts, after returning. The
+// events passed to taps, the event store and at-least-once deliveries are
+// not reused.
+func (b *ExampleServiceDaemonBuilder) SetEventPooling(enabled bool) {
+	b.pooling = enabled
+}
+
+// SetThreadAffinity sets the locking of the daemon's goroutines to OS
+// threads.
+func (b *ExampleServiceDaemonBuilder) SetThreadAffinity(a ThreadAffinity) {
+	b.affinity = &a
+}
+
+// SetQoS sets the quality-of-service class of the event type.
+func (b *ExampleServiceDaemonBuilder) SetQoS(typ EventType, class QoSClass) {
+	if b.qos == nil {
+		b.qos = make(map[EventType]QoSClass)
+	}
+	b.qos[typ] = class
+}
+
+// SetTTL sets the time-to-live of the events of the type. The events
+// still queued when their TTL has passed are dropped. A TTL set with
+// WithTTL when emitting takes precedence.
+func (b *ExampleServiceDaemonBuilder) SetTTL(typ EventType, ttl time.Duration) {
+	if ttl <= 0 {
+		delete(b.ttls, typ)
+		return
+	}
+	if b.ttls == nil {
+		b.ttls = make(map[EventType]time.Duration)
+	}
+	b.ttls[typ] = ttl
+}
+
+// SetDeadLetterExpired makes the daemon emit a DeadLetter for each
+// delivery of an expired event it drops.
+func (b *ExampleServiceDaemonBuilder) SetDeadLetterExpired(enabled bool) {
+	b.deadLetterExpired = enabled
+}
+
+// SetValidator sets the validator for the payloads of the event type.
+// Emitting an event with a payload rejected by the validator fails with
+// a *ValidationError.
+func (b *ExampleServiceDaemonBuilder) SetValidator(typ EventType, v Validator) {
+	b.validators[typ] = v
+}
+
 func (b *ExampleServiceDaemonBuilder) Start() ServiceDaemon {
-	svcs, subs := toposortServices(b.handles)
+	if problems := b.resolveTagged(); len(problems) > 0 {
+		panic(strings.Join(problems, "; "))
+	}
+	svcs, subs := toposortServices(b.log, b.handles)
+	applyACLs(b.log, subs, b.acls)
+	buffers := b.buffers.withDefaults()
 	s := &ExampleServiceDaemon{
 		handles:  b.handles,
 		services: svcs,
 		subs:     subs,
-		evs:      b.evs,
+		evs:      newEventRing(buffers.Emit),
+		queues:   make(map[EventType]*dispatcher),
+		versions: newVersionIndex(subs),
+		metrics:  newDaemonMetrics(b.metricsSink),
+		clock:    b.clock,
+		tracer:   b.tracer,
+		log:      b.log,
+		audit:    b.audit,
+
+		validators: b.validators,
+		emitCaps:   b.emitCaps,
+		acls:       b.acls,
+		budgets:    b.budgets,
+		journal:    b.journal,
+		store:      b.store,
+
+		snapshots:        b.snapshots,
+		snapshotInterval: b.snapshotInterval,
+		restore:          b.restore,
+		checkpointDir:    b.checkpointDir,
+		coord:            b.coord,
+
+		ackTimeouts: b.ackTimeouts,
+		retryPolicy: b.retryPolicy.withDefaults(),
+		breaker:     b.breaker,
+		quarantine:  b.quarantine,
+		pooling:     b.pooling,
+
+		ttls:              b.ttls,
+		deadLetterExpired: b.deadLetterExpired,
+		retention:         newRetention(b.retention),
+		creditWindows:     b.creditWindows,
+		handlerTimeouts:   b.handlerTimeouts,
+
+		groups: b.groups,
+
+		slowConsumers: b.slowConsumers,
+		watermarks:    b.watermarks,
+		stallTimeout:  b.stallTimeout,
+		stop:          make(chan struct{}),
+		drained:       make(chan struct{}),
+
+		goroutines:      newGoroutineTracker(),
+		leakGracePeriod: b.leakGracePeriod,
+		errs:            newErrorReporter(),
+		ready:           make(chan struct{}),
+		shuttingDown:    make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	s.taps.size = buffers.Tap
+	for _, h := range b.handles {
+		h.d = s
+		h.budget = s.newBudgetState(h.ID())
+	}
+	s.orders = newSourceOrders(b.handles)
+	if b.affinity != nil {
+		s.threads = newThreadLocker(*b.affinity)
+	}
+	if b.chaos != nil {
+		s.chaos = newChaos(b.log, *b.chaos)
 	}
-	fmt.Print("Services in dependency order: ")
-	for _, svc := range svcs {
-		fmt.Printf("%d ", svc.ID())
+	if s.coord == nil {
+		s.coord = NewMemoryCoordination()
 	}
-	fmt.Println("")
+	switch b.dispatchMode {
+	case DispatchWorkStealing:
+		s.sched = newScheduler(b.dispatchWorkers, true)
+	case DispatchSharded:
+		s.sched = newScheduler(b.dispatchWorkers, false)
+	}
+	for typ, svcs := range subs {
+		hs := make([]*ExampleServiceHandle, len(svcs))
+		for i, svc := range svcs {
+			hs[i] = b.handles[svc.ID()]
+		}
+		s.queues[typ] = newDispatcher(typ, b.qos[typ], buffers.Dispatch, hs)
+		s.queues[typ].delivery = b.delivery[typ]
+		s.queues[typ].bufs = b.newSubscriptions(typ, hs)
+	}
+	s.indexTypes()
+	s.buildSupervisors(b.supervisors)
+	order := make([]ServiceId, len(svcs))
+	for i, svc := range svcs {
+		order[i] = svc.ID()
+	}
+	s.emitDone = sync.NewCond(&s.emitMu)
+	b.log.Info("Starting daemon", "services", order)
 
-	go s.run()
+	s.spawn(s.run)
+	if b.signals {
+		s.spawn(s.handleSignals)
+	}
 
 	return s
 }
@@ -104,7 +679,7 @@ func removeEdge(edge ServiceId, edges []ServiceId) []ServiceId {
 	panic(fmt.Sprintf("edge %v not found from %v", edge, edges))
 }
 
-func toposortServices(svcs map[ServiceId]*ExampleServiceHandle) ([]Service, map[EventType][]Service) {
+func toposortServices(log Logger, svcs map[ServiceId]*ExampleServiceHandle) ([]Service, map[EventType][]Service) {
 	if len(svcs) == 0 {
 		return nil, nil
 	}
@@ -130,8 +705,7 @@ func toposortServices(svcs map[ServiceId]*ExampleServiceHandle) ([]Service, map[
 		}
 	}
 
-	fmt.Printf("in: %v\n", in)
-	fmt.Printf("out: %v\n", out)
+	log.Debug("Sorting service dependency graph", "in", in, "out", out)
 
 	if len(s) == 0 {
 		panic("toposortServices: Services don't form a DAG!")
@@ -178,6 +752,20 @@ func toposortServices(svcs map[ServiceId]*ExampleServiceHandle) ([]Service, map[
 //
 
 type ExampleServiceDaemon struct {
+	// routed is the sequence number of the last event moved to the
+	// dispatch queues.
+	routed uint64
+
+	// pending counts the events being routed, queued for dispatch or
+	// handled by workers.
+	pending int64
+
+	// routing is one while an event is being moved to a dispatch queue.
+	routing int32
+
+	// mu protects the registry of services: 'handles', 'services', 'subs',
+	// 'completed' and 'queues'.
+	mu      sync.RWMutex
 	handles map[ServiceId]*ExampleServiceHandle
 
 	// Services in dependency order, roots first.
@@ -186,49 +774,770 @@ type ExampleServiceDaemon struct {
 	// Subscriptions, in topologically sorted order.
 	subs map[EventType][]Service
 
-	// Event channel
-	evs chan *ExampleEvent
+	// Emitted events to route
+	evs *eventRing
+
+	// completed are the one-shot services that have completed.
+	completed map[ServiceId]bool
+
+	// Dispatch queue for each subscribed event type.
+	queues map[EventType]*dispatcher
+
+	// sched runs the dispatch queues on a pool of workers, or is nil if
+	// each queue has its own goroutine.
+	sched *scheduler
+
+	// Event types resolved at Start, indexed by 'typeIndex'.
+	types     []*typeInfo
+	typeIndex map[EventType]int
+
+	// Subscribed versions of the versioned event types.
+	versions versionIndex
+
+	metrics *daemonMetrics
+
+	// clock is the source of the timestamps, see Clock.
+	clock Clock
+
+	// Taps mirroring events to external observers.
+	taps tapSet
+
+	tracer Tracer
+
+	log Logger
+
+	audit *AuditLog
+
+	validators map[EventType]Validator
+
+	// Event types the services may emit, for the restricted services.
+	emitCaps map[ServiceId]map[EventType]bool
+
+	// Services allowed to subscribe to the restricted event types.
+	acls map[EventType]map[ServiceId]bool
+
+	budgets map[ServiceId]ResourceBudget
+
+	// chaos injects faults into the delivery of events, or is nil.
+	chaos *chaos
+
+	journal *Journal
+
+	// Events queued for appending to the store.
+	store     EventStore
+	storeCh   chan Event
+	storeDone chan struct{}
+
+	snapshots        SnapshotStore
+	snapshotInterval time.Duration
+
+	// The checkpoint restored at Start, see SetRestore. Guarded by 'mu'
+	// after Start.
+	restore       *Checkpoint
+	checkpointDir string
+
+	// The store of the semaphores of the services, and the channel
+	// closed when a permit is released.
+	coord   CoordinationStore
+	coordMu sync.Mutex
+	coordCh chan struct{}
+
+	// Unacknowledged deliveries of the at-least-once event types.
+	ackTimeouts map[EventType]time.Duration
+	acks        ackTracker
+
+	// The journaled events not yet delivered.
+	inFlight inFlightEvents
+
+	retryPolicy RetryPolicy
+	breaker     CircuitBreakerPolicy
+	quarantine  QuarantinePolicy
+
+	// pooling is true if the events are reused.
+	pooling bool
+
+	// pause holds the dispatchers while the dispatch is paused.
+	pause pauseGate
+
+	// groups are the services of the named groups.
+	groups map[string][]ServiceId
+
+	// supervisorOf are the supervisors of the supervised services.
+	supervisorOf map[ServiceId]*supervisor
+
+	// cancels are the cancellable events being routed or queued.
+	cancels cancelSet
+
+	// ttls are the time-to-lives of the event types.
+	ttls              map[EventType]time.Duration
+	deadLetterExpired bool
+
+	// retention keeps the retained events, or is nil if no type is
+	// retained.
+	retention *retention
+
+	// creditWindows are the credit windows of the event types set with
+	// the builder.
+	creditWindows map[EventType]int
+
+	// handlerTimeouts are the handler timeouts of the services.
+	handlerTimeouts map[ServiceId]HandlerTimeout
+
+	// orders sequence the events delivered to the SourceOrdered
+	// services, or is nil if there are none.
+	orders map[ServiceId]*sourceOrder
+
+	// threads locks goroutines to OS threads, or is nil if not configured.
+	threads *threadLocker
+
+	slowConsumers SlowConsumerPolicy
+	watermarks    QueueWatermarks
+
+	stallTimeout  time.Duration
+	stallDetector stallDetector
+
+	// stop is closed when the daemon is shut down to stop the background
+	// tasks.
+	stop chan struct{}
+
+	goroutines      *goroutineTracker
+	leakGracePeriod time.Duration
+
+	// workers tracks the workers of the concurrent services.
+	workers sync.WaitGroup
+
+	// emitMu protects 'stopping' and 'emitting'.
+	emitMu   sync.Mutex
+	emitDone *sync.Cond
+
+	// stopping is true once the daemon has stopped accepting new events.
+	stopping bool
+
+	// emitting is the number of emits in progress.
+	emitting int
+
+	// drained is closed when all emitted events have been delivered after
+	// 'evs' has been closed.
+	drained chan struct{}
+
+	shutdownOnce sync.Once
+
+	errs *errorReporter
+
+	// ready is closed when the services have been initialized.
+	ready chan struct{}
+
+	// shuttingDown is closed when the shutdown starts.
+	shuttingDown chan struct{}
+
+	// done is closed when the shutdown has completed.
+	done chan struct{}
+}
+
+func (d *ExampleServiceDaemon) handle(id ServiceId) (*ExampleServiceHandle, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	h, ok := d.handles[id]
+	return h, ok
+}
+
+// orderedHandles returns the handles of the services in dependency order.
+func (d *ExampleServiceDaemon) orderedHandles() []*ExampleServiceHandle {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	hs := make([]*ExampleServiceHandle, len(d.services))
+	for i, s := range d.services {
+		hs[i] = d.handles[s.ID()]
+	}
+	return hs
+}
+
+func (d *ExampleServiceDaemon) queue(typ EventType) (*dispatcher, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	q, ok := d.queues[typ]
+	return q, ok
+}
+
+func (d *ExampleServiceDaemon) queueDepth(typ EventType) int {
+	if q, ok := d.queue(typ); ok {
+		return len(q.ch)
+	}
+	return 0
+}
+
+func (d *ExampleServiceDaemon) serviceName(id ServiceId) string {
+	if h, ok := d.handle(id); ok {
+		return h.Name()
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+func (d *ExampleServiceDaemon) allQueues() []*dispatcher {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	qs := make([]*dispatcher, 0, len(d.queues))
+	for _, q := range d.queues {
+		qs = append(qs, q)
+	}
+	return qs
+}
+
+// spawn runs 'f' in a goroutine of the daemon.
+func (d *ExampleServiceDaemon) spawn(f func()) {
+	d.goroutines.Go(daemonOwner, func() {
+		pprof.Do(context.Background(), pprof.Labels("gosvcd", daemonOwner), func(context.Context) {
+			f()
+		})
+	})
 }
 
 func (d *ExampleServiceDaemon) run() {
 
-	// Initialize the services (in dependency order)
-	for _, s := range d.services {
-		s.Init(d.handles[s.ID()])
+	if d.restore != nil {
+		d.checkRestore()
 	}
 
+	// Initialize the services (in dependency order)
+	d.initServices()
+	d.log.Info("Daemon ready")
+	close(d.ready)
+
 	// Dispatch events to services
-	chans := make(map[EventType]chan *ExampleEvent)
-
-	for typ, svcs := range d.subs {
-		ch := make(chan *ExampleEvent, 128)
-		chans[typ] = ch
-		go func() {
-			for ev := range ch {
-				for _, svc := range svcs {
-					svc.HandleEvent(ev)
-				}
+	var dispatchers sync.WaitGroup
+	if d.sched != nil {
+		d.sched.start(d)
+	} else {
+		for _, q := range d.allQueues() {
+			q := q
+			dispatchers.Add(1)
+			d.spawn(func() {
+				defer dispatchers.Done()
+				defer d.lockDispatch("dispatcher " + string(q.typ))()
+				q.run(d)
+			})
+		}
+	}
+	var subscriptions sync.WaitGroup
+	d.startSubscriptions(&subscriptions)
+	d.spawn(d.monitorQueues)
+	d.spawn(d.watchLoops)
+	d.startBudgets()
+	if len(d.ackTimeouts) > 0 {
+		d.spawn(d.redeliverLoop)
+	}
+	if d.snapshots != nil && d.snapshotInterval > 0 {
+		d.spawn(d.snapshotLoop)
+	}
+	if d.store != nil {
+		d.storeCh = make(chan Event, 4*storeBatchSize)
+		d.storeDone = make(chan struct{})
+		d.spawn(d.storeEvents)
+	}
+	if d.journal != nil {
+		d.replayJournal()
+		d.spawn(d.checkpointJournal)
+	}
+	if d.restore != nil {
+		d.restoreEvents()
+	}
+
+	buf := make([]*ExampleEvent, 0, routeBatch)
+	for {
+		buf = d.evs.drain(buf[:0])
+		if len(buf) == 0 {
+			break
+		}
+		for i, ev := range buf {
+			d.route(ev)
+			buf[i] = nil
+		}
+	}
+
+	// 'evs' has been closed by Shutdown. Wait for the dispatchers to deliver
+	// the remaining events.
+	for _, q := range d.allQueues() {
+		close(q.ch)
+	}
+	if d.sched != nil {
+		d.sched.stop()
+	}
+	dispatchers.Wait()
+	d.closeSubscriptions()
+	subscriptions.Wait()
+	d.workers.Wait()
+	if d.store != nil {
+		close(d.storeCh)
+		<-d.storeDone
+	}
+	if d.journal != nil {
+		if err := d.journal.Checkpoint(d.checkpointSeq(d.journal.LastSeq())); err != nil {
+			d.log.Error("Failed to checkpoint journal", "error", err)
+		}
+	}
+	d.taps.close()
+	close(d.drained)
+}
+
+// route journals the event and queues it to the dispatchers of its type.
+func (d *ExampleServiceDaemon) route(ev *ExampleEvent) {
+	// Count the event as pending until it has been queued.
+	atomic.AddInt64(&d.pending, 1)
+	defer atomic.AddInt64(&d.pending, -1)
+
+	// The events replayed from the journal or restored from a checkpoint
+	// keep the sequence number they were encoded with, unless passed.
+	// See ExampleEvent.Sequence.
+	if last := atomic.LoadUint64(&d.routed); ev.routed <= last {
+		ev.routed = last + 1
+	}
+
+	// Replayed events have already been journaled and stored, and the
+	// directed events are not.
+	if replayed := ev.seq != 0; !replayed && !ev.directed {
+		if d.journal != nil {
+			seq, err := d.journal.Append(ev)
+			if err != nil {
+				d.log.Error("Failed to append event to journal", "event_type", ev.eventType, "error", err)
 			}
-		}()
+			ev.seq = seq
+		}
+		if d.store != nil {
+			ev.pin()
+			d.storeCh <- ev
+		}
+	}
+	if ev.seq != 0 && d.journal != nil {
+		if !ev.counted() {
+			ev.refs = 1
+		}
+		ev.inFlight = &d.inFlight
+		d.inFlight.add(ev.seq)
 	}
+	d.metrics.eventEmitted(ev.eventType)
+	if d.taps.publish(ev) {
+		ev.pin()
+	}
+	if ev.info == nil {
+		ev.info = d.resolve(ev.eventType)
+	}
+	var q *dispatcher
+	if ev.info != nil {
+		q = ev.info.queue
+	}
+	ok := q != nil
+	converted := d.convertVersions(ev)
+	if ok || len(converted) > 0 {
+		if d.audit != nil {
+			if err := d.audit.Record(ev); err != nil {
+				d.log.Error("Failed to write audit record", "error", err)
+			}
+		}
+	}
+	d.retention.store(ev)
+	for _, cev := range converted {
+		d.retention.store(cev)
+		cq, _ := d.queue(cev.eventType)
+		d.enqueue(cq, cev)
+		cev.release()
+	}
+	if ok {
+		d.enqueue(q, ev)
+	} else {
+		if len(converted) == 0 {
+			d.metrics.eventDropped(DropNoSubscribers, ev.eventType)
+		}
+		if ev.span != nil {
+			ev.span.End()
+		}
+	}
+	atomic.StoreUint64(&d.routed, ev.routed)
+	d.cancels.release(ev.cancel)
+	ev.release()
+}
 
-	for ev := range d.evs {
-		chans[ev.eventType] <- ev
+// enqueue queues the event to the dispatcher.
+func (d *ExampleServiceDaemon) enqueue(q *dispatcher, ev *ExampleEvent) {
+	d.assignTickets(q, ev)
+	atomic.AddInt64(&d.pending, 1)
+	ev.retain()
+	d.cancels.retain(ev.cancel)
+	atomic.StoreInt32(&d.routing, 1)
+	q.ch <- ev
+	atomic.StoreInt32(&d.routing, 0)
+	if d.sched != nil {
+		d.sched.schedule(q)
 	}
+	q.checkPressure(d)
+}
 
-	for _, ch := range chans {
-		close(ch)
+func (d *ExampleServiceDaemon) Tap(types ...EventType) (<-chan Event, func()) {
+	return d.taps.add(types, d.restrictedTypes())
+}
+
+func (d *ExampleServiceDaemon) emit(ctx context.Context, source ServiceId, eventType EventType, data interface{}) error {
+	return d.emitResolved(ctx, source, ResolvedType{typ: eventType}, data)
+}
+
+func (d *ExampleServiceDaemon) emitResolved(ctx context.Context, source ServiceId, rt ResolvedType, data interface{}) error {
+	if rt.info == nil {
+		rt.info = d.resolve(rt.typ)
+	}
+	if err := d.checkEmit(source, rt.typ); err != nil {
+		return err
+	}
+	if err := d.validate(source, rt, data); err != nil {
+		return err
 	}
+	credited, err := d.takeCredit(rt)
+	if err != nil {
+		return err
+	}
+	e := emission{ctx, source, rt.typ, data, rt.info, credited}
+	if ob := outboxFrom(ctx); ob != nil && ob.stage(e) {
+		return nil
+	}
+	return d.send(e)
 }
 
+// send sends the events to the router. Either all or none of them are
+// sent.
+func (d *ExampleServiceDaemon) send(evs ...emission) error {
+	d.emitMu.Lock()
+	if d.stopping {
+		d.emitMu.Unlock()
+		for _, e := range evs {
+			e.returnCredit()
+		}
+		return ErrDaemonStopped
+	}
+	d.emitting++
+	d.emitMu.Unlock()
+
+	defer func() {
+		d.emitMu.Lock()
+		d.emitting--
+		if d.emitting == 0 {
+			d.emitDone.Broadcast()
+		}
+		d.emitMu.Unlock()
+	}()
+
+	for _, e := range evs {
+		key, ctx := idempotencyKey(e.ctx)
+		ttl, ctx := ttlOf(ctx)
+		id, ctx := eventId(ctx)
+		target, directed, ctx := targetOf(ctx)
+		replyTo, ctx := replyToOf(ctx)
+		correlation, ctx := correlationOf(ctx)
+		delivery, ctx := deliveryOf(ctx)
+		var ev *ExampleEvent
+		if d.pooling {
+			ev = newPooledEvent()
+		} else {
+			ev = &ExampleEvent{}
+		}
+		ev.source = e.source
+		ev.eventType = e.eventType
+		ev.data = e.data
+		ev.timestamp = d.clock.Now()
+		ev.ctx = ctx
+		ev.key = key
+		ev.expires = expiry(ev.timestamp, ttl, e.info)
+		ev.info = e.info
+		ev.target, ev.directed = target, directed
+		ev.replyTo, ev.correlation = replyTo, correlation
+		if e.credited {
+			ev.credit = e.info.credits
+			if !ev.pooled {
+				ev.refs = 1
+			}
+		}
+		if delivery != nil {
+			delivery.track(ev)
+		}
+		if id != 0 {
+			ev.cancel = d.cancels.add(id)
+		}
+		if d.tracer != nil {
+			ev.ctx, ev.span = d.tracer.StartEmit(ctx, ev)
+		}
+		ev.admitted = e.info.admit()
+		if d.handling(e.source) {
+			d.evs.pushOrSpill(ev)
+		} else {
+			d.evs.push(ev)
+		}
+	}
+	return nil
+}
+
+// handling returns true if the service is handling an event, so that its
+// emits may come from a handler invoked by a dispatcher that the router
+// is waiting for.
+func (d *ExampleServiceDaemon) handling(id ServiceId) bool {
+	h, ok := d.handle(id)
+	return ok && atomic.LoadInt32(&h.handling) > 0
+}
+
+// emitAsync emits an event on behalf of the daemon without blocking the
+// caller, which may be a dispatcher.
+func (d *ExampleServiceDaemon) emitAsync(eventType EventType, data interface{}) {
+	d.spawn(func() { d.EmitEvent(eventType, data) })
+}
+
+// deliver invokes the event handler of the service, unless it is paused.
+func (d *ExampleServiceDaemon) deliver(h *ExampleServiceHandle, ev *ExampleEvent) {
+	if h.isDormant() {
+		d.activate(h)
+	}
+	if d.replayed(h, ev) {
+		return
+	}
+	if d.hold(h, ev) {
+		return
+	}
+	if d.chaos != nil && d.chaos.selected(h) {
+		d.chaos.deliver(d, h, ev)
+		return
+	}
+	d.deliverEvent(h, ev)
+}
+
+// deliverEvent invokes the event handler of the service.
+func (d *ExampleServiceDaemon) deliverEvent(h *ExampleServiceHandle, ev *ExampleEvent) {
+	var (
+		event Event = ev
+		span  Span
+	)
+	if d.tracer != nil {
+		var ctx context.Context
+		ctx, span = d.tracer.StartHandle(ev.ctx, h.Service, ev)
+		event = &tracedEvent{ev, ctx}
+	}
+	if d.expired(h, ev) || d.cancelled(ev) || d.isDuplicate(h, ev) {
+		if span != nil {
+			span.End()
+		}
+		return
+	}
+	var rec *ackRecord
+	if timeout := d.ackTimeout(ev); timeout > 0 {
+		ev.pin()
+		rec = d.acks.track(h, ev, timeout)
+	}
+	if h.partitions != nil {
+		d.deliverPartitioned(h, ev, event, rec, span)
+		return
+	}
+	if h.workers != nil {
+		d.deliverAsync(h, ev, event, rec, span)
+		return
+	}
+	d.deliverTo(h, ev, event, rec, span)
+}
+
+// deliverTo invokes the handler, retrying it if it fails, and ends the
+// span of the delivery.
+func (d *ExampleServiceDaemon) deliverTo(h *ExampleServiceHandle, ev *ExampleEvent, event Event, rec *ackRecord, span Span) {
+	if err := d.invoke(h, ev, event, rec); err != nil {
+		if _, ok := h.Service.(RetryHandler); ok {
+			d.retryDelivery(h, ev, event, rec, err)
+		} else {
+			ev.delivery.recordFailed()
+		}
+	}
+	if span != nil {
+		span.End()
+	}
+}
+
+// invokeHandler calls the event handler of the service if it is running.
+// Returns the error returned by a RetryHandler, or ErrCircuitOpen if the
+// event was not delivered because of the service's circuit breaker.
+func (d *ExampleServiceDaemon) invokeHandler(h *ExampleServiceHandle, ev *ExampleEvent, event Event, rec *ackRecord) (herr error) {
+	h.lockHandler()
+	if h.getState() != ServiceRunning {
+		h.unlockHandler()
+		d.metrics.eventDropped(DropNotRunning, ev.eventType)
+		return nil
+	}
+	h.bookMu.Lock()
+	allow := d.checkCircuit(h, ev.eventType)
+	h.bookMu.Unlock()
+	if !allow {
+		h.unlockHandler()
+		return ErrCircuitOpen
+	}
+	event, ob := withOutbox(h, event)
+	labels := d.labelContext(h, ev)
+	var stopCPU func()
+	if h.measuresCPU() {
+		stopCPU = h.measureCPU()
+	}
+	start := d.clock.Now()
+
+	// The emits of the handler and of its outbox must not block the
+	// dispatcher the router may be waiting for.
+	atomic.AddInt32(&h.handling, 1)
+	defer atomic.AddInt32(&h.handling, -1)
+	err := safeCall(func() {
+		// The labels are left on the goroutine: the dispatchers and
+		// workers restore their own once the event is dispatched.
+		pprof.SetGoroutineLabels(labels)
+		if d.chaos != nil {
+			d.chaos.injectPanic(h, ev)
+		}
+		herr = d.handleEvent(h, event, rec)
+	})
+	if stopCPU != nil {
+		stopCPU()
+	}
+	if err != nil {
+		d.fail(h, OpHandle, ev.eventType, err)
+	}
+	if err == nil && herr == nil {
+		d.handled(h, ev)
+		ev.delivery.recordHandled()
+	} else if err != nil {
+		ev.delivery.recordFailed()
+	}
+	latency := d.clock.Now().Sub(start)
+	d.metrics.eventHandled(h.ID(), ev.eventType, latency)
+	h.bookMu.Lock()
+	d.recordCircuit(h, err != nil || herr != nil)
+	d.recordQuarantine(h, err != nil || herr != nil)
+	d.checkLatency(h, ev.eventType, latency)
+	h.bookMu.Unlock()
+	h.unlockHandler()
+	if ob != nil {
+		d.commitOutbox(h, ob, err == nil && herr == nil)
+	}
+	return herr
+}
+
+func (d *ExampleServiceDaemon) Services() []Service {
+	hs := d.orderedHandles()
+	svcs := make([]Service, len(hs))
+	for i, h := range hs {
+		svcs[i] = h.Service
+	}
+	return svcs
+}
+
+// Services returns the services registered with the daemon of the service
+// in dependency order.
+func (h *ExampleServiceHandle) Services() []Service {
+	return h.d.Services()
+}
+
+func (d *ExampleServiceDaemon) EmitEvent(eventType EventType, data interface{}) error {
+	return d.emit(context.Background(), DaemonServiceId, eventType, data)
+}
+
+func (d *ExampleServiceDaemon) State(id ServiceId) (ServiceState, bool) {
+	h, ok := d.handle(id)
+	if !ok {
+		return ServicePending, false
+	}
+	return h.getState(), true
+}
+
+func (d *ExampleServiceDaemon) Restart(id ServiceId) error {
+	h, ok := d.handle(id)
+	if !ok {
+		return fmt.Errorf("service %d not found", id)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if d.isStopping() {
+		return ErrDaemonStopped
+	}
+	d.log.Info("Restarting service", "service", h.Name(), "id", id)
+	h.shutdownService()
+	h.initService()
+	d.metrics.serviceRestarted(id)
+	return nil
+}
+
+func (d *ExampleServiceDaemon) Metrics() MetricsSnapshot {
+	m := d.metrics.snapshot()
+	m.EmitQueueDepth = d.evs.len()
+	for _, q := range d.allQueues() {
+		m.QueueDepth[q.typ] = len(q.ch)
+	}
+	m.Retention = d.retention.stats()
+	m.Credits = d.creditStats()
+	m.SubscriptionDepth = make(map[HandlerKey]int)
+	for _, q := range d.allQueues() {
+		for id, s := range q.bufs {
+			m.SubscriptionDepth[HandlerKey{Service: id, EventType: q.typ}] = len(s.ch)
+		}
+	}
+	return m
+}
+
+func (d *ExampleServiceDaemon) Ready() <-chan struct{} {
+	return d.ready
+}
+
+func (d *ExampleServiceDaemon) Stopping() <-chan struct{} {
+	return d.shuttingDown
+}
+
+func (d *ExampleServiceDaemon) Done() <-chan struct{} {
+	return d.done
+}
+
+func (d *ExampleServiceDaemon) Err() <-chan error {
+	return d.errs.ch
+}
+
+func (d *ExampleServiceDaemon) isStopping() bool {
+	d.emitMu.Lock()
+	defer d.emitMu.Unlock()
+	return d.stopping
+}
+
+// Shutdown is idempotent and concurrent calls wait for the shutdown to
+// complete.
 func (d *ExampleServiceDaemon) Shutdown() {
+	d.shutdownOnce.Do(d.shutdown)
+}
+
+func (d *ExampleServiceDaemon) shutdown() {
+	d.log.Info("Shutting down daemon")
+	close(d.shuttingDown)
+
+	// Stop accepting new events and wait for the emits in progress to
+	// finish before closing the event channel.
+	d.emitMu.Lock()
+	d.stopping = true
+	for d.emitting > 0 {
+		d.emitDone.Wait()
+	}
+	d.emitMu.Unlock()
+	d.evs.close()
+	d.dropHeld()
+
+	// Drain the queued events to the subscribers.
+	<-d.drained
+	close(d.stop)
+
 	// Shut down the services in reverse dependency order, starting
 	// from the leafs.
-	for i := len(d.services) - 1; i >= 0; i-- {
-		d.services[i].Shutdown()
+	hs := d.orderedHandles()
+	for i := len(hs) - 1; i >= 0; i-- {
+		h := hs[i]
+		h.mu.Lock()
+		h.shutdownService()
+		h.mu.Unlock()
 	}
 
-	close(d.evs)
+	d.checkLeaks()
+	d.errs.close()
+	d.log.Info("Daemon stopped")
+	close(d.done)
 }
 
 //
@@ -259,17 +1568,26 @@ func (s *ExService) Dependencies() []ServiceId {
 func (s *ExService) Subscriptions() []EventType {
 	return []EventType{ExSomeEvent_Type}
 }
+func (s *ExService) Emits() []EventType {
+	if s.eventSource {
+		return []EventType{ExSomeEvent_Type}
+	}
+	return nil
+}
 func (s *ExService) Init(handle ServiceHandle) {
 	fmt.Println(s.Name() + ".Init")
 
 	if s.eventSource {
-		go func() {
+		handle.Go(func(ctx context.Context) {
 			for i := 0; i < 10; i++ {
-				time.Sleep(100 * time.Millisecond)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
 				handle.EmitEvent(ExSomeEvent_Type, &ExSomeEvent{i})
 			}
-
-		}()
+		})
 	}
 }
 func (s *ExService) HandleEvent(event Event) {
@@ -288,18 +1606,31 @@ func (s *ExService) Shutdown() {
 // Entrypoint
 //
 
-func RunExample() {
-
+// RunExample runs the example daemon for two seconds. With 'checkConfig'
+// set, it only validates the configuration.
+func RunExample(checkConfig bool) {
 	builder := NewBuilder()
+	builder.HandleSignals()
 
 	builder.Register(&ExService{2, []ServiceId{0, 1}, false})
 	builder.Register(&ExService{3, []ServiceId{2}, true})
 	builder.Register(&ExService{0, []ServiceId{}, false})
 	builder.Register(&ExService{1, []ServiceId{0}, false})
 
-	daemon := builder.Start()
+	if checkConfig {
+		if err := builder.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration OK")
+		return
+	}
 
-	time.Sleep(time.Second * 2)
+	daemon := builder.Start()
 
-	daemon.Shutdown()
+	select {
+	case <-daemon.Done():
+	case <-time.After(time.Second * 2):
+		daemon.Shutdown()
+	}
 }
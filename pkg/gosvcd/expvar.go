@@ -0,0 +1,83 @@
+package gosvcd
+
+import "expvar"
+
+// PublishExpvar publishes the daemon's statistics as an expvar variable with
+// the given name, making them available from the /debug/vars endpoint. Like
+// expvar.Publish, it panics if the name is already in use.
+func PublishExpvar(name string, d ServiceDaemon) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return expvarStats(d)
+	}))
+}
+
+func expvarStats(d ServiceDaemon) map[string]interface{} {
+	m := d.Metrics()
+
+	queues := map[string]int{"emit": m.EmitQueueDepth}
+	for typ, n := range m.QueueDepth {
+		queues[string(typ)] = n
+	}
+
+	latencies := make(map[ServiceId]map[string]interface{})
+	for key, h := range m.HandlerLatency {
+		byType, ok := latencies[key.Service]
+		if !ok {
+			byType = make(map[string]interface{})
+			latencies[key.Service] = byType
+		}
+		byType[string(key.EventType)] = expvarLatency(h)
+	}
+
+	services := make(map[string]interface{})
+	for _, svc := range d.Services() {
+		state, _ := d.State(svc.ID())
+		services[svc.Name()] = map[string]interface{}{
+			"id":              svc.ID(),
+			"state":           state.String(),
+			"degraded":        d.Degraded(svc.ID()),
+			"restarts":        m.Restarts[svc.ID()],
+			"timeouts":        m.HandlerTimeouts[svc.ID()],
+			"latency":         expvarLatency(m.ServiceLatency(svc.ID())),
+			"latency_by_type": latencies[svc.ID()],
+		}
+	}
+
+	retention := make(map[string]interface{}, len(m.Retention))
+	for typ, stats := range m.Retention {
+		retention[string(typ)] = map[string]interface{}{
+			"depth":  stats.Depth,
+			"events": stats.Events,
+			"bytes":  stats.Bytes,
+		}
+	}
+
+	credits := make(map[string]interface{}, len(m.Credits))
+	for typ, stats := range m.Credits {
+		credits[string(typ)] = map[string]interface{}{
+			"window":    stats.Window,
+			"in_flight": stats.InFlight,
+			"exhausted": stats.Exhausted,
+		}
+	}
+
+	return map[string]interface{}{
+		"emitted":    m.Emitted,
+		"dispatched": m.Dispatched,
+		"dropped":    m.Dropped,
+		"queues":     queues,
+		"services":   services,
+		"retention":  retention,
+		"credits":    credits,
+	}
+}
+
+func expvarLatency(h Histogram) map[string]interface{} {
+	stats := h.Stats()
+	return map[string]interface{}{
+		"count": stats.Count,
+		"p50":   stats.P50.String(),
+		"p95":   stats.P95.String(),
+		"p99":   stats.P99.String(),
+	}
+}
@@ -0,0 +1,143 @@
+package gosvcd
+
+import "fmt"
+
+// SetGroup assigns the services to the named group, for controlling their
+// lifecycle together with StartGroup, StopGroup and RestartGroup. A
+// service belongs to at most one group.
+func (b *ExampleServiceDaemonBuilder) SetGroup(name string, ids ...ServiceId) {
+	if b.groups == nil {
+		b.groups = make(map[string][]ServiceId)
+	}
+	for _, id := range ids {
+		for g, members := range b.groups {
+			b.groups[g] = removeId(members, id)
+		}
+	}
+	b.groups[name] = append(b.groups[name], ids...)
+}
+
+func removeId(ids []ServiceId, id ServiceId) []ServiceId {
+	out := ids[:0]
+	for _, other := range ids {
+		if other != id {
+			out = append(out, other)
+		}
+	}
+	return out
+}
+
+// groupHandles returns the handles of the registered services of the group
+// in dependency order.
+func (d *ExampleServiceDaemon) groupHandles(name string) ([]*ExampleServiceHandle, error) {
+	members, ok := d.groups[name]
+	if !ok {
+		return nil, fmt.Errorf("group %q not found", name)
+	}
+	set := make(map[ServiceId]bool, len(members))
+	for _, id := range members {
+		set[id] = true
+	}
+	var hs []*ExampleServiceHandle
+	for _, h := range d.orderedHandles() {
+		if set[h.ID()] {
+			hs = append(hs, h)
+		}
+	}
+	return hs, nil
+}
+
+// StartGroup initializes the stopped services of the group. Fails if a
+// service of the group depends on a service outside of it that is not
+// running.
+func (d *ExampleServiceDaemon) StartGroup(name string) error {
+	hs, err := d.groupHandles(name)
+	if err != nil {
+		return err
+	}
+	inGroup := make(map[ServiceId]bool, len(hs))
+	for _, h := range hs {
+		inGroup[h.ID()] = true
+	}
+	for _, h := range hs {
+		for _, dep := range h.Dependencies() {
+			if inGroup[dep] || d.isCompleted(dep) {
+				continue
+			}
+			if state, _ := d.State(dep); state != ServiceRunning {
+				return fmt.Errorf("service %s depends on service %d which is %s", h.Name(), dep, state)
+			}
+		}
+	}
+	d.log.Info("Starting group", "group", name)
+	for _, h := range hs {
+		h.mu.Lock()
+		if d.isStopping() {
+			h.mu.Unlock()
+			return ErrDaemonStopped
+		}
+		if state := h.getState(); state == ServiceStopped || state == ServiceFailed {
+			h.initService()
+		}
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+// StopGroup shuts down the services of the group in reverse dependency
+// order. The events of their subscriptions are not delivered to them while
+// stopped. Fails if a running service outside of the group depends on a
+// service of the group.
+func (d *ExampleServiceDaemon) StopGroup(name string) error {
+	hs, err := d.groupHandles(name)
+	if err != nil {
+		return err
+	}
+	inGroup := make(map[ServiceId]bool, len(hs))
+	for _, h := range hs {
+		inGroup[h.ID()] = true
+	}
+	for _, other := range d.orderedHandles() {
+		if inGroup[other.ID()] || other.getState() != ServiceRunning {
+			continue
+		}
+		for _, dep := range other.Dependencies() {
+			if inGroup[dep] {
+				return fmt.Errorf("service %s outside of group %q depends on service %d", other.Name(), name, dep)
+			}
+		}
+	}
+	d.log.Info("Stopping group", "group", name)
+	for i := len(hs) - 1; i >= 0; i-- {
+		h := hs[i]
+		h.mu.Lock()
+		if d.isStopping() {
+			h.mu.Unlock()
+			return ErrDaemonStopped
+		}
+		if h.getState() == ServiceRunning {
+			h.shutdownService()
+		}
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+// RestartGroup shuts down the services of the group in reverse dependency
+// order and initializes them again in dependency order.
+func (d *ExampleServiceDaemon) RestartGroup(name string) error {
+	hs, err := d.groupHandles(name)
+	if err != nil {
+		return err
+	}
+	if d.isStopping() {
+		return ErrDaemonStopped
+	}
+	d.log.Info("Restarting group", "group", name)
+	ids := make([]ServiceId, len(hs))
+	for i, h := range hs {
+		ids[i] = h.ID()
+	}
+	d.restartServices(ids)
+	return nil
+}
@@ -0,0 +1,115 @@
+package gosvcd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HealthChecker is implemented by services that can report their health.
+// Health is called concurrently with the other methods of the service.
+type HealthChecker interface {
+	// Health returns nil if the service is healthy, or an error
+	// describing the problem.
+	Health() error
+}
+
+// ReadinessChecker is implemented by services that are not ready to serve
+// immediately after being initialized, e.g. because they are waiting for
+// a connection. Readiness is called concurrently with the other methods of
+// the service.
+type ReadinessChecker interface {
+	// Readiness returns nil if the service is ready, or an error
+	// describing what it is waiting for.
+	Readiness() error
+}
+
+// multiError joins the errors of multiple checks.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (m multiError) errOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+func (d *ExampleServiceDaemon) Health() error {
+	var errs multiError
+	if err := d.Liveness(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, h := range d.orderedHandles() {
+		if h.getState() != ServiceRunning {
+			continue
+		}
+		if hc, ok := h.Service.(HealthChecker); ok {
+			if err := hc.Health(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", h.Name(), err))
+			}
+		}
+	}
+	return errs.errOrNil()
+}
+
+func (d *ExampleServiceDaemon) Readiness() error {
+	select {
+	case <-d.shuttingDown:
+		return fmt.Errorf("shutting down")
+	default:
+	}
+	var errs multiError
+	for _, h := range d.orderedHandles() {
+		if h.isDormant() {
+			continue
+		}
+		if state := h.getState(); state != ServiceRunning {
+			errs = append(errs, fmt.Errorf("%s: %s", h.Name(), state))
+			continue
+		}
+		if rc, ok := h.Service.(ReadinessChecker); ok {
+			if err := rc.Readiness(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", h.Name(), err))
+			}
+		}
+	}
+	return errs.errOrNil()
+}
+
+//
+// Probes
+//
+
+// NewProbeHandler returns a HTTP handler serving the Kubernetes style
+// probes: "/livez" responds with 200 when ServiceDaemon.Health() reports no
+// problems and "/readyz" when ServiceDaemon.Readiness() does. Otherwise the
+// response is 503 with the problems in the body.
+func NewProbeHandler(d ServiceDaemon) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		probeResponse(w, d.Health())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		probeResponse(w, d.Readiness())
+	})
+	return mux
+}
+
+func probeResponse(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,569 @@
+package gosvcd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SyncPolicy selects when the journal is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncNone leaves the flushing to the operating system. Events may
+	// be lost on a machine crash but not on a process crash.
+	SyncNone SyncPolicy = iota
+
+	// SyncInterval is SyncNone with the journal additionally flushed
+	// periodically.
+	SyncInterval
+
+	// SyncAlways flushes the journal after each appended event.
+	SyncAlways
+)
+
+// Journal defaults
+const (
+	DefaultJournalSegmentSize  = 64 << 20
+	DefaultJournalSyncInterval = time.Second
+)
+
+// JournalOptions configure the journal.
+type JournalOptions struct {
+	Sync SyncPolicy
+
+	// SyncInterval is the interval of the SyncInterval policy.
+	// Defaults to DefaultJournalSyncInterval.
+	SyncInterval time.Duration
+
+	// SegmentSize is the size after which a new segment file is
+	// started. Defaults to DefaultJournalSegmentSize.
+	SegmentSize int64
+
+	// Retain keeps the segments whose events have all been
+	// checkpointed. By default they are removed.
+	Retain bool
+}
+
+const (
+	journalSegmentExt  = ".wal"
+	journalCheckpoint  = "checkpoint"
+	journalHeaderSize  = 16
+	journalMaxRecord   = 64 << 20
+	journalFileMode    = 0600
+	journalSeqNameSize = 20
+)
+
+var journalCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// Journal is a write-ahead log of events. When set with the builder's
+// SetJournal, the daemon appends every emitted event to the journal before
+// dispatching it and periodically checkpoints the sequence number up to
+// which all events have been handled. After a crash the events after the
+// checkpoint are replayed to the services on the next start.
+//
+// The journal is a directory of segment files named by the sequence number
+// of their first event. Each record consists of the length of the data,
+// a CRC-32C checksum, the sequence number and the event encoded with
+// MarshalEvent. A partially written record at the end of the journal,
+// e.g. due to a crash, is discarded when the journal is opened.
+type Journal struct {
+	lastSeq uint64 // atomic
+
+	mu       sync.Mutex
+	dir      string
+	opts     JournalOptions
+	segments []uint64 // first sequence numbers of the segments
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+	dirty    bool
+	stop     chan struct{}
+	stopped  chan struct{}
+	closed   bool
+
+	checkpoint uint64
+}
+
+// OpenJournal opens or creates the journal in the directory.
+func OpenJournal(dir string, opts JournalOptions) (*Journal, error) {
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultJournalSyncInterval
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultJournalSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	j := &Journal{dir: dir, opts: opts}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		j.stop = make(chan struct{})
+		j.stopped = make(chan struct{})
+		go j.syncLoop()
+	}
+	return j, nil
+}
+
+func (j *Journal) open() error {
+	b, err := ioutil.ReadFile(filepath.Join(j.dir, journalCheckpoint))
+	if err == nil {
+		if j.checkpoint, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return fmt.Errorf("invalid journal checkpoint: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, journalSegmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, journalSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		j.segments = append(j.segments, first)
+	}
+	sort.Slice(j.segments, func(a, b int) bool { return j.segments[a] < j.segments[b] })
+
+	if len(j.segments) == 0 {
+		j.lastSeq = j.checkpoint
+		return j.createSegment(j.checkpoint + 1)
+	}
+
+	// Find the last complete record of the last segment and truncate
+	// anything after it.
+	first := j.segments[len(j.segments)-1]
+	path := j.segmentPath(first)
+	last, valid, err := scanSegment(path, first-1)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, journalFileMode)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	j.f, j.w, j.size = f, bufio.NewWriter(f), valid
+	j.lastSeq = last
+	if j.lastSeq < j.checkpoint {
+		j.lastSeq = j.checkpoint
+	}
+	return nil
+}
+
+// scanSegment returns the sequence number of the last complete record in
+// the segment and the offset after it.
+func scanSegment(path string, prev uint64) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		seq, data, err := readJournalRecord(r)
+		if err != nil {
+			// A torn or corrupt record ends the segment.
+			return prev, offset, nil
+		}
+		prev = seq
+		offset += journalHeaderSize + int64(len(data))
+	}
+}
+
+func readJournalRecord(r io.Reader) (uint64, []byte, error) {
+	var hdr [journalHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[0:4])
+	sum := binary.BigEndian.Uint32(hdr[4:8])
+	seq := binary.BigEndian.Uint64(hdr[8:16])
+	if n > journalMaxRecord {
+		return 0, nil, errors.New("journal record too large")
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	crc := crc32.Update(crc32.Checksum(hdr[8:16], journalCRC), journalCRC, data)
+	if crc != sum {
+		return 0, nil, errors.New("journal record checksum mismatch")
+	}
+	return seq, data, nil
+}
+
+func (j *Journal) segmentPath(first uint64) string {
+	return filepath.Join(j.dir, fmt.Sprintf("%0*d%s", journalSeqNameSize, first, journalSegmentExt))
+}
+
+// createSegment starts a new segment. Must be called with 'mu' held.
+func (j *Journal) createSegment(first uint64) error {
+	if j.f != nil {
+		if err := j.flush(true); err != nil {
+			return err
+		}
+		j.f.Close()
+	}
+	f, err := os.OpenFile(j.segmentPath(first), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, journalFileMode)
+	if err != nil {
+		return err
+	}
+	j.f, j.w, j.size = f, bufio.NewWriter(f), 0
+	j.segments = append(j.segments, first)
+	return nil
+}
+
+// Append appends the event to the journal and returns its sequence number.
+func (j *Journal) Append(ev Event) (uint64, error) {
+	data, err := MarshalEvent(ev)
+	if err != nil {
+		return 0, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return 0, os.ErrClosed
+	}
+	seq := j.lastSeq + 1
+	if j.size >= j.opts.SegmentSize {
+		if err := j.createSegment(seq); err != nil {
+			return 0, err
+		}
+	}
+
+	var hdr [journalHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint64(hdr[8:16], seq)
+	crc := crc32.Update(crc32.Checksum(hdr[8:16], journalCRC), journalCRC, data)
+	binary.BigEndian.PutUint32(hdr[4:8], crc)
+	if _, err := j.w.Write(hdr[:]); err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(data); err != nil {
+		return 0, err
+	}
+	j.size += journalHeaderSize + int64(len(data))
+	atomic.StoreUint64(&j.lastSeq, seq)
+	j.dirty = true
+
+	return seq, j.flush(j.opts.Sync == SyncAlways)
+}
+
+// flush writes the buffered records to the file and optionally syncs it.
+// Must be called with 'mu' held.
+func (j *Journal) flush(sync bool) error {
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	if sync && j.dirty {
+		j.dirty = false
+		return j.f.Sync()
+	}
+	return nil
+}
+
+// Sync flushes the journal to stable storage.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return os.ErrClosed
+	}
+	return j.flush(true)
+}
+
+func (j *Journal) syncLoop() {
+	defer close(j.stopped)
+	ticker := time.NewTicker(j.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.Sync()
+		}
+	}
+}
+
+// LastSeq returns the sequence number of the last appended event.
+func (j *Journal) LastSeq() uint64 {
+	return atomic.LoadUint64(&j.lastSeq)
+}
+
+// Checkpointed returns the sequence number up to which the events have been
+// handled.
+func (j *Journal) Checkpointed() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.checkpoint
+}
+
+// writeCheckpoint writes the checkpoint to a temporary file and renames it
+// over the previous one, syncing the file before and the directory after
+// the rename so that the checkpoint survives a machine crash.
+func (j *Journal) writeCheckpoint(seq uint64) error {
+	tmp := filepath.Join(j.dir, journalCheckpoint+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, journalFileMode)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.FormatUint(seq, 10) + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(j.dir, journalCheckpoint))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	dir, err := os.Open(j.dir)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if cerr := dir.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Checkpoint records that the events up to and including 'seq' have been
+// handled and removes the segments containing only such events, unless
+// the journal retains them.
+func (j *Journal) Checkpoint(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.closed {
+		return os.ErrClosed
+	}
+	if seq <= j.checkpoint {
+		return nil
+	}
+	if err := j.flush(true); err != nil {
+		return err
+	}
+	if err := j.writeCheckpoint(seq); err != nil {
+		return err
+	}
+	j.checkpoint = seq
+
+	if j.opts.Retain {
+		return nil
+	}
+	// A segment can be removed when the next one starts at or before the
+	// first event after the checkpoint. The current segment is kept.
+	for len(j.segments) > 1 && j.segments[1] <= seq+1 {
+		if err := os.Remove(j.segmentPath(j.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		j.segments = j.segments[1:]
+	}
+	return nil
+}
+
+// Replay calls 'fn' with the events after the sequence number 'after' in
+// order. The payloads are decoded as by UnmarshalEvent. Replay stops at
+// the first error returned by 'fn'.
+func (j *Journal) Replay(after uint64, fn func(seq uint64, ev Event) error) error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return os.ErrClosed
+	}
+	if err := j.flush(false); err != nil {
+		j.mu.Unlock()
+		return err
+	}
+	segments := append([]uint64(nil), j.segments...)
+	last := atomic.LoadUint64(&j.lastSeq)
+	j.mu.Unlock()
+
+	for i, first := range segments {
+		if i+1 < len(segments) && segments[i+1] <= after+1 {
+			continue
+		}
+		if err := j.replaySegment(first, after, last, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Journal) replaySegment(first, after, last uint64, fn func(uint64, Event) error) error {
+	f, err := os.Open(j.segmentPath(first))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Removed by a concurrent checkpoint.
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		seq, data, err := readJournalRecord(r)
+		if err != nil || seq > last {
+			return nil
+		}
+		if seq <= after {
+			continue
+		}
+		ev, err := UnmarshalEvent(data)
+		if err != nil {
+			return fmt.Errorf("journal record %d: %w", seq, err)
+		}
+		if err := fn(seq, ev); err != nil {
+			return err
+		}
+	}
+}
+
+// Close flushes and closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return nil
+	}
+	j.closed = true
+	err := j.flush(true)
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	j.mu.Unlock()
+	if j.stop != nil {
+		close(j.stop)
+		<-j.stopped
+	}
+	return err
+}
+
+//
+// Daemon integration
+//
+
+// replayJournal dispatches the events after the journal's checkpoint.
+func (d *ExampleServiceDaemon) replayJournal() {
+	after := d.journal.Checkpointed()
+	from := after
+	if last := d.journal.LastSeq(); last > 0 && from >= last {
+		// Read the last event to continue its sequence numbering.
+		from = last - 1
+	}
+	n := 0
+	err := d.journal.Replay(from, func(seq uint64, ev Event) error {
+		e := ev.(*ExampleEvent)
+		if seq <= after {
+			if e.routed > atomic.LoadUint64(&d.routed) {
+				atomic.StoreUint64(&d.routed, e.routed)
+			}
+			return nil
+		}
+		e.seq = seq
+		d.route(e)
+		n++
+		return nil
+	})
+	if err != nil {
+		d.log.Error("Failed to replay journal", "after", after, "error", err)
+	}
+	if n > 0 {
+		d.log.Info("Replayed journal", "after", after, "events", n)
+	}
+}
+
+// checkpointJournal periodically checkpoints the journal up to the first
+// journaled event not yet delivered.
+func (d *ExampleServiceDaemon) checkpointJournal() {
+	ticker := time.NewTicker(DefaultJournalSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		if err := d.journal.Checkpoint(d.checkpointSeq(d.inFlight.checkpoint())); err != nil {
+			d.log.Error("Failed to checkpoint journal", "error", err)
+		}
+	}
+}
+
+// inFlightEvents tracks the journaled events from their routing until they
+// have been delivered. The events are added in the order of their sequence
+// numbers, as they are journaled by the router.
+type inFlightEvents struct {
+	mu   sync.Mutex
+	seqs map[uint64]struct{}
+	last uint64
+}
+
+func (f *inFlightEvents) add(seq uint64) {
+	f.mu.Lock()
+	if f.seqs == nil {
+		f.seqs = make(map[uint64]struct{})
+	}
+	f.seqs[seq] = struct{}{}
+	if seq > f.last {
+		f.last = seq
+	}
+	f.mu.Unlock()
+}
+
+func (f *inFlightEvents) done(seq uint64) {
+	f.mu.Lock()
+	delete(f.seqs, seq)
+	f.mu.Unlock()
+}
+
+// checkpoint returns the sequence number up to which the events have been
+// delivered: the one before the lowest in flight, or the last one added.
+// The events not yet added come after it.
+func (f *inFlightEvents) checkpoint() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	min := f.last
+	for seq := range f.seqs {
+		if seq <= min {
+			min = seq - 1
+		}
+	}
+	return min
+}
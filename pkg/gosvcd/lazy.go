@@ -0,0 +1,61 @@
+package gosvcd
+
+// Lazy is implemented by services that are initialized on first use
+// instead of at Start: when the first event of their subscriptions is
+// delivered to them, when a service depending on them is initialized, or
+// when they are looked up with Lookup. A lazy service that has not been
+// used is not reported as not ready.
+type Lazy interface {
+	Lazy() bool
+}
+
+func isLazy(svc Service) bool {
+	l, ok := svc.(Lazy)
+	return ok && l.Lazy()
+}
+
+// isDormant returns true if the service is lazy and has not been
+// initialized.
+func (h *ExampleServiceHandle) isDormant() bool {
+	return h.lazy && h.getState() == ServicePending
+}
+
+// activate initializes the dormant lazy dependencies of the service, and
+// the service itself if it is dormant.
+func (d *ExampleServiceDaemon) activate(h *ExampleServiceHandle) {
+	d.activateDependencies(h)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.isDormant() || d.isStopping() {
+		return
+	}
+	d.log.Info("Activating lazy service", "service", h.Name(), "id", h.ID())
+	h.initService()
+}
+
+// activateDependencies initializes the dormant lazy dependencies of the
+// service.
+func (d *ExampleServiceDaemon) activateDependencies(h *ExampleServiceHandle) {
+	for _, id := range h.Dependencies() {
+		if dep, ok := d.handle(id); ok && dep.isDormant() {
+			d.activate(dep)
+		}
+	}
+}
+
+// Lookup returns the registered service, initializing it if it is lazy.
+func (d *ExampleServiceDaemon) Lookup(id ServiceId) (Service, bool) {
+	h, ok := d.handle(id)
+	if !ok {
+		return nil, false
+	}
+	if h.isDormant() {
+		d.activate(h)
+	}
+	return h.Service, true
+}
+
+// Lookup returns the registered service, initializing it if it is lazy.
+func (h *ExampleServiceHandle) Lookup(id ServiceId) (Service, bool) {
+	return h.d.Lookup(id)
+}
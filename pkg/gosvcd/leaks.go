@@ -0,0 +1,118 @@
+package gosvcd
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLeakGracePeriod is the default time to wait after shutdown for the
+// goroutines of the daemon and the services to exit.
+const DefaultLeakGracePeriod = time.Second
+
+// daemonOwner is the owner of the goroutines of the daemon itself.
+const daemonOwner = "daemon"
+
+// goroutineTracker counts the live goroutines started by the daemon and the
+// services, by owner.
+type goroutineTracker struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	changed chan struct{}
+}
+
+func newGoroutineTracker() *goroutineTracker {
+	return &goroutineTracker{
+		counts:  make(map[string]int),
+		changed: make(chan struct{}),
+	}
+}
+
+func (t *goroutineTracker) Go(owner string, f func()) {
+	t.mu.Lock()
+	t.counts[owner]++
+	t.mu.Unlock()
+
+	go func() {
+		defer t.done(owner)
+		f()
+	}()
+}
+
+func (t *goroutineTracker) done(owner string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[owner]--
+	if t.counts[owner] == 0 {
+		delete(t.counts, owner)
+	}
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// wait waits for all goroutines to exit, at most for the grace period, and
+// returns the number of goroutines still running by owner.
+func (t *goroutineTracker) wait(grace time.Duration) map[string]int {
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	for {
+		t.mu.Lock()
+		if len(t.counts) == 0 {
+			t.mu.Unlock()
+			return nil
+		}
+		changed := t.changed
+		t.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			leaks := make(map[string]int, len(t.counts))
+			for owner, n := range t.counts {
+				leaks[owner] = n
+			}
+			return leaks
+		}
+	}
+}
+
+// checkLeaks waits for the goroutines to exit and reports the ones that
+// did not.
+func (d *ExampleServiceDaemon) checkLeaks() {
+	if d.leakGracePeriod < 0 {
+		return
+	}
+	for owner, n := range d.goroutines.wait(d.leakGracePeriod) {
+		label := "service"
+		if owner == daemonOwner {
+			label = "gosvcd"
+		}
+		d.log.Error("Goroutines leaked after shutdown",
+			"owner", owner,
+			"count", n,
+			"stacks", labeledStacks(label, owner))
+	}
+}
+
+// labeledStacks returns the stacks of the goroutines with the given pprof
+// label.
+func labeledStacks(key, value string) string {
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 1)
+
+	// With debug=1 the profile consists of blank line separated groups of
+	// identical stacks, including the labels of the goroutines.
+	label := strconv.Quote(key) + ":" + strconv.Quote(value)
+	var stacks []string
+	for _, group := range strings.Split(buf.String(), "\n\n") {
+		if strings.Contains(group, "# labels: ") && strings.Contains(group, label) {
+			stacks = append(stacks, group)
+		}
+	}
+	return strings.Join(stacks, "\n\n")
+}
@@ -0,0 +1,490 @@
+package gosvcd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Drop reasons
+const (
+	// DropNoSubscribers is the reason for dropping events of a type that
+	// no service is subscribed to.
+	DropNoSubscribers = "no_subscribers"
+
+	// DropNotRunning is the reason for not delivering an event to a
+	// service that is not running, e.g. because it failed to initialize.
+	DropNotRunning = "not_running"
+
+	// DropInvalidPayload is the reason for rejecting an event whose
+	// payload failed validation.
+	DropInvalidPayload = "invalid_payload"
+
+	// DropConversionFailed is the reason for not delivering an event to
+	// the subscribers of another version of its type because the payload
+	// could not be converted.
+	DropConversionFailed = "conversion_failed"
+
+	// DropDeadLettered is the reason for not delivering an event to a
+	// service whose handler kept failing after retries.
+	DropDeadLettered = "dead_lettered"
+
+	// DropCircuitOpen is the reason for not delivering an event to a
+	// service whose circuit breaker is open.
+	DropCircuitOpen = "circuit_open"
+
+	// DropDuplicate is the reason for not delivering an event to a
+	// service that has already handled an event with the same
+	// idempotency key.
+	DropDuplicate = "duplicate"
+
+	// DropExpired is the reason for not delivering an event whose TTL
+	// passed while it was queued.
+	DropExpired = "expired"
+
+	// DropCancelled is the reason for not delivering an event cancelled
+	// by its emitter.
+	DropCancelled = "cancelled"
+
+	// DropPaused is the reason for not delivering an event to a paused
+	// service.
+	DropPaused = "paused"
+
+	// DropNotPermitted is the reason for rejecting an event of a type
+	// its source is not permitted to emit.
+	DropNotPermitted = "not_permitted"
+
+	// DropChaos is the reason for not delivering an event dropped by the
+	// chaos mode.
+	DropChaos = "chaos"
+
+	// DropOverflow is the reason for not delivering an event dispatched
+	// to a full subscription buffer.
+	DropOverflow = "overflow"
+)
+
+// HandlerLatencyBuckets are the upper bounds, in seconds, of the
+// histogram buckets for the handler latencies.
+var HandlerLatencyBuckets = []float64{
+	.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5, 10,
+}
+
+// Histogram is a snapshot of a histogram of durations in seconds.
+type Histogram struct {
+	// Bounds are the upper bounds of the buckets.
+	Bounds []float64
+
+	// Counts are the number of observations in each bucket. The last
+	// element counts the observations above the last bound.
+	Counts []uint64
+
+	Count uint64
+	Sum   float64
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{
+		Bounds: HandlerLatencyBuckets,
+		Counts: make([]uint64, len(HandlerLatencyBuckets)+1),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the observations by
+// interpolating linearly within the bucket the quantile falls into.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	var cumulative uint64
+	for i, n := range h.Counts {
+		if float64(cumulative+n) < rank || n == 0 {
+			cumulative += n
+			continue
+		}
+		if i == len(h.Bounds) {
+			// Above the last bound, nothing better to return.
+			return h.Bounds[len(h.Bounds)-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		}
+		return lower + (h.Bounds[i]-lower)*(rank-float64(cumulative))/float64(n)
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+func (h *Histogram) merge(o Histogram) {
+	for i, n := range o.Counts {
+		h.Counts[i] += n
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+}
+
+// LatencyStats summarizes a latency histogram.
+type LatencyStats struct {
+	Count         uint64
+	P50, P95, P99 time.Duration
+}
+
+func (h *Histogram) Stats() LatencyStats {
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second))
+	}
+	return LatencyStats{
+		Count: h.Count,
+		P50:   seconds(h.Quantile(.5)),
+		P95:   seconds(h.Quantile(.95)),
+		P99:   seconds(h.Quantile(.99)),
+	}
+}
+
+func (h *Histogram) clone() Histogram {
+	c := *h
+	c.Counts = make([]uint64, len(h.Counts))
+	copy(c.Counts, h.Counts)
+	return c
+}
+
+// HandlerKey identifies the handling of an event type by a service.
+type HandlerKey struct {
+	Service   ServiceId
+	EventType EventType
+}
+
+// MetricsSnapshot is a point-in-time copy of the daemon's metrics.
+type MetricsSnapshot struct {
+	// Emitted counts the emitted events by type.
+	Emitted map[EventType]uint64
+
+	// Dispatched counts the deliveries of events to services by type.
+	Dispatched map[EventType]uint64
+
+	// Dropped counts the dropped events by reason and type.
+	Dropped map[string]map[EventType]uint64
+
+	// Restarts counts the restarts of each service.
+	Restarts map[ServiceId]uint64
+
+	// HandlerTimeouts counts the handler invocations of each service
+	// that timed out. See SetHandlerTimeout.
+	HandlerTimeouts map[ServiceId]uint64
+
+	// EmitQueueDepth is the number of emitted events waiting to be
+	// routed to the dispatch queues.
+	EmitQueueDepth int
+
+	// QueueDepth is the number of events waiting in the dispatch queue
+	// of each event type.
+	QueueDepth map[EventType]int
+
+	// HandlerLatency is the distribution of the time spent in HandleEvent
+	// by each service for each event type.
+	HandlerLatency map[HandlerKey]Histogram
+
+	// Retention describes the retained events of each retained type.
+	Retention map[EventType]RetentionStats
+
+	// Credits describes the credits of each type with a credit window.
+	Credits map[EventType]CreditStats
+
+	// SubscriptionDepth is the number of events waiting in the buffer of
+	// each subscription with one.
+	SubscriptionDepth map[HandlerKey]int
+}
+
+// ServiceLatency returns the distribution of the time spent in HandleEvent
+// by the service across all event types.
+func (m *MetricsSnapshot) ServiceLatency(id ServiceId) Histogram {
+	h := newHistogram().clone()
+	for key, kh := range m.HandlerLatency {
+		if key.Service == id {
+			h.merge(kh)
+		}
+	}
+	return h
+}
+
+type daemonMetrics struct {
+	sink MetricsSink
+
+	mu             sync.Mutex
+	emitted        map[EventType]uint64
+	dispatched     map[EventType]uint64
+	dropped        map[string]map[EventType]uint64
+	restarts       map[ServiceId]uint64
+	timeouts       map[ServiceId]uint64
+	handlerLatency map[HandlerKey]*Histogram
+}
+
+func newDaemonMetrics(sink MetricsSink) *daemonMetrics {
+	return &daemonMetrics{
+		sink:           sink,
+		emitted:        make(map[EventType]uint64),
+		dispatched:     make(map[EventType]uint64),
+		dropped:        make(map[string]map[EventType]uint64),
+		restarts:       make(map[ServiceId]uint64),
+		timeouts:       make(map[ServiceId]uint64),
+		handlerLatency: make(map[HandlerKey]*Histogram),
+	}
+}
+
+func (m *daemonMetrics) eventEmitted(typ EventType) {
+	m.mu.Lock()
+	m.emitted[typ]++
+	m.mu.Unlock()
+	if m.sink != nil {
+		m.sink.EventEmitted(typ)
+	}
+}
+
+func (m *daemonMetrics) eventDropped(reason string, typ EventType) {
+	m.mu.Lock()
+	byType, ok := m.dropped[reason]
+	if !ok {
+		byType = make(map[EventType]uint64)
+		m.dropped[reason] = byType
+	}
+	byType[typ]++
+	m.mu.Unlock()
+	if m.sink != nil {
+		m.sink.EventDropped(reason, typ)
+	}
+}
+
+func (m *daemonMetrics) eventHandled(id ServiceId, typ EventType, d time.Duration) {
+	m.mu.Lock()
+	m.dispatched[typ]++
+	key := HandlerKey{id, typ}
+	h, ok := m.handlerLatency[key]
+	if !ok {
+		h = newHistogram()
+		m.handlerLatency[key] = h
+	}
+	h.observe(d)
+	m.mu.Unlock()
+	if m.sink != nil {
+		m.sink.EventHandled(id, typ, d)
+	}
+}
+
+func (m *daemonMetrics) serviceRestarted(id ServiceId) {
+	m.mu.Lock()
+	m.restarts[id]++
+	m.mu.Unlock()
+	if m.sink != nil {
+		m.sink.ServiceRestarted(id)
+	}
+}
+
+func (m *daemonMetrics) handlerTimedOut(id ServiceId) {
+	m.mu.Lock()
+	m.timeouts[id]++
+	m.mu.Unlock()
+}
+
+func (m *daemonMetrics) snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := MetricsSnapshot{
+		Emitted:         make(map[EventType]uint64, len(m.emitted)),
+		Dispatched:      make(map[EventType]uint64, len(m.dispatched)),
+		Dropped:         make(map[string]map[EventType]uint64, len(m.dropped)),
+		Restarts:        make(map[ServiceId]uint64, len(m.restarts)),
+		HandlerTimeouts: make(map[ServiceId]uint64, len(m.timeouts)),
+		QueueDepth:      make(map[EventType]int),
+		HandlerLatency:  make(map[HandlerKey]Histogram, len(m.handlerLatency)),
+	}
+	for typ, n := range m.emitted {
+		s.Emitted[typ] = n
+	}
+	for typ, n := range m.dispatched {
+		s.Dispatched[typ] = n
+	}
+	for reason, byType := range m.dropped {
+		c := make(map[EventType]uint64, len(byType))
+		for typ, n := range byType {
+			c[typ] = n
+		}
+		s.Dropped[reason] = c
+	}
+	for id, n := range m.restarts {
+		s.Restarts[id] = n
+	}
+	for id, n := range m.timeouts {
+		s.HandlerTimeouts[id] = n
+	}
+	for key, h := range m.handlerLatency {
+		s.HandlerLatency[key] = h.clone()
+	}
+	return s
+}
+
+//
+// Prometheus exposition
+//
+
+type metricsHandler struct {
+	d ServiceDaemon
+}
+
+// NewMetricsHandler returns a HTTP handler that serves the daemon's metrics
+// in the Prometheus text exposition format.
+func NewMetricsHandler(d ServiceDaemon) http.Handler {
+	return &metricsHandler{d}
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WritePrometheus(w, h.d)
+}
+
+// WritePrometheus writes the daemon's metrics to 'w' in the Prometheus
+// text exposition format.
+func WritePrometheus(w io.Writer, d ServiceDaemon) error {
+	m := d.Metrics()
+	names := make(map[ServiceId]string)
+	for _, svc := range d.Services() {
+		names[svc.ID()] = svc.Name()
+	}
+	pw := &promWriter{w: w}
+
+	pw.header("gosvcd_events_emitted_total", "counter", "Number of emitted events.")
+	for _, typ := range sortedTypes(m.Emitted) {
+		pw.sample("gosvcd_events_emitted_total", m.Emitted[typ], "event_type", string(typ))
+	}
+
+	pw.header("gosvcd_events_dispatched_total", "counter", "Number of events delivered to services.")
+	for _, typ := range sortedTypes(m.Dispatched) {
+		pw.sample("gosvcd_events_dispatched_total", m.Dispatched[typ], "event_type", string(typ))
+	}
+
+	pw.header("gosvcd_events_dropped_total", "counter", "Number of dropped events.")
+	reasons := make([]string, 0, len(m.Dropped))
+	for reason := range m.Dropped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		for _, typ := range sortedTypes(m.Dropped[reason]) {
+			pw.sample("gosvcd_events_dropped_total", m.Dropped[reason][typ],
+				"event_type", string(typ), "reason", reason)
+		}
+	}
+
+	pw.header("gosvcd_queue_depth", "gauge", "Number of events waiting in a queue.")
+	pw.sample("gosvcd_queue_depth", m.EmitQueueDepth, "queue", "emit")
+	types := make([]EventType, 0, len(m.QueueDepth))
+	for typ := range m.QueueDepth {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, typ := range types {
+		pw.sample("gosvcd_queue_depth", m.QueueDepth[typ], "queue", "dispatch", "event_type", string(typ))
+	}
+
+	pw.header("gosvcd_service_restarts_total", "counter", "Number of service restarts.")
+	for _, id := range sortedIds(m.Restarts) {
+		pw.sample("gosvcd_service_restarts_total", m.Restarts[id], "service", names[id])
+	}
+
+	pw.header("gosvcd_handler_timeouts_total", "counter", "Number of handler invocations that timed out.")
+	for _, id := range sortedIds(m.HandlerTimeouts) {
+		pw.sample("gosvcd_handler_timeouts_total", m.HandlerTimeouts[id], "service", names[id])
+	}
+
+	pw.header("gosvcd_handler_duration_seconds", "histogram", "Time spent handling events.")
+	keys := make([]HandlerKey, 0, len(m.HandlerLatency))
+	for key := range m.HandlerLatency {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Service != keys[j].Service {
+			return keys[i].Service < keys[j].Service
+		}
+		return keys[i].EventType < keys[j].EventType
+	})
+	for _, key := range keys {
+		pw.histogram("gosvcd_handler_duration_seconds", m.HandlerLatency[key],
+			"service", names[key.Service], "event_type", string(key.EventType))
+	}
+
+	return pw.err
+}
+
+func sortedTypes(m map[EventType]uint64) []EventType {
+	types := make([]EventType, 0, len(m))
+	for typ := range m {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func sortedIds(m map[ServiceId]uint64) []ServiceId {
+	ids := make([]ServiceId, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) sample(name string, value interface{}, labels ...string) {
+	p.printf("%s%s %v\n", name, promLabels(labels), value)
+}
+
+func (p *promWriter) histogram(name string, h Histogram, labels ...string) {
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		p.sample(name+"_bucket", cumulative, append(labels, "le", fmt.Sprint(bound))...)
+	}
+	p.sample(name+"_bucket", h.Count, append(labels, "le", "+Inf")...)
+	p.sample(name+"_sum", h.Sum, labels...)
+	p.sample(name+"_count", h.Count, labels...)
+}
+
+// promLabels formats label name-value pairs.
+func promLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	s := "{"
+	for i := 0; i < len(labels); i += 2 {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf("%s=%q", labels[i], labels[i+1])
+	}
+	return s + "}"
+}
@@ -0,0 +1,155 @@
+package gosvcd
+
+import (
+	"context"
+	"sync"
+)
+
+// nestedBridgeId is the id of the service that exports the events of a
+// nested daemon to its parent, and the source of the events imported
+// into it.
+const nestedBridgeId ServiceId = -2
+
+// NestedConfig describes a daemon nested in another one.
+type NestedConfig struct {
+	ID           ServiceId
+	Name         string
+	Dependencies []ServiceId
+
+	// Import are the event types of the parent that are emitted in the
+	// nested daemon.
+	Import []EventType
+
+	// Export are the event types of the nested daemon that are emitted
+	// in the parent with the nested daemon as the source.
+	Export []EventType
+
+	// Build returns the builder of the nested daemon with its services
+	// registered. It is called each time the nested daemon is started,
+	// as a builder can be started only once. The service id -2 is
+	// reserved in the nested daemon.
+	Build func() *ExampleServiceDaemonBuilder
+}
+
+// Nested is a daemon registered in another daemon as a single service, for
+// assembling an application from independently built parts. The nested
+// daemon is started when the service is initialized and shut down with
+// it, and the events of the configured types are bridged between the two.
+// An exported event of an imported type is not imported back.
+type Nested struct {
+	cfg NestedConfig
+
+	mu     sync.Mutex
+	handle *ExampleServiceHandle
+	child  *ExampleServiceDaemon
+}
+
+// NewNested returns the service for registering the nested daemon.
+func NewNested(cfg NestedConfig) *Nested {
+	return &Nested{cfg: cfg}
+}
+
+func (n *Nested) ID() ServiceId              { return n.cfg.ID }
+func (n *Nested) Name() string               { return n.cfg.Name }
+func (n *Nested) Dependencies() []ServiceId  { return n.cfg.Dependencies }
+func (n *Nested) Subscriptions() []EventType { return n.cfg.Import }
+func (n *Nested) Emits() []EventType         { return n.cfg.Export }
+
+// Daemon returns the running nested daemon, or nil if the service is not
+// running.
+func (n *Nested) Daemon() ServiceDaemon {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.child == nil {
+		return nil
+	}
+	return n.child
+}
+
+func (n *Nested) Init(handle ServiceHandle) {
+	h := handle.(*ExampleServiceHandle)
+	b := n.cfg.Build()
+	b.Register(&nestedBridge{n: n, h: h})
+	child := b.Start().(*ExampleServiceDaemon)
+	n.mu.Lock()
+	n.handle, n.child = h, child
+	n.mu.Unlock()
+
+	h.Go(func(ctx context.Context) {
+		for err := range child.Err() {
+			h.d.log.Error("Nested daemon failure", "service", n.Name(), "error", err)
+		}
+	})
+	<-child.Ready()
+}
+
+func (n *Nested) HandleEvent(ev Event) {
+	if ev.ServiceId() == n.cfg.ID {
+		// Exported by the nested daemon.
+		return
+	}
+	n.mu.Lock()
+	child := n.child
+	n.mu.Unlock()
+	if e := eventOf(ev); e != nil {
+		e.pin()
+	}
+	if err := child.emit(ev.Context(), nestedBridgeId, ev.EventType(), ev.Data()); err != nil {
+		n.handle.d.log.Warn("Failed to import event to nested daemon",
+			"service", n.Name(),
+			"event_type", ev.EventType(),
+			"error", err)
+	}
+}
+
+func (n *Nested) Shutdown() {
+	n.mu.Lock()
+	child := n.child
+	n.child = nil
+	n.mu.Unlock()
+	child.Shutdown()
+	<-child.Done()
+}
+
+func (n *Nested) Health() error {
+	if d := n.Daemon(); d != nil {
+		return d.Health()
+	}
+	return nil
+}
+
+func (n *Nested) Readiness() error {
+	if d := n.Daemon(); d != nil {
+		return d.Readiness()
+	}
+	return nil
+}
+
+// nestedBridge is registered in the nested daemon to export its events.
+type nestedBridge struct {
+	n *Nested
+	h *ExampleServiceHandle
+}
+
+func (b *nestedBridge) ID() ServiceId              { return nestedBridgeId }
+func (b *nestedBridge) Name() string               { return b.n.Name() + "-export" }
+func (b *nestedBridge) Dependencies() []ServiceId  { return nil }
+func (b *nestedBridge) Subscriptions() []EventType { return b.n.cfg.Export }
+func (b *nestedBridge) Init(ServiceHandle)         {}
+func (b *nestedBridge) Shutdown()                  {}
+
+func (b *nestedBridge) HandleEvent(ev Event) {
+	if ev.ServiceId() == nestedBridgeId {
+		// Imported from the parent.
+		return
+	}
+	if e := eventOf(ev); e != nil {
+		e.pin()
+	}
+	if err := b.h.EmitEventContext(ev.Context(), ev.EventType(), ev.Data()); err != nil {
+		b.h.d.log.Warn("Failed to export event from nested daemon",
+			"service", b.n.Name(),
+			"event_type", ev.EventType(),
+			"error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package gosvcd
+
+// OneShot is implemented by services that perform a task in Init, e.g. a
+// migration or a cache warmup, and are then done. Once Init has returned,
+// a one-shot service is shut down and unregistered. The services depending
+// on it are initialized only if it completed successfully, i.e. Init did
+// not panic.
+type OneShot interface {
+	OneShot() bool
+}
+
+func isOneShot(svc Service) bool {
+	o, ok := svc.(OneShot)
+	return ok && o.OneShot()
+}
+
+// initServices initializes the services in dependency order, skipping the
+// lazy services and the dependents of failed one-shot services.
+func (d *ExampleServiceDaemon) initServices() {
+	blocked := make(map[ServiceId]bool)
+	for _, h := range d.orderedHandles() {
+		if dep, ok := blockedBy(h, blocked); ok {
+			d.log.Error("Not initializing service, one-shot dependency failed",
+				"service", h.Name(),
+				"dependency", dep)
+			blocked[h.ID()] = true
+			continue
+		}
+		if h.lazy {
+			continue
+		}
+		d.activateDependencies(h)
+		h.mu.Lock()
+		h.initService()
+		h.mu.Unlock()
+		if !isOneShot(h.Service) {
+			continue
+		}
+		if h.getState() != ServiceRunning {
+			blocked[h.ID()] = true
+			continue
+		}
+		d.completeOneShot(h)
+	}
+}
+
+func blockedBy(h *ExampleServiceHandle, blocked map[ServiceId]bool) (ServiceId, bool) {
+	for _, dep := range h.Dependencies() {
+		if blocked[dep] && !isOptionalDependency(h.Service, dep) {
+			return dep, true
+		}
+	}
+	return 0, false
+}
+
+// completeOneShot shuts down and unregisters the completed one-shot
+// service.
+func (d *ExampleServiceDaemon) completeOneShot(h *ExampleServiceHandle) {
+	h.mu.Lock()
+	h.shutdownService()
+	h.mu.Unlock()
+	d.unregister(h)
+	d.mu.Lock()
+	if d.completed == nil {
+		d.completed = make(map[ServiceId]bool)
+	}
+	d.completed[h.ID()] = true
+	d.mu.Unlock()
+	d.log.Info("One-shot service completed", "service", h.Name(), "id", h.ID())
+}
+
+// isCompleted returns true if the service is a completed one-shot.
+func (d *ExampleServiceDaemon) isCompleted(id ServiceId) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.completed[id]
+}
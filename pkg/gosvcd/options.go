@@ -0,0 +1,299 @@
+package gosvcd
+
+import "time"
+
+// Option configures the builder returned by NewBuilder. Each option is
+// equivalent to calling the corresponding setter of the builder, e.g.
+// WithLogger to SetLogger, and there is one for each setter. The services
+// are not configured with options but registered with Register,
+// RegisterReplicas, Supervise and Override.
+type Option func(b *ExampleServiceDaemonBuilder)
+
+// Default buffer sizes.
+const (
+	DefaultEmitBufferSize     = 128
+	DefaultDispatchBufferSize = 128
+	DefaultTapBufferSize      = 64
+)
+
+// BufferSizes are the capacities of the daemon's buffers. Zero sizes
+// default to the Default*BufferSize constants.
+type BufferSizes struct {
+	// Emit is the capacity of the queue of the emitted events waiting to
+	// be routed, rounded up to a power of two.
+	Emit int
+
+	// Dispatch is the capacity of the dispatch queue of an event type of
+	// QoSNormal. The queues of QoSRealtime types are half of it, and the
+	// queues of QoSBulk types eight times it.
+	Dispatch int
+
+	// Tap is the capacity of the channel of a tap.
+	Tap int
+}
+
+func (s BufferSizes) withDefaults() BufferSizes {
+	if s.Emit <= 0 {
+		s.Emit = DefaultEmitBufferSize
+	}
+	if s.Dispatch <= 0 {
+		s.Dispatch = DefaultDispatchBufferSize
+	}
+	if s.Tap <= 0 {
+		s.Tap = DefaultTapBufferSize
+	}
+	return s
+}
+
+// MetricsSink receives the measurements of the daemon as they are made,
+// e.g. for exporting them to a metrics system other than the ones
+// supported by NewMetricsHandler and PublishExpvar. The methods are called
+// from the event loops and must not block.
+type MetricsSink interface {
+	EventEmitted(typ EventType)
+	EventDropped(reason string, typ EventType)
+	EventHandled(id ServiceId, typ EventType, latency time.Duration)
+	ServiceRestarted(id ServiceId)
+}
+
+// SetClock sets the clock of the daemon, SystemClock by default.
+func (b *ExampleServiceDaemonBuilder) SetClock(c Clock) {
+	b.clock = c
+}
+
+// SetBufferSizes sets the capacities of the daemon's buffers.
+func (b *ExampleServiceDaemonBuilder) SetBufferSizes(s BufferSizes) {
+	b.buffers = s
+}
+
+// SetMetricsSink sets the sink to which the measurements are passed, in
+// addition to being collected for Metrics.
+func (b *ExampleServiceDaemonBuilder) SetMetricsSink(sink MetricsSink) {
+	b.metricsSink = sink
+}
+
+// WithLogger sets the logger for the daemon's internals. See SetLogger.
+func WithLogger(l Logger) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetLogger(l) }
+}
+
+// WithClock sets the clock of the daemon, SystemClock by default. See
+// SetClock.
+func WithClock(c Clock) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetClock(c) }
+}
+
+// WithBufferSizes sets the capacities of the daemon's buffers. See
+// SetBufferSizes.
+func WithBufferSizes(s BufferSizes) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetBufferSizes(s) }
+}
+
+// WithMetrics sets the sink to which the measurements are passed. See
+// SetMetricsSink.
+func WithMetrics(sink MetricsSink) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetMetricsSink(sink) }
+}
+
+// WithDispatchMode sets how the dispatch queues are run. See
+// SetDispatchMode.
+func WithDispatchMode(mode DispatchMode, workers int) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetDispatchMode(mode, workers) }
+}
+
+// WithEventPooling enables the reuse of the events. See SetEventPooling.
+func WithEventPooling(enabled bool) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetEventPooling(enabled) }
+}
+
+// WithTracer sets the tracer of the event deliveries. See SetTracer.
+func WithTracer(t Tracer) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetTracer(t) }
+}
+
+// WithAuditLog sets the log to which all dispatched events are recorded.
+// See SetAuditLog.
+func WithAuditLog(a *AuditLog) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetAuditLog(a) }
+}
+
+// WithSignals makes the daemon handle SIGINT, SIGTERM and SIGHUP. See
+// HandleSignals.
+func WithSignals() Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.HandleSignals() }
+}
+
+// WithSlowConsumerPolicy enables the detection of slow consumers. See
+// SetSlowConsumerPolicy.
+func WithSlowConsumerPolicy(p SlowConsumerPolicy) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetSlowConsumerPolicy(p) }
+}
+
+// WithQueueWatermarks enables the QueuePressure events. See
+// SetQueueWatermarks.
+func WithQueueWatermarks(w QueueWatermarks) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetQueueWatermarks(w) }
+}
+
+// WithStallTimeout enables the detection of stalled event loops. See
+// SetStallTimeout.
+func WithStallTimeout(timeout time.Duration) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetStallTimeout(timeout) }
+}
+
+// WithLeakGracePeriod sets the grace period of the goroutine leak check.
+// See SetLeakGracePeriod.
+func WithLeakGracePeriod(grace time.Duration) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetLeakGracePeriod(grace) }
+}
+
+// WithRetryPolicy sets the retry policy of the RetryHandler services. See
+// SetRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetRetryPolicy(p) }
+}
+
+// WithCircuitBreaker sets the circuit breaker policy of the services. See
+// SetCircuitBreaker.
+func WithCircuitBreaker(p CircuitBreakerPolicy) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetCircuitBreaker(p) }
+}
+
+// WithQuarantinePolicy sets the quarantine policy of the services. See
+// SetQuarantinePolicy.
+func WithQuarantinePolicy(p QuarantinePolicy) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetQuarantinePolicy(p) }
+}
+
+// WithThreadAffinity sets the locking of the daemon's goroutines to OS
+// threads. See SetThreadAffinity.
+func WithThreadAffinity(a ThreadAffinity) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetThreadAffinity(a) }
+}
+
+// WithChaos enables the injection of faults. Meant for tests only. See
+// SetChaos.
+func WithChaos(cfg ChaosConfig) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetChaos(cfg) }
+}
+
+// WithRetention sets the number of retained events of the type. See
+// SetRetention.
+func WithRetention(typ EventType, depth int) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetRetention(typ, depth) }
+}
+
+// WithDeliveryMode sets the delivery mode of the event type. See
+// SetDeliveryMode.
+func WithDeliveryMode(typ EventType, mode DeliveryMode) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetDeliveryMode(typ, mode) }
+}
+
+// WithCreditWindow sets the credit window of the event type. See
+// SetCreditWindow.
+func WithCreditWindow(typ EventType, window int) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetCreditWindow(typ, window) }
+}
+
+// WithSubscriptionBuffers sets the default buffer of the subscriptions. See
+// SetSubscriptionBuffers.
+func WithSubscriptionBuffers(buf SubscriptionBuffer) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetSubscriptionBuffers(buf) }
+}
+
+// WithSubscriptionBuffer sets the buffer of a subscription of the service.
+// See SetSubscriptionBuffer.
+func WithSubscriptionBuffer(id ServiceId, typ EventType, buf SubscriptionBuffer) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetSubscriptionBuffer(id, typ, buf) }
+}
+
+// WithHandlerTimeout sets the timeout of the event handler of the service.
+// See SetHandlerTimeout.
+func WithHandlerTimeout(id ServiceId, t HandlerTimeout) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetHandlerTimeout(id, t) }
+}
+
+// WithJournal sets the journal of the emitted events. See SetJournal.
+func WithJournal(j *Journal) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetJournal(j) }
+}
+
+// WithEventStore sets the store to which the emitted events are appended.
+// See SetEventStore.
+func WithEventStore(s EventStore) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetEventStore(s) }
+}
+
+// WithSnapshots enables the snapshots of the services. See SetSnapshots.
+func WithSnapshots(store SnapshotStore, interval time.Duration) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetSnapshots(store, interval) }
+}
+
+// WithRestore makes the daemon resume from the checkpoint. See SetRestore.
+func WithRestore(cp *Checkpoint) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetRestore(cp) }
+}
+
+// WithCheckpointDir sets the directory of the named checkpoints. See
+// SetCheckpointDir.
+func WithCheckpointDir(dir string) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetCheckpointDir(dir) }
+}
+
+// WithAckTimeout makes the event type delivered at least once. See
+// SetAckTimeout.
+func WithAckTimeout(typ EventType, timeout time.Duration) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetAckTimeout(typ, timeout) }
+}
+
+// WithQoS sets the quality-of-service class of the event type. See SetQoS.
+func WithQoS(typ EventType, class QoSClass) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetQoS(typ, class) }
+}
+
+// WithTypeTTL sets the time-to-live of the events of the type. See SetTTL,
+// and WithTTL for the TTL of an emitted event.
+func WithTypeTTL(typ EventType, ttl time.Duration) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetTTL(typ, ttl) }
+}
+
+// WithDeadLetterExpired makes the daemon emit a DeadLetter for the expired
+// events. See SetDeadLetterExpired.
+func WithDeadLetterExpired(enabled bool) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetDeadLetterExpired(enabled) }
+}
+
+// WithValidator sets the validator of the payloads of the event type. See
+// SetValidator.
+func WithValidator(typ EventType, v Validator) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetValidator(typ, v) }
+}
+
+// WithSubscriptionACL restricts the subscribers of the event type. See
+// SetSubscriptionACL.
+func WithSubscriptionACL(typ EventType, allowed ...ServiceId) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetSubscriptionACL(typ, allowed...) }
+}
+
+// WithEmitCapabilities restricts the event types the service may emit. See
+// SetEmitCapabilities.
+func WithEmitCapabilities(id ServiceId, types ...EventType) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetEmitCapabilities(id, types...) }
+}
+
+// WithResourceBudget sets the resource budget of the service. See
+// SetResourceBudget.
+func WithResourceBudget(id ServiceId, budget ResourceBudget) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetResourceBudget(id, budget) }
+}
+
+// WithCoordination sets the store of the semaphores and locks. See
+// SetCoordination.
+func WithCoordination(store CoordinationStore) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetCoordination(store) }
+}
+
+// WithGroup assigns the services to the named group. See SetGroup.
+func WithGroup(name string, ids ...ServiceId) Option {
+	return func(b *ExampleServiceDaemonBuilder) { b.SetGroup(name, ids...) }
+}
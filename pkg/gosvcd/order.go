@@ -0,0 +1,154 @@
+package gosvcd
+
+import "sync"
+
+// SourceOrdered is implemented by services that need the events of each
+// source delivered in the order they were emitted, across event types, if
+// SourceOrdered returns true. By default, the events of different types
+// are dispatched independently, so an event may be delivered before an
+// event of another type emitted earlier by the same source.
+//
+// The order is the order in which the events of the source were emitted,
+// which for the events emitted concurrently by the same source is the
+// order in which the emits completed. A dispatcher delivering an event to
+// the service waits for the earlier events of its source to be delivered
+// to the service by the other dispatchers. The events of a
+// ConcurrentHandler are dispatched in order but may be handled out of it.
+// The ordering is fixed when the daemon is started.
+type SourceOrdered interface {
+	SourceOrdered() bool
+}
+
+// sourceOrder sequences the events delivered to a SourceOrdered service
+// by their source.
+type sourceOrder struct {
+	// next is the sequence number of the next event of each source to
+	// be routed to the service. Accessed by the router only.
+	next map[ServiceId]uint64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// done is the sequence number of the next event of each source to
+	// be delivered to the service.
+	done map[ServiceId]uint64
+}
+
+// orderTicket is the sequence number of an event among the events of its
+// source routed to an ordered subscriber.
+type orderTicket struct {
+	sub ServiceId
+	n   uint64
+}
+
+// newSourceOrders returns the source orders of the services that are
+// SourceOrdered, or nil if none is.
+func newSourceOrders(hs map[ServiceId]*ExampleServiceHandle) map[ServiceId]*sourceOrder {
+	var orders map[ServiceId]*sourceOrder
+	for id, h := range hs {
+		if o, ok := h.Service.(SourceOrdered); !ok || !o.SourceOrdered() {
+			continue
+		}
+		if orders == nil {
+			orders = make(map[ServiceId]*sourceOrder)
+		}
+		so := &sourceOrder{
+			next: make(map[ServiceId]uint64),
+			done: make(map[ServiceId]uint64),
+		}
+		so.cond = sync.NewCond(&so.mu)
+		orders[id] = so
+	}
+	return orders
+}
+
+// assignTickets numbers the event among the events of its source for each
+// ordered subscriber of its queue. Called by the router before queueing
+// the event.
+func (d *ExampleServiceDaemon) assignTickets(q *dispatcher, ev *ExampleEvent) {
+	if d.orders == nil {
+		return
+	}
+	for _, h := range q.subscribers() {
+		so, ok := d.orders[h.ID()]
+		if !ok {
+			continue
+		}
+		n := so.next[ev.source]
+		so.next[ev.source] = n + 1
+		ev.tickets = append(ev.tickets, orderTicket{h.ID(), n})
+	}
+}
+
+// ticket returns the order of the event for the subscriber, if ordered.
+func (ev *ExampleEvent) ticket(id ServiceId) (orderTicket, bool) {
+	for _, t := range ev.tickets {
+		if t.sub == id {
+			return t, true
+		}
+	}
+	return orderTicket{}, false
+}
+
+// deliverOrdered delivers the event to the service once the earlier events
+// of its source have been delivered, if the service is ordered.
+func (d *ExampleServiceDaemon) deliverOrdered(h *ExampleServiceHandle, ev *ExampleEvent) {
+	t, ok := ev.ticket(h.ID())
+	if !ok {
+		d.deliver(h, ev)
+		return
+	}
+	so := d.orders[h.ID()]
+	so.wait(ev.source, t.n)
+	d.deliver(h, ev)
+	so.advance(ev.source)
+}
+
+// skipOrdered accounts for the event not being delivered to the service,
+// so that the later events of its source are not waiting for it.
+func (d *ExampleServiceDaemon) skipOrdered(h *ExampleServiceHandle, ev *ExampleEvent) {
+	t, ok := ev.ticket(h.ID())
+	if !ok {
+		return
+	}
+	so := d.orders[h.ID()]
+	so.wait(ev.source, t.n)
+	so.advance(ev.source)
+}
+
+// skipRemoved accounts for the event not being delivered to the ordered
+// subscribers that were removed from its queue after it was routed, e.g.
+// drained.
+func (d *ExampleServiceDaemon) skipRemoved(ev *ExampleEvent, subs []*ExampleServiceHandle) {
+	for _, t := range ev.tickets {
+		found := false
+		for _, h := range subs {
+			if h.ID() == t.sub {
+				found = true
+				break
+			}
+		}
+		if !found {
+			so := d.orders[t.sub]
+			so.wait(ev.source, t.n)
+			so.advance(ev.source)
+		}
+	}
+}
+
+// wait waits until the event 'n' of the source is the next one to be
+// delivered.
+func (so *sourceOrder) wait(source ServiceId, n uint64) {
+	so.mu.Lock()
+	for so.done[source] != n {
+		so.cond.Wait()
+	}
+	so.mu.Unlock()
+}
+
+func (so *sourceOrder) advance(source ServiceId) {
+	so.mu.Lock()
+	so.done[source]++
+	so.cond.Broadcast()
+	so.mu.Unlock()
+}
@@ -0,0 +1,118 @@
+package gosvcd
+
+import (
+	"context"
+	"sync"
+)
+
+// Transactional is implemented by services whose events emitted while
+// handling an event are published only if the handler succeeds. The
+// events emitted with the context of the event being handled, i.e.
+// with EmitEventContext(event.Context(), ...), are staged in an outbox
+// and published together after the handler has returned. If the handler
+// panics or a RetryHandler returns an error, the staged events are
+// discarded. Events emitted with other contexts are published
+// immediately.
+type Transactional interface {
+	Transactional() bool
+}
+
+type outboxCtx struct{}
+
+// emission is an event to be emitted.
+type emission struct {
+	ctx       context.Context
+	source    ServiceId
+	eventType EventType
+	data      interface{}
+	info      *typeInfo
+
+	// credited is true if a credit of the type was taken for the event.
+	credited bool
+}
+
+// outbox holds the events staged during the handling of an event.
+type outbox struct {
+	mu     sync.Mutex
+	closed bool
+	evs    []emission
+}
+
+// outboxFrom returns the outbox in the context, if any.
+func outboxFrom(ctx context.Context) *outbox {
+	if ctx == nil {
+		return nil
+	}
+	ob, _ := ctx.Value(outboxCtx{}).(*outbox)
+	return ob
+}
+
+// stage adds the event to the outbox. Returns false if the outbox has
+// been closed, i.e. the handler has returned.
+func (ob *outbox) stage(e emission) bool {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	if ob.closed {
+		return false
+	}
+	// The events emitted while handling the staged event are not part of
+	// this outbox.
+	e.ctx = context.WithValue(e.ctx, outboxCtx{}, (*outbox)(nil))
+	ob.evs = append(ob.evs, e)
+	return true
+}
+
+// close closes the outbox and returns the staged events.
+func (ob *outbox) close() []emission {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.closed = true
+	evs := ob.evs
+	ob.evs = nil
+	return evs
+}
+
+// outboxEvent is the event passed to the handler of a transactional
+// service, with the outbox in its context.
+type outboxEvent struct {
+	Event
+	ctx context.Context
+}
+
+func (ev *outboxEvent) Context() context.Context {
+	return ev.ctx
+}
+
+func (ev *outboxEvent) IdempotencyKey() string {
+	return IdempotencyKey(ev.Event)
+}
+
+// withOutbox returns the event to pass to the handler of the service and
+// the outbox for the events emitted by it, if the service is
+// transactional.
+func withOutbox(h *ExampleServiceHandle, event Event) (Event, *outbox) {
+	if t, ok := h.Service.(Transactional); !ok || !t.Transactional() {
+		return event, nil
+	}
+	ob := &outbox{}
+	return &outboxEvent{event, context.WithValue(event.Context(), outboxCtx{}, ob)}, ob
+}
+
+// commitOutbox publishes the staged events if the handler succeeded and
+// discards them otherwise.
+func (d *ExampleServiceDaemon) commitOutbox(h *ExampleServiceHandle, ob *outbox, ok bool) {
+	evs := ob.close()
+	if len(evs) == 0 {
+		return
+	}
+	if !ok {
+		for _, e := range evs {
+			e.returnCredit()
+		}
+		d.log.Debug("Discarded staged events", "service", h.Name(), "events", len(evs))
+		return
+	}
+	if err := d.send(evs...); err != nil {
+		d.log.Warn("Failed to publish staged events", "service", h.Name(), "events", len(evs), "error", err)
+	}
+}
@@ -0,0 +1,31 @@
+package gosvcd
+
+import "fmt"
+
+// Override replaces the service with the identifier with 'fake', e.g. a
+// stub of a database or network client in an integration test. The fake
+// is registered in place of the service whether it is registered before
+// or after the call, so the services depending on the identifier depend
+// on the fake. The fake must have the identifier.
+func (b *ExampleServiceDaemonBuilder) Override(id ServiceId, fake Service) {
+	if fake.ID() != id {
+		panic(fmt.Sprintf("override of service %d has identifier %d", id, fake.ID()))
+	}
+	if b.overrides == nil {
+		b.overrides = make(map[ServiceId]Service)
+	}
+	b.overrides[id] = fake
+	if h, ok := b.handles[id]; ok {
+		b.register(b.overridden(h.Service))
+	}
+}
+
+// overridden returns the fake registered in place of the service, or the
+// service itself.
+func (b *ExampleServiceDaemonBuilder) overridden(svc Service) Service {
+	if fake, ok := b.overrides[svc.ID()]; ok {
+		b.log.Info("Overriding service", "service", svc.Name(), "id", svc.ID(), "fake", fake.Name())
+		return fake
+	}
+	return svc
+}
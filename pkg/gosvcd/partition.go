@@ -0,0 +1,111 @@
+package gosvcd
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// PartitionedHandler is implemented by concurrent services that need the
+// events of each entity handled in order. The events with the same
+// partition key are handled one at a time, in the order they were
+// dispatched, and the events with different keys concurrently, up to
+// MaxConcurrency at a time. The events with an empty key are handled as
+// by a ConcurrentHandler. The keys are extracted when the events are
+// dispatched, from the dispatcher's goroutine, and PartitionKey must not
+// block.
+//
+// Unlike the partition keys of RegisterReplicas, the keys are not hashed
+// to a fixed set of workers, so a slow entity delays only its own events.
+type PartitionedHandler interface {
+	ConcurrentHandler
+	PartitionKey(ev Event) string
+}
+
+// partitionBacklog is the number of events per worker of a partitioned
+// service that may be queued or in progress before the dispatchers are
+// blocked.
+const partitionBacklog = 16
+
+// partitions serializes the handling of the events with the same key.
+type partitions struct {
+	mu sync.Mutex
+
+	// busy are the keys being handled, with the events waiting for them.
+	busy map[string][]keyedEvent
+
+	// backlog limits the queued and in-progress events.
+	backlog chan struct{}
+}
+
+// keyedEvent is an event waiting for the preceding events with the same
+// key.
+type keyedEvent struct {
+	ev    *ExampleEvent
+	event Event
+	rec   *ackRecord
+	span  Span
+}
+
+// newPartitions returns the partitions of the service, or nil if it does
+// not partition its events.
+func newPartitions(svc Service) *partitions {
+	p, ok := svc.(PartitionedHandler)
+	if !ok || p.MaxConcurrency() <= 1 {
+		return nil
+	}
+	return &partitions{
+		busy:    make(map[string][]keyedEvent),
+		backlog: make(chan struct{}, partitionBacklog*p.MaxConcurrency()),
+	}
+}
+
+// deliverPartitioned delivers the event to a partitioned service, in a
+// worker handling the events with its key in order.
+func (d *ExampleServiceDaemon) deliverPartitioned(h *ExampleServiceHandle, ev *ExampleEvent, event Event, rec *ackRecord, span Span) {
+	key := h.Service.(PartitionedHandler).PartitionKey(event)
+	if key == "" {
+		d.deliverAsync(h, ev, event, rec, span)
+		return
+	}
+	p := h.partitions
+	p.backlog <- struct{}{}
+
+	// The event is pending until it has been handled.
+	atomic.AddInt64(&d.pending, 1)
+	ev.retain()
+	d.workers.Add(1)
+	ke := keyedEvent{ev, event, rec, span}
+
+	p.mu.Lock()
+	if waiting, ok := p.busy[key]; ok {
+		p.busy[key] = append(waiting, ke)
+		p.mu.Unlock()
+		return
+	}
+	p.busy[key] = nil
+	p.mu.Unlock()
+
+	h.workers <- struct{}{}
+	d.goroutines.Go(h.Name(), func() {
+		defer func() { <-h.workers }()
+		for {
+			d.deliverTo(h, ke.ev, ke.event, ke.rec, ke.span)
+			atomic.AddInt64(&d.pending, -1)
+			ke.ev.release()
+			d.workers.Done()
+			<-p.backlog
+
+			p.mu.Lock()
+			waiting := p.busy[key]
+			if len(waiting) == 0 {
+				delete(p.busy, key)
+				p.mu.Unlock()
+				return
+			}
+			ke = waiting[0]
+			waiting[0] = keyedEvent{}
+			p.busy[key] = waiting[1:]
+			p.mu.Unlock()
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package gosvcd
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// pauseGate holds the dispatchers while the dispatch is paused.
+type pauseGate struct {
+	paused int32
+
+	mu sync.Mutex
+
+	// resumed is closed when the dispatch is resumed, or is nil if it is
+	// not paused.
+	resumed chan struct{}
+}
+
+func (g *pauseGate) isPaused() bool {
+	return atomic.LoadInt32(&g.paused) != 0
+}
+
+// pause closes the gate. Returns false if it was already closed.
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		return false
+	}
+	g.resumed = make(chan struct{})
+	atomic.StoreInt32(&g.paused, 1)
+	return true
+}
+
+// resume opens the gate. Returns false if it was not closed.
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		return false
+	}
+	atomic.StoreInt32(&g.paused, 0)
+	close(g.resumed)
+	g.resumed = nil
+	return true
+}
+
+// wait blocks while the gate is closed, or until 'stop' is closed.
+func (g *pauseGate) wait(stop <-chan struct{}) {
+	if !g.isPaused() {
+		return
+	}
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return
+	}
+	select {
+	case <-resumed:
+	case <-stop:
+	}
+}
+
+// Pause stops the delivery of events without shutting down the services.
+// The emitted events are queued while paused, and once a queue is full,
+// the routing of the events, or with QoSBulk the emitters of its type, is
+// blocked until the dispatch is resumed. The handlers being invoked are
+// not interrupted. Shutting down the daemon resumes the dispatch to
+// deliver the queued events.
+func (d *ExampleServiceDaemon) Pause() {
+	if d.pause.pause() {
+		d.log.Info("Event dispatch paused")
+	}
+}
+
+// Resume resumes the delivery of events paused with Pause.
+func (d *ExampleServiceDaemon) Resume() {
+	if d.pause.resume() {
+		d.log.Info("Event dispatch resumed")
+	}
+}
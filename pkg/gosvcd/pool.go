@@ -0,0 +1,63 @@
+package gosvcd
+
+import (
+	"sync/atomic"
+)
+
+// ConcurrentHandler is implemented by services whose event handlers can
+// be invoked concurrently. Up to MaxConcurrency events are handled at a
+// time, by a pool of workers. The events are not handled in the order they
+// were emitted, nor before the events are delivered to the dependent
+// services. A MaxConcurrency of one or less keeps the default of handling
+// one event at a time. See PartitionedHandler for ordering the events by
+// key.
+type ConcurrentHandler interface {
+	MaxConcurrency() int
+}
+
+// newWorkerSlots returns the semaphore for the workers of the service, or
+// nil if the service handles one event at a time.
+func newWorkerSlots(svc Service) chan struct{} {
+	if c, ok := svc.(ConcurrentHandler); ok && c.MaxConcurrency() > 1 {
+		return make(chan struct{}, c.MaxConcurrency())
+	}
+	return nil
+}
+
+// lockHandler locks the service for invoking its handler: exclusively,
+// unless the service handles events concurrently.
+func (h *ExampleServiceHandle) lockHandler() {
+	if h.workers != nil {
+		h.mu.RLock()
+	} else {
+		h.mu.Lock()
+	}
+}
+
+func (h *ExampleServiceHandle) unlockHandler() {
+	if h.workers != nil {
+		h.mu.RUnlock()
+	} else {
+		h.mu.Unlock()
+	}
+}
+
+// deliverAsync delivers the event to a concurrent service in a worker,
+// blocking until a worker is available.
+func (d *ExampleServiceDaemon) deliverAsync(h *ExampleServiceHandle, ev *ExampleEvent, event Event, rec *ackRecord, span Span) {
+	h.workers <- struct{}{}
+
+	// The event is pending until the worker has handled it.
+	atomic.AddInt64(&d.pending, 1)
+	ev.retain()
+	d.workers.Add(1)
+	d.goroutines.Go(h.Name(), func() {
+		defer func() {
+			<-h.workers
+			atomic.AddInt64(&d.pending, -1)
+			ev.release()
+			d.workers.Done()
+		}()
+		d.deliverTo(h, ev, event, rec, span)
+	})
+}
@@ -0,0 +1,9 @@
+module github.com/joamaki/gosvcd/pkg/gosvcd/protocodec
+
+go 1.23
+
+require github.com/joamaki/gosvcd v0.0.0
+
+require google.golang.org/protobuf v1.36.11
+
+replace github.com/joamaki/gosvcd => ../../..
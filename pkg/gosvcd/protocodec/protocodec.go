@@ -0,0 +1,36 @@
+// Package protocodec is the gosvcd codec of the protobuf messages generated
+// by google.golang.org/protobuf, which have no Marshal and Unmarshal methods
+// for gosvcd.ProtobufCodec. It is a module of its own, so that the daemon
+// does not depend on the protobuf runtime unless its payloads are protobuf
+// messages:
+//
+//	gosvcd.RegisterPayload("Reading", &pb.Reading{}, protocodec.Codec)
+package protocodec
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// Codec encodes the payloads that are proto.Message with proto.Marshal. The
+// encoding is named "protobuf", as with gosvcd.ProtobufCodec, so that the
+// messages can be decoded with either.
+var Codec = gosvcd.NewProtobufCodec(
+	func(v interface{}) ([]byte, error) {
+		m, ok := v.(proto.Message)
+		if !ok {
+			return nil, fmt.Errorf("%T is not a protobuf message", v)
+		}
+		return proto.Marshal(m)
+	},
+	func(data []byte, v interface{}) error {
+		m, ok := v.(proto.Message)
+		if !ok {
+			return fmt.Errorf("%T is not a protobuf message", v)
+		}
+		return proto.Unmarshal(data, m)
+	},
+)
@@ -0,0 +1,74 @@
+package gosvcd
+
+// QoSClass is the quality-of-service class of an event type, assigned with
+// the builder's SetQoS. The class sets the capacity of the dispatch queue
+// of the type and, with a pooled DispatchMode, the priority of the queue:
+// the queues of realtime types are run before the normal ones, and those
+// before the bulk ones.
+type QoSClass int
+
+const (
+	// QoSNormal is the default class.
+	QoSNormal QoSClass = iota
+
+	// QoSRealtime is for control events that must not wait behind other
+	// traffic.
+	QoSRealtime
+
+	// QoSBulk is for high-volume events. Their dispatch queue is larger,
+	// and emitters of bulk events are blocked while it is full instead
+	// of blocking the routing of the other events.
+	QoSBulk
+
+	numQoSClasses = 3
+)
+
+func (c QoSClass) String() string {
+	switch c {
+	case QoSNormal:
+		return "normal"
+	case QoSRealtime:
+		return "realtime"
+	case QoSBulk:
+		return "bulk"
+	}
+	return "unknown"
+}
+
+// priority returns the scheduling priority of the class, zero being the
+// highest.
+func (c QoSClass) priority() int {
+	switch c {
+	case QoSRealtime:
+		return 0
+	case QoSBulk:
+		return 2
+	}
+	return 1
+}
+
+// queueSize returns the capacity of the dispatch queues of the class, given
+// the capacity of the QoSNormal queues.
+func (c QoSClass) queueSize(normal int) int {
+	switch c {
+	case QoSRealtime:
+		if normal < 2 {
+			return 1
+		}
+		return normal / 2
+	case QoSBulk:
+		return normal * 8
+	}
+	return normal
+}
+
+// admit waits for room in the dispatch queue of a bulk event type, so that
+// routing the event does not block. Returns false if the type is not
+// admission controlled.
+func (ti *typeInfo) admit() bool {
+	if ti == nil || ti.queue == nil || ti.queue.slots == nil {
+		return false
+	}
+	ti.queue.slots <- struct{}{}
+	return true
+}
@@ -0,0 +1,270 @@
+package gosvcd
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// PauseMode selects what happens to the events of a paused service.
+type PauseMode int
+
+const (
+	// PauseHold holds the events of the service until it is resumed, up
+	// to MaxHeldEvents, after which the oldest are dropped.
+	PauseHold PauseMode = iota
+
+	// PauseDrop drops the events of the service until it is resumed.
+	PauseDrop
+)
+
+func (m PauseMode) String() string {
+	switch m {
+	case PauseHold:
+		return "hold"
+	case PauseDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+// MaxHeldEvents is the number of events held for a service paused with
+// PauseHold.
+const MaxHeldEvents = 1024
+
+// QuarantinePolicy configures the automatic pausing of misbehaving
+// services. A service whose handler fails (panics or returns an error
+// from a RetryHandler) Failures consecutive times is paused with Mode
+// until resumed with ResumeService.
+type QuarantinePolicy struct {
+	Failures int
+	Mode     PauseMode
+}
+
+// quarantine is the pause state of a service.
+type quarantine struct {
+	// paused is one when the service is paused, accessed atomically by
+	// the dispatchers.
+	paused int32
+
+	// The fields below are protected by the handle's 'bookMu'.
+
+	mode PauseMode
+
+	// held are the events held for the service, in the order they were
+	// dispatched.
+	held []*ExampleEvent
+
+	// draining is true while the held events are being delivered.
+	draining bool
+
+	// failures is the number of consecutive failed invocations.
+	failures int
+
+	// handoff is true while the events are held for the replacement of
+	// the service, lifting the MaxHeldEvents limit.
+	handoff bool
+
+	// forward is the service that replaced this one. The events are
+	// delivered to it instead.
+	forward *ExampleServiceHandle
+
+	// stalled is true while the service is paused for its handlers that
+	// timed out, until they return.
+	stalled bool
+}
+
+//
+// Service paused
+//
+
+var ServicePaused_Type = EventType("ServicePaused")
+
+// ServicePaused is emitted when the delivery of events to a service is
+// paused, manually with PauseService or by the QuarantinePolicy, in which
+// case Failures is the number of consecutive failures.
+type ServicePaused struct {
+	Service  ServiceId
+	Mode     PauseMode
+	Failures int
+}
+
+// PauseService pauses the delivery of events to the service, without
+// affecting the other subscribers. The events are held or dropped
+// according to the mode until ResumeService is called. The held events
+// are dropped if the daemon shuts down.
+func (d *ExampleServiceDaemon) PauseService(id ServiceId, mode PauseMode) error {
+	h, ok := d.handle(id)
+	if !ok {
+		return fmt.Errorf("service %d not found", id)
+	}
+	h.bookMu.Lock()
+	d.pauseService(h, mode, 0)
+	h.quarantine.stalled = false
+	h.bookMu.Unlock()
+	return nil
+}
+
+// pauseService pauses the service. Must be called with 'bookMu' held.
+func (d *ExampleServiceDaemon) pauseService(h *ExampleServiceHandle, mode PauseMode, failures int) {
+	q := &h.quarantine
+	if atomic.LoadInt32(&q.paused) != 0 && q.mode == mode {
+		return
+	}
+	q.mode = mode
+	atomic.StoreInt32(&q.paused, 1)
+	d.log.Warn("Service paused", "service", h.Name(), "mode", mode, "failures", failures)
+	d.emitAsync(ServicePaused_Type, &ServicePaused{
+		Service:  h.ID(),
+		Mode:     mode,
+		Failures: failures,
+	})
+}
+
+// ResumeService resumes the delivery of events to a service paused with
+// PauseService. The held events are delivered before the new ones.
+func (d *ExampleServiceDaemon) ResumeService(id ServiceId) error {
+	h, ok := d.handle(id)
+	if !ok {
+		return fmt.Errorf("service %d not found", id)
+	}
+	d.resumeService(h)
+	return nil
+}
+
+// resumeService resumes the delivery of events to the service.
+func (d *ExampleServiceDaemon) resumeService(h *ExampleServiceHandle) {
+	q := &h.quarantine
+	h.bookMu.Lock()
+	defer h.bookMu.Unlock()
+	if atomic.LoadInt32(&q.paused) == 0 || q.draining {
+		return
+	}
+	q.failures = 0
+	d.log.Info("Service resumed", "service", h.Name(), "held", len(q.held))
+	if len(q.held) == 0 {
+		q.handoff = false
+		atomic.StoreInt32(&q.paused, 0)
+		return
+	}
+	// The service stays paused until the held events have been delivered,
+	// holding the events dispatched meanwhile after them.
+	q.draining = true
+	d.spawn(func() { d.drainHeld(h) })
+}
+
+// drainHeld delivers the held events of a resumed service.
+func (d *ExampleServiceDaemon) drainHeld(h *ExampleServiceHandle) {
+	q := &h.quarantine
+	for {
+		h.bookMu.Lock()
+		held := q.held
+		q.held = nil
+		if len(held) == 0 {
+			q.draining = false
+			q.handoff = false
+			atomic.StoreInt32(&q.paused, 0)
+			h.bookMu.Unlock()
+			return
+		}
+		h.bookMu.Unlock()
+		for _, ev := range held {
+			d.deliverEvent(h, ev)
+			d.unhold(ev)
+		}
+	}
+}
+
+// hold returns true if the service is paused, holding or dropping the
+// event.
+func (d *ExampleServiceDaemon) hold(h *ExampleServiceHandle, ev *ExampleEvent) bool {
+	q := &h.quarantine
+	if atomic.LoadInt32(&q.paused) == 0 {
+		return false
+	}
+	h.bookMu.Lock()
+	if atomic.LoadInt32(&q.paused) == 0 {
+		h.bookMu.Unlock()
+		return false
+	}
+	if fwd := q.forward; fwd != nil {
+		// The service has been replaced.
+		h.bookMu.Unlock()
+		d.deliver(fwd, ev)
+		return true
+	}
+	defer h.bookMu.Unlock()
+	if q.mode == PauseDrop || d.isShuttingDown() {
+		d.metrics.eventDropped(DropPaused, ev.eventType)
+		return true
+	}
+	if len(q.held) >= MaxHeldEvents && !q.handoff {
+		d.metrics.eventDropped(DropPaused, q.held[0].eventType)
+		d.unhold(q.held[0])
+		q.held[0] = nil
+		q.held = q.held[1:]
+	}
+	atomic.AddInt64(&d.pending, 1)
+	ev.retain()
+	d.cancels.retain(ev.cancel)
+	q.held = append(q.held, ev)
+	return true
+}
+
+// unhold releases an event that was held.
+func (d *ExampleServiceDaemon) unhold(ev *ExampleEvent) {
+	atomic.AddInt64(&d.pending, -1)
+	d.cancels.release(ev.cancel)
+	ev.release()
+}
+
+// dropHeld drops the held events of the services when the daemon shuts
+// down.
+func (d *ExampleServiceDaemon) dropHeld() {
+	for _, h := range d.orderedHandles() {
+		d.dropHeldOf(h)
+	}
+}
+
+// dropHeldOf drops the held events of the service, unless they are being
+// delivered.
+func (d *ExampleServiceDaemon) dropHeldOf(h *ExampleServiceHandle) {
+	q := &h.quarantine
+	h.bookMu.Lock()
+	defer h.bookMu.Unlock()
+	if q.draining {
+		return
+	}
+	for _, ev := range q.held {
+		d.metrics.eventDropped(DropPaused, ev.eventType)
+		d.unhold(ev)
+	}
+	q.held = nil
+}
+
+// recordQuarantine updates the count of consecutive failures of the
+// service with the outcome of an invocation. Must be called with
+// 'bookMu' held.
+func (d *ExampleServiceDaemon) recordQuarantine(h *ExampleServiceHandle, failed bool) {
+	p := d.quarantine
+	if p.Failures <= 0 {
+		return
+	}
+	q := &h.quarantine
+	if !failed {
+		q.failures = 0
+		return
+	}
+	q.failures++
+	if q.failures >= p.Failures {
+		d.pauseService(h, p.Mode, q.failures)
+	}
+}
+
+func (d *ExampleServiceDaemon) isShuttingDown() bool {
+	select {
+	case <-d.shuttingDown:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,258 @@
+package gosvcd
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// HandoffMode selects how the events are handed off from a service to its
+// replacement.
+type HandoffMode int
+
+const (
+	// HandoffBuffer holds the events of the service from the start of
+	// the replacement, and delivers them to the new instance once it is
+	// ready. No event is lost or delivered to both instances.
+	HandoffBuffer HandoffMode = iota
+
+	// HandoffMirror keeps delivering the events to the old instance and
+	// mirrors them to the new one once it has been initialized, letting
+	// it warm up. The events delivered before the switch are handled by
+	// both instances.
+	HandoffMirror
+)
+
+func (m HandoffMode) String() string {
+	switch m {
+	case HandoffBuffer:
+		return "buffer"
+	case HandoffMirror:
+		return "mirror"
+	}
+	return "unknown"
+}
+
+// DefaultReadyTimeout is the default time to wait for the replacement of
+// a service to become ready.
+const DefaultReadyTimeout = 30 * time.Second
+
+// readyPollInterval is the interval for checking the readiness of the
+// replacement of a service.
+const readyPollInterval = 10 * time.Millisecond
+
+// ReplaceOptions configures the replacement of a service.
+type ReplaceOptions struct {
+	Mode HandoffMode
+
+	// ReadyTimeout is the time to wait for the new instance to report
+	// ready, see ReadinessChecker. Defaults to DefaultReadyTimeout.
+	ReadyTimeout time.Duration
+}
+
+// Replace replaces the registered service with the same id with a new
+// instance, e.g. a new version of it, without losing events. The new
+// instance is initialized alongside the old one, and once it reports
+// ready, the delivery is switched to it and the old instance is shut
+// down. If the new instance fails to initialize or does not become ready
+// in time, it is shut down and the old instance keeps running.
+//
+// The new instance may change the subscriptions, but only to the event
+// types that had subscribers when the daemon was started, as the dispatch
+// queues are not created at runtime. The held events of the types the new
+// instance does not subscribe to are dropped.
+func (d *ExampleServiceDaemon) Replace(svc Service, opts ReplaceOptions) error {
+	id := svc.ID()
+	old, ok := d.handle(id)
+	if !ok {
+		return fmt.Errorf("service %d not found", id)
+	}
+	for _, typ := range svc.Subscriptions() {
+		if _, ok := d.queue(typ); !ok && d.permitted(id, typ) {
+			return fmt.Errorf("replacement of service %d subscribes to %s, which has no dispatch queue", id, typ)
+		}
+	}
+	if d.isStopping() {
+		return ErrDaemonStopped
+	}
+	if opts.ReadyTimeout <= 0 {
+		opts.ReadyTimeout = DefaultReadyTimeout
+	}
+	d.log.Info("Replacing service", "service", old.Name(), "id", id, "mode", opts.Mode)
+
+	h := &ExampleServiceHandle{Service: svc, d: d, workers: newWorkerSlots(svc), partitions: newPartitions(svc), budget: d.newBudgetState(id)}
+	if opts.Mode == HandoffBuffer {
+		old.bookMu.Lock()
+		old.quarantine.mode = PauseHold
+		old.quarantine.handoff = true
+		atomic.StoreInt32(&old.quarantine.paused, 1)
+		old.bookMu.Unlock()
+	}
+	h.mu.Lock()
+	h.initService()
+	h.mu.Unlock()
+	if opts.Mode == HandoffMirror && h.getState() == ServiceRunning {
+		d.replaceSubscriber(old, h, true)
+	}
+	if err := waitReady(h, opts.ReadyTimeout); err != nil {
+		d.log.Error("Replacement of service failed", "service", old.Name(), "error", err)
+		if opts.Mode == HandoffMirror {
+			d.replaceSubscriber(h, old, false)
+		}
+		h.mu.Lock()
+		h.shutdownService()
+		h.mu.Unlock()
+		if opts.Mode == HandoffBuffer {
+			d.ResumeService(id)
+		}
+		return err
+	}
+
+	d.mu.Lock()
+	d.handles[id] = h
+	for i, s := range d.services {
+		if s.ID() == id {
+			d.services[i] = svc
+		}
+	}
+	for _, typ := range unionTypes(old.Subscriptions(), svc.Subscriptions()) {
+		d.subs[typ] = removeService(d.subs[typ], id)
+		if d.subscribes(h, typ) {
+			d.subs[typ] = append(d.subs[typ], svc)
+		}
+	}
+	d.mu.Unlock()
+	d.replaceSubscriber(old, h, false)
+	if opts.Mode == HandoffBuffer {
+		// Move the held events to the new instance and forward the ones
+		// dispatched to the old instance before the switch.
+		old.bookMu.Lock()
+		h.bookMu.Lock()
+		for _, ev := range old.quarantine.held {
+			if d.subscribes(h, ev.eventType) {
+				h.quarantine.held = append(h.quarantine.held, ev)
+				continue
+			}
+			d.metrics.eventDropped(DropNoSubscribers, ev.eventType)
+			d.unhold(ev)
+		}
+		h.quarantine.mode = PauseHold
+		h.quarantine.handoff = true
+		atomic.StoreInt32(&h.quarantine.paused, 1)
+		h.bookMu.Unlock()
+		old.quarantine.held = nil
+		old.quarantine.forward = h
+		old.bookMu.Unlock()
+		d.ResumeService(id)
+	}
+
+	old.mu.Lock()
+	old.shutdownService()
+	old.mu.Unlock()
+	d.log.Info("Service replaced", "service", h.Name(), "id", id)
+	return nil
+}
+
+// replaceSubscriber replaces the service in the subscribers of the
+// dispatch queues, or adds the new one after it if 'keep' is true. The new
+// service is removed from the queues of the types it does not subscribe
+// to, and added to the queues of the types only it subscribes to.
+func (d *ExampleServiceDaemon) replaceSubscriber(old, h *ExampleServiceHandle, keep bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, typ := range unionTypes(old.Subscriptions(), h.Subscriptions()) {
+		q, ok := d.queues[typ]
+		if !ok {
+			continue
+		}
+		add := d.subscribes(h, typ)
+		var subs []*ExampleServiceHandle
+		for _, sub := range q.subscribers() {
+			switch {
+			case sub == h:
+				// Added when mirroring.
+			case sub != old:
+				subs = append(subs, sub)
+			default:
+				if keep {
+					subs = append(subs, old)
+				}
+				if add {
+					subs = append(subs, h)
+					add = false
+				}
+			}
+		}
+		if add {
+			subs = append(subs, h)
+		}
+		q.setSubscribers(subs)
+	}
+}
+
+// subscribes returns true if the service subscribes to the event type and
+// the subscription is permitted.
+func (d *ExampleServiceDaemon) subscribes(h *ExampleServiceHandle, typ EventType) bool {
+	for _, t := range h.Subscriptions() {
+		if t == typ {
+			return d.permitted(h.ID(), typ)
+		}
+	}
+	return false
+}
+
+// permitted returns false if the subscription of the service to the event
+// type is denied by an access control list.
+func (d *ExampleServiceDaemon) permitted(id ServiceId, typ EventType) bool {
+	acl, ok := d.acls[typ]
+	return !ok || acl[id]
+}
+
+// waitReady waits for the initialized service to report ready.
+func waitReady(h *ExampleServiceHandle, timeout time.Duration) error {
+	if state := h.getState(); state != ServiceRunning {
+		return fmt.Errorf("service %s is %s", h.Name(), state)
+	}
+	rc, ok := h.Service.(ReadinessChecker)
+	if !ok {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := rc.Readiness()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s not ready: %w", h.Name(), err)
+		}
+		time.Sleep(readyPollInterval)
+	}
+}
+
+// unionTypes returns the event types in either of the lists, each once.
+func unionTypes(a, b []EventType) []EventType {
+	seen := make(map[EventType]bool, len(a)+len(b))
+	var out []EventType
+	for _, typ := range append(append([]EventType(nil), a...), b...) {
+		if !seen[typ] {
+			seen[typ] = true
+			out = append(out, typ)
+		}
+	}
+	return out
+}
+
+func sameTypes(a, b []EventType) bool {
+	set := make(map[EventType]bool, len(a))
+	for _, typ := range a {
+		set[typ] = true
+	}
+	for _, typ := range b {
+		if !set[typ] {
+			return false
+		}
+		delete(set, typ)
+	}
+	return len(set) == 0
+}
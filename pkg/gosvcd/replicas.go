@@ -0,0 +1,168 @@
+package gosvcd
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// BalancePolicy selects the replica to deliver an event to.
+type BalancePolicy int
+
+const (
+	// RoundRobin delivers the events to the replicas in turn.
+	RoundRobin BalancePolicy = iota
+
+	// LeastLoaded delivers an event to the replica with the fewest
+	// queued and in-progress events.
+	LeastLoaded
+)
+
+// ReplicaOptions configures a set of replicas registered with
+// RegisterReplicas.
+type ReplicaOptions struct {
+	Balance BalancePolicy
+
+	// PartitionKeys are the functions extracting the partition keys from
+	// the events of each type. The events with the same key are delivered
+	// to the same replica, which handles them in order. The events without
+	// a key are balanced with Balance.
+	PartitionKeys map[EventType]func(Event) string
+}
+
+// RegisterReplicas registers 'n' replicas of a service created with
+// 'newReplica'. The replicas are registered as one service, with the
+// identifier, name, dependencies and subscriptions of the first replica,
+// and each event is delivered to exactly one of them. The replicas handle
+// their events in parallel, one at a time each, so the events are not
+// handled in the order they were emitted, nor before the events are
+// delivered to the dependent services.
+//
+// The events are delivered to the replicas as to a PartitionedHandler, so
+// the acknowledgements, retries, circuit breaker, handler timeout and
+// tracing of the service apply to them. The replicas are used as a
+// RetryHandler or a Transactional service if the first one is.
+func (b *ExampleServiceDaemonBuilder) RegisterReplicas(n int, newReplica func(replica int) Service, opts ReplicaOptions) {
+	if n < 1 {
+		n = 1
+	}
+	rs := &replicaSet{opts: opts}
+	for i := 0; i < n; i++ {
+		rs.replicas = append(rs.replicas, &replica{Service: newReplica(i)})
+	}
+	if _, ok := rs.replicas[0].Service.(RetryHandler); ok {
+		b.Register(&retryReplicaSet{rs})
+		return
+	}
+	b.Register(rs)
+}
+
+// replica is a replica of a service.
+type replica struct {
+	// load is the number of in-progress events, including those waiting
+	// for the replica.
+	load int64
+
+	// mu serializes the handling of the events by the replica.
+	mu sync.Mutex
+
+	Service
+}
+
+// replicaSet is the service registered for a set of replicas. It is
+// invoked concurrently by the daemon, up to once per replica, and passes
+// each event to a replica.
+type replicaSet struct {
+	// next is the index of the next replica for RoundRobin.
+	next uint64
+
+	opts     ReplicaOptions
+	replicas []*replica
+}
+
+func (rs *replicaSet) ID() ServiceId              { return rs.replicas[0].ID() }
+func (rs *replicaSet) Name() string               { return rs.replicas[0].Name() }
+func (rs *replicaSet) Dependencies() []ServiceId  { return rs.replicas[0].Dependencies() }
+func (rs *replicaSet) Subscriptions() []EventType { return rs.replicas[0].Subscriptions() }
+func (rs *replicaSet) Emits() []EventType         { return emittedTypes(rs.replicas[0].Service) }
+func (rs *replicaSet) MaxConcurrency() int        { return len(rs.replicas) }
+
+func (rs *replicaSet) Transactional() bool {
+	t, ok := rs.replicas[0].Service.(Transactional)
+	return ok && t.Transactional()
+}
+
+// PartitionKey returns the partition key of the event, so that the events
+// with the same key are handled in order.
+func (rs *replicaSet) PartitionKey(event Event) string {
+	if f, ok := rs.opts.PartitionKeys[event.EventType()]; ok {
+		return f(event)
+	}
+	return ""
+}
+
+func (rs *replicaSet) Init(handle ServiceHandle) {
+	for _, r := range rs.replicas {
+		r.Init(handle)
+	}
+}
+
+// replicaFor selects the replica for the event.
+func (rs *replicaSet) replicaFor(event Event) *replica {
+	if key := rs.PartitionKey(event); key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return rs.replicas[h.Sum32()%uint32(len(rs.replicas))]
+	}
+	switch rs.opts.Balance {
+	case LeastLoaded:
+		best := rs.replicas[0]
+		for _, r := range rs.replicas[1:] {
+			if atomic.LoadInt64(&r.load) < atomic.LoadInt64(&best.load) {
+				best = r
+			}
+		}
+		return best
+	default:
+		n := atomic.AddUint64(&rs.next, 1) - 1
+		return rs.replicas[n%uint64(len(rs.replicas))]
+	}
+}
+
+// handle passes the event to 'f' with the replica selected for it, once
+// the replica has handled its previous event.
+func (rs *replicaSet) handle(event Event, f func(r *replica) error) error {
+	r := rs.replicaFor(event)
+	atomic.AddInt64(&r.load, 1)
+	defer atomic.AddInt64(&r.load, -1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return f(r)
+}
+
+func (rs *replicaSet) HandleEvent(event Event) {
+	rs.handle(event, func(r *replica) error {
+		r.HandleEvent(event)
+		return nil
+	})
+}
+
+// Shutdown shuts down the replicas. The daemon has waited for their
+// in-progress events.
+func (rs *replicaSet) Shutdown() {
+	for _, r := range rs.replicas {
+		r.Shutdown()
+	}
+}
+
+// retryReplicaSet is the service registered for a set of replicas
+// implementing RetryHandler.
+type retryReplicaSet struct {
+	*replicaSet
+}
+
+func (rs *retryReplicaSet) TryHandleEvent(event Event) error {
+	return rs.handle(event, func(r *replica) error {
+		return r.Service.(RetryHandler).TryHandleEvent(event)
+	})
+}
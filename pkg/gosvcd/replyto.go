@@ -0,0 +1,130 @@
+package gosvcd
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoReplyTo is returned by Reply for an event without a reply address.
+var ErrNoReplyTo = errors.New("event has no reply address")
+
+// ReplyAddress is where the replies to an event are sent, for building
+// request/response exchanges on plain events.
+type ReplyAddress struct {
+	// Service is the service the replies are directed to.
+	Service ServiceId
+
+	// EventType is the type of the replies.
+	EventType EventType
+
+	// Correlation is carried by the replies, see Correlation, for the
+	// requester to match them to its request.
+	Correlation string
+}
+
+// Replier is implemented by the handles that reply to events, for the
+// services to assert their handle to.
+type Replier interface {
+	Reply(ev Event, data interface{}) error
+}
+
+type replyToCtx struct{}
+
+type correlationCtx struct{}
+
+// WithReplyTo returns a context for emitting an event with a reply
+// address. The handlers of the event reply to it with Reply. The address
+// is not inherited by the events emitted with the context of the event.
+func WithReplyTo(ctx context.Context, addr ReplyAddress) context.Context {
+	return context.WithValue(ctx, replyToCtx{}, &addr)
+}
+
+// WithCorrelation returns a context for emitting an event with the
+// correlation of a reply. Used by Reply, and by the bridges to carry the
+// correlation across processes. The correlation is not inherited by the
+// events emitted with the context of the event.
+func WithCorrelation(ctx context.Context, correlation string) context.Context {
+	return context.WithValue(ctx, correlationCtx{}, correlation)
+}
+
+// ReplyTo returns the reply address of the event, false if it has none.
+func ReplyTo(ev Event) (ReplyAddress, bool) {
+	if e := eventOf(ev); e != nil {
+		return e.ReplyTo()
+	}
+	if r, ok := ev.(interface{ ReplyTo() (ReplyAddress, bool) }); ok {
+		return r.ReplyTo()
+	}
+	return ReplyAddress{}, false
+}
+
+// Correlation returns the correlation of a reply, or the empty string if
+// the event is not a reply.
+func Correlation(ev Event) string {
+	if e := eventOf(ev); e != nil {
+		return e.Correlation()
+	}
+	if c, ok := ev.(interface{ Correlation() string }); ok {
+		return c.Correlation()
+	}
+	return ""
+}
+
+func (ev *ExampleEvent) ReplyTo() (ReplyAddress, bool) {
+	if ev.replyTo == nil {
+		return ReplyAddress{}, false
+	}
+	return *ev.replyTo, true
+}
+
+func (ev *ExampleEvent) Correlation() string {
+	return ev.correlation
+}
+
+// replyToOf returns the reply address in the context and the context
+// without it.
+func replyToOf(ctx context.Context) (*ReplyAddress, context.Context) {
+	if ctx == nil {
+		return nil, ctx
+	}
+	addr, _ := ctx.Value(replyToCtx{}).(*ReplyAddress)
+	if addr == nil {
+		return nil, ctx
+	}
+	return addr, context.WithValue(ctx, replyToCtx{}, (*ReplyAddress)(nil))
+}
+
+// correlationOf returns the correlation in the context and the context
+// without it.
+func correlationOf(ctx context.Context) (string, context.Context) {
+	if ctx == nil {
+		return "", ctx
+	}
+	correlation, _ := ctx.Value(correlationCtx{}).(string)
+	if correlation == "" {
+		return "", ctx
+	}
+	return correlation, context.WithValue(ctx, correlationCtx{}, "")
+}
+
+// Reply emits the reply to the event, of the type in its reply address
+// and carrying its correlation, as part of the operation of the event.
+// The reply is directed to the service of the address if it is registered
+// with the daemon, and emitted to all the subscribers of its type
+// otherwise, e.g. to a bridge forwarding it to the process of the
+// requester. Returns ErrNoReplyTo if the event has no reply address.
+func (h *ExampleServiceHandle) Reply(ev Event, data interface{}) error {
+	addr, ok := ReplyTo(ev)
+	if !ok {
+		return ErrNoReplyTo
+	}
+	ctx := ev.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = WithCorrelation(ctx, addr.Correlation)
+	if _, ok := h.d.handle(addr.Service); ok {
+		return h.EmitToContext(ctx, addr.Service, addr.EventType, data)
+	}
+	return h.EmitEventContext(ctx, addr.EventType, data)
+}
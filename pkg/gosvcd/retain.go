@@ -0,0 +1,277 @@
+package gosvcd
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// SetRetention makes the events of the type retained: the daemon keeps the
+// last 'depth' events of the type, and delivers them to its subscribers
+// when they are initialized after the events were emitted, e.g. when a
+// service is restarted, activated lazily or replaced, before the events
+// dispatched after. A depth of one keeps the latest value, as with the
+// retained messages of MQTT. A depth of zero or less removes the
+// retention.
+//
+// The retained events are not recycled by event pooling. Their memory
+// usage is reported in MetricsSnapshot.Retention for tuning the depths.
+func (b *ExampleServiceDaemonBuilder) SetRetention(typ EventType, depth int) {
+	if depth <= 0 {
+		delete(b.retention, typ)
+		return
+	}
+	if b.retention == nil {
+		b.retention = make(map[EventType]int)
+	}
+	b.retention[typ] = depth
+}
+
+// RetentionStats describes the retained events of a type.
+type RetentionStats struct {
+	// Depth is the configured number of retained events.
+	Depth int
+
+	// Events is the number of events retained.
+	Events int
+
+	// Bytes is the estimated memory used by the retained events and
+	// their payloads.
+	Bytes int64
+}
+
+// retention keeps the retained events.
+type retention struct {
+	mu sync.Mutex
+
+	// types are the retained types, fixed at Start.
+	types map[EventType]*retained
+}
+
+type retained struct {
+	depth int
+
+	// evs are the retained events, oldest first, and sizes their
+	// estimated sizes.
+	evs   []*ExampleEvent
+	sizes []int64
+	bytes int64
+}
+
+func newRetention(depths map[EventType]int) *retention {
+	if len(depths) == 0 {
+		return nil
+	}
+	r := &retention{types: make(map[EventType]*retained, len(depths))}
+	for typ, depth := range depths {
+		r.types[typ] = &retained{depth: depth}
+	}
+	return r
+}
+
+func (r *retention) retains(typ EventType) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.types[typ]
+	return ok
+}
+
+// store retains the routed event, evicting the oldest one of its type if
+// the depth is exceeded.
+func (r *retention) store(ev *ExampleEvent) {
+	if !r.retains(ev.eventType) || ev.directed {
+		return
+	}
+	ev.pin()
+	size := eventSize(ev)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rt := r.types[ev.eventType]
+	if len(rt.evs) == rt.depth {
+		rt.bytes -= rt.sizes[0]
+		copy(rt.evs, rt.evs[1:])
+		copy(rt.sizes, rt.sizes[1:])
+		rt.evs[len(rt.evs)-1] = nil
+		rt.evs, rt.sizes = rt.evs[:len(rt.evs)-1], rt.sizes[:len(rt.sizes)-1]
+	}
+	rt.evs = append(rt.evs, ev)
+	rt.sizes = append(rt.sizes, size)
+	rt.bytes += size
+}
+
+// snapshot returns the retained events of the types in the order they
+// were routed, and the routing sequence number of the last event routed
+// before the snapshot among them.
+func (r *retention) snapshot(types []EventType) ([]*ExampleEvent, uint64) {
+	if r == nil {
+		return nil, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var (
+		evs  []*ExampleEvent
+		last uint64
+	)
+	for _, typ := range types {
+		if rt, ok := r.types[typ]; ok {
+			evs = append(evs, rt.evs...)
+		}
+	}
+	sort.SliceStable(evs, func(i, j int) bool { return evs[i].routed < evs[j].routed })
+	for _, ev := range evs {
+		if ev.routed > last {
+			last = ev.routed
+		}
+	}
+	return evs, last
+}
+
+func (r *retention) stats() map[EventType]RetentionStats {
+	stats := make(map[EventType]RetentionStats)
+	if r == nil {
+		return stats
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for typ, rt := range r.types {
+		stats[typ] = RetentionStats{Depth: rt.depth, Events: len(rt.evs), Bytes: rt.bytes}
+	}
+	return stats
+}
+
+// Retained returns the retained events of the type, oldest first. See
+// SetRetention.
+func (d *ExampleServiceDaemon) Retained(typ EventType) []Event {
+	evs, _ := d.retention.snapshot([]EventType{typ})
+	out := make([]Event, len(evs))
+	for i, ev := range evs {
+		out[i] = ev
+	}
+	return out
+}
+
+// Retained returns the retained events of the type, oldest first. See
+// SetRetention.
+func (h *ExampleServiceHandle) Retained(typ EventType) []Event {
+	return h.d.Retained(typ)
+}
+
+// replayRetained delivers the retained events of the subscriptions to the
+// initialized service before the events dispatched after, by holding them
+// for the service. The events that were retained are skipped when they
+// are dispatched to the service. Must be called with 'mu' held.
+func (d *ExampleServiceDaemon) replayRetained(h *ExampleServiceHandle) {
+	if d.retention == nil {
+		return
+	}
+	var types []EventType
+	for _, typ := range h.Subscriptions() {
+		if d.retention.retains(typ) && d.permitted(h.ID(), typ) {
+			types = append(types, typ)
+		}
+	}
+	evs, last := d.retention.snapshot(types)
+	if len(evs) == 0 {
+		return
+	}
+	q := &h.quarantine
+	h.bookMu.Lock()
+	if atomic.LoadInt32(&q.paused) != 0 && q.mode == PauseDrop {
+		h.bookMu.Unlock()
+		return
+	}
+	atomic.StoreUint64(&h.replayedUpTo, last)
+	for _, ev := range evs {
+		atomic.AddInt64(&d.pending, 1)
+		ev.retain()
+		d.cancels.retain(ev.cancel)
+	}
+	q.held = append(evs, q.held...)
+	resume := atomic.LoadInt32(&q.paused) == 0
+	if resume {
+		q.mode = PauseHold
+		q.handoff = true
+		atomic.StoreInt32(&q.paused, 1)
+	}
+	h.bookMu.Unlock()
+	d.log.Debug("Replaying retained events", "service", h.Name(), "events", len(evs))
+	if resume {
+		d.resumeService(h)
+	}
+}
+
+// replayed returns true if the event was delivered to the service by
+// replayRetained.
+func (d *ExampleServiceDaemon) replayed(h *ExampleServiceHandle, ev *ExampleEvent) bool {
+	return ev.routed <= atomic.LoadUint64(&h.replayedUpTo) && d.retention.retains(ev.eventType)
+}
+
+// eventSize estimates the memory used by the event and its payload.
+func eventSize(ev *ExampleEvent) int64 {
+	seen := make(map[uintptr]bool)
+	return int64(unsafe.Sizeof(*ev)) + valueSize(reflect.ValueOf(ev.data), seen)
+}
+
+// valueSize estimates the memory referenced by the value, in addition to
+// the value itself, counting the memory behind each pointer once.
+func valueSize(v reflect.Value, seen map[uintptr]bool) int64 {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return 0
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		return int64(v.Type().Elem().Size()) + valueSize(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		if e.Kind() == reflect.Ptr {
+			return valueSize(e, seen)
+		}
+		return int64(e.Type().Size()) + valueSize(e, seen)
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		n := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		for i := 0; i < v.Len(); i++ {
+			n += valueSize(v.Index(i), seen)
+		}
+		return n
+	case reflect.Array:
+		var n int64
+		for i := 0; i < v.Len(); i++ {
+			n += valueSize(v.Index(i), seen)
+		}
+		return n
+	case reflect.Struct:
+		var n int64
+		for i := 0; i < v.NumField(); i++ {
+			n += valueSize(v.Field(i), seen)
+		}
+		return n
+	case reflect.Map:
+		if v.IsNil() || seen[v.Pointer()] {
+			return 0
+		}
+		seen[v.Pointer()] = true
+		kt, vt := v.Type().Key(), v.Type().Elem()
+		n := int64(v.Len()) * int64(kt.Size()+vt.Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			n += valueSize(iter.Key(), seen) + valueSize(iter.Value(), seen)
+		}
+		return n
+	}
+	return 0
+}
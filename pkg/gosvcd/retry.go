@@ -0,0 +1,146 @@
+package gosvcd
+
+import (
+	"time"
+)
+
+// RetryHandler is implemented by services whose handlers can fail without
+// failing the service. If the service implements RetryHandler, it is used
+// instead of HandleEvent, and the delivery of an event for which it returns
+// an error is retried with a backoff as specified by the daemon's
+// RetryPolicy. Once the attempts are exhausted, the event is dead-lettered.
+type RetryHandler interface {
+	TryHandleEvent(event Event) error
+}
+
+// RetryPolicy specifies how the failed deliveries to a RetryHandler are
+// retried. The backoff starts from MinBackoff and is multiplied by
+// Multiplier after each attempt, up to MaxBackoff.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts before dead-lettering the
+	// event, including the first delivery.
+	MaxAttempts int
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+}
+
+// DefaultRetryPolicy is used when the builder is not configured with
+// SetRetryPolicy. The zero fields of a configured policy are taken from it.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	MinBackoff:  100 * time.Millisecond,
+	MaxBackoff:  10 * time.Second,
+	Multiplier:  2,
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = DefaultRetryPolicy.MinBackoff
+	}
+	if p.MaxBackoff < p.MinBackoff {
+		p.MaxBackoff = p.MinBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	return p
+}
+
+// Backoff returns the delay before the attempt following the given one.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.MinBackoff)
+	for i := 1; i < attempt && backoff < float64(p.MaxBackoff); i++ {
+		backoff *= p.Multiplier
+	}
+	if backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+//
+// Dead letters
+//
+
+var DeadLetter_Type = EventType("DeadLetter")
+
+// DeadLetter is emitted when the delivery of an event to a RetryHandler
+// has failed MaxAttempts times, or when the daemon shuts down while the
+// delivery is being retried. With SetDeadLetterExpired, it is also
+// emitted for the events that expired before their delivery, with zero
+// Attempts.
+type DeadLetter struct {
+	Service   ServiceId
+	EventType EventType
+	Source    ServiceId
+	Timestamp time.Time
+	Data      interface{}
+	Attempts  int
+	Err       string
+}
+
+// retryDelivery retries the failed delivery of the event to the service
+// until it succeeds or the attempts are exhausted. The dispatch queue is
+// blocked meanwhile to keep the events in order.
+func (d *ExampleServiceDaemon) retryDelivery(h *ExampleServiceHandle, ev *ExampleEvent, event Event, rec *ackRecord, err error) {
+	for attempt := 1; ; attempt++ {
+		if attempt >= d.retryPolicy.MaxAttempts {
+			d.deadLetter(h, ev, attempt, err)
+			break
+		}
+		backoff := d.retryPolicy.Backoff(attempt)
+		d.log.Warn("Event handler failed, retrying",
+			"service", h.Name(),
+			"event_type", ev.eventType,
+			"attempt", attempt,
+			"backoff", backoff,
+			"error", err)
+		select {
+		case <-time.After(backoff):
+		case <-d.shuttingDown:
+			d.deadLetter(h, ev, attempt, err)
+			return
+		}
+		if err = d.invoke(h, ev, event, rec); err == nil {
+			return
+		}
+	}
+	if rec != nil {
+		d.acks.ack(rec)
+	}
+}
+
+func (d *ExampleServiceDaemon) deadLetter(h *ExampleServiceHandle, ev *ExampleEvent, attempts int, err error) {
+	d.log.Error("Dead-lettering event",
+		"service", h.Name(),
+		"event_type", ev.eventType,
+		"attempts", attempts,
+		"error", err)
+	d.metrics.eventDropped(DropDeadLettered, ev.eventType)
+	ev.delivery.recordFailed()
+	d.emitDeadLetter(h, ev, attempts, err)
+}
+
+// emitDeadLetter emits the DeadLetter of the event not delivered to the
+// service.
+func (d *ExampleServiceDaemon) emitDeadLetter(h *ExampleServiceHandle, ev *ExampleEvent, attempts int, err error) {
+	ev.pin()
+	if ev.eventType == DeadLetter_Type {
+		// Avoid a dead letter loop.
+		return
+	}
+	d.emitAsync(DeadLetter_Type, &DeadLetter{
+		Service:   h.ID(),
+		EventType: ev.eventType,
+		Source:    ev.source,
+		Timestamp: ev.timestamp,
+		Data:      ev.data,
+		Attempts:  attempts,
+		Err:       err.Error(),
+	})
+}
@@ -0,0 +1,252 @@
+package gosvcd
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// routeBatch is the maximum number of events the router drains from the
+// ring at a time.
+const routeBatch = 64
+
+// eventRing is a bounded lock-free multi-producer single-consumer queue
+// of events, carrying the emitted events to the router. The producers
+// claim slots by advancing 'tail' and publish them by setting their
+// sequence numbers, and the consumer drains the published slots in
+// batches. The mutex is only taken to block when the ring is full or
+// empty, and to spill.
+//
+// The events emitted by the handlers are spilled instead of blocking on a
+// full ring: the router may be blocked on the dispatch queue the handler
+// is invoked from, and would never drain the ring. The spilled events are
+// drained in order with the ones in the ring, each after the events pushed
+// before it was spilled.
+type eventRing struct {
+	// tail is the position of the next slot to claim by a producer.
+	tail uint64
+
+	// head is the position of the next slot to consume. Only accessed by
+	// the consumer, except for the length.
+	head uint64
+
+	// producersWaiting is the number of producers blocked on a full ring,
+	// and consumerWaiting is one when the consumer is blocked on an empty
+	// one.
+	producersWaiting int32
+	consumerWaiting  int32
+
+	closed int32
+
+	// nspilled is the number of spilled events.
+	nspilled int32
+
+	mask  uint64
+	slots []ringSlot
+
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	notEmpty *sync.Cond
+
+	// spilled are the spilled events in order. Protected by 'mu'.
+	spilled []spilledEvent
+}
+
+// spilledEvent is an event spilled when the tail of the ring was at 'pos'.
+// It is drained after the event at 'pos-1'.
+type spilledEvent struct {
+	pos uint64
+	ev  *ExampleEvent
+}
+
+type ringSlot struct {
+	// seq is the position the slot is next written at when it equals the
+	// position, and has been written at the position when it is one past
+	// it.
+	seq uint64
+	ev  *ExampleEvent
+}
+
+// newEventRing returns a ring with a capacity of 'size' rounded up to a
+// power of two.
+func newEventRing(size int) *eventRing {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	r := &eventRing{
+		mask:  uint64(n - 1),
+		slots: make([]ringSlot, n),
+	}
+	for i := range r.slots {
+		r.slots[i].seq = uint64(i)
+	}
+	r.notFull = sync.NewCond(&r.mu)
+	r.notEmpty = sync.NewCond(&r.mu)
+	return r
+}
+
+// len returns the number of events in the ring, including the spilled
+// ones.
+func (r *eventRing) len() int {
+	n := int64(atomic.LoadUint64(&r.tail)) - int64(atomic.LoadUint64(&r.head))
+	if n < 0 {
+		n = 0
+	}
+	return int(n) + int(atomic.LoadInt32(&r.nspilled))
+}
+
+// push adds the event to the ring, blocking while it is full. Must not be
+// called after close.
+func (r *eventRing) push(ev *ExampleEvent) {
+	r.offer(ev, true)
+}
+
+// pushOrSpill adds the event to the ring, or spills it if the ring is full
+// or events have been spilled before, so that the events spilled by the
+// same producer stay in order. Must not be called after close.
+func (r *eventRing) pushOrSpill(ev *ExampleEvent) {
+	if atomic.LoadInt32(&r.nspilled) == 0 && r.offer(ev, false) {
+		return
+	}
+	r.mu.Lock()
+	r.spilled = append(r.spilled, spilledEvent{atomic.LoadUint64(&r.tail), ev})
+	atomic.AddInt32(&r.nspilled, 1)
+	if atomic.LoadInt32(&r.consumerWaiting) != 0 {
+		r.notEmpty.Signal()
+	}
+	r.mu.Unlock()
+}
+
+// offer adds the event to the ring. If the ring is full, blocks until it is
+// not if 'wait' is set, and returns false otherwise.
+func (r *eventRing) offer(ev *ExampleEvent, wait bool) bool {
+	for spins := 0; ; spins++ {
+		pos := atomic.LoadUint64(&r.tail)
+		slot := &r.slots[pos&r.mask]
+		diff := int64(atomic.LoadUint64(&slot.seq)) - int64(pos)
+		switch {
+		case diff == 0:
+			if !atomic.CompareAndSwapUint64(&r.tail, pos, pos+1) {
+				continue
+			}
+			slot.ev = ev
+			atomic.StoreUint64(&slot.seq, pos+1)
+			if atomic.LoadInt32(&r.consumerWaiting) != 0 {
+				r.mu.Lock()
+				r.notEmpty.Signal()
+				r.mu.Unlock()
+			}
+			return true
+		case diff < 0:
+			// Full
+			if spins < 16 {
+				runtime.Gosched()
+				continue
+			}
+			if !wait {
+				return false
+			}
+			r.waitNotFull(pos)
+			spins = 0
+		}
+	}
+}
+
+func (r *eventRing) waitNotFull(pos uint64) {
+	r.mu.Lock()
+	atomic.AddInt32(&r.producersWaiting, 1)
+	// Check again after announcing the wait, as the consumer may have
+	// freed the slot before seeing it.
+	if atomic.LoadUint64(&r.slots[pos&r.mask].seq) < pos {
+		r.notFull.Wait()
+	}
+	atomic.AddInt32(&r.producersWaiting, -1)
+	r.mu.Unlock()
+}
+
+// drain appends the published events to 'buf', up to its capacity,
+// blocking while the ring is empty. Returns an empty slice when the ring
+// has been closed and drained.
+func (r *eventRing) drain(buf []*ExampleEvent) []*ExampleEvent {
+	for {
+		for len(buf) < cap(buf) {
+			if atomic.LoadInt32(&r.nspilled) != 0 {
+				if ev := r.unspill(); ev != nil {
+					buf = append(buf, ev)
+					continue
+				}
+			}
+			slot := &r.slots[r.head&r.mask]
+			if atomic.LoadUint64(&slot.seq) != r.head+1 {
+				break
+			}
+			buf = append(buf, slot.ev)
+			slot.ev = nil
+			atomic.StoreUint64(&slot.seq, r.head+r.mask+1)
+			atomic.StoreUint64(&r.head, r.head+1)
+		}
+		if len(buf) > 0 {
+			if atomic.LoadInt32(&r.producersWaiting) != 0 {
+				r.mu.Lock()
+				r.notFull.Broadcast()
+				r.mu.Unlock()
+			}
+			return buf
+		}
+		if !r.waitNotEmpty() {
+			return buf
+		}
+	}
+}
+
+// unspill returns the next spilled event if it is due, i.e. the events
+// pushed before it have been drained, or nil.
+func (r *eventRing) unspill() *ExampleEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.spillDue() {
+		return nil
+	}
+	ev := r.spilled[0].ev
+	r.spilled[0] = spilledEvent{}
+	r.spilled = r.spilled[1:]
+	if len(r.spilled) == 0 {
+		r.spilled = nil
+	}
+	atomic.AddInt32(&r.nspilled, -1)
+	return ev
+}
+
+// spillDue returns true if the next spilled event is due. Must be called
+// with 'mu' held.
+func (r *eventRing) spillDue() bool {
+	return len(r.spilled) > 0 && r.spilled[0].pos <= r.head
+}
+
+// waitNotEmpty blocks until the next slot has been published or a spilled
+// event is due. Returns false if the ring is closed and empty.
+func (r *eventRing) waitNotEmpty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	atomic.StoreInt32(&r.consumerWaiting, 1)
+	defer atomic.StoreInt32(&r.consumerWaiting, 0)
+	for {
+		if atomic.LoadUint64(&r.slots[r.head&r.mask].seq) == r.head+1 || r.spillDue() {
+			return true
+		}
+		if atomic.LoadInt32(&r.closed) != 0 && atomic.LoadUint64(&r.tail) == r.head && len(r.spilled) == 0 {
+			return false
+		}
+		r.notEmpty.Wait()
+	}
+}
+
+// close closes the ring once the producers are done. The consumer drains
+// the remaining events.
+func (r *eventRing) close() {
+	r.mu.Lock()
+	atomic.StoreInt32(&r.closed, 1)
+	r.notEmpty.Signal()
+	r.mu.Unlock()
+}
@@ -0,0 +1,173 @@
+package gosvcd
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DispatchMode selects how the dispatch queues are run.
+type DispatchMode int
+
+const (
+	// DispatchPerType runs each dispatch queue in its own goroutine.
+	DispatchPerType DispatchMode = iota
+
+	// DispatchWorkStealing runs the dispatch queues on a fixed pool of
+	// workers. A queue with events is scheduled on the worker selected by
+	// the hash of its event type, and idle workers steal the queues
+	// scheduled on busy workers. A queue is run by one worker at a time,
+	// so the events of a type are still delivered in order.
+	DispatchWorkStealing
+
+	// DispatchSharded runs the dispatch queues on a fixed pool of shards.
+	// Each queue is always run by the shard selected by the hash of its
+	// event type, bounding the number of goroutines of daemons with many
+	// event types.
+	DispatchSharded
+)
+
+// dispatchBatch is the number of events a worker dispatches from a queue
+// before rescheduling it, to be fair to the other queues.
+const dispatchBatch = 32
+
+// scheduler runs the dispatch queues on a pool of workers.
+type scheduler struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	// local are the queues scheduled on each worker, by the priority of
+	// their QoS class.
+	local [numQoSClasses][][]*dispatcher
+
+	workers int
+
+	// stopping is set when no more events are routed to the queues.
+	stopping bool
+
+	// steal is true if idle workers take the queues of other workers.
+	steal bool
+
+	wg sync.WaitGroup
+}
+
+func newScheduler(workers int, steal bool) *scheduler {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	s := &scheduler{workers: workers, steal: steal}
+	for p := range s.local {
+		s.local[p] = make([][]*dispatcher, workers)
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// home returns the worker the queue is scheduled on.
+func (s *scheduler) home(q *dispatcher) int {
+	h := fnv.New32a()
+	h.Write([]byte(q.typ))
+	return int(h.Sum32() % uint32(s.workers))
+}
+
+// schedule queues the dispatcher to its home worker if it is not already
+// scheduled. Called after an event has been queued to it.
+func (s *scheduler) schedule(q *dispatcher) {
+	if !atomic.CompareAndSwapInt32(&q.scheduled, 0, 1) {
+		return
+	}
+	s.mu.Lock()
+	w, p := s.home(q), q.class.priority()
+	s.local[p][w] = append(s.local[p][w], q)
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// next returns the next queue for the worker, by priority: the oldest one
+// scheduled on it, or if stealing, the newest one scheduled on another
+// worker. Blocks until there is one, and returns nil when the scheduler
+// has been stopped and all the queues have been run.
+func (s *scheduler) next(w int) *dispatcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for p := range s.local {
+			local := s.local[p]
+			if qs := local[w]; len(qs) > 0 {
+				q := qs[0]
+				local[w] = qs[1:]
+				return q
+			}
+			for i := 1; s.steal && i < s.workers; i++ {
+				v := (w + i) % s.workers
+				if qs := local[v]; len(qs) > 0 {
+					q := qs[len(qs)-1]
+					local[v] = qs[:len(qs)-1]
+					return q
+				}
+			}
+		}
+		if s.stopping {
+			return nil
+		}
+		s.cond.Wait()
+	}
+}
+
+// start starts the workers.
+func (s *scheduler) start(d *ExampleServiceDaemon) {
+	for w := 0; w < s.workers; w++ {
+		w := w
+		s.wg.Add(1)
+		d.spawn(func() {
+			defer s.wg.Done()
+			defer d.lockDispatch("dispatch worker " + strconv.Itoa(w))()
+			labels := pprof.Labels("gosvcd", "dispatch", "worker", strconv.Itoa(w))
+			pprof.Do(context.Background(), labels, func(ctx context.Context) {
+				for q := s.next(w); q != nil; q = s.next(w) {
+					s.run(d, q)
+					pprof.SetGoroutineLabels(ctx)
+				}
+			})
+		})
+	}
+}
+
+// run dispatches a batch of events from the queue and reschedules it if
+// it has more.
+func (s *scheduler) run(d *ExampleServiceDaemon, q *dispatcher) {
+	for i := 0; i < dispatchBatch; i++ {
+		var (
+			ev *ExampleEvent
+			ok bool
+		)
+		select {
+		case ev, ok = <-q.ch:
+		default:
+		}
+		if !ok {
+			break
+		}
+		q.dispatch(d, ev)
+	}
+	// Clear the flag before checking for more events: an event queued
+	// after the check finds the flag cleared and schedules the queue.
+	atomic.StoreInt32(&q.scheduled, 0)
+	if len(q.ch) > 0 {
+		s.schedule(q)
+	}
+}
+
+// stop stops the workers once the queued events have been dispatched.
+// Must be called after the last event has been routed.
+func (s *scheduler) stop() {
+	s.mu.Lock()
+	s.stopping = true
+	s.mu.Unlock()
+	s.cond.Broadcast()
+	s.wg.Wait()
+}
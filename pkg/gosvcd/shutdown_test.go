@@ -0,0 +1,150 @@
+package gosvcd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testService counts the events it handles and optionally emits an event
+// of type 'reemit' for each one.
+type testService struct {
+	id      ServiceId
+	subs    []EventType
+	reemit  EventType
+	handled int64
+
+	h ServiceHandle
+}
+
+func (s *testService) ID() ServiceId              { return s.id }
+func (s *testService) Name() string               { return fmt.Sprint("test", s.id) }
+func (s *testService) Dependencies() []ServiceId  { return nil }
+func (s *testService) Subscriptions() []EventType { return s.subs }
+func (s *testService) Init(h ServiceHandle)       { s.h = h }
+func (s *testService) Shutdown()                  {}
+
+func (s *testService) HandleEvent(ev Event) {
+	atomic.AddInt64(&s.handled, 1)
+	if s.reemit != "" {
+		err := s.h.EmitEvent(s.reemit, ev.Data())
+		if err != nil && !errors.Is(err, ErrDaemonStopped) {
+			panic(err)
+		}
+	}
+}
+
+func waitShutdown(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+}
+
+func TestShutdownTwice(t *testing.T) {
+	b := NewBuilder()
+	b.Register(&testService{id: 1, subs: []EventType{"a"}})
+	d := b.Start()
+	if err := d.EmitEvent("a", 1); err != nil {
+		t.Fatalf("EmitEvent: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.Shutdown()
+		d.Shutdown()
+		close(done)
+	}()
+	waitShutdown(t, done)
+
+	if err := d.EmitEvent("a", 2); !errors.Is(err, ErrDaemonStopped) {
+		t.Fatalf("EmitEvent after Shutdown: got %v, want ErrDaemonStopped", err)
+	}
+	d.Shutdown()
+}
+
+func TestShutdownConcurrent(t *testing.T) {
+	b := NewBuilder()
+	b.Register(&testService{id: 1, subs: []EventType{"a"}})
+	d := b.Start()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Shutdown()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	waitShutdown(t, done)
+}
+
+func TestEmitDuringShutdown(t *testing.T) {
+	for _, pooling := range []bool{false, true} {
+		b := NewBuilder()
+		b.SetEventPooling(pooling)
+		sink := &testService{id: 1, subs: []EventType{"a", "b"}}
+		relay := &testService{id: 2, subs: []EventType{"a"}, reemit: "b"}
+		b.Register(sink)
+		b.Register(relay)
+		d := b.Start()
+		<-d.Ready()
+
+		// Emit from the daemon and from a service handle while the
+		// daemon is shut down. Every emit must either succeed or fail
+		// with ErrDaemonStopped, and every emitted event be handled.
+		var emitted int64
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		emitters := []func(EventType, interface{}) error{d.EmitEvent, relay.h.EmitEvent}
+		for i := 0; i < 4; i++ {
+			emit := emitters[i%len(emitters)]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				for n := 0; ; n++ {
+					err := emit("a", n)
+					if errors.Is(err, ErrDaemonStopped) {
+						return
+					}
+					if err != nil {
+						t.Errorf("EmitEvent: %v", err)
+						return
+					}
+					atomic.AddInt64(&emitted, 1)
+				}
+			}()
+		}
+		close(start)
+		time.Sleep(10 * time.Millisecond)
+
+		done := make(chan struct{})
+		go func() {
+			d.Shutdown()
+			close(done)
+		}()
+		waitShutdown(t, done)
+		wg.Wait()
+
+		// Each emitted "a" is handled by both services, and the relay
+		// emits a "b" for each one it handled unless already stopping.
+		handledA := atomic.LoadInt64(&relay.handled)
+		if handledA != atomic.LoadInt64(&emitted) {
+			t.Errorf("pooling=%v: relay handled %d events, %d emitted", pooling, handledA, emitted)
+		}
+		if sink.handled < handledA || sink.handled > 2*handledA {
+			t.Errorf("pooling=%v: sink handled %d events, want %d to %d", pooling, sink.handled, handledA, 2*handledA)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package gosvcd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals translates SIGINT and SIGTERM into a shutdown of the daemon
+// and SIGHUP into a ReloadRequested event.
+func (d *ExampleServiceDaemon) handleSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGHUP:
+				d.log.Info("Reload requested", "signal", sig)
+				d.emitAsync(ReloadRequested_Type, &ReloadRequested{sig.String()})
+			default:
+				d.log.Info("Shutdown requested", "signal", sig)
+				// Not a goroutine of the daemon as it waits for them
+				// to exit.
+				go d.Shutdown()
+				return
+			}
+		}
+	}
+}
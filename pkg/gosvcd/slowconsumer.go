@@ -0,0 +1,102 @@
+package gosvcd
+
+import "time"
+
+// SlowConsumerPolicy configures the detection of services that do not keep
+// up with the events they are subscribed to. A SlowConsumer event is emitted
+// when a slow consumer is detected.
+type SlowConsumerPolicy struct {
+	// Threshold is the handler latency above which an invocation is
+	// considered slow. Zero disables the latency check.
+	Threshold time.Duration
+
+	// Repeats is the number of consecutive slow invocations after which
+	// the service is reported. The count starts over after each report.
+	Repeats int
+
+	// QueueGrowth is the number of consecutive samples in which the depth
+	// of a dispatch queue has grown after which the service being invoked
+	// from it is reported. Zero disables the queue check.
+	QueueGrowth int
+
+	// SampleInterval is the interval for sampling the queue depths.
+	SampleInterval time.Duration
+}
+
+// checkLatency updates the count of slow invocations of the service. Must be
+// called with the handle's 'bookMu' held.
+func (d *ExampleServiceDaemon) checkLatency(h *ExampleServiceHandle, typ EventType, latency time.Duration) {
+	p := d.slowConsumers
+	if p.Threshold <= 0 {
+		return
+	}
+	if latency <= p.Threshold {
+		h.slowCount = 0
+		return
+	}
+	h.slowCount++
+	if h.slowCount >= p.Repeats {
+		h.slowCount = 0
+		d.log.Warn("Slow consumer", "service", h.Name(), "event_type", typ, "latency", latency)
+		d.emitAsync(SlowConsumer_Type, &SlowConsumer{
+			Service:    h.ID(),
+			EventType:  typ,
+			Reason:     SlowHandler,
+			Latency:    latency,
+			QueueDepth: d.queueDepth(typ),
+		})
+	}
+}
+
+// monitorQueues samples the depths of the dispatch queues and reports the
+// services that are being invoked while a queue keeps growing.
+func (d *ExampleServiceDaemon) monitorQueues() {
+	p := d.slowConsumers
+	if p.QueueGrowth <= 0 || p.SampleInterval <= 0 {
+		return
+	}
+
+	type sample struct {
+		depth  int
+		growth int
+	}
+	samples := make(map[EventType]*sample)
+
+	ticker := time.NewTicker(p.SampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, q := range d.allQueues() {
+			typ := q.typ
+			s, ok := samples[typ]
+			if !ok {
+				s = &sample{}
+				samples[typ] = s
+			}
+			depth := len(q.ch)
+			if depth > s.depth {
+				s.growth++
+			} else {
+				s.growth = 0
+			}
+			s.depth = depth
+			if s.growth < p.QueueGrowth {
+				continue
+			}
+			s.growth = 0
+			if id, ok := q.current(); ok {
+				d.log.Warn("Slow consumer", "service", d.serviceName(id), "event_type", typ, "queue_depth", depth)
+				d.emitAsync(SlowConsumer_Type, &SlowConsumer{
+					Service:    id,
+					EventType:  typ,
+					Reason:     QueueGrowth,
+					QueueDepth: depth,
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package gosvcd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshotter is implemented by services with state that should survive
+// restarts. When the builder is configured with SetSnapshots, the daemon
+// takes snapshots of the service periodically and when it is shut down,
+// and restores the latest snapshot before initializing the service.
+// Snapshot and Restore are not called concurrently with the handlers.
+type Snapshotter interface {
+	Snapshot() ([]byte, error)
+	Restore(data []byte) error
+}
+
+// SnapshotStore persists the snapshots of the services.
+type SnapshotStore interface {
+	// Save replaces the snapshot of the service.
+	Save(id ServiceId, data []byte) error
+
+	// Load returns the snapshot of the service, or nil if there is none.
+	Load(id ServiceId) ([]byte, error)
+}
+
+type fileSnapshotStore struct {
+	dir string
+}
+
+// NewFileSnapshotStore returns a snapshot store keeping the snapshots as
+// files in the directory, which is created if needed.
+func NewFileSnapshotStore(dir string) (SnapshotStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileSnapshotStore{dir: dir}, nil
+}
+
+func (s *fileSnapshotStore) path(id ServiceId) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%d.snap", id))
+}
+
+// Save writes the snapshot to a temporary file and renames it over the
+// previous one, so that a crash does not leave a partial snapshot.
+func (s *fileSnapshotStore) Save(id ServiceId, data []byte) error {
+	f, err := ioutil.TempFile(s.dir, ".snap")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(id))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *fileSnapshotStore) Load(id ServiceId) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// restoreSnapshot restores the latest snapshot of the service. Must be
+// called with 'mu' held.
+func (h *ExampleServiceHandle) restoreSnapshot() {
+	sn, ok := h.Service.(Snapshotter)
+	if !ok {
+		return
+	}
+	data, restored := h.d.restoredSnapshot(h.ID())
+	if !restored {
+		if h.d.snapshots == nil {
+			return
+		}
+		var err error
+		if data, err = h.d.snapshots.Load(h.ID()); err != nil {
+			h.d.log.Error("Failed to load snapshot", "service", h.Name(), "error", err)
+			return
+		}
+	}
+	if data == nil {
+		return
+	}
+	var err error
+	if perr := safeCall(func() { err = sn.Restore(data) }); perr != nil {
+		err = perr
+	}
+	if err != nil {
+		h.d.log.Error("Failed to restore snapshot", "service", h.Name(), "error", err)
+		return
+	}
+	h.d.log.Info("Restored snapshot", "service", h.Name(), "size", len(data))
+}
+
+// takeSnapshot takes and saves a snapshot of the service. Must be called
+// with 'mu' held.
+func (h *ExampleServiceHandle) takeSnapshot() {
+	_, ok := h.Service.(Snapshotter)
+	if !ok || h.d.snapshots == nil || h.getState() != ServiceRunning {
+		return
+	}
+	data, err := h.snapshot()
+	if err == nil {
+		err = h.d.snapshots.Save(h.ID(), data)
+	}
+	if err != nil {
+		h.d.log.Error("Failed to take snapshot", "service", h.Name(), "error", err)
+	}
+}
+
+// snapshot takes a snapshot of the service implementing Snapshotter. Must
+// be called with 'mu' held.
+func (h *ExampleServiceHandle) snapshot() (data []byte, err error) {
+	sn := h.Service.(Snapshotter)
+	if perr := safeCall(func() { data, err = sn.Snapshot() }); perr != nil {
+		err = perr
+	}
+	return data, err
+}
+
+// snapshotLoop takes snapshots of the services periodically.
+func (d *ExampleServiceDaemon) snapshotLoop() {
+	ticker := time.NewTicker(d.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, h := range d.orderedHandles() {
+			if _, ok := h.Service.(Snapshotter); !ok {
+				continue
+			}
+			h.mu.Lock()
+			h.takeSnapshot()
+			h.mu.Unlock()
+		}
+	}
+}
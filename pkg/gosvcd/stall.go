@@ -0,0 +1,131 @@
+package gosvcd
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The stall detector watches the event loops of the daemon, the router that
+// moves emitted events into the dispatch queues and the per-type dispatchers,
+// and reports a loop that has work pending but has not made progress within
+// the stall timeout. This catches e.g. a handler that never returns or a
+// router blocked on a full queue.
+
+// loopProgress tracks the progress of a single event loop.
+type loopProgress struct {
+	name     string
+	progress func() uint64
+	pending  func() bool
+	describe func() []interface{}
+
+	last    uint64
+	since   time.Time
+	stalled bool
+}
+
+type stallDetector struct {
+	mu     sync.Mutex
+	stalls map[string]time.Duration
+}
+
+// Liveness returns an error describing the stalled event loops, or nil if
+// all loops are making progress.
+func (d *ExampleServiceDaemon) Liveness() error {
+	d.stallDetector.mu.Lock()
+	defer d.stallDetector.mu.Unlock()
+	if len(d.stallDetector.stalls) == 0 {
+		return nil
+	}
+	var desc string
+	for name, dur := range d.stallDetector.stalls {
+		if desc != "" {
+			desc += ", "
+		}
+		desc += fmt.Sprintf("%s for %s", name, dur.Round(time.Millisecond))
+	}
+	return fmt.Errorf("stalled: %s", desc)
+}
+
+func (d *ExampleServiceDaemon) watchLoops() {
+	if d.stallTimeout <= 0 {
+		return
+	}
+
+	loops := []*loopProgress{{
+		name:     "router",
+		progress: func() uint64 { return atomic.LoadUint64(&d.routed) },
+		pending:  func() bool { return d.evs.len() > 0 || atomic.LoadInt32(&d.routing) != 0 },
+		describe: func() []interface{} { return []interface{}{"queue_depth", d.evs.len()} },
+	}}
+	for _, q := range d.allQueues() {
+		q := q
+		loops = append(loops, &loopProgress{
+			name:     "dispatcher " + string(q.typ),
+			progress: func() uint64 { return atomic.LoadUint64(&q.progress) },
+			pending:  func() bool { return len(q.ch) > 0 || atomic.LoadInt64(&q.busy) != 0 },
+			describe: func() []interface{} {
+				args := []interface{}{"event_type", q.typ, "queue_depth", len(q.ch)}
+				if id, ok := q.current(); ok {
+					args = append(args, "service", d.serviceName(id))
+				}
+				return args
+			},
+		})
+	}
+	now := time.Now()
+	for _, l := range loops {
+		l.since = now
+	}
+
+	ticker := time.NewTicker(d.stallTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now = <-ticker.C:
+		}
+
+		stalls := make(map[string]time.Duration)
+		for _, l := range loops {
+			progress := l.progress()
+			if progress != l.last || !l.pending() || d.pause.isPaused() {
+				if l.stalled {
+					d.log.Info("Event loop recovered from stall", "loop", l.name)
+				}
+				l.last, l.since, l.stalled = progress, now, false
+				continue
+			}
+			stalledFor := now.Sub(l.since)
+			if stalledFor < d.stallTimeout {
+				continue
+			}
+			stalls[l.name] = stalledFor
+			if !l.stalled {
+				l.stalled = true
+				args := append([]interface{}{"loop", l.name, "duration", stalledFor}, l.describe()...)
+				args = append(args, "stacks", allStacks())
+				d.log.Error("Event loop stalled", args...)
+			}
+		}
+
+		d.stallDetector.mu.Lock()
+		d.stallDetector.stalls = stalls
+		d.stallDetector.mu.Unlock()
+	}
+}
+
+// allStacks returns the stack traces of all goroutines.
+func allStacks() string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
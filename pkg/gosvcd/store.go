@@ -0,0 +1,85 @@
+package gosvcd
+
+import (
+	"context"
+	"time"
+)
+
+// EventStore persists events for querying, e.g. for recording, replaying
+// and auditing the event flows. When set with the builder's SetEventStore,
+// the daemon stores all the emitted events in batches in the background.
+type EventStore interface {
+	// Append stores the events in the given order.
+	Append(evs ...Event) error
+
+	// Query calls 'fn' with the stored events matching the query in
+	// the order they were stored. Query stops at the first error
+	// returned by 'fn'.
+	Query(ctx context.Context, q EventQuery, fn func(StoredEvent) error) error
+}
+
+// EventQuery selects stored events. The zero value selects all events.
+type EventQuery struct {
+	// Types and Sources, if not empty, select the events with one of
+	// the event types and one of the sources.
+	Types   []EventType
+	Sources []ServiceId
+
+	// Since and Until, if not zero, select the events with a timestamp
+	// at or after Since and before Until.
+	Since time.Time
+	Until time.Time
+
+	// AfterID selects the events stored after the event with the id,
+	// for paging through the events.
+	AfterID uint64
+
+	// Limit, if positive, limits the number of events.
+	Limit int
+}
+
+// StoredEvent is an event with the id assigned by the store. The ids
+// increase in the order the events were stored.
+type StoredEvent struct {
+	ID uint64
+	Event
+}
+
+// NewEvent returns an event, e.g. for an event read from storage.
+func NewEvent(source ServiceId, eventType EventType, timestamp time.Time, data interface{}) Event {
+	return &ExampleEvent{
+		source:    source,
+		eventType: eventType,
+		data:      data,
+		timestamp: timestamp,
+		ctx:       context.Background(),
+	}
+}
+
+// storeBatchSize is the maximum number of events stored at once.
+const storeBatchSize = 256
+
+// storeEvents appends the events queued to 'storeCh' to the event store
+// until the channel is closed.
+func (d *ExampleServiceDaemon) storeEvents() {
+	defer close(d.storeDone)
+	batch := make([]Event, 0, storeBatchSize)
+	for ev := range d.storeCh {
+		batch = append(batch[:0], ev)
+	fill:
+		for len(batch) < storeBatchSize {
+			select {
+			case ev, ok := <-d.storeCh:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, ev)
+			default:
+				break fill
+			}
+		}
+		if err := d.store.Append(batch...); err != nil {
+			d.log.Error("Failed to store events", "events", len(batch), "error", err)
+		}
+	}
+}
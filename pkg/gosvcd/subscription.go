@@ -0,0 +1,217 @@
+package gosvcd
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy selects what happens to an event dispatched to a full
+// subscription buffer.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the dispatcher of the type until the buffer
+	// has room, delaying the other subscribers. The default.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest drops the dispatched event.
+	OverflowDropNewest
+
+	// OverflowDropOldest drops the oldest event in the buffer to make
+	// room for the dispatched event.
+	OverflowDropOldest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	}
+	return "unknown"
+}
+
+// SubscriptionBuffer configures the buffer of a subscription: an
+// independent queue of the events of a type dispatched to a service, from
+// which the events are delivered to the service in their own goroutine.
+// The dispatcher of the type hands the events to the buffers of the
+// subscribers instead of invoking their handlers, so that a slow
+// subscriber does not delay the others subscribed to the type until its
+// buffer fills up.
+type SubscriptionBuffer struct {
+	// Size is the capacity of the buffer. Zero or less disables the
+	// buffer, the events being delivered from the dispatcher.
+	Size int
+
+	// Overflow is the policy for the events dispatched to a full buffer.
+	Overflow OverflowPolicy
+}
+
+// SetSubscriptionBuffers sets the buffer of the subscriptions without a
+// buffer of their own set with SetSubscriptionBuffer. By default the
+// events are delivered from the dispatchers of their types.
+func (b *ExampleServiceDaemonBuilder) SetSubscriptionBuffers(buf SubscriptionBuffer) {
+	b.subBuffer = buf
+}
+
+// SetSubscriptionBuffer sets the buffer of the subscription of the service
+// to the event type. A buffer with a size of zero or less makes the events
+// delivered from the dispatcher of the type regardless of
+// SetSubscriptionBuffers.
+func (b *ExampleServiceDaemonBuilder) SetSubscriptionBuffer(id ServiceId, typ EventType, buf SubscriptionBuffer) {
+	if b.subBuffers == nil {
+		b.subBuffers = make(map[HandlerKey]SubscriptionBuffer)
+	}
+	b.subBuffers[HandlerKey{Service: id, EventType: typ}] = buf
+}
+
+// subscription is the buffer of a subscription.
+type subscription struct {
+	// queued is the number of events buffered or being delivered.
+	queued int64
+
+	typ      EventType
+	overflow OverflowPolicy
+	ch       chan buffered
+}
+
+// buffered is an event in a subscription buffer. The handle is the one of
+// the subscriber when the event was dispatched, as a replaced service
+// keeps its buffer.
+type buffered struct {
+	h  *ExampleServiceHandle
+	ev *ExampleEvent
+}
+
+// newSubscriptions returns the buffers of the subscriptions to the type,
+// by the id of the subscriber, or nil if none has one.
+func (b *ExampleServiceDaemonBuilder) newSubscriptions(typ EventType, subs []*ExampleServiceHandle) map[ServiceId]*subscription {
+	var bufs map[ServiceId]*subscription
+	for _, h := range subs {
+		buf, ok := b.subBuffers[HandlerKey{Service: h.ID(), EventType: typ}]
+		if !ok {
+			buf = b.subBuffer
+		}
+		if buf.Size <= 0 {
+			continue
+		}
+		if bufs == nil {
+			bufs = make(map[ServiceId]*subscription)
+		}
+		bufs[h.ID()] = &subscription{
+			typ:      typ,
+			overflow: buf.Overflow,
+			ch:       make(chan buffered, buf.Size),
+		}
+	}
+	return bufs
+}
+
+// push adds the event dispatched to the service to the buffer, applying
+// the overflow policy if it is full.
+func (s *subscription) push(d *ExampleServiceDaemon, h *ExampleServiceHandle, ev *ExampleEvent) {
+	atomic.AddInt64(&s.queued, 1)
+	atomic.AddInt64(&d.pending, 1)
+	ev.retain()
+	d.cancels.retain(ev.cancel)
+	e := buffered{h, ev}
+	switch s.overflow {
+	case OverflowDropNewest:
+		select {
+		case s.ch <- e:
+		default:
+			s.drop(d, e)
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case s.ch <- e:
+				return
+			default:
+			}
+			select {
+			case old := <-s.ch:
+				s.drop(d, old)
+			default:
+			}
+		}
+	default:
+		s.ch <- e
+	}
+}
+
+func (s *subscription) drop(d *ExampleServiceDaemon, e buffered) {
+	d.metrics.eventDropped(DropOverflow, s.typ)
+	d.skipOrdered(e.h, e.ev)
+	d.unhold(e.ev)
+	atomic.AddInt64(&s.queued, -1)
+}
+
+// run delivers the buffered events until the buffer is closed.
+func (s *subscription) run(d *ExampleServiceDaemon, q *dispatcher) {
+	for e := range s.ch {
+		d.deliverOrdered(e.h, e.ev)
+		atomic.AddUint64(&q.progress, 1)
+		d.unhold(e.ev)
+		atomic.AddInt64(&s.queued, -1)
+	}
+}
+
+// startSubscriptions starts delivering the events from the subscription
+// buffers.
+func (d *ExampleServiceDaemon) startSubscriptions(wg *sync.WaitGroup) {
+	for _, q := range d.allQueues() {
+		for _, s := range q.bufs {
+			q, s := q, s
+			wg.Add(1)
+			d.spawn(func() {
+				defer wg.Done()
+				s.run(d, q)
+			})
+		}
+	}
+}
+
+// closeSubscriptions closes the subscription buffers once the dispatchers
+// have stopped.
+func (d *ExampleServiceDaemon) closeSubscriptions() {
+	for _, q := range d.allQueues() {
+		for _, s := range q.bufs {
+			close(s.ch)
+		}
+	}
+}
+
+// buffered returns the number of events in the buffer of the service's
+// subscription to the type of the dispatcher.
+func (q *dispatcher) buffered(id ServiceId) int {
+	if s, ok := q.bufs[id]; ok {
+		return len(s.ch)
+	}
+	return 0
+}
+
+// waitBuffered waits until the events in the buffer of the service's
+// subscription, if any, have been delivered.
+func (q *dispatcher) waitBuffered(id ServiceId) {
+	s, ok := q.bufs[id]
+	if !ok {
+		return
+	}
+	for atomic.LoadInt64(&s.queued) > 0 {
+		time.Sleep(drainPollInterval)
+	}
+}
+
+// subscriptionDepth returns the number of events in the buffer of the
+// service's subscription to the type.
+func (d *ExampleServiceDaemon) subscriptionDepth(id ServiceId, typ EventType) int {
+	if q, ok := d.queue(typ); ok {
+		return q.buffered(id)
+	}
+	return 0
+}
@@ -0,0 +1,261 @@
+package gosvcd
+
+import (
+	"sync"
+	"time"
+)
+
+// RestartStrategy selects which children a supervisor restarts when one
+// of them fails.
+type RestartStrategy int
+
+const (
+	// OneForOne restarts the failed child.
+	OneForOne RestartStrategy = iota
+
+	// OneForAll restarts all the children.
+	OneForAll
+
+	// RestForOne restarts the failed child and the children after it.
+	RestForOne
+)
+
+func (s RestartStrategy) String() string {
+	switch s {
+	case OneForOne:
+		return "one-for-one"
+	case OneForAll:
+		return "one-for-all"
+	case RestForOne:
+		return "rest-for-one"
+	}
+	return "unknown"
+}
+
+// Default restart intensity of the supervisors.
+const (
+	DefaultMaxRestarts   = 3
+	DefaultRestartPeriod = 5 * time.Second
+)
+
+// SupervisorSpec describes a supervisor registered with the builder's
+// Supervise. A supervisor restarts its children when one of them fails,
+// i.e. panics when initialized or handling an event. The children are the
+// Services, in the given order, followed by the supervisors naming it as
+// their Parent, in the order they were registered. Restarting a child
+// supervisor restarts all the services under it. The restarted services
+// are shut down in reverse dependency order and initialized in dependency
+// order.
+//
+// If the children fail more than MaxRestarts times within Period, the
+// supervisor gives up and fails itself: its parent applies its strategy
+// to it, and a root supervisor emits SupervisorFailed and leaves the
+// failed services as they are.
+type SupervisorSpec struct {
+	Name     string
+	Parent   string
+	Strategy RestartStrategy
+	Services []ServiceId
+
+	// MaxRestarts and Period default to DefaultMaxRestarts and
+	// DefaultRestartPeriod.
+	MaxRestarts int
+	Period      time.Duration
+}
+
+type supervisor struct {
+	spec     SupervisorSpec
+	parent   *supervisor
+	subtrees []*supervisor
+
+	// mu serializes the restarts of the children.
+	mu sync.Mutex
+
+	// restarts are the times of the restarts within the period.
+	restarts []time.Time
+}
+
+// numChildren returns the number of children.
+func (s *supervisor) numChildren() int {
+	return len(s.spec.Services) + len(s.subtrees)
+}
+
+// childServices returns the services under the child with the index.
+func (s *supervisor) childServices(i int) []ServiceId {
+	if i < len(s.spec.Services) {
+		return []ServiceId{s.spec.Services[i]}
+	}
+	return s.subtrees[i-len(s.spec.Services)].allServices()
+}
+
+// allServices returns the services under the supervisor.
+func (s *supervisor) allServices() []ServiceId {
+	var ids []ServiceId
+	for i := 0; i < s.numChildren(); i++ {
+		ids = append(ids, s.childServices(i)...)
+	}
+	return ids
+}
+
+// indexOf returns the index of the child supervisor.
+func (s *supervisor) indexOf(sub *supervisor) int {
+	for i, t := range s.subtrees {
+		if t == sub {
+			return len(s.spec.Services) + i
+		}
+	}
+	return -1
+}
+
+// allow records a restart and returns false if the restart intensity has
+// been exceeded. Must be called with 'mu' held.
+func (s *supervisor) allow(now time.Time) bool {
+	cutoff := now.Add(-s.spec.Period)
+	i := 0
+	for i < len(s.restarts) && s.restarts[i].Before(cutoff) {
+		i++
+	}
+	s.restarts = append(s.restarts[i:], now)
+	return len(s.restarts) <= s.spec.MaxRestarts
+}
+
+// buildSupervisors builds the supervisor tree from the specs.
+func (d *ExampleServiceDaemon) buildSupervisors(specs []SupervisorSpec) {
+	if len(specs) == 0 {
+		return
+	}
+	byName := make(map[string]*supervisor, len(specs))
+	for _, spec := range specs {
+		if spec.MaxRestarts <= 0 {
+			spec.MaxRestarts = DefaultMaxRestarts
+		}
+		if spec.Period <= 0 {
+			spec.Period = DefaultRestartPeriod
+		}
+		byName[spec.Name] = &supervisor{spec: spec}
+	}
+	d.supervisorOf = make(map[ServiceId]*supervisor)
+	for _, spec := range specs {
+		s := byName[spec.Name]
+		if spec.Parent != "" {
+			parent, ok := byName[spec.Parent]
+			if !ok {
+				d.log.Error("Unknown parent supervisor", "supervisor", spec.Name, "parent", spec.Parent)
+			} else {
+				s.parent = parent
+				parent.subtrees = append(parent.subtrees, s)
+			}
+		}
+		for _, id := range spec.Services {
+			d.supervisorOf[id] = s
+		}
+	}
+}
+
+// supervise handles the failure of a service with its supervisor, if it
+// has one.
+func (d *ExampleServiceDaemon) supervise(h *ExampleServiceHandle, op string) {
+	s, ok := d.supervisorOf[h.ID()]
+	if !ok || op == OpShutdown {
+		return
+	}
+	for i, id := range s.spec.Services {
+		if id == h.ID() {
+			// The failed service's lock is held, restart asynchronously.
+			d.spawn(func() {
+				select {
+				case <-d.ready:
+				case <-d.shuttingDown:
+					return
+				}
+				d.restartChild(s, i)
+			})
+			return
+		}
+	}
+}
+
+// restartChild applies the strategy of the supervisor to the failure of
+// the child with the index.
+func (d *ExampleServiceDaemon) restartChild(s *supervisor, i int) {
+	if d.isStopping() {
+		return
+	}
+	s.mu.Lock()
+	if !s.allow(d.clock.Now()) {
+		s.mu.Unlock()
+		d.log.Error("Supervisor restart intensity exceeded",
+			"supervisor", s.spec.Name,
+			"max_restarts", s.spec.MaxRestarts,
+			"period", s.spec.Period)
+		if s.parent != nil {
+			d.restartChild(s.parent, s.parent.indexOf(s))
+			return
+		}
+		d.emitAsync(SupervisorFailed_Type, &SupervisorFailed{
+			Supervisor: s.spec.Name,
+			Services:   s.allServices(),
+		})
+		return
+	}
+	defer s.mu.Unlock()
+
+	var ids []ServiceId
+	switch s.spec.Strategy {
+	case OneForAll:
+		ids = s.allServices()
+	case RestForOne:
+		for j := i; j < s.numChildren(); j++ {
+			ids = append(ids, s.childServices(j)...)
+		}
+	default:
+		ids = s.childServices(i)
+	}
+	d.log.Warn("Supervisor restarting services",
+		"supervisor", s.spec.Name,
+		"strategy", s.spec.Strategy,
+		"services", ids)
+	d.restartServices(ids)
+}
+
+// restartServices shuts down the services in reverse dependency order and
+// initializes them in dependency order.
+func (d *ExampleServiceDaemon) restartServices(ids []ServiceId) {
+	set := make(map[ServiceId]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	var hs []*ExampleServiceHandle
+	for _, h := range d.orderedHandles() {
+		if set[h.ID()] {
+			hs = append(hs, h)
+		}
+	}
+	for i := len(hs) - 1; i >= 0; i-- {
+		h := hs[i]
+		h.mu.Lock()
+		h.shutdownService()
+		h.mu.Unlock()
+	}
+	for _, h := range hs {
+		h.mu.Lock()
+		if !d.isStopping() {
+			h.initService()
+			d.metrics.serviceRestarted(h.ID())
+		}
+		h.mu.Unlock()
+	}
+}
+
+//
+// Supervisor failed
+//
+
+var SupervisorFailed_Type = EventType("SupervisorFailed")
+
+// SupervisorFailed is emitted when a root supervisor exceeds its restart
+// intensity and stops restarting its services.
+type SupervisorFailed struct {
+	Supervisor string
+	Services   []ServiceId
+}
@@ -0,0 +1,239 @@
+package gosvcd
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Tagged derives the dependencies and the subscriptions of a service from
+// the tags of its fields. It is embedded in the service struct, which
+// implements the other methods of Service:
+//
+//	type Reporter struct {
+//		gosvcd.Tagged
+//
+//		DB    *Database   `gosvcd:"dep"`
+//		Cache CacheClient `gosvcd:"dep=7,optional"`
+//		_     struct{}    `gosvcd:"subscribe=ExSomeEvent,subscribe=Reload"`
+//	}
+//
+// The comma-separated options of a tag are:
+//
+//   - dep: the field is a dependency on the registered service assignable
+//     to the type of the field, of which there must be exactly one.
+//   - dep=<id>: the field is a dependency on the service with the id.
+//   - optional: the dependency is optional, see OptionalDependencies.
+//   - subscribe=<type>: the service subscribes to the event type. Usually
+//     set on blank fields.
+//
+// The dependencies are resolved when the daemon is started. An optional
+// dependency that is not registered is left out. The dependency fields
+// are set to the running dependencies before the service is initialized,
+// each time it is, and the fields of the dependencies that are not
+// running to the zero value.
+type Tagged struct {
+	fields []taggedField
+	subs   []EventType
+	deps   []ServiceId
+}
+
+type taggedField struct {
+	name     string
+	index    []int
+	typ      reflect.Type
+	id       ServiceId
+	byType   bool
+	optional bool
+
+	// resolved is true if 'id' is the dependency, false if an optional
+	// dependency is not registered.
+	resolved bool
+}
+
+func (t *Tagged) tagged() *Tagged { return t }
+
+func (t *Tagged) Dependencies() []ServiceId { return t.deps }
+
+func (t *Tagged) Subscriptions() []EventType { return t.subs }
+
+func (t *Tagged) OptionalDependencies() []ServiceId {
+	var ids []ServiceId
+	for _, f := range t.fields {
+		if f.optional && f.resolved {
+			ids = append(ids, f.id)
+		}
+	}
+	return ids
+}
+
+// taggedService is implemented by the services embedding Tagged.
+type taggedService interface {
+	tagged() *Tagged
+}
+
+// parseTags parses the tags of the service struct. Panics on a malformed
+// tag, as does Register on other misuse.
+func (t *Tagged) parseTags(svc Service) {
+	v := reflect.ValueOf(svc)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("service %d embeds Tagged but is not a pointer to a struct", svc.ID()))
+	}
+	t.fields, t.subs, t.deps = nil, nil, nil
+	var walk func(typ reflect.Type, index []int)
+	walk = func(typ reflect.Type, index []int) {
+		for i := 0; i < typ.NumField(); i++ {
+			sf := typ.Field(i)
+			idx := append(append([]int(nil), index...), i)
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(Tagged{}) {
+				walk(sf.Type, idx)
+				continue
+			}
+			tag, ok := sf.Tag.Lookup("gosvcd")
+			if !ok {
+				continue
+			}
+			if err := t.parseTag(sf, idx, tag); err != nil {
+				panic(fmt.Sprintf("service %d: field %s: %s", svc.ID(), sf.Name, err))
+			}
+		}
+	}
+	walk(v.Elem().Type(), nil)
+}
+
+func (t *Tagged) parseTag(sf reflect.StructField, index []int, tag string) error {
+	var (
+		dep      *taggedField
+		optional bool
+	)
+	for _, opt := range strings.Split(tag, ",") {
+		key, value := opt, ""
+		if i := strings.IndexByte(opt, '='); i >= 0 {
+			key, value = opt[:i], opt[i+1:]
+		}
+		switch key {
+		case "dep":
+			if sf.Name == "_" || sf.PkgPath != "" {
+				return fmt.Errorf("dependency field is not exported")
+			}
+			dep = &taggedField{name: sf.Name, index: index, typ: sf.Type, byType: value == ""}
+			if value != "" {
+				id, err := strconv.Atoi(value)
+				if err != nil {
+					return fmt.Errorf("invalid service id %q", value)
+				}
+				dep.id = ServiceId(id)
+			}
+		case "optional":
+			optional = true
+		case "subscribe":
+			if value == "" {
+				return fmt.Errorf("subscribe without an event type")
+			}
+			t.subs = append(t.subs, EventType(value))
+		default:
+			return fmt.Errorf("unknown tag option %q", opt)
+		}
+	}
+	if optional && dep == nil {
+		return fmt.Errorf("optional without dep")
+	}
+	if dep != nil {
+		dep.optional = optional
+		t.fields = append(t.fields, *dep)
+	}
+	return nil
+}
+
+// resolveTagged resolves the dependencies by type of the services
+// embedding Tagged, and returns the problems found.
+func (b *ExampleServiceDaemonBuilder) resolveTagged() []string {
+	var problems []string
+	for _, id := range sortServiceIds(b.ids()) {
+		svc := b.handles[id].Service
+		ts, ok := svc.(taggedService)
+		if !ok {
+			continue
+		}
+		t := ts.tagged()
+		t.deps = nil
+		for i := range t.fields {
+			f := &t.fields[i]
+			if f.byType {
+				var found []ServiceId
+				for _, other := range sortServiceIds(b.ids()) {
+					if other != id && reflect.TypeOf(b.handles[other].Service).AssignableTo(f.typ) {
+						found = append(found, other)
+					}
+				}
+				f.resolved = len(found) == 1
+				switch {
+				case len(found) > 1:
+					problems = append(problems, fmt.Sprintf("service %d (%s): dependency %s matches services %v", id, svc.Name(), f.name, found))
+					continue
+				case len(found) == 0:
+					if !f.optional {
+						problems = append(problems, fmt.Sprintf("service %d (%s): no registered service for dependency %s of type %v", id, svc.Name(), f.name, f.typ))
+					}
+					continue
+				}
+				f.id = found[0]
+			} else {
+				h, ok := b.handles[f.id]
+				f.resolved = ok || !f.optional
+				if !f.resolved {
+					continue
+				}
+				if ok && !reflect.TypeOf(h.Service).AssignableTo(f.typ) {
+					problems = append(problems, fmt.Sprintf("service %d (%s): service %d is not assignable to dependency %s of type %v", id, svc.Name(), f.id, f.name, f.typ))
+				}
+			}
+			if !containsId(t.deps, f.id) {
+				t.deps = append(t.deps, f.id)
+			}
+		}
+	}
+	return problems
+}
+
+func (b *ExampleServiceDaemonBuilder) ids() []ServiceId {
+	ids := make([]ServiceId, 0, len(b.handles))
+	for id := range b.handles {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func containsId(ids []ServiceId, id ServiceId) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// injectDependencies sets the dependency fields of the service embedding
+// Tagged to the running dependencies.
+func (h *ExampleServiceHandle) injectDependencies() {
+	ts, ok := h.Service.(taggedService)
+	if !ok {
+		return
+	}
+	v := reflect.ValueOf(h.Service).Elem()
+	for _, f := range ts.tagged().fields {
+		field := v.FieldByIndex(f.index)
+		field.Set(reflect.Zero(f.typ))
+		if !f.resolved {
+			continue
+		}
+		dep, ok := h.d.handle(f.id)
+		if !ok || dep.getState() != ServiceRunning {
+			continue
+		}
+		if dv := reflect.ValueOf(dep.Service); dv.Type().AssignableTo(f.typ) {
+			field.Set(dv)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package gosvcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event taps mirror emitted events to external observers for debugging.
+// Taps are lossy: if an observer falls behind, events are dropped rather
+// than slowing down the dispatch of events to services.
+
+type eventTap struct {
+	types  map[EventType]bool
+	denied map[EventType]bool
+	ch     chan Event
+}
+
+func (t *eventTap) wants(typ EventType) bool {
+	return !t.denied[typ] && (len(t.types) == 0 || t.types[typ])
+}
+
+type tapSet struct {
+	// size is the capacity of the channels of the taps.
+	size int
+
+	mu     sync.Mutex
+	taps   map[*eventTap]struct{}
+	closed bool
+}
+
+func (s *tapSet) add(types []EventType, denied map[EventType]bool) (<-chan Event, func()) {
+	t := &eventTap{
+		types:  make(map[EventType]bool),
+		denied: denied,
+		ch:     make(chan Event, s.size),
+	}
+	for _, typ := range types {
+		t.types[typ] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(t.ch)
+		return t.ch, func() {}
+	}
+	if s.taps == nil {
+		s.taps = make(map[*eventTap]struct{})
+	}
+	s.taps[t] = struct{}{}
+
+	var once sync.Once
+	return t.ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, ok := s.taps[t]; ok {
+				delete(s.taps, t)
+				close(t.ch)
+			}
+		})
+	}
+}
+
+// publish sends the event to the taps that want it. Returns true if it
+// was sent to any.
+func (s *tapSet) publish(ev Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sent := false
+	for t := range s.taps {
+		if !t.wants(ev.EventType()) {
+			continue
+		}
+		select {
+		case t.ch <- ev:
+			sent = true
+		default:
+		}
+	}
+	return sent
+}
+
+func (s *tapSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t := range s.taps {
+		close(t.ch)
+	}
+	s.taps = nil
+	s.closed = true
+}
+
+//
+// Server-sent events endpoint
+//
+
+type tapHandler struct {
+	d ServiceDaemon
+}
+
+// NewTapHandler returns a HTTP handler that streams the events emitted in
+// the daemon as server-sent events. The event types to stream are selected
+// with the "type" query parameter, which can be repeated. Without it all
+// events are streamed.
+func NewTapHandler(d ServiceDaemon) http.Handler {
+	return &tapHandler{d}
+}
+
+type tapMessage struct {
+	Source ServiceId   `json:"source"`
+	Type   EventType   `json:"type"`
+	Time   time.Time   `json:"time"`
+	Seq    uint64      `json:"seq,omitempty"`
+	Data   interface{} `json:"data"`
+}
+
+func (h *tapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var types []EventType
+	for _, typ := range r.URL.Query()["type"] {
+		types = append(types, EventType(typ))
+	}
+	evs, cancel := h.d.Tap(types...)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-evs:
+			if !ok {
+				return
+			}
+			data, err := MarshalEvent(ev)
+			if err != nil {
+				// Fall back to the printed form of the payload.
+				msg := tapMessage{ev.ServiceId(), ev.EventType(), ev.Timestamp(), ev.Sequence(), fmt.Sprintf("%v", ev.Data())}
+				data, _ = json.Marshal(&msg)
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.EventType(), data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
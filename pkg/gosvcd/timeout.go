@@ -0,0 +1,144 @@
+package gosvcd
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// HandlerTimeout limits the time the event handlers of a service may take.
+type HandlerTimeout struct {
+	// Timeout is the maximum duration of an invocation of the handler.
+	// When it is exceeded, the context of the event is cancelled and the
+	// dispatcher moves on, leaving the handler to return on its own.
+	Timeout time.Duration
+
+	// Quarantine pauses the service with Mode when a handler times out,
+	// until resumed with ResumeService, instead of until the handler that
+	// timed out returns.
+	Quarantine bool
+	Mode       PauseMode
+}
+
+// SetHandlerTimeout sets the handler timeout of the service. A timeout of
+// zero or less removes it.
+//
+// The handlers are cancelled through the context of the event, see
+// Event.Context, and must watch it to return early. Until a handler that
+// timed out returns, the service is paused with PauseHold, so that the
+// dispatchers do not wait for it, and it is resumed once the handler
+// returns, unless it is quarantined. The handlers of a ConcurrentHandler
+// that timed out do not pause it and do not count against its
+// MaxConcurrency. The timeouts are counted in
+// MetricsSnapshot.HandlerTimeouts.
+func (b *ExampleServiceDaemonBuilder) SetHandlerTimeout(id ServiceId, t HandlerTimeout) {
+	if t.Timeout <= 0 {
+		delete(b.handlerTimeouts, id)
+		return
+	}
+	if b.handlerTimeouts == nil {
+		b.handlerTimeouts = make(map[ServiceId]HandlerTimeout)
+	}
+	b.handlerTimeouts[id] = t
+}
+
+// invoke calls the event handler of the service, with the handler timeout
+// of the service if it has one. See invokeHandler.
+func (d *ExampleServiceDaemon) invoke(h *ExampleServiceHandle, ev *ExampleEvent, event Event, rec *ackRecord) error {
+	t, ok := d.handlerTimeouts[h.ID()]
+	if !ok || (h.workers == nil && atomic.LoadInt32(&h.abandoned) > 0) {
+		return d.invokeHandler(h, ev, event, rec)
+	}
+	return d.invokeWithTimeout(h, ev, event, rec, t)
+}
+
+// invokeWithTimeout invokes the handler in a goroutine, returning once it
+// has returned or timed out.
+func (d *ExampleServiceDaemon) invokeWithTimeout(h *ExampleServiceHandle, ev *ExampleEvent, event Event, rec *ackRecord, t HandlerTimeout) error {
+	ctx := event.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	event = &timeoutEvent{event, ctx}
+
+	// The event is pending until the handler has returned. 'state' is set
+	// to one when the handler is abandoned and to two when it returns
+	// first.
+	var state int32
+	done := make(chan error, 1)
+	atomic.AddInt64(&d.pending, 1)
+	ev.retain()
+	d.cancels.retain(ev.cancel)
+	d.workers.Add(1)
+	d.goroutines.Go(h.Name(), func() {
+		defer func() {
+			cancel()
+			if !atomic.CompareAndSwapInt32(&state, 0, 2) {
+				atomic.AddInt32(&h.abandoned, -1)
+				d.log.Info("Timed out event handler returned", "service", h.Name(), "event_type", ev.eventType)
+				d.unstall(h)
+			}
+			d.unhold(ev)
+			d.workers.Done()
+		}()
+		done <- d.invokeHandler(h, ev, event, rec)
+	})
+
+	timer := time.NewTimer(t.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+	}
+	atomic.AddInt32(&h.abandoned, 1)
+	if !atomic.CompareAndSwapInt32(&state, 0, 1) {
+		atomic.AddInt32(&h.abandoned, -1)
+		return <-done
+	}
+	cancel()
+	d.log.Warn("Event handler timed out", "service", h.Name(), "event_type", ev.eventType, "timeout", t.Timeout)
+	d.metrics.handlerTimedOut(h.ID())
+	h.bookMu.Lock()
+	switch {
+	case t.Quarantine:
+		d.pauseService(h, t.Mode, h.quarantine.failures)
+	case h.workers == nil && atomic.LoadInt32(&h.abandoned) > 0 && atomic.LoadInt32(&h.quarantine.paused) == 0:
+		// The next event would wait for the handler to release the
+		// service. Hold the events until it returns instead.
+		d.pauseService(h, PauseHold, h.quarantine.failures)
+		h.quarantine.stalled = true
+	}
+	h.bookMu.Unlock()
+	return nil
+}
+
+// unstall resumes the service paused for the handlers that timed out once
+// they all have returned.
+func (d *ExampleServiceDaemon) unstall(h *ExampleServiceHandle) {
+	h.bookMu.Lock()
+	resume := h.quarantine.stalled && atomic.LoadInt32(&h.abandoned) == 0
+	if resume {
+		h.quarantine.stalled = false
+	}
+	h.bookMu.Unlock()
+	if resume {
+		d.resumeService(h)
+	}
+}
+
+// timeoutEvent is the event passed to the handler of a service with a
+// handler timeout, with the context cancelled when it times out.
+type timeoutEvent struct {
+	Event
+	ctx context.Context
+}
+
+func (ev *timeoutEvent) Context() context.Context {
+	return ev.ctx
+}
+
+func (ev *timeoutEvent) IdempotencyKey() string {
+	return IdempotencyKey(ev.Event)
+}
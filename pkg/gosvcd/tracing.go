@@ -0,0 +1,50 @@
+package gosvcd
+
+import "context"
+
+// Tracer creates spans for the flow of events through the daemon. A span is
+// started for each emitted event, and a child span for each invocation of
+// HandleEvent. The context of the handler span is available to the handler
+// via Event.Context(), and events emitted with it using EmitEventContext()
+// become children of the handler span. This allows tracing a single action
+// through all the services it touches.
+//
+// The interface is modeled after OpenTelemetry and can be implemented with
+// an OpenTelemetry trace.Tracer, adapting its spans with SpanFunc:
+//
+//	func (t *otelTracer) StartEmit(ctx context.Context, ev gosvcd.Event) (context.Context, gosvcd.Span) {
+//		ctx, span := t.tracer.Start(ctx, "emit "+string(ev.EventType()),
+//			trace.WithSpanKind(trace.SpanKindProducer))
+//		return ctx, gosvcd.SpanFunc(func() { span.End() })
+//	}
+type Tracer interface {
+	// StartEmit starts the span for an emitted event. The span ends when
+	// the event has been handled by all subscribers.
+	StartEmit(ctx context.Context, ev Event) (context.Context, Span)
+
+	// StartHandle starts the span for the handling of an event by a
+	// service. 'ctx' carries the span of the emit.
+	StartHandle(ctx context.Context, svc Service, ev Event) (context.Context, Span)
+}
+
+// Span is a traced operation.
+type Span interface {
+	End()
+}
+
+// SpanFunc adapts a function ending a span to a Span, e.g. for the spans
+// whose End method takes options, such as the OpenTelemetry trace.Span.
+type SpanFunc func()
+
+func (f SpanFunc) End() { f() }
+
+// tracedEvent is the event as seen by a single handler, carrying the
+// context of the handler's span.
+type tracedEvent struct {
+	*ExampleEvent
+	ctx context.Context
+}
+
+func (ev *tracedEvent) Context() context.Context {
+	return ev.ctx
+}
@@ -0,0 +1,76 @@
+package gosvcd
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrEventExpired is the error of the dead letters of expired events.
+var ErrEventExpired = errors.New("event expired")
+
+type ttlCtx struct{}
+
+// WithTTL returns a context for emitting an event with a time-to-live,
+// overriding the TTL of its type set with SetTTL. An event still queued
+// when its TTL has passed is dropped instead of being delivered stale.
+// The TTL is not inherited by the events emitted with the context of the
+// event.
+func WithTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, ttlCtx{}, ttl)
+}
+
+// ttlOf returns the TTL in the context and the context without it.
+func ttlOf(ctx context.Context) (time.Duration, context.Context) {
+	if ctx == nil {
+		return 0, ctx
+	}
+	ttl, _ := ctx.Value(ttlCtx{}).(time.Duration)
+	if ttl <= 0 {
+		return 0, ctx
+	}
+	return ttl, context.WithValue(ctx, ttlCtx{}, time.Duration(0))
+}
+
+// Expires returns the time after which the event is not delivered, if it
+// has a TTL.
+func Expires(ev Event) (time.Time, bool) {
+	if e, ok := ev.(interface{ Expires() time.Time }); ok {
+		t := e.Expires()
+		return t, !t.IsZero()
+	}
+	return time.Time{}, false
+}
+
+func (ev *ExampleEvent) Expires() time.Time {
+	return ev.expires
+}
+
+// expiry returns the expiry time of an event emitted now with the TTL
+// from the context or its type.
+func expiry(now time.Time, ttl time.Duration, ti *typeInfo) time.Time {
+	if ttl <= 0 && ti != nil {
+		ttl = ti.ttl
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// expired returns true if the event has expired before its delivery to
+// the service, dropping it.
+func (d *ExampleServiceDaemon) expired(h *ExampleServiceHandle, ev *ExampleEvent) bool {
+	if ev.expires.IsZero() || d.clock.Now().Before(ev.expires) {
+		return false
+	}
+	d.log.Debug("Dropping expired event",
+		"service", h.Name(),
+		"event_type", ev.eventType,
+		"expires", ev.expires)
+	d.metrics.eventDropped(DropExpired, ev.eventType)
+	if d.deadLetterExpired {
+		d.emitDeadLetter(h, ev, 0, ErrEventExpired)
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package gosvcd
+
+import (
+	"context"
+	"runtime/pprof"
+	"time"
+)
+
+// typeInfo is what the hot path needs to know about an event type,
+// resolved at Start.
+type typeInfo struct {
+	typ EventType
+
+	// queue is the dispatch queue of the type, or nil if it has no
+	// subscribers.
+	queue *dispatcher
+
+	validator  Validator
+	ackTimeout time.Duration
+	ttl        time.Duration
+
+	// credits are the credits of the type, or nil if it has no credit
+	// window.
+	credits *credits
+
+	// labels are the contexts with the profiler labels for the handlers
+	// of the subscribers.
+	labels map[*ExampleServiceHandle]context.Context
+}
+
+// ResolvedType is an event type resolved by the daemon, for emitting the
+// events of the type without looking it up. The zero value of an unknown
+// type is resolved when the event is routed.
+type ResolvedType struct {
+	typ  EventType
+	info *typeInfo
+}
+
+func (rt ResolvedType) EventType() EventType {
+	return rt.typ
+}
+
+// indexTypes resolves the subscribed and configured event types to
+// indices into 'types'.
+func (d *ExampleServiceDaemon) indexTypes() {
+	d.typeIndex = make(map[EventType]int)
+	add := func(typ EventType) *typeInfo {
+		if i, ok := d.typeIndex[typ]; ok {
+			return d.types[i]
+		}
+		ti := &typeInfo{typ: typ}
+		d.typeIndex[typ] = len(d.types)
+		d.types = append(d.types, ti)
+		return ti
+	}
+	for typ, q := range d.queues {
+		ti := add(typ)
+		ti.queue = q
+		subs := q.subscribers()
+		ti.labels = make(map[*ExampleServiceHandle]context.Context, len(subs))
+		for _, h := range subs {
+			labels := pprof.Labels("service", h.Name(), "event_type", string(typ))
+			ti.labels[h] = pprof.WithLabels(context.Background(), labels)
+		}
+		ti.credits = d.newCredits(typ, subs)
+	}
+	for typ, v := range d.validators {
+		add(typ).validator = v
+	}
+	for typ, timeout := range d.ackTimeouts {
+		add(typ).ackTimeout = timeout
+	}
+	for typ, ttl := range d.ttls {
+		add(typ).ttl = ttl
+	}
+}
+
+// resolve returns the information on the event type, or nil if it is
+// unknown.
+func (d *ExampleServiceDaemon) resolve(typ EventType) *typeInfo {
+	if i, ok := d.typeIndex[typ]; ok {
+		return d.types[i]
+	}
+	return nil
+}
+
+// Resolve resolves the event type for EmitResolved.
+func (d *ExampleServiceDaemon) Resolve(typ EventType) ResolvedType {
+	return ResolvedType{typ, d.resolve(typ)}
+}
+
+// EmitResolved emits an event of a resolved type on behalf of the daemon.
+func (d *ExampleServiceDaemon) EmitResolved(rt ResolvedType, data interface{}) error {
+	return d.emitResolved(context.Background(), DaemonServiceId, rt, data)
+}
+
+// EmitResolved emits an event of a type resolved with the daemon's
+// Resolve. Together with event pooling, this avoids the lookups and
+// allocations of emitting and dispatching the event.
+func (h *ExampleServiceHandle) EmitResolved(rt ResolvedType, data interface{}) error {
+	return h.d.emitResolved(context.Background(), h.ID(), rt, data)
+}
+
+// labelContext returns the context with the profiler labels for the
+// handler of the service.
+func (d *ExampleServiceDaemon) labelContext(h *ExampleServiceHandle, ev *ExampleEvent) context.Context {
+	ti := ev.info
+	if ti == nil {
+		ti = d.resolve(ev.eventType)
+	}
+	if ti != nil {
+		if ctx, ok := ti.labels[h]; ok {
+			return ctx
+		}
+	}
+	labels := pprof.Labels("service", h.Name(), "event_type", string(ev.eventType))
+	return pprof.WithLabels(context.Background(), labels)
+}
@@ -1,10 +1,60 @@
 package gosvcd
 
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
 // ServiceId is a globally unique identifier for the service
 // TODO(JM): How to assign these nicely? A compile-time construction
 // would be useful. Preferably without having an external tool.
 type ServiceId int64
 
+// DaemonServiceId is the source of the events emitted by the daemon itself.
+const DaemonServiceId ServiceId = -1
+
+// ErrDaemonStopped is returned when emitting an event after the daemon has
+// started shutting down.
+var ErrDaemonStopped = errors.New("daemon stopped")
+
+// ServiceState is the lifecycle state of a registered service.
+type ServiceState int32
+
+const (
+	// ServicePending is the state of a service waiting to be initialized.
+	ServicePending ServiceState = iota
+
+	// ServiceInitializing is the state of a service being initialized.
+	ServiceInitializing
+
+	// ServiceRunning is the state of an initialized service.
+	ServiceRunning
+
+	// ServiceStopped is the state of a service that has been shut down.
+	ServiceStopped
+
+	// ServiceFailed is the state of a service that failed to initialize.
+	ServiceFailed
+)
+
+func (s ServiceState) String() string {
+	switch s {
+	case ServicePending:
+		return "pending"
+	case ServiceInitializing:
+		return "initializing"
+	case ServiceRunning:
+		return "running"
+	case ServiceStopped:
+		return "stopped"
+	case ServiceFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
 // TODO: Should events be tightly associated with a specific service?
 // This would make introspection easier as we could produce the graph
 // of services and the events they may emit.
@@ -21,13 +71,131 @@ type ServiceDaemonBuilder interface {
 }
 
 type ServiceDaemon interface {
-	// Shutdown stops all services and the daemon.
+	// Tap returns a channel that mirrors the emitted events of the given
+	// types, or all events if no types are given. Events are dropped if
+	// the channel is not drained fast enough. The events of the types
+	// restricted with SetSubscriptionACL are not mirrored. The returned
+	// function removes the tap and closes the channel.
+	Tap(types ...EventType) (<-chan Event, func())
+
+	// Services returns the registered services in dependency order.
+	Services() []Service
+
+	// EmitEvent emits an event on behalf of the daemon.
+	EmitEvent(eventType EventType, data interface{}) error
+
+	// Cancel cancels an event emitted with a context from WithEventId
+	// that has not yet been dispatched. Returns false if it has.
+	Cancel(id EventId) bool
+
+	// State returns the lifecycle state of the service.
+	State(id ServiceId) (ServiceState, bool)
+
+	// Degraded returns the optional dependencies of the service that are
+	// missing. See OptionalDependencies.
+	Degraded(id ServiceId) []ServiceId
+
+	// Restart shuts down the service and initializes it again.
+	Restart(id ServiceId) error
+
+	// Pause stops the delivery of events, queueing them, without shutting
+	// down the services.
+	Pause()
+
+	// Resume resumes the delivery of events paused with Pause.
+	Resume()
+
+	// PauseService pauses the delivery of events to the service, holding
+	// or dropping them according to the mode.
+	PauseService(id ServiceId, mode PauseMode) error
+
+	// ResumeService resumes the delivery of events to a paused service.
+	ResumeService(id ServiceId) error
+
+	// StartGroup initializes the stopped services of the named group.
+	StartGroup(name string) error
+
+	// StopGroup shuts down the services of the named group.
+	StopGroup(name string) error
+
+	// RestartGroup shuts down the services of the named group and
+	// initializes them again.
+	RestartGroup(name string) error
+
+	// Drain stops the delivery of new events to the service, waits for
+	// the queued ones to be handled, and then shuts down and unregisters
+	// the service.
+	Drain(id ServiceId) error
+
+	// Replace replaces the service with a new instance with the same id,
+	// handing off its events once the new instance is ready.
+	Replace(svc Service, opts ReplaceOptions) error
+
+	// Checkpoint writes the state of the daemon for restoring it in a
+	// new process. See SetRestore.
+	Checkpoint(w io.Writer) error
+
+	// CheckpointFile writes the checkpoint of the daemon to the file.
+	CheckpointFile(path string) error
+
+	// CheckpointNamed writes the checkpoint of the daemon to the file of
+	// the name in its checkpoint directory. See SetCheckpointDir.
+	CheckpointNamed(name string) error
+
+	// Metrics returns a snapshot of the daemon's metrics.
+	Metrics() MetricsSnapshot
+
+	// Liveness returns an error if the daemon's event loops have stalled.
+	Liveness() error
+
+	// Health returns an error if the daemon is not live or if any running
+	// service implementing HealthChecker reports a problem.
+	Health() error
+
+	// Readiness returns an error until all services have been initialized
+	// and the services implementing ReadinessChecker report ready.
+	Readiness() error
+
+	// Err returns a channel for the unrecoverable failures inside the
+	// daemon, e.g. a service that panics when initialized or handling an
+	// event. Such failures are reported as *ServiceError. The channel is
+	// closed when the daemon has shut down.
+	Err() <-chan error
+
+	// Ready returns a channel that is closed when all services have been
+	// initialized.
+	Ready() <-chan struct{}
+
+	// Stopping returns a channel that is closed when the daemon starts
+	// shutting down.
+	Stopping() <-chan struct{}
+
+	// Done returns a channel that is closed when the daemon has shut down.
+	Done() <-chan struct{}
+
+	// Shutdown stops all services and the daemon. New events are rejected
+	// with ErrDaemonStopped and the events already emitted are delivered
+	// before the services are shut down in reverse dependency order.
 	Shutdown()
 }
 
 // ServiceHandle contains the set of operations common to all services.
 type ServiceHandle interface {
-	EmitEvent(eventType EventType, data interface{})
+	// EmitEvent emits an event to the subscribers of the event type.
+	// Returns ErrDaemonStopped if the daemon is shutting down.
+	EmitEvent(eventType EventType, data interface{}) error
+
+	// EmitEventContext emits an event as part of the operation carried by
+	// the context, e.g. as a consequence of handling another event, in which
+	// case the context is the one returned by Event.Context().
+	EmitEventContext(ctx context.Context, eventType EventType, data interface{}) error
+
+	// Go runs 'f' in a new goroutine on behalf of the service. The goroutine
+	// is labeled with the service name for profiling and the labels are
+	// carried in 'ctx'. 'ctx' is cancelled when the service is shut down,
+	// after which the goroutine is expected to exit.
+	Go(f func(ctx context.Context))
+
 	Unregister()
 }
 
@@ -75,6 +243,17 @@ type Event interface {
 	// The event type
 	EventType() EventType
 
+	// Timestamp is the time the event was emitted.
+	Timestamp() time.Time
+
 	// Data, if any, associated with the event.
 	Data() interface{}
+
+	// Context carries the trace of the event. See Tracer.
+	Context() context.Context
+
+	// Sequence is the daemon-wide sequence number of the event, in the
+	// order in which the events are routed, or zero if not routed. See
+	// ExampleEvent.Sequence.
+	Sequence() uint64
 }
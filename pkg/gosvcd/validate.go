@@ -0,0 +1,66 @@
+package gosvcd
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Validator validates the payload of an event. Validators are registered
+// per event type with the builder's SetValidator and run at emit time, so
+// that malformed payloads are rejected at the source.
+type Validator func(data interface{}) error
+
+// ErrInvalidPayload is matched by the errors returned from emitting an
+// event with a payload rejected by its validator.
+var ErrInvalidPayload = errors.New("invalid event payload")
+
+// ValidationError is returned when an event payload fails validation.
+type ValidationError struct {
+	Source    ServiceId
+	EventType EventType
+	Err       error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrInvalidPayload, e.EventType, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+func (e *ValidationError) Is(target error) bool { return target == ErrInvalidPayload }
+
+// TypeValidator returns a validator accepting payloads with the same type
+// as the example value, e.g. TypeValidator(&ExSomeEvent{}).
+func TypeValidator(prototype interface{}) Validator {
+	want := reflect.TypeOf(prototype)
+	return func(data interface{}) error {
+		if got := reflect.TypeOf(data); got != want {
+			return fmt.Errorf("payload type %v, expected %v", got, want)
+		}
+		return nil
+	}
+}
+
+// validate runs the validator of the event type. A panicking validator
+// rejects the payload.
+func (d *ExampleServiceDaemon) validate(source ServiceId, rt ResolvedType, data interface{}) error {
+	eventType := rt.typ
+	validator := d.validators[eventType]
+	if rt.info != nil {
+		validator = rt.info.validator
+	}
+	if validator == nil {
+		return nil
+	}
+	var err error
+	if perr := safeCall(func() { err = validator(data) }); perr != nil {
+		err = perr
+	}
+	if err == nil {
+		return nil
+	}
+	d.metrics.eventDropped(DropInvalidPayload, eventType)
+	d.log.Warn("Rejected invalid event payload", "source", d.serviceName(source), "event_type", eventType, "error", err)
+	return &ValidationError{Source: source, EventType: eventType, Err: err}
+}
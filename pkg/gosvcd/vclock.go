@@ -0,0 +1,141 @@
+package gosvcd
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VectorClock is the causal history of an event in a federated deployment:
+// the number of events sent by each node, identified by its bridge origin,
+// that the event follows. The clocks are attached to the events by the
+// bridges, see package wire, and carried by the context of the events, so
+// that the events emitted with the context of an event from another node
+// follow it. Comparing the clocks of two events tells whether one causally
+// precedes the other, or whether they are concurrent, e.g. conflicting
+// updates of the same entity made on different nodes.
+//
+// The clocks are not modified once attached to an event. Merge returns a
+// new clock.
+type VectorClock map[string]uint64
+
+// Causality is the causal order of two vector clocks.
+type Causality int
+
+const (
+	// CausalEqual is the order of clocks with the same history.
+	CausalEqual Causality = iota
+
+	// CausalBefore is the order of a clock preceding the other.
+	CausalBefore
+
+	// CausalAfter is the order of a clock following the other.
+	CausalAfter
+
+	// CausalConcurrent is the order of clocks neither preceding the
+	// other.
+	CausalConcurrent
+)
+
+func (c Causality) String() string {
+	switch c {
+	case CausalEqual:
+		return "equal"
+	case CausalBefore:
+		return "before"
+	case CausalAfter:
+		return "after"
+	case CausalConcurrent:
+		return "concurrent"
+	}
+	return "unknown"
+}
+
+// Compare returns the order of the clock relative to 'other'.
+func (vc VectorClock) Compare(other VectorClock) Causality {
+	before, after := false, false
+	for node, n := range vc {
+		if m := other[node]; n > m {
+			after = true
+		} else if n < m {
+			before = true
+		}
+	}
+	for node, m := range other {
+		if _, ok := vc[node]; !ok && m > 0 {
+			before = true
+		}
+	}
+	switch {
+	case before && after:
+		return CausalConcurrent
+	case before:
+		return CausalBefore
+	case after:
+		return CausalAfter
+	}
+	return CausalEqual
+}
+
+// Concurrent returns true if neither clock precedes the other, i.e. the
+// events were emitted without knowledge of each other.
+func (vc VectorClock) Concurrent(other VectorClock) bool {
+	return vc.Compare(other) == CausalConcurrent
+}
+
+// Merge returns the clock following both clocks, with the greater count
+// of each node.
+func (vc VectorClock) Merge(other VectorClock) VectorClock {
+	merged := make(VectorClock, len(vc)+len(other))
+	for node, n := range vc {
+		merged[node] = n
+	}
+	for node, n := range other {
+		if n > merged[node] {
+			merged[node] = n
+		}
+	}
+	return merged
+}
+
+// String returns the clock as comma separated node:count pairs ordered by
+// the node.
+func (vc VectorClock) String() string {
+	nodes := make([]string, 0, len(vc))
+	for node := range vc {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	var b strings.Builder
+	for i, node := range nodes {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(node)
+		b.WriteByte(':')
+		b.WriteString(strconv.FormatUint(vc[node], 10))
+	}
+	return b.String()
+}
+
+type vectorClockCtx struct{}
+
+// WithVectorClock returns a context for emitting an event with the vector
+// clock. Used by the bridges to carry the clocks across processes. Unlike
+// the reply address, the clock is inherited by the events emitted with the
+// context of the event.
+func WithVectorClock(ctx context.Context, vc VectorClock) context.Context {
+	return context.WithValue(ctx, vectorClockCtx{}, vc)
+}
+
+// EventClock returns the vector clock of the event, or nil if it has none,
+// e.g. if it did not follow an event from another node.
+func EventClock(ev Event) VectorClock {
+	ctx := ev.Context()
+	if ctx == nil {
+		return nil
+	}
+	vc, _ := ctx.Value(vectorClockCtx{}).(VectorClock)
+	return vc
+}
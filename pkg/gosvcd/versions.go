@@ -0,0 +1,204 @@
+package gosvcd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Event types can be versioned with a ".vN" suffix, e.g. "ExSomeEvent.v2",
+// with the unsuffixed type being version 1. The daemon delivers an event to
+// the subscribers of the other versions of its type by converting the
+// payload with the registered converters, so that the producers and the
+// consumers of an event can be upgraded independently.
+
+// Versioned returns the version 'v' of the event type.
+func (t EventType) Versioned(v int) EventType {
+	base := t.Base()
+	if v <= 1 {
+		return base
+	}
+	return EventType(fmt.Sprintf("%s.v%d", base, v))
+}
+
+// Base returns the event type without the version suffix.
+func (t EventType) Base() EventType {
+	base, _ := t.split()
+	return base
+}
+
+// Version returns the version of the event type.
+func (t EventType) Version() int {
+	_, v := t.split()
+	return v
+}
+
+func (t EventType) split() (EventType, int) {
+	s := string(t)
+	i := strings.LastIndex(s, ".v")
+	if i < 0 {
+		return t, 1
+	}
+	v, err := strconv.Atoi(s[i+2:])
+	if err != nil || v < 1 || strings.HasPrefix(s[i+2:], "+") {
+		return t, 1
+	}
+	return EventType(s[:i]), v
+}
+
+// Converter converts the payload of an event to the payload of another
+// version of the event type.
+type Converter func(data interface{}) (interface{}, error)
+
+// ErrNoConverter is returned when there is no chain of converters between
+// the versions of an event type.
+var ErrNoConverter = errors.New("no converter")
+
+// ConverterRegistry holds the converters between the versions of event
+// types. Conversions between versions without a direct converter are done
+// by chaining the converters, e.g. v1 to v2 and v2 to v3.
+type ConverterRegistry struct {
+	mu    sync.RWMutex
+	edges map[EventType]map[EventType]Converter
+}
+
+// NewConverterRegistry returns an empty registry.
+func NewConverterRegistry() *ConverterRegistry {
+	return &ConverterRegistry{edges: map[EventType]map[EventType]Converter{}}
+}
+
+// Converters is the default registry used by the daemon and for reading
+// persisted events.
+var Converters = NewConverterRegistry()
+
+// RegisterConverter registers the converter between the versions of an
+// event type in the default registry.
+func RegisterConverter(from, to EventType, c Converter) {
+	Converters.Register(from, to, c)
+}
+
+// Register registers the converter from one version of an event type to
+// another, e.g. an up converter from "ExSomeEvent" to "ExSomeEvent.v2"
+// and a down converter back.
+func (r *ConverterRegistry) Register(from, to EventType, c Converter) {
+	if from.Base() != to.Base() {
+		panic(fmt.Sprintf("converter between different event types %s and %s", from, to))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.edges[from] == nil {
+		r.edges[from] = map[EventType]Converter{}
+	}
+	r.edges[from][to] = c
+}
+
+// Convert converts the payload from one version of the event type to
+// another through the shortest chain of converters.
+func (r *ConverterRegistry) Convert(from, to EventType, data interface{}) (interface{}, error) {
+	if from == to {
+		return data, nil
+	}
+	path := r.path(from, to)
+	if path == nil {
+		return nil, fmt.Errorf("%w from %s to %s", ErrNoConverter, from, to)
+	}
+	for _, c := range path {
+		var err error
+		if data, err = c(data); err != nil {
+			return nil, fmt.Errorf("convert %s to %s: %w", from, to, err)
+		}
+	}
+	return data, nil
+}
+
+// path returns the shortest chain of converters with a breadth-first search.
+func (r *ConverterRegistry) path(from, to EventType) []Converter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	type step struct {
+		prev EventType
+		c    Converter
+	}
+	visited := map[EventType]step{from: {}}
+	queue := []EventType{from}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		if t == to {
+			var path []Converter
+			for t != from {
+				s := visited[t]
+				path = append([]Converter{s.c}, path...)
+				t = s.prev
+			}
+			return path
+		}
+		for next, c := range r.edges[t] {
+			if _, ok := visited[next]; !ok {
+				visited[next] = step{t, c}
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// versionIndex maps the base event types to their subscribed versions.
+type versionIndex map[EventType][]EventType
+
+func newVersionIndex(subscribed map[EventType][]Service) versionIndex {
+	idx := versionIndex{}
+	for typ := range subscribed {
+		base := typ.Base()
+		idx[base] = append(idx[base], typ)
+	}
+	for base, typs := range idx {
+		if len(typs) == 1 && typs[0] == base {
+			delete(idx, base)
+		}
+	}
+	return idx
+}
+
+// convertVersions returns the event converted to the other subscribed
+// versions of its type.
+func (d *ExampleServiceDaemon) convertVersions(ev *ExampleEvent) []*ExampleEvent {
+	var evs []*ExampleEvent
+	for _, typ := range d.versions[ev.eventType.Base()] {
+		if typ == ev.eventType {
+			continue
+		}
+		data, err := Converters.Convert(ev.eventType, typ, ev.data)
+		if err != nil {
+			d.metrics.eventDropped(DropConversionFailed, typ)
+			if errors.Is(err, ErrNoConverter) {
+				d.log.Debug("No converter for event", "event_type", ev.eventType, "to", typ)
+			} else {
+				d.log.Warn("Failed to convert event", "event_type", ev.eventType, "to", typ, "error", err)
+			}
+			continue
+		}
+		cev := &ExampleEvent{
+			source:      ev.source,
+			eventType:   typ,
+			data:        data,
+			timestamp:   ev.timestamp,
+			ctx:         ev.ctx,
+			key:         ev.key,
+			expires:     ev.expires,
+			cancel:      ev.cancel,
+			routed:      ev.routed,
+			target:      ev.target,
+			directed:    ev.directed,
+			replyTo:     ev.replyTo,
+			correlation: ev.correlation,
+		}
+		if ev.delivery != nil {
+			ev.delivery.follow(cev)
+		}
+		evs = append(evs, cev)
+	}
+	return evs
+}
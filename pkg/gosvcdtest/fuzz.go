@@ -0,0 +1,269 @@
+// Package gosvcdtest provides utilities for testing gosvcd daemons and
+// services.
+package gosvcdtest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// Limits of the daemons generated by Fuzz.
+const (
+	fuzzMaxServices = 8
+	fuzzTypes       = 4
+	fuzzMaxEvents   = 256
+)
+
+// FuzzTimeout limits the time Fuzz waits for the events to be delivered
+// and for the daemon to shut down before reporting a deadlock.
+var FuzzTimeout = 5 * time.Second
+
+var fuzzTypeNames = func() []gosvcd.EventType {
+	types := make([]gosvcd.EventType, fuzzTypes)
+	for i := range types {
+		types[i] = gosvcd.EventType(fmt.Sprintf("Fuzz%d", i))
+	}
+	return types
+}()
+
+// FuzzEvent is the payload of the events emitted by Fuzz.
+type FuzzEvent struct {
+	// Emitter is the service that emitted the event, or
+	// DaemonServiceId.
+	Emitter gosvcd.ServiceId
+
+	// Seq numbers the events of the type emitted by the emitter.
+	Seq int
+}
+
+// Fuzz runs a daemon generated from the data: the services, their
+// dependencies and subscriptions, the dispatch mode and the events to
+// emit. The services re-emit some of the events they receive. Fuzz
+// returns an error if an event is lost, if the events of a type from an
+// emitter are delivered out of order, or if the delivery or the shutdown
+// deadlocks. A panic in the daemon crashes the fuzzing process. Fuzz is
+// called from the FuzzDispatch fuzz target of the package:
+//
+//	go test -run XXX -fuzz FuzzDispatch ./pkg/gosvcdtest
+func Fuzz(data []byte) error {
+	r := &fuzzReader{data: data}
+	f := &fuzzRun{}
+
+	b := gosvcd.NewBuilder()
+	b.SetLogger(gosvcd.NewTextLogger(ioutil.Discard, gosvcd.LevelError))
+	b.SetDispatchMode(gosvcd.DispatchMode(r.next()%3), 1+int(r.next()%4))
+	b.SetEventPooling(r.next()&1 == 1)
+
+	n := 1 + int(r.next())%fuzzMaxServices
+	for i := 1; i <= n; i++ {
+		svc := &fuzzService{f: f, id: gosvcd.ServiceId(i), last: make(map[fuzzKey]int), seqs: make(map[gosvcd.EventType]int)}
+		for j := 1; j < i; j++ {
+			if r.next()&1 == 1 {
+				svc.deps = append(svc.deps, gosvcd.ServiceId(j))
+			}
+		}
+		mask := r.next()
+		for t, typ := range fuzzTypeNames {
+			if mask&(1<<uint(t)) != 0 {
+				svc.subs = append(svc.subs, typ)
+			}
+		}
+		if re := int(r.next() % (fuzzTypes + 1)); re < fuzzTypes {
+			svc.reemit = fuzzTypeNames[re]
+		}
+		f.services = append(f.services, svc)
+		b.Register(svc)
+	}
+
+	d := b.Start()
+	select {
+	case <-d.Ready():
+	case <-time.After(FuzzTimeout):
+		go d.Shutdown()
+		return fmt.Errorf("deadlock: daemon not ready after %s", FuzzTimeout)
+	}
+
+	// Emit the events from the daemon, counting the deliveries expected
+	// for each service and type. The events are emitted in the
+	// background, as emitting blocks if the daemon deadlocks.
+	var events []gosvcd.EventType
+	for i := 0; i < fuzzMaxEvents && r.more(); i++ {
+		events = append(events, fuzzTypeNames[int(r.next())%fuzzTypes])
+	}
+	expected := make(map[fuzzKey]int)
+	for _, typ := range events {
+		for _, svc := range f.services {
+			if svc.subscribes(typ) {
+				expected[fuzzKey{svc.id, typ}]++
+			}
+		}
+	}
+	emitted := make(chan error, 1)
+	go func() {
+		seqs := make(map[gosvcd.EventType]int)
+		for _, typ := range events {
+			seqs[typ]++
+			if err := d.EmitEvent(typ, &FuzzEvent{Emitter: gosvcd.DaemonServiceId, Seq: seqs[typ]}); err != nil {
+				emitted <- fmt.Errorf("emit %s: %w", typ, err)
+				return
+			}
+		}
+		emitted <- nil
+	}()
+
+	deadline := time.After(FuzzTimeout)
+	select {
+	case err := <-emitted:
+		if err != nil {
+			go d.Shutdown()
+			return err
+		}
+	case <-deadline:
+		go d.Shutdown()
+		return fmt.Errorf("deadlock: events not emitted after %s", FuzzTimeout)
+	}
+	for !f.received(expected) {
+		select {
+		case <-deadline:
+			go d.Shutdown()
+			return fmt.Errorf("deadlock: events not delivered after %s", FuzzTimeout)
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	// Shutdown waits for the daemon to stop, so it is called in the
+	// background to detect a deadlock.
+	go d.Shutdown()
+	select {
+	case <-d.Done():
+	case <-time.After(FuzzTimeout):
+		return fmt.Errorf("deadlock: daemon not shut down after %s", FuzzTimeout)
+	}
+	return f.err()
+}
+
+type fuzzKey struct {
+	id  gosvcd.ServiceId
+	typ gosvcd.EventType
+}
+
+type fuzzRun struct {
+	services []*fuzzService
+
+	mu     sync.Mutex
+	counts map[fuzzKey]int
+	errs   []error
+}
+
+func (f *fuzzRun) record(id gosvcd.ServiceId, typ gosvcd.EventType, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		f.errs = append(f.errs, err)
+		return
+	}
+	if f.counts == nil {
+		f.counts = make(map[fuzzKey]int)
+	}
+	f.counts[fuzzKey{id, typ}]++
+}
+
+// received returns true if the services have received the expected
+// numbers of the events emitted by the daemon.
+func (f *fuzzRun) received(expected map[fuzzKey]int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k, n := range expected {
+		if f.counts[k] < n {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fuzzRun) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.errs) > 0 {
+		return f.errs[0]
+	}
+	return nil
+}
+
+type fuzzService struct {
+	f      *fuzzRun
+	id     gosvcd.ServiceId
+	deps   []gosvcd.ServiceId
+	subs   []gosvcd.EventType
+	reemit gosvcd.EventType
+
+	handle gosvcd.ServiceHandle
+
+	// last is the sequence number of the last event received by emitter
+	// and type, and seqs the sequence numbers of the re-emitted events.
+	// Only accessed by the handler.
+	last map[fuzzKey]int
+	seqs map[gosvcd.EventType]int
+}
+
+func (s *fuzzService) ID() gosvcd.ServiceId              { return s.id }
+func (s *fuzzService) Name() string                      { return fmt.Sprintf("fuzz-%d", s.id) }
+func (s *fuzzService) Dependencies() []gosvcd.ServiceId  { return s.deps }
+func (s *fuzzService) Subscriptions() []gosvcd.EventType { return s.subs }
+func (s *fuzzService) Init(handle gosvcd.ServiceHandle)  { s.handle = handle }
+func (s *fuzzService) Shutdown()                         {}
+
+func (s *fuzzService) subscribes(typ gosvcd.EventType) bool {
+	for _, sub := range s.subs {
+		if sub == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fuzzService) HandleEvent(ev gosvcd.Event) {
+	fe, ok := ev.Data().(*FuzzEvent)
+	if !ok {
+		s.f.record(s.id, ev.EventType(), fmt.Errorf("service %d: unexpected payload %T of %s", s.id, ev.Data(), ev.EventType()))
+		return
+	}
+	k := fuzzKey{fe.Emitter, ev.EventType()}
+	if last := s.last[k]; fe.Seq <= last {
+		s.f.record(s.id, ev.EventType(), fmt.Errorf("service %d: %s #%d from %d delivered after #%d",
+			s.id, ev.EventType(), fe.Seq, fe.Emitter, last))
+	}
+	s.last[k] = fe.Seq
+	if fe.Emitter != gosvcd.DaemonServiceId {
+		return
+	}
+	s.f.record(s.id, ev.EventType(), nil)
+	if s.reemit != "" {
+		s.seqs[s.reemit]++
+		if err := s.handle.EmitEvent(s.reemit, &FuzzEvent{Emitter: s.id, Seq: s.seqs[s.reemit]}); err != nil && !errors.Is(err, gosvcd.ErrDaemonStopped) {
+			s.f.record(s.id, s.reemit, fmt.Errorf("service %d: emit %s: %w", s.id, s.reemit, err))
+		}
+	}
+}
+
+// fuzzReader reads the fuzzing data, returning zeros after its end.
+type fuzzReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *fuzzReader) more() bool { return r.pos < len(r.data) }
+
+func (r *fuzzReader) next() byte {
+	if r.pos >= len(r.data) {
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
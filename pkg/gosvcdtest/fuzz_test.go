@@ -0,0 +1,33 @@
+//go:build go1.18
+// +build go1.18
+
+package gosvcdtest
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func FuzzDispatch(f *testing.F) {
+	// A handler re-emitting into the queue it is dispatched from, with
+	// enough events to fill the queues.
+	reemit := []byte{0, 1, 0, 2, 0x1, 0, 0x3, 1}
+	for i := 0; i < 200; i++ {
+		reemit = append(reemit, byte(i%2))
+	}
+	f.Add(reemit)
+	f.Add([]byte{})
+	f.Add([]byte{1, 2, 1, 7, 1, 0xf, 2, 1, 0xf, 1, 0, 0xf, 0, 1, 2, 3})
+	f.Add([]byte{2, 4, 0, 4, 1, 1, 3, 0, 1, 5, 1, 1, 7, 2, 0, 1, 2, 3, 0, 1, 2, 3})
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 16; i++ {
+		data := make([]byte, 16+rng.Intn(300))
+		rng.Read(data)
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := Fuzz(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
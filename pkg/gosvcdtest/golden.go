@@ -0,0 +1,272 @@
+package gosvcdtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// maxDiffs limits the differences listed by the error of CheckGolden.
+const maxDiffs = 10
+
+// TraceEvent is an event of a trace. The payload is encoded with the codec
+// registered for the event type in gosvcd.Payloads.
+type TraceEvent struct {
+	Source   gosvcd.ServiceId `json:"source"`
+	Type     gosvcd.EventType `json:"type"`
+	Encoding string           `json:"encoding,omitempty"`
+	Data     json.RawMessage  `json:"data"`
+}
+
+func (ev *TraceEvent) String() string {
+	return fmt.Sprintf("%s from %d: %s", ev.Type, ev.Source, ev.Data)
+}
+
+// Trace is a sequence of events, e.g. recorded by a TraceRecorder.
+type Trace []TraceEvent
+
+// Normalizer normalizes an event of a trace before comparing it, e.g. to
+// blank out the timestamps and other values that differ between runs.
+type Normalizer func(ev *TraceEvent)
+
+// NormalizeFields returns a normalizer replacing the values of the fields
+// with the given names in the JSON payloads, at any depth, with null.
+func NormalizeFields(names ...string) Normalizer {
+	blank := make(map[string]bool, len(names))
+	for _, name := range names {
+		blank[name] = true
+	}
+	var normalize func(v interface{}) interface{}
+	normalize = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, x := range v {
+				if blank[k] {
+					v[k] = nil
+				} else {
+					v[k] = normalize(x)
+				}
+			}
+		case []interface{}:
+			for i, x := range v {
+				v[i] = normalize(x)
+			}
+		}
+		return v
+	}
+	return func(ev *TraceEvent) {
+		if ev.Encoding != "" {
+			return
+		}
+		var v interface{}
+		if err := json.Unmarshal(ev.Data, &v); err != nil {
+			return
+		}
+		if data, err := json.Marshal(normalize(v)); err == nil {
+			ev.Data = data
+		}
+	}
+}
+
+// Canonical returns the normalized trace in the canonical order: by event
+// type and source, keeping the order of the events of a type from a
+// source. The order of the events of different types or sources depends
+// on the scheduling and is not compared.
+func (t Trace) Canonical(normalize ...Normalizer) Trace {
+	c := make(Trace, len(t))
+	for i, ev := range t {
+		ev.Data = append(json.RawMessage(nil), ev.Data...)
+		for _, n := range normalize {
+			n(&ev)
+		}
+		c[i] = ev
+	}
+	sort.SliceStable(c, func(i, j int) bool {
+		if c[i].Type != c[j].Type {
+			return c[i].Type < c[j].Type
+		}
+		return c[i].Source < c[j].Source
+	})
+	return c
+}
+
+// ReadTrace reads the trace written with Write.
+func ReadTrace(path string) (Trace, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Trace
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("decode trace %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// Write writes the trace to the file as indented JSON.
+func (t Trace) Write(path string) error {
+	if t == nil {
+		t = Trace{}
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Diff returns the differences of the canonical forms of the traces, one
+// per line.
+func Diff(golden, got Trace, normalize ...Normalizer) []string {
+	golden, got = golden.Canonical(normalize...), got.Canonical(normalize...)
+	var diffs []string
+	n := len(golden)
+	if len(got) > n {
+		n = len(got)
+	}
+	for i := 0; i < n; i++ {
+		switch {
+		case i >= len(got):
+			diffs = append(diffs, fmt.Sprintf("missing: %s", &golden[i]))
+		case i >= len(golden):
+			diffs = append(diffs, fmt.Sprintf("unexpected: %s", &got[i]))
+		case !sameEvent(&golden[i], &got[i]):
+			diffs = append(diffs, fmt.Sprintf("golden: %s\n   got: %s", &golden[i], &got[i]))
+		}
+	}
+	return diffs
+}
+
+func sameEvent(a, b *TraceEvent) bool {
+	if a.Source != b.Source || a.Type != b.Type || a.Encoding != b.Encoding {
+		return false
+	}
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a.Data) != nil || json.Compact(&cb, b.Data) != nil {
+		return bytes.Equal(a.Data, b.Data)
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// CheckGolden compares the trace with the golden trace in the file. If
+// 'update' is true or the file does not exist, the canonical form of the
+// trace is written to the file instead. Tests usually pass the value of
+// an -update flag.
+func CheckGolden(path string, got Trace, update bool, normalize ...Normalizer) error {
+	golden, err := ReadTrace(path)
+	if update || errors.Is(err, os.ErrNotExist) {
+		return got.Canonical(normalize...).Write(path)
+	}
+	if err != nil {
+		return err
+	}
+	diffs := Diff(golden, got, normalize...)
+	if len(diffs) == 0 {
+		return nil
+	}
+	more := ""
+	if len(diffs) > maxDiffs {
+		more = fmt.Sprintf("\n(%d more)", len(diffs)-maxDiffs)
+		diffs = diffs[:maxDiffs]
+	}
+	return fmt.Errorf("trace differs from %s:\n%s%s", path, strings.Join(diffs, "\n"), more)
+}
+
+// Replay emits the events of the trace from the given sources, or from
+// DaemonServiceId if none are given, into the daemon, in the order of
+// the trace. Replaying the inputs of a recorded run reproduces the run.
+// The payloads are decoded with gosvcd.Payloads, into the registered
+// payload types.
+func Replay(d gosvcd.ServiceDaemon, t Trace, sources ...gosvcd.ServiceId) error {
+	if len(sources) == 0 {
+		sources = []gosvcd.ServiceId{gosvcd.DaemonServiceId}
+	}
+	replayed := make(map[gosvcd.ServiceId]bool, len(sources))
+	for _, id := range sources {
+		replayed[id] = true
+	}
+	for i := range t {
+		ev := &t[i]
+		if !replayed[ev.Source] {
+			continue
+		}
+		data := []byte(ev.Data)
+		if ev.Encoding != "" {
+			if err := json.Unmarshal(ev.Data, &data); err != nil {
+				return fmt.Errorf("decode %s: %w", ev, err)
+			}
+		}
+		v, err := gosvcd.Payloads.Decode(ev.Type, ev.Encoding, data)
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", ev, err)
+		}
+		if err := d.EmitEvent(ev.Type, v); err != nil {
+			return fmt.Errorf("emit %s: %w", ev, err)
+		}
+	}
+	return nil
+}
+
+// TraceRecorder is a service recording the events of the types it is
+// subscribed to, in the order they are delivered to it.
+type TraceRecorder struct {
+	id    gosvcd.ServiceId
+	types []gosvcd.EventType
+
+	mu    sync.Mutex
+	trace Trace
+	err   error
+}
+
+// NewTraceRecorder returns the recorder of the event types, to register
+// with the daemon under test.
+func NewTraceRecorder(id gosvcd.ServiceId, types ...gosvcd.EventType) *TraceRecorder {
+	return &TraceRecorder{id: id, types: types}
+}
+
+func (r *TraceRecorder) ID() gosvcd.ServiceId              { return r.id }
+func (r *TraceRecorder) Name() string                      { return "trace-recorder" }
+func (r *TraceRecorder) Dependencies() []gosvcd.ServiceId  { return nil }
+func (r *TraceRecorder) Subscriptions() []gosvcd.EventType { return r.types }
+func (r *TraceRecorder) Init(gosvcd.ServiceHandle)         {}
+func (r *TraceRecorder) Shutdown()                         {}
+
+func (r *TraceRecorder) HandleEvent(ev gosvcd.Event) {
+	encoding, data, err := gosvcd.Payloads.Encode(ev.EventType(), ev.Data())
+	if err == nil && encoding != gosvcd.JSONCodec.Name() {
+		// Binary payloads are stored as base64 strings.
+		data, err = json.Marshal(data)
+	} else {
+		encoding = ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if r.err == nil {
+			r.err = fmt.Errorf("encode %s payload: %w", ev.EventType(), err)
+		}
+		return
+	}
+	r.trace = append(r.trace, TraceEvent{
+		Source:   ev.ServiceId(),
+		Type:     ev.EventType(),
+		Encoding: encoding,
+		Data:     data,
+	})
+}
+
+// Trace returns the recorded events, or the first error encoding their
+// payloads.
+func (r *TraceRecorder) Trace() (Trace, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(Trace(nil), r.trace...), r.err
+}
@@ -0,0 +1,294 @@
+package gosvcdtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// StartFunc starts a daemon with the services, e.g. a custom
+// implementation of ServiceDaemon under test.
+type StartFunc func(svcs []gosvcd.Service) gosvcd.ServiceDaemon
+
+// StartExample starts the daemon of package gosvcd with the services.
+func StartExample(svcs []gosvcd.Service) gosvcd.ServiceDaemon {
+	b := gosvcd.NewBuilder()
+	b.SetLogger(gosvcd.NewTextLogger(ioutil.Discard, gosvcd.LevelError))
+	for _, svc := range svcs {
+		b.Register(svc)
+	}
+	return b.Start()
+}
+
+// InvariantTimeout limits the time CheckInvariants waits for the daemon
+// to become ready, to deliver the events and to shut down.
+var InvariantTimeout = 5 * time.Second
+
+// CheckInvariants starts a daemon with the services and checks that:
+//
+//   - each service is initialized after its dependencies, and
+//   - each of the emitted events is delivered to every subscriber of its
+//     type, after it has been delivered to the subscribers the
+//     subscriber depends on.
+//
+// The services are wrapped for recording the initializations and the
+// deliveries, and the calls are passed on to them. The wrappers hide
+// the optional interfaces of the services, such as HealthChecker. The
+// events are emitted by the daemon in order, with an *InvariantEvent as
+// the payload, and the daemon is shut down before returning.
+func CheckInvariants(start StartFunc, svcs []gosvcd.Service, events []gosvcd.EventType) error {
+	rec := &recorder{inits: make(map[gosvcd.ServiceId]int)}
+	wrapped := make([]gosvcd.Service, len(svcs))
+	byID := make(map[gosvcd.ServiceId]gosvcd.Service, len(svcs))
+	for i, svc := range svcs {
+		wrapped[i] = &recordingService{Service: svc, rec: rec}
+		byID[svc.ID()] = svc
+	}
+
+	d := start(wrapped)
+	defer func() { go d.Shutdown() }()
+	select {
+	case <-d.Ready():
+	case <-time.After(InvariantTimeout):
+		return fmt.Errorf("daemon not ready after %s", InvariantTimeout)
+	}
+
+	for _, svc := range svcs {
+		n, ok := rec.initOrder(svc.ID())
+		if !ok {
+			return fmt.Errorf("service %d not initialized", svc.ID())
+		}
+		for _, dep := range svc.Dependencies() {
+			if _, ok := byID[dep]; !ok {
+				continue
+			}
+			m, ok := rec.initOrder(dep)
+			if !ok || m > n {
+				return fmt.Errorf("service %d initialized before its dependency %d", svc.ID(), dep)
+			}
+		}
+	}
+
+	expected := 0
+	for i, typ := range events {
+		for _, svc := range svcs {
+			if subscribes(svc, typ) {
+				expected++
+			}
+		}
+		if err := d.EmitEvent(typ, &InvariantEvent{Seq: i + 1}); err != nil {
+			return fmt.Errorf("emit %s: %w", typ, err)
+		}
+	}
+	deadline := time.Now().Add(InvariantTimeout)
+	for rec.delivered() < expected {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d of %d deliveries after %s", rec.delivered(), expected, InvariantTimeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	deps := transitiveDeps(svcs)
+	for i, typ := range events {
+		seq := i + 1
+		for _, svc := range svcs {
+			if !subscribes(svc, typ) {
+				continue
+			}
+			n, ok := rec.deliveryOrder(svc.ID(), seq)
+			if !ok {
+				return fmt.Errorf("%s #%d not delivered to service %d", typ, seq, svc.ID())
+			}
+			for dep := range deps[svc.ID()] {
+				if !subscribes(byID[dep], typ) {
+					continue
+				}
+				if m, ok := rec.deliveryOrder(dep, seq); !ok || m > n {
+					return fmt.Errorf("%s #%d delivered to service %d before its dependency %d", typ, seq, svc.ID(), dep)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// CheckRandomInvariants checks the invariants of CheckInvariants with
+// 'runs' random service graphs of up to 'maxServices' services, and
+// random events. The graphs are generated from the seed, which is
+// included in the returned error for repeating the run.
+func CheckRandomInvariants(start StartFunc, seed int64, runs, maxServices int) error {
+	rng := rand.New(rand.NewSource(seed))
+	for run := 0; run < runs; run++ {
+		svcs := RandomGraph(rng, 1+rng.Intn(maxServices))
+		events := make([]gosvcd.EventType, rng.Intn(64))
+		for i := range events {
+			events[i] = randomTypes[rng.Intn(len(randomTypes))]
+		}
+		if err := CheckInvariants(start, svcs, events); err != nil {
+			return fmt.Errorf("seed %d, run %d: %w", seed, run, err)
+		}
+	}
+	return nil
+}
+
+var randomTypes = []gosvcd.EventType{"Random0", "Random1", "Random2", "Random3"}
+
+// RandomGraph returns 'n' services with random dependencies and
+// subscriptions. The services do nothing.
+func RandomGraph(rng *rand.Rand, n int) []gosvcd.Service {
+	svcs := make([]gosvcd.Service, n)
+	for i := range svcs {
+		svc := &randomService{id: gosvcd.ServiceId(i + 1)}
+		for j := 0; j < i; j++ {
+			if rng.Intn(3) == 0 {
+				svc.deps = append(svc.deps, gosvcd.ServiceId(j+1))
+			}
+		}
+		for _, typ := range randomTypes {
+			if rng.Intn(2) == 0 {
+				svc.subs = append(svc.subs, typ)
+			}
+		}
+		svcs[i] = svc
+	}
+	// Register in random order.
+	rng.Shuffle(n, func(i, j int) { svcs[i], svcs[j] = svcs[j], svcs[i] })
+	return svcs
+}
+
+type randomService struct {
+	id   gosvcd.ServiceId
+	deps []gosvcd.ServiceId
+	subs []gosvcd.EventType
+}
+
+func (s *randomService) ID() gosvcd.ServiceId              { return s.id }
+func (s *randomService) Name() string                      { return fmt.Sprintf("random-%d", s.id) }
+func (s *randomService) Dependencies() []gosvcd.ServiceId  { return s.deps }
+func (s *randomService) Subscriptions() []gosvcd.EventType { return s.subs }
+func (s *randomService) Init(gosvcd.ServiceHandle)         {}
+func (s *randomService) HandleEvent(gosvcd.Event)          {}
+func (s *randomService) Shutdown()                         {}
+
+// InvariantEvent is the payload of the events emitted by CheckInvariants.
+type InvariantEvent struct {
+	Seq int
+}
+
+// recorder records the order of the initializations and the deliveries.
+type recorder struct {
+	mu         sync.Mutex
+	n          int
+	inits      map[gosvcd.ServiceId]int
+	deliveries map[deliveryKey]int
+}
+
+type deliveryKey struct {
+	id  gosvcd.ServiceId
+	seq int
+}
+
+func (r *recorder) init(id gosvcd.ServiceId) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n++
+	r.inits[id] = r.n
+}
+
+func (r *recorder) deliver(id gosvcd.ServiceId, seq int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.deliveries == nil {
+		r.deliveries = make(map[deliveryKey]int)
+	}
+	r.n++
+	r.deliveries[deliveryKey{id, seq}] = r.n
+}
+
+func (r *recorder) initOrder(id gosvcd.ServiceId) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, ok := r.inits[id]
+	return n, ok
+}
+
+func (r *recorder) deliveryOrder(id gosvcd.ServiceId, seq int) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n, ok := r.deliveries[deliveryKey{id, seq}]
+	return n, ok
+}
+
+func (r *recorder) delivered() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.deliveries)
+}
+
+// recordingService records the calls of the daemon and passes them on to
+// the service.
+type recordingService struct {
+	gosvcd.Service
+	rec *recorder
+}
+
+func (s *recordingService) Init(handle gosvcd.ServiceHandle) {
+	s.rec.init(s.ID())
+	s.Service.Init(handle)
+}
+
+func (s *recordingService) HandleEvent(ev gosvcd.Event) {
+	if ie, ok := ev.Data().(*InvariantEvent); ok {
+		s.rec.deliver(s.ID(), ie.Seq)
+	}
+	s.Service.HandleEvent(ev)
+}
+
+func subscribes(svc gosvcd.Service, typ gosvcd.EventType) bool {
+	for _, sub := range svc.Subscriptions() {
+		if sub == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// transitiveDeps returns the direct and indirect dependencies of each
+// service.
+func transitiveDeps(svcs []gosvcd.Service) map[gosvcd.ServiceId]map[gosvcd.ServiceId]bool {
+	byID := make(map[gosvcd.ServiceId]gosvcd.Service, len(svcs))
+	for _, svc := range svcs {
+		byID[svc.ID()] = svc
+	}
+	deps := make(map[gosvcd.ServiceId]map[gosvcd.ServiceId]bool, len(svcs))
+	var visit func(id gosvcd.ServiceId) map[gosvcd.ServiceId]bool
+	visit = func(id gosvcd.ServiceId) map[gosvcd.ServiceId]bool {
+		if ds, ok := deps[id]; ok {
+			return ds
+		}
+		ds := make(map[gosvcd.ServiceId]bool)
+		deps[id] = ds
+		svc, ok := byID[id]
+		if !ok {
+			return ds
+		}
+		for _, dep := range svc.Dependencies() {
+			if _, ok := byID[dep]; !ok {
+				continue
+			}
+			ds[dep] = true
+			for d := range visit(dep) {
+				ds[d] = true
+			}
+		}
+		return ds
+	}
+	for _, svc := range svcs {
+		visit(svc.ID())
+	}
+	return deps
+}
@@ -0,0 +1,352 @@
+package gosvcdtest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// T is the part of testing.TB used by the assertions.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Matcher matches the payload of an emitted event.
+type Matcher func(data interface{}) bool
+
+// Any matches any payload.
+func Any(interface{}) bool { return true }
+
+// Equal returns a matcher of the payloads deeply equal to 'v'.
+func Equal(v interface{}) Matcher {
+	return func(data interface{}) bool { return reflect.DeepEqual(data, v) }
+}
+
+// Emitted is an event emitted with a MockHandle.
+type Emitted struct {
+	Type    gosvcd.EventType
+	Data    interface{}
+	Context context.Context
+
+	// Target is the target of a directed event, if Directed is set.
+	Target   gosvcd.ServiceId
+	Directed bool
+}
+
+// MockHandle is a ServiceHandle for unit testing a service without a
+// daemon. It records the emitted events, and serves the lookups and the
+// degraded dependencies set by the test, as ExampleServiceHandle does. Its
+// semaphores and locks are kept in memory, for asserting on the permits
+// held.
+type MockHandle struct {
+	// EmitErr is returned by EmitEvent when set, e.g.
+	// gosvcd.ErrDaemonStopped.
+	EmitErr error
+
+	mu           sync.Mutex
+	changed      chan struct{}
+	emitted      []Emitted
+	services     map[gosvcd.ServiceId]gosvcd.Service
+	degraded     []gosvcd.ServiceId
+	unregistered bool
+	permits      map[string]map[string]bool
+	coord        gosvcd.CoordinationStore
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewMockHandle returns a handle to pass to the Init of the service under
+// test.
+func NewMockHandle() *MockHandle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MockHandle{
+		changed:  make(chan struct{}),
+		services: make(map[gosvcd.ServiceId]gosvcd.Service),
+		permits:  make(map[string]map[string]bool),
+		coord:    gosvcd.NewMemoryCoordination(),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+var (
+	_ gosvcd.ServiceHandle = &MockHandle{}
+	_ gosvcd.DirectEmitter = &MockHandle{}
+	_ gosvcd.Replier       = &MockHandle{}
+	_ gosvcd.Coordinator   = &MockHandle{}
+)
+
+func (m *MockHandle) EmitEvent(eventType gosvcd.EventType, data interface{}) error {
+	return m.EmitEventContext(context.Background(), eventType, data)
+}
+
+func (m *MockHandle) EmitEventContext(ctx context.Context, eventType gosvcd.EventType, data interface{}) error {
+	return m.record(Emitted{Type: eventType, Data: data, Context: ctx})
+}
+
+// EmitTo records a directed event, see gosvcd.ExampleServiceHandle.EmitTo.
+func (m *MockHandle) EmitTo(target gosvcd.ServiceId, eventType gosvcd.EventType, data interface{}) error {
+	return m.EmitToContext(context.Background(), target, eventType, data)
+}
+
+func (m *MockHandle) EmitToContext(ctx context.Context, target gosvcd.ServiceId, eventType gosvcd.EventType, data interface{}) error {
+	return m.record(Emitted{Type: eventType, Data: data, Context: ctx, Target: target, Directed: true})
+}
+
+// Reply records the reply as directed to the service of the reply address
+// of the event, see gosvcd.ExampleServiceHandle.Reply.
+func (m *MockHandle) Reply(ev gosvcd.Event, data interface{}) error {
+	addr, ok := gosvcd.ReplyTo(ev)
+	if !ok {
+		return gosvcd.ErrNoReplyTo
+	}
+	ctx := ev.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return m.EmitToContext(gosvcd.WithCorrelation(ctx, addr.Correlation), addr.Service, addr.EventType, data)
+}
+
+// AwaitCredit does not wait, as the mock has no credit windows.
+func (m *MockHandle) AwaitCredit(ctx context.Context, typ gosvcd.EventType) error {
+	return ctx.Err()
+}
+
+func (m *MockHandle) record(e Emitted) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.EmitErr != nil {
+		return m.EmitErr
+	}
+	m.emitted = append(m.emitted, e)
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return nil
+}
+
+// Go runs 'f' in a new goroutine. The context is cancelled by Close.
+func (m *MockHandle) Go(f func(ctx context.Context)) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		f(m.ctx)
+	}()
+}
+
+// Unregister records the request, see Unregistered.
+func (m *MockHandle) Unregister() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unregistered = true
+}
+
+// Unregistered returns true if the service called Unregister.
+func (m *MockHandle) Unregistered() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.unregistered
+}
+
+// Close cancels the context of the goroutines started with Go and waits
+// for them to exit, as the daemon does when shutting down the service.
+func (m *MockHandle) Close() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// SetService adds the service returned by Lookup and Services.
+func (m *MockHandle) SetService(svc gosvcd.Service) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.services[svc.ID()] = svc
+}
+
+// Lookup returns the service set with SetService.
+func (m *MockHandle) Lookup(id gosvcd.ServiceId) (gosvcd.Service, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	svc, ok := m.services[id]
+	return svc, ok
+}
+
+// Services returns the services set with SetService.
+func (m *MockHandle) Services() []gosvcd.Service {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	svcs := make([]gosvcd.Service, 0, len(m.services))
+	for _, svc := range m.services {
+		svcs = append(svcs, svc)
+	}
+	return svcs
+}
+
+// SetDegraded sets the missing optional dependencies returned by
+// Degraded.
+func (m *MockHandle) SetDegraded(ids ...gosvcd.ServiceId) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.degraded = append([]gosvcd.ServiceId(nil), ids...)
+}
+
+func (m *MockHandle) Degraded() []gosvcd.ServiceId {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]gosvcd.ServiceId(nil), m.degraded...)
+}
+
+// Semaphore returns the named semaphore with 'n' permits, see
+// gosvcd.ExampleServiceHandle.Semaphore. The semaphores are shared by the
+// services using the handle.
+func (m *MockHandle) Semaphore(name string, n int) *gosvcd.Semaphore {
+	return gosvcd.NewSemaphore(mockCoordination{m}, "mock", name, n)
+}
+
+// Lock returns the named lock, i.e. a semaphore with one permit.
+func (m *MockHandle) Lock(name string) *gosvcd.Semaphore {
+	return m.Semaphore(name, 1)
+}
+
+// mockCoordination records the permits held in the store of the handle.
+type mockCoordination struct {
+	m *MockHandle
+}
+
+func (c mockCoordination) TryAcquire(ctx context.Context, name, holder string, limit int) (bool, error) {
+	ok, err := c.m.coord.TryAcquire(ctx, name, holder, limit)
+	if ok {
+		c.m.mu.Lock()
+		if c.m.permits[name] == nil {
+			c.m.permits[name] = make(map[string]bool)
+		}
+		c.m.permits[name][holder] = true
+		c.m.mu.Unlock()
+	}
+	return ok, err
+}
+
+func (c mockCoordination) Release(ctx context.Context, name, holder string) error {
+	c.m.mu.Lock()
+	delete(c.m.permits[name], holder)
+	c.m.mu.Unlock()
+	return c.m.coord.Release(ctx, name, holder)
+}
+
+// Permits returns the number of permits held of the named semaphore or
+// lock.
+func (m *MockHandle) Permits(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.permits[name])
+}
+
+// AssertPermits checks that 'n' permits of the named semaphore or lock are
+// held, e.g. none once the service has been shut down.
+func (m *MockHandle) AssertPermits(t T, name string, n int) bool {
+	t.Helper()
+	if held := m.Permits(name); held != n {
+		t.Errorf("%d permits of %s held, expected %d", held, name, n)
+		return false
+	}
+	return true
+}
+
+// Emitted returns the emitted events, in order.
+func (m *MockHandle) Emitted() []Emitted {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Emitted(nil), m.emitted...)
+}
+
+// EmittedData returns the payloads of the emitted events of the type, in
+// order.
+func (m *MockHandle) EmittedData(typ gosvcd.EventType) []interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []interface{}
+	for _, e := range m.emitted {
+		if e.Type == typ {
+			data = append(data, e.Data)
+		}
+	}
+	return data
+}
+
+// Reset forgets the emitted events.
+func (m *MockHandle) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emitted = nil
+}
+
+// Deliver calls the event handler of the service with an event of the
+// type emitted by the source.
+func Deliver(svc gosvcd.Service, source gosvcd.ServiceId, typ gosvcd.EventType, data interface{}) {
+	svc.HandleEvent(gosvcd.NewEvent(source, typ, time.Now(), data))
+}
+
+func (m *MockHandle) find(typ gosvcd.EventType, match Matcher) bool {
+	for _, e := range m.emitted {
+		if e.Type == typ && match(e.Data) {
+			return true
+		}
+	}
+	return false
+}
+
+// AssertEmitted checks that an event of the type with a payload matching
+// 'match' has been emitted.
+func (m *MockHandle) AssertEmitted(t T, typ gosvcd.EventType, match Matcher) bool {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.find(typ, match) {
+		return true
+	}
+	var emitted []string
+	for _, e := range m.emitted {
+		emitted = append(emitted, fmt.Sprintf("%s %v", e.Type, e.Data))
+	}
+	t.Errorf("no matching %s emitted, emitted: %v", typ, emitted)
+	return false
+}
+
+// AssertNotEmitted checks that no event of the type has been emitted.
+func (m *MockHandle) AssertNotEmitted(t T, typ gosvcd.EventType) bool {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.find(typ, Any) {
+		return true
+	}
+	t.Errorf("%s emitted", typ)
+	return false
+}
+
+// WaitEmitted waits for an event of the type with a payload matching
+// 'match' to be emitted, e.g. from a goroutine of the service, at most
+// for the timeout. Returns false if none was.
+func (m *MockHandle) WaitEmitted(typ gosvcd.EventType, match Matcher, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		m.mu.Lock()
+		found := m.find(typ, match)
+		changed := m.changed
+		m.mu.Unlock()
+		if found {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Package hotreload watches a directory of plugins or scripts and replaces
+// the running services with the new versions of their files, for fast
+// iteration in development environments:
+//
+//	w := hotreload.New(hotreload.Config{
+//		Dir:     "plugins",
+//		Loaders: map[string]hotreload.Loader{goplugin.Ext: goplugin.Reload},
+//	})
+//	if err := w.Register(b); err != nil { ... }
+//	d := b.Start()
+//	go w.Run(ctx, d)
+//
+// Scripts are loaded by a Loader creating a scriptsvc.Service with the path
+// of the file and the service identifier configured for it.
+//
+// A changed file is loaded once it has not changed for a polling interval,
+// and the running service is replaced with the loaded one with
+// ServiceDaemon.Replace: the events are handed off to the new instance once
+// it is ready and the old instance is shut down. The new instance may
+// change its subscriptions among the event types the daemon dispatches. A
+// removed file drains its service with ServiceDaemon.Drain. New services
+// cannot be added to a running daemon, so a file added after Register is
+// only loaded if it replaces a registered service.
+//
+// The directory is polled rather than watched with inotify or similar, to
+// work the same on all platforms and file systems.
+package hotreload
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// DefaultInterval is the default interval of polling the directory.
+const DefaultInterval = time.Second
+
+// Loader loads the service from the file.
+type Loader func(path string) (gosvcd.Service, error)
+
+// Config describes the watched directory.
+type Config struct {
+	Dir string
+
+	// Loaders are the loaders of the files by their extension, e.g.
+	// goplugin.Ext. The other files are ignored.
+	Loaders map[string]Loader
+
+	// Interval is the interval of polling the directory. Defaults to
+	// DefaultInterval.
+	Interval time.Duration
+
+	// Replace configures the replacement of the services.
+	Replace gosvcd.ReplaceOptions
+
+	// Logger logs the reloads. Optional.
+	Logger gosvcd.Logger
+
+	// OnError is called with the errors of loading and replacing the
+	// services, after which the old instance keeps running. Optional.
+	OnError func(error)
+}
+
+// Watcher reloads the services of the files in the directory.
+type Watcher struct {
+	cfg   Config
+	files map[string]*file
+}
+
+// file is the state of a watched file.
+type file struct {
+	// loaded is the stat of the file when it was last loaded, or
+	// attempted to.
+	loaded stat
+
+	// seen is the stat of the file in the last poll.
+	seen stat
+
+	// id is the service loaded from the file, if registered.
+	id         gosvcd.ServiceId
+	registered bool
+}
+
+type stat struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s stat) equal(other stat) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size
+}
+
+// New returns the watcher of the directory.
+func New(cfg Config) *Watcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	return &Watcher{cfg: cfg, files: make(map[string]*file)}
+}
+
+// Register loads the services of the files in the directory, in the order
+// of their names, and registers them with the builder. Nothing is
+// registered if loading any of the files fails.
+func (w *Watcher) Register(b *gosvcd.ExampleServiceDaemonBuilder) error {
+	stats, err := w.scan()
+	if err != nil {
+		return err
+	}
+	paths := sortedPaths(stats)
+	svcs := make([]gosvcd.Service, len(paths))
+	for i, path := range paths {
+		if svcs[i], err = w.load(path); err != nil {
+			return err
+		}
+	}
+	for i, path := range paths {
+		b.Register(svcs[i])
+		st := stats[path]
+		w.files[path] = &file{loaded: st, seen: st, id: svcs[i].ID(), registered: true}
+	}
+	return nil
+}
+
+// Run polls the directory and reloads the changed files until 'ctx' is
+// done or the daemon stops. The files present when Run is called that were
+// not loaded by Register are reloaded once they change.
+func (w *Watcher) Run(ctx context.Context, d gosvcd.ServiceDaemon) {
+	if stats, err := w.scan(); err != nil {
+		w.error(err)
+	} else {
+		for path, st := range stats {
+			if _, ok := w.files[path]; !ok {
+				w.files[path] = &file{loaded: st, seen: st}
+			}
+		}
+	}
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.Stopping():
+			return
+		case <-ticker.C:
+			w.poll(d)
+		}
+	}
+}
+
+// poll reloads the files that have changed and have not changed since the
+// previous poll, and drains the services of the removed files.
+func (w *Watcher) poll(d gosvcd.ServiceDaemon) {
+	stats, err := w.scan()
+	if err != nil {
+		w.error(err)
+		return
+	}
+	for _, path := range sortedPaths(stats) {
+		st := stats[path]
+		f, ok := w.files[path]
+		if !ok {
+			// Wait for the new file to settle.
+			w.files[path] = &file{seen: st}
+			continue
+		}
+		settled := st.equal(f.seen)
+		f.seen = st
+		if !settled || st.equal(f.loaded) {
+			continue
+		}
+		f.loaded = st
+		w.reload(d, path, f)
+	}
+	for path, f := range w.files {
+		if _, ok := stats[path]; ok {
+			continue
+		}
+		delete(w.files, path)
+		if !f.registered {
+			continue
+		}
+		w.info("Plugin removed, draining service", "path", path, "id", f.id)
+		if err := d.Drain(f.id); err != nil {
+			w.error(fmt.Errorf("drain service %d of removed %s: %w", f.id, path, err))
+		}
+	}
+}
+
+func (w *Watcher) reload(d gosvcd.ServiceDaemon, path string, f *file) {
+	svc, err := w.load(path)
+	if err != nil {
+		w.error(err)
+		return
+	}
+	id := svc.ID()
+	if f.registered && id != f.id {
+		w.error(fmt.Errorf("%s: service %d changed its id to %d", path, f.id, id))
+		return
+	}
+	if _, running := d.State(id); !running {
+		w.error(fmt.Errorf("%s: service %d is not registered", path, id))
+		return
+	}
+	w.info("Plugin changed, replacing service", "path", path, "service", svc.Name(), "id", id)
+	if err := d.Replace(svc, w.cfg.Replace); err != nil {
+		w.error(fmt.Errorf("replace service %d from %s: %w", id, path, err))
+		return
+	}
+	f.id, f.registered = id, true
+}
+
+// scan returns the stats of the files in the directory that have a
+// loader.
+func (w *Watcher) scan() (map[string]stat, error) {
+	entries, err := ioutil.ReadDir(w.cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]stat)
+	for _, e := range entries {
+		if _, ok := w.cfg.Loaders[filepath.Ext(e.Name())]; ok && !e.IsDir() {
+			stats[filepath.Join(w.cfg.Dir, e.Name())] = stat{e.ModTime(), e.Size()}
+		}
+	}
+	return stats, nil
+}
+
+func (w *Watcher) load(path string) (gosvcd.Service, error) {
+	svc, err := w.cfg.Loaders[filepath.Ext(path)](path)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+	return svc, nil
+}
+
+func (w *Watcher) info(msg string, args ...interface{}) {
+	if w.cfg.Logger != nil {
+		w.cfg.Logger.Info(msg, args...)
+	}
+}
+
+func (w *Watcher) error(err error) {
+	if w.cfg.Logger != nil {
+		w.cfg.Logger.Error("Hot reload failed", "error", err)
+	}
+	if w.cfg.OnError != nil {
+		w.cfg.OnError(err)
+	}
+}
+
+func sortedPaths(stats map[string]stat) []string {
+	paths := make([]string, 0, len(stats))
+	for path := range stats {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,110 @@
+package ipcbridge
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// clientBuffer is the number of received events buffered by the client.
+const clientBuffer = 256
+
+// Client is the connection of a helper process to the bridge.
+type Client struct {
+	conn   net.Conn
+	origin string
+	events chan gosvcd.Event
+
+	mu  sync.Mutex
+	err error
+}
+
+// Dial connects to the bridge listening on the socket at the path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:   conn,
+		origin: wire.DefaultOrigin(),
+		events: make(chan gosvcd.Event, clientBuffer),
+	}
+	go c.receive()
+	return c, nil
+}
+
+// Subscribe sets the event types to receive. The types must be forwarded
+// by the bridge.
+func (c *Client) Subscribe(types ...gosvcd.EventType) error {
+	if types == nil {
+		types = []gosvcd.EventType{}
+	}
+	body, err := json.Marshal(types)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeFrame(c.conn, frameSubscribe, body)
+}
+
+// Emit emits the event to the daemon.
+func (c *Client) Emit(typ gosvcd.EventType, data interface{}) error {
+	body, err := wire.EncodeNew(c.origin, gosvcd.DaemonServiceId, typ, data)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeFrame(c.conn, frameEvent, body)
+}
+
+// Events returns the channel of the received events. The channel is closed
+// when the connection is closed, after which Err returns the error that
+// closed it. The bridge disconnects a client that does not receive the
+// events fast enough.
+func (c *Client) Events() <-chan gosvcd.Event {
+	return c.events
+}
+
+// Err returns the error that closed the connection, if any.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) receive() {
+	defer close(c.events)
+	for {
+		kind, body, err := readFrame(c.conn)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+		if kind != frameEvent {
+			continue
+		}
+		env, err := wire.Decode(body)
+		if err != nil {
+			continue
+		}
+		ev, err := env.Event(env.Context(context.Background()))
+		if err != nil {
+			continue
+		}
+		c.events <- ev
+	}
+}
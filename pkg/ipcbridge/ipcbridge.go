@@ -0,0 +1,291 @@
+// Package ipcbridge connects local helper processes to a gosvcd daemon over
+// a Unix socket, without a broker or an RPC framework. The helpers use the
+// Client of this package to emit events to the daemon and to receive the
+// events of the types they subscribe to.
+//
+// The protocol is a stream of frames in both directions. A frame is the
+// big-endian 32-bit length of the rest of the frame, the kind of the frame
+// as one byte and the body:
+//
+//	's'  subscribe: the JSON array of the event types to receive,
+//	     replacing the previous subscriptions (client to daemon)
+//	'e'  event: the event encoded with wire.Encode (both directions)
+package ipcbridge
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// DefaultPath is the default path of the socket.
+const DefaultPath = "/tmp/gosvcd-ipc.sock"
+
+// Frame kinds
+const (
+	frameSubscribe = 's'
+	frameEvent     = 'e'
+)
+
+// maxFrame limits the size of a frame.
+const maxFrame = 16 << 20
+
+// writeTimeout limits the time to write a frame to a client, after which
+// the client is disconnected.
+const writeTimeout = 5 * time.Second
+
+// Config of the bridge.
+type Config struct {
+	// Path of the Unix socket. Defaults to DefaultPath. A stale socket
+	// file is removed.
+	Path string
+
+	// Mode is the permissions of the socket file, controlling which
+	// users can connect. Defaults to 0600.
+	Mode os.FileMode
+
+	// Listen listens on Path, e.g. with a listener handed over on a
+	// live upgrade. Defaults to net.Listen.
+	Listen func(network, addr string) (net.Listener, error)
+
+	// Forward are the event types the clients can subscribe to.
+	Forward []gosvcd.EventType
+
+	// OnError is called with the errors of the client connections.
+	// Optional.
+	OnError func(error)
+}
+
+// Bridge is a service accepting the connections of the helper processes.
+type Bridge struct {
+	id  gosvcd.ServiceId
+	cfg Config
+
+	mu       sync.Mutex
+	handle   gosvcd.ServiceHandle
+	listener net.Listener
+	clients  map[*client]bool
+}
+
+type client struct {
+	conn net.Conn
+
+	mu   sync.Mutex // serializes writes
+	subs map[gosvcd.EventType]bool
+}
+
+// clientKey is the context key of the client emitting an event, to not
+// send the event back to it.
+type clientKey struct{}
+
+// New returns the bridge service with the given identifier.
+func New(id gosvcd.ServiceId, cfg Config) *Bridge {
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath
+	}
+	if cfg.Mode == 0 {
+		cfg.Mode = 0600
+	}
+	if cfg.Listen == nil {
+		cfg.Listen = net.Listen
+	}
+	return &Bridge{id: id, cfg: cfg}
+}
+
+func (b *Bridge) ID() gosvcd.ServiceId              { return b.id }
+func (b *Bridge) Name() string                      { return "ipc-bridge" }
+func (b *Bridge) Dependencies() []gosvcd.ServiceId  { return nil }
+func (b *Bridge) Subscriptions() []gosvcd.EventType { return b.cfg.Forward }
+
+// Init starts accepting the clients. Fails the service if listening on the
+// socket fails.
+func (b *Bridge) Init(handle gosvcd.ServiceHandle) {
+	if err := os.Remove(b.cfg.Path); err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	l, err := b.cfg.Listen("unix", b.cfg.Path)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chmod(b.cfg.Path, b.cfg.Mode); err != nil {
+		l.Close()
+		panic(err)
+	}
+	b.mu.Lock()
+	b.handle = handle
+	b.listener = l
+	b.clients = make(map[*client]bool)
+	b.mu.Unlock()
+	handle.Go(func(context.Context) { b.accept(l) })
+}
+
+// Shutdown stops accepting clients and disconnects them.
+func (b *Bridge) Shutdown() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.listener != nil {
+		b.listener.Close()
+		b.listener = nil
+	}
+	for c := range b.clients {
+		c.conn.Close()
+	}
+	b.handle = nil
+}
+
+// HandleEvent sends the event to the clients subscribed to its type, other
+// than the client that emitted it.
+func (b *Bridge) HandleEvent(ev gosvcd.Event) {
+	origin, _ := ev.Context().Value(clientKey{}).(*client)
+	var targets []*client
+	b.mu.Lock()
+	for c := range b.clients {
+		if c != origin && c.subscribed(ev.EventType()) {
+			targets = append(targets, c)
+		}
+	}
+	b.mu.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+	data, err := wire.Encode(wire.DefaultOrigin(), ev)
+	if err != nil {
+		b.error(fmt.Errorf("encode %s: %w", ev.EventType(), err))
+		return
+	}
+	for _, c := range targets {
+		if err := c.write(frameEvent, data); err != nil {
+			b.error(fmt.Errorf("send %s to client: %w", ev.EventType(), err))
+			c.conn.Close()
+		}
+	}
+}
+
+func (b *Bridge) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{conn: conn}
+		b.mu.Lock()
+		if b.handle == nil {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.clients[c] = true
+		handle := b.handle
+		b.mu.Unlock()
+		handle.Go(func(ctx context.Context) {
+			if err := b.serve(ctx, handle, c); err != nil {
+				b.error(err)
+			}
+			conn.Close()
+			b.mu.Lock()
+			delete(b.clients, c)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// serve handles the frames from the client until it disconnects.
+func (b *Bridge) serve(ctx context.Context, handle gosvcd.ServiceHandle, c *client) error {
+	ctx = context.WithValue(ctx, clientKey{}, c)
+	for {
+		kind, body, err := readFrame(c.conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("read from client: %w", err)
+		}
+		switch kind {
+		case frameSubscribe:
+			var types []gosvcd.EventType
+			if err := json.Unmarshal(body, &types); err != nil {
+				return fmt.Errorf("decode subscriptions: %w", err)
+			}
+			c.subscribe(types)
+		case frameEvent:
+			env, err := wire.Decode(body)
+			if err != nil {
+				b.error(fmt.Errorf("decode event from client: %w", err))
+				continue
+			}
+			v, err := env.Value()
+			if err != nil {
+				b.error(fmt.Errorf("decode %s data from client: %w", env.Type, err))
+				continue
+			}
+			if err := handle.EmitEventContext(env.Context(ctx), env.Type, v); err != nil {
+				b.error(fmt.Errorf("emit %s from client: %w", env.Type, err))
+			}
+		default:
+			return fmt.Errorf("unknown frame kind %q from client", kind)
+		}
+	}
+}
+
+func (c *client) subscribe(types []gosvcd.EventType) {
+	subs := make(map[gosvcd.EventType]bool, len(types))
+	for _, typ := range types {
+		subs[typ] = true
+	}
+	c.mu.Lock()
+	c.subs = subs
+	c.mu.Unlock()
+}
+
+func (c *client) subscribed(typ gosvcd.EventType) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subs[typ]
+}
+
+func (c *client) write(kind byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return writeFrame(c.conn, kind, body)
+}
+
+func (b *Bridge) error(err error) {
+	if b.cfg.OnError != nil {
+		b.cfg.OnError(err)
+	}
+}
+
+func writeFrame(w io.Writer, kind byte, body []byte) error {
+	buf := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(body)))
+	buf[4] = kind
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n == 0 || n > maxFrame {
+		return 0, nil, fmt.Errorf("invalid frame length %d", n)
+	}
+	body := make([]byte, n-1)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[4], body, nil
+}
@@ -0,0 +1,201 @@
+// Package kafkabridge connects a gosvcd daemon to Kafka: selected local
+// events are produced to topics and the messages consumed from topics are
+// emitted as local events, giving a durable and replayable event flow
+// between processes.
+//
+// The Kafka client is not part of the package: the bridge produces
+// through a Producer and consumes through a Consumer of a consumer group,
+// e.g. kafka.Writer and kafka.Reader with a GroupID from
+// github.com/segmentio/kafka-go. The group membership and the partition
+// assignment are left to the Consumer.
+package kafkabridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// DefaultTopicPrefix is the default prefix of the topics of produced events.
+const DefaultTopicPrefix = "gosvcd."
+
+// Message is a Kafka message.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Time      time.Time
+}
+
+// Producer produces messages to Kafka.
+type Producer interface {
+	Produce(ctx context.Context, msgs ...Message) error
+}
+
+// Consumer consumes messages from the topics as a member of a consumer
+// group.
+type Consumer interface {
+	// Fetch blocks until the next message is available or the context
+	// is cancelled.
+	Fetch(ctx context.Context) (Message, error)
+
+	// Commit commits the offsets of the messages for the group.
+	Commit(ctx context.Context, msgs ...Message) error
+}
+
+// Config of the bridge.
+type Config struct {
+	// Forward are the local event types produced to Kafka.
+	Forward []gosvcd.EventType
+
+	// Topic returns the topic of a forwarded event type. Defaults to
+	// DefaultTopicPrefix followed by the event type.
+	Topic func(gosvcd.EventType) string
+
+	// Key returns the message key of an event, which selects the
+	// partition. Defaults to no key, which spreads the events over the
+	// partitions.
+	Key func(gosvcd.Event) []byte
+
+	// Origin identifies this process. Consumed messages with the same
+	// origin are not emitted locally. Defaults to hostname:pid.
+	Origin string
+
+	// OnError is called with the errors of producing, consuming and
+	// decoding messages. Optional.
+	OnError func(error)
+}
+
+// KeyBySource keys the events by the emitting service, which keeps the
+// events of a service in order.
+func KeyBySource(ev gosvcd.Event) []byte {
+	return []byte(fmt.Sprint(ev.ServiceId()))
+}
+
+// KeyByType keys the events by their type, which keeps the events of a
+// type in order.
+func KeyByType(ev gosvcd.Event) []byte {
+	return []byte(ev.EventType())
+}
+
+// Bridge is a service that bridges events to and from Kafka.
+type Bridge struct {
+	id       gosvcd.ServiceId
+	producer Producer
+	consumer Consumer
+	cfg      Config
+}
+
+// New returns the bridge service with the given identifier. Either the
+// producer or the consumer may be nil to bridge in one direction only.
+func New(id gosvcd.ServiceId, producer Producer, consumer Consumer, cfg Config) *Bridge {
+	if cfg.Topic == nil {
+		cfg.Topic = func(typ gosvcd.EventType) string {
+			return DefaultTopicPrefix + string(typ)
+		}
+	}
+	if cfg.Origin == "" {
+		cfg.Origin = wire.DefaultOrigin()
+	}
+	if producer == nil {
+		cfg.Forward = nil
+	}
+	return &Bridge{id: id, producer: producer, consumer: consumer, cfg: cfg}
+}
+
+func (b *Bridge) ID() gosvcd.ServiceId              { return b.id }
+func (b *Bridge) Name() string                      { return "kafka-bridge" }
+func (b *Bridge) Dependencies() []gosvcd.ServiceId  { return nil }
+func (b *Bridge) Subscriptions() []gosvcd.EventType { return b.cfg.Forward }
+
+// Init starts consuming.
+func (b *Bridge) Init(handle gosvcd.ServiceHandle) {
+	if b.consumer != nil {
+		handle.Go(func(ctx context.Context) { b.consume(ctx, handle) })
+	}
+}
+
+// consume emits the consumed messages and commits their offsets once the
+// event has been emitted. On restart the consumption resumes from the
+// last committed offset.
+func (b *Bridge) consume(ctx context.Context, handle gosvcd.ServiceHandle) {
+	for {
+		msg, err := b.consumer.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.error(fmt.Errorf("fetch: %w", err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		if err := b.emit(ctx, handle, msg); err != nil {
+			if errors.Is(err, gosvcd.ErrDaemonStopped) || ctx.Err() != nil {
+				// Not committed, consumed again after restart.
+				return
+			}
+			b.error(err)
+		}
+		if err := b.consumer.Commit(ctx, msg); err != nil && ctx.Err() == nil {
+			b.error(fmt.Errorf("commit %s/%d@%d: %w", msg.Topic, msg.Partition, msg.Offset, err))
+		}
+	}
+}
+
+func (b *Bridge) emit(ctx context.Context, handle gosvcd.ServiceHandle, msg Message) error {
+	env, err := wire.Decode(msg.Value)
+	if err != nil {
+		return fmt.Errorf("decode message %s/%d@%d: %w", msg.Topic, msg.Partition, msg.Offset, err)
+	}
+	if env.Origin == b.cfg.Origin {
+		return nil
+	}
+	v, err := env.Value()
+	if err != nil {
+		return fmt.Errorf("decode %s data %s/%d@%d: %w", env.Type, msg.Topic, msg.Partition, msg.Offset, err)
+	}
+	return handle.EmitEventContext(env.Context(ctx), env.Type, v)
+}
+
+// HandleEvent produces the event. Events emitted by the bridge itself are
+// not produced back.
+func (b *Bridge) HandleEvent(ev gosvcd.Event) {
+	if ev.ServiceId() == b.id {
+		return
+	}
+	value, err := wire.Encode(b.cfg.Origin, ev)
+	if err != nil {
+		b.error(fmt.Errorf("encode %s: %w", ev.EventType(), err))
+		return
+	}
+	msg := Message{
+		Topic: b.cfg.Topic(ev.EventType()),
+		Value: value,
+		Time:  ev.Timestamp(),
+	}
+	if b.cfg.Key != nil {
+		msg.Key = b.cfg.Key(ev)
+	}
+	if err := b.producer.Produce(context.Background(), msg); err != nil {
+		b.error(fmt.Errorf("produce %s: %w", ev.EventType(), err))
+	}
+}
+
+// Shutdown stops consuming. The consumer and the producer are not closed.
+func (b *Bridge) Shutdown() {}
+
+func (b *Bridge) error(err error) {
+	if b.cfg.OnError != nil {
+		b.cfg.OnError(err)
+	}
+}
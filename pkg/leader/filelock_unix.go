@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package leader
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// NewFileLock returns a backend electing the candidate holding an exclusive
+// lock on the file, for electing one of the daemons of a host. The lock is
+// released by the operating system when the process exits. The name of the
+// leader is written to the file.
+func NewFileLock(path string) Backend {
+	return &fileLock{path: path}
+}
+
+type fileLock struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (l *fileLock) TryAcquire(ctx context.Context, candidate string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		return true, nil
+	}
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(candidate+"\n"), 0)
+	}
+	l.f = f
+	return true, nil
+}
+
+func (l *fileLock) Release(ctx context.Context, candidate string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	l.f.Truncate(0)
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package leader
+
+import "context"
+
+// NewFileLock returns a backend electing the candidate holding an exclusive
+// lock on the file. Not supported on Windows.
+func NewFileLock(path string) Backend {
+	return fileLock{}
+}
+
+type fileLock struct{}
+
+func (fileLock) TryAcquire(ctx context.Context, candidate string) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func (fileLock) Release(ctx context.Context, candidate string) error {
+	return nil
+}
@@ -0,0 +1,194 @@
+// Package leader provides a service electing one of a set of daemons as
+// the leader, for running services as active/standby pairs. The election
+// emits LeaderAcquired and LeaderLost events, on which the other services
+// of the daemon start and stop their active behavior.
+//
+// The leadership is held with a Backend: a lock file for the daemons of a
+// host (see NewFileLock), or a lease in a coordination service such as
+// etcd or Consul for the daemons of a cluster.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// DefaultRenewInterval is the default interval of acquiring or renewing
+// the leadership.
+const DefaultRenewInterval = time.Second
+
+// ErrNotSupported is returned by the backends not supported on the
+// platform.
+var ErrNotSupported = errors.New("leader election backend not supported on this platform")
+
+// Backend holds the leadership. For a lease based backend, e.g. on etcd,
+// TryAcquire creates the lease or keeps it alive, and the lease should
+// expire within a few renew intervals when not renewed.
+type Backend interface {
+	// TryAcquire acquires the leadership for the candidate, or renews
+	// it if the candidate already holds it. Returns false if another
+	// candidate holds it.
+	TryAcquire(ctx context.Context, candidate string) (bool, error)
+
+	// Release gives up the leadership held by the candidate.
+	Release(ctx context.Context, candidate string) error
+}
+
+// Config of the election.
+type Config struct {
+	// Candidate identifies this daemon in the election. Defaults to
+	// hostname:pid.
+	Candidate string
+
+	Backend Backend
+
+	// RenewInterval is the interval of acquiring or renewing the
+	// leadership. Defaults to DefaultRenewInterval.
+	RenewInterval time.Duration
+
+	// OnError is called with the errors of the backend. Optional.
+	OnError func(error)
+}
+
+//
+// Events
+//
+
+var LeaderAcquired_Type = gosvcd.EventType("LeaderAcquired")
+
+// LeaderAcquired is emitted when the daemon has become the leader.
+type LeaderAcquired struct {
+	Candidate string
+}
+
+var LeaderLost_Type = gosvcd.EventType("LeaderLost")
+
+// LeaderLost is emitted when the daemon is no longer the leader: the
+// leadership could not be renewed, or it was released when the election
+// was shut down.
+type LeaderLost struct {
+	Candidate string
+
+	// Err is the error of renewing the leadership, if any.
+	Err string
+}
+
+// Election is the service taking part in the election.
+type Election struct {
+	id  gosvcd.ServiceId
+	cfg Config
+
+	mu     sync.Mutex
+	handle gosvcd.ServiceHandle
+	leader bool
+}
+
+// New returns the election service with the given identifier.
+func New(id gosvcd.ServiceId, cfg Config) *Election {
+	if cfg.Candidate == "" {
+		cfg.Candidate = wire.DefaultOrigin()
+	}
+	if cfg.RenewInterval <= 0 {
+		cfg.RenewInterval = DefaultRenewInterval
+	}
+	return &Election{id: id, cfg: cfg}
+}
+
+func (e *Election) ID() gosvcd.ServiceId              { return e.id }
+func (e *Election) Name() string                      { return "leader-election" }
+func (e *Election) Dependencies() []gosvcd.ServiceId  { return nil }
+func (e *Election) Subscriptions() []gosvcd.EventType { return nil }
+func (e *Election) HandleEvent(ev gosvcd.Event)       {}
+
+// Init starts campaigning for the leadership.
+func (e *Election) Init(handle gosvcd.ServiceHandle) {
+	e.mu.Lock()
+	e.handle = handle
+	e.mu.Unlock()
+	handle.Go(e.campaign)
+}
+
+// Shutdown releases the leadership if held.
+func (e *Election) Shutdown() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.leader {
+		if err := e.cfg.Backend.Release(context.Background(), e.cfg.Candidate); err != nil {
+			e.error(fmt.Errorf("release leadership: %w", err))
+		}
+		e.setLeader(false, nil)
+	}
+	e.handle = nil
+}
+
+// IsLeader returns true if the daemon is the leader.
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// campaign acquires or renews the leadership periodically until the
+// service is shut down.
+func (e *Election) campaign(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.RenewInterval)
+	defer ticker.Stop()
+	for {
+		ok, err := e.cfg.Backend.TryAcquire(ctx, e.cfg.Candidate)
+		if err != nil && ctx.Err() == nil {
+			e.error(fmt.Errorf("acquire leadership: %w", err))
+		}
+		e.mu.Lock()
+		if ctx.Err() != nil || e.handle == nil {
+			// Shut down meanwhile.
+			if ok && !e.leader {
+				e.cfg.Backend.Release(context.Background(), e.cfg.Candidate)
+			}
+			e.mu.Unlock()
+			return
+		}
+		// Leadership that could not be renewed is considered lost, as
+		// another candidate may acquire it once it expires.
+		e.setLeader(ok && err == nil, err)
+		e.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setLeader records the leadership and emits the change. Must be called
+// with 'mu' held.
+func (e *Election) setLeader(leader bool, err error) {
+	if leader == e.leader {
+		return
+	}
+	e.leader = leader
+	if e.handle == nil {
+		return
+	}
+	if leader {
+		e.handle.EmitEvent(LeaderAcquired_Type, &LeaderAcquired{Candidate: e.cfg.Candidate})
+		return
+	}
+	lost := &LeaderLost{Candidate: e.cfg.Candidate}
+	if err != nil {
+		lost.Err = err.Error()
+	}
+	e.handle.EmitEvent(LeaderLost_Type, lost)
+}
+
+func (e *Election) error(err error) {
+	if e.cfg.OnError != nil {
+		e.cfg.OnError(err)
+	}
+}
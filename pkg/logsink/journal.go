@@ -0,0 +1,112 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// JournalSocket is the socket of the systemd journal's native protocol.
+const JournalSocket = "/run/systemd/journal/socket"
+
+type journalLogger struct {
+	conn       *net.UnixConn
+	identifier string
+	level      gosvcd.Level
+}
+
+// NewJournal returns a logger that writes messages of at least the given
+// level to the systemd journal. The message is written as MESSAGE and the
+// key-value pairs as fields with upper-cased keys, e.g. "service" as SERVICE,
+// which allows e.g. "journalctl SERVICE=foo". If identifier is empty the
+// executable name is used as SYSLOG_IDENTIFIER.
+func NewJournal(identifier string, level gosvcd.Level) (gosvcd.Logger, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JournalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	if identifier == "" {
+		identifier = filepath.Base(os.Args[0])
+	}
+	return &journalLogger{conn: conn, identifier: identifier, level: level}, nil
+}
+
+func (l *journalLogger) Debug(msg string, args ...interface{}) { l.log(gosvcd.LevelDebug, msg, args) }
+func (l *journalLogger) Info(msg string, args ...interface{})  { l.log(gosvcd.LevelInfo, msg, args) }
+func (l *journalLogger) Warn(msg string, args ...interface{})  { l.log(gosvcd.LevelWarn, msg, args) }
+func (l *journalLogger) Error(msg string, args ...interface{}) { l.log(gosvcd.LevelError, msg, args) }
+
+func (l *journalLogger) log(level gosvcd.Level, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", formatLine(msg, args))
+	writeJournalField(&b, "PRIORITY", fmt.Sprint(journalPriority(level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", l.identifier)
+	for i := 0; i+1 < len(args); i += 2 {
+		key := journalFieldName(fmt.Sprint(args[i]))
+		if key == "" {
+			continue
+		}
+		writeJournalField(&b, key, fmt.Sprint(args[i+1]))
+	}
+	// Errors are ignored as there is nowhere to report them to.
+	l.conn.Write(b.Bytes())
+}
+
+// journalPriority maps the level to the syslog priority.
+func journalPriority(level gosvcd.Level) int {
+	switch level {
+	case gosvcd.LevelDebug:
+		return 7
+	case gosvcd.LevelInfo:
+		return 6
+	case gosvcd.LevelWarn:
+		return 4
+	}
+	return 3
+}
+
+// writeJournalField writes the field in the native protocol format. Values
+// containing newlines are written length-prefixed.
+func writeJournalField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	if strings.IndexByte(value, '\n') < 0 {
+		b.WriteByte('=')
+		b.WriteString(value)
+	} else {
+		b.WriteByte('\n')
+		binary.Write(b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value)
+	}
+	b.WriteByte('\n')
+}
+
+// journalFieldName converts the key to a valid journal field name, which
+// consists of upper case letters, digits and underscores and does not start
+// with an underscore or a digit.
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	s := strings.TrimLeft(string(name), "_")
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		s = "F_" + s
+	}
+	switch s {
+	case "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER":
+		// Do not override the fields set by the logger.
+		s = "F_" + s
+	}
+	return s
+}
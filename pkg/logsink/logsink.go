@@ -0,0 +1,32 @@
+// Package logsink provides gosvcd.Logger implementations that write the
+// daemon's log, including the service lifecycle transitions, to the host's
+// system log: either to the systemd journal with the key-value pairs as
+// structured fields, or to syslog.
+package logsink
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// ErrNotSupported is returned when the sink is not available on the platform.
+var ErrNotSupported = errors.New("log sink is not supported on this platform")
+
+// NewSystemLogger returns a logger writing to the systemd journal if it is
+// available and to syslog otherwise.
+func NewSystemLogger(identifier string, level gosvcd.Level) (gosvcd.Logger, error) {
+	if log, err := NewJournal(identifier, level); err == nil {
+		return log, nil
+	}
+	return NewSyslog(identifier, level)
+}
+
+// formatLine formats the message and the key-value pairs as a single line.
+func formatLine(msg string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	gosvcd.WriteLogArgs(&b, args)
+	return b.String()
+}
@@ -0,0 +1,48 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logsink
+
+import (
+	"log/syslog"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+type syslogLogger struct {
+	w     *syslog.Writer
+	level gosvcd.Level
+}
+
+// NewSyslog returns a logger that writes messages of at least the given
+// level to the local syslog daemon with the daemon facility. The key-value
+// pairs are appended to the message.
+func NewSyslog(tag string, level gosvcd.Level) (gosvcd.Logger, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogLogger{w: w, level: level}, nil
+}
+
+func (l *syslogLogger) Debug(msg string, args ...interface{}) {
+	if l.level <= gosvcd.LevelDebug {
+		l.w.Debug(formatLine(msg, args))
+	}
+}
+
+func (l *syslogLogger) Info(msg string, args ...interface{}) {
+	if l.level <= gosvcd.LevelInfo {
+		l.w.Info(formatLine(msg, args))
+	}
+}
+
+func (l *syslogLogger) Warn(msg string, args ...interface{}) {
+	if l.level <= gosvcd.LevelWarn {
+		l.w.Warning(formatLine(msg, args))
+	}
+}
+
+func (l *syslogLogger) Error(msg string, args ...interface{}) {
+	l.w.Err(formatLine(msg, args))
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package logsink
+
+import "github.com/joamaki/gosvcd/pkg/gosvcd"
+
+// NewSyslog returns ErrNotSupported as syslog is not available on this
+// platform.
+func NewSyslog(tag string, level gosvcd.Level) (gosvcd.Logger, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,192 @@
+// Package mqttbridge connects a gosvcd daemon to an MQTT broker. The dotted
+// event type namespace is mapped to the MQTT topic hierarchy: with the
+// default prefix the event type "sensor.temp.kitchen" is published to the
+// topic "gosvcd/sensor/temp/kitchen" and messages on that topic are
+// emitted as events of that type. The payloads are the event data encoded
+// with the codec registered for the event type in gosvcd.Payloads, JSON by
+// default, so that the bridge interoperates with other MQTT clients.
+//
+// The MQTT client is provided by the application as a Conn, so that the
+// package does not depend on one. With github.com/eclipse/paho.mqtt.golang,
+// the Conn waits for the tokens returned by Publish and Subscribe of
+// mqtt.Client.
+package mqttbridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// DefaultPrefix is the default topic prefix.
+const DefaultPrefix = "gosvcd/"
+
+// Message is a message received from the broker.
+type Message struct {
+	Topic    string
+	QoS      byte
+	Retained bool
+	Payload  []byte
+}
+
+// Conn is the MQTT client connection used by the bridge.
+type Conn interface {
+	// Publish publishes the payload to the topic.
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+
+	// Subscribe calls 'handler' with the messages matching the topic
+	// filter. Returns a function to unsubscribe. The messages published
+	// by the connection itself must not be delivered back, e.g. by
+	// subscribing with the MQTT 5 No Local option, if the filter
+	// matches the forwarded event types.
+	Subscribe(filter string, qos byte, handler func(Message)) (func() error, error)
+}
+
+// Config of the bridge.
+type Config struct {
+	// Forward are the local event types published to the broker.
+	Forward []gosvcd.EventType
+
+	// Subscribe are the topic filters, relative to Prefix, from which
+	// the messages are emitted as local events, e.g. "sensor/#".
+	Subscribe []string
+
+	// Prefix of the topics. Defaults to DefaultPrefix.
+	Prefix string
+
+	// QoS is the quality of service of the publishes and the
+	// subscriptions. QoSByType overrides it for the published events.
+	QoS       byte
+	QoSByType map[gosvcd.EventType]byte
+
+	// Retain are the event types published as retained messages, e.g.
+	// the events describing the current state of something, so that
+	// clients subscribing later receive the latest one.
+	Retain []gosvcd.EventType
+
+	// IgnoreRetained drops the retained messages delivered when
+	// subscribing, so that only the messages published after starting
+	// are emitted.
+	IgnoreRetained bool
+
+	// OnError is called with the errors of publishing and decoding
+	// messages. Optional.
+	OnError func(error)
+}
+
+// Bridge is a service that bridges events to and from an MQTT broker.
+type Bridge struct {
+	id     gosvcd.ServiceId
+	conn   Conn
+	cfg    Config
+	retain map[gosvcd.EventType]bool
+	handle gosvcd.ServiceHandle
+	unsubs []func() error
+}
+
+// New returns the bridge service with the given identifier.
+func New(id gosvcd.ServiceId, conn Conn, cfg Config) *Bridge {
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	b := &Bridge{id: id, conn: conn, cfg: cfg, retain: map[gosvcd.EventType]bool{}}
+	for _, typ := range cfg.Retain {
+		b.retain[typ] = true
+	}
+	return b
+}
+
+// TopicForType returns the topic of the event type.
+func TopicForType(prefix string, typ gosvcd.EventType) string {
+	return prefix + strings.ReplaceAll(string(typ), ".", "/")
+}
+
+// TypeForTopic returns the event type of the topic, or false if the topic
+// does not have the prefix.
+func TypeForTopic(prefix, topic string) (gosvcd.EventType, bool) {
+	if !strings.HasPrefix(topic, prefix) || len(topic) == len(prefix) {
+		return "", false
+	}
+	return gosvcd.EventType(strings.ReplaceAll(topic[len(prefix):], "/", ".")), true
+}
+
+func (b *Bridge) ID() gosvcd.ServiceId              { return b.id }
+func (b *Bridge) Name() string                      { return "mqtt-bridge" }
+func (b *Bridge) Dependencies() []gosvcd.ServiceId  { return nil }
+func (b *Bridge) Subscriptions() []gosvcd.EventType { return b.cfg.Forward }
+
+// Init subscribes to the configured topic filters. Fails the service if a
+// subscription fails.
+func (b *Bridge) Init(handle gosvcd.ServiceHandle) {
+	b.handle = handle
+	for _, filter := range b.cfg.Subscribe {
+		unsub, err := b.conn.Subscribe(b.cfg.Prefix+filter, b.cfg.QoS, b.receive)
+		if err != nil {
+			b.unsubscribe()
+			panic(fmt.Errorf("subscribe %q: %w", filter, err))
+		}
+		b.unsubs = append(b.unsubs, unsub)
+	}
+}
+
+// receive emits the message. Payloads of unregistered event types that are
+// not valid JSON are emitted as strings.
+func (b *Bridge) receive(msg Message) {
+	if msg.Retained && b.cfg.IgnoreRetained {
+		return
+	}
+	typ, ok := TypeForTopic(b.cfg.Prefix, msg.Topic)
+	if !ok {
+		b.error(fmt.Errorf("message on unexpected topic %q", msg.Topic))
+		return
+	}
+	data, err := gosvcd.Payloads.Decode(typ, "", msg.Payload)
+	if err != nil {
+		if gosvcd.Payloads.Codec(typ) != gosvcd.JSONCodec {
+			b.error(fmt.Errorf("decode %s from %q: %w", typ, msg.Topic, err))
+			return
+		}
+		data = string(msg.Payload)
+	}
+	b.handle.EmitEvent(typ, data)
+}
+
+// HandleEvent publishes the event. Events emitted by the bridge itself
+// are not published back.
+func (b *Bridge) HandleEvent(ev gosvcd.Event) {
+	if ev.ServiceId() == b.id {
+		return
+	}
+	_, payload, err := gosvcd.Payloads.Encode(ev.EventType(), ev.Data())
+	if err != nil {
+		b.error(fmt.Errorf("encode %s: %w", ev.EventType(), err))
+		return
+	}
+	qos, ok := b.cfg.QoSByType[ev.EventType()]
+	if !ok {
+		qos = b.cfg.QoS
+	}
+	topic := TopicForType(b.cfg.Prefix, ev.EventType())
+	if err := b.conn.Publish(topic, qos, b.retain[ev.EventType()], payload); err != nil {
+		b.error(fmt.Errorf("publish %s: %w", ev.EventType(), err))
+	}
+}
+
+// Shutdown unsubscribes from the topic filters.
+func (b *Bridge) Shutdown() {
+	b.unsubscribe()
+}
+
+func (b *Bridge) unsubscribe() {
+	for _, unsub := range b.unsubs {
+		unsub()
+	}
+	b.unsubs = nil
+}
+
+func (b *Bridge) error(err error) {
+	if b.cfg.OnError != nil {
+		b.cfg.OnError(err)
+	}
+}
@@ -0,0 +1,422 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+var errShort = errors.New("msgpack: unexpected end of data")
+
+// maxDepth limits the nesting of the arrays and maps, so that a deeply
+// nested input does not exhaust the stack.
+const maxDepth = 10000
+
+// Unmarshal decodes the MessagePack data into the value pointed to by v.
+// Into an interface{} value maps are decoded as map[string]interface{} if
+// all the keys are strings, integers as int64 or uint64, floats as float64,
+// binary as []byte and timestamps as time.Time.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: Unmarshal of non-pointer %T", v)
+	}
+	d := decoder{data: data}
+	x, err := d.decode()
+	if err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return errors.New("msgpack: trailing data")
+	}
+	return assign(rv.Elem(), x)
+}
+
+type decoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// count returns the length 'n' of an array or a map, checking that its
+// elements, of at least 'size' bytes each, fit in the remaining data.
+func (d *decoder) count(n uint64, size int) (int, error) {
+	if n > uint64(len(d.data)-d.pos)/uint64(size) {
+		return 0, errShort
+	}
+	return int(n), nil
+}
+
+func (d *decoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// decode decodes the next value into its generic form.
+func (d *decoder) decode() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.decodeMap(uint64(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.decodeArray(uint64(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.decodeString(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(int(n))
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+	case 0xd0:
+		n, err := d.uint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.uint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.uint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.uint(8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type byte 0x%x", c)
+}
+
+func (d *decoder) decodeString(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// nest enters an array or a map.
+func (d *decoder) nest() error {
+	if d.depth++; d.depth > maxDepth {
+		return errors.New("msgpack: exceeded max depth")
+	}
+	return nil
+}
+
+func (d *decoder) decodeArray(count uint64) (interface{}, error) {
+	n, err := d.count(count, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { d.depth-- }()
+	a := make([]interface{}, n)
+	for i := range a {
+		x, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		a[i] = x
+	}
+	return a, nil
+}
+
+func (d *decoder) decodeMap(count uint64) (interface{}, error) {
+	n, err := d.count(count, 2)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { d.depth-- }()
+	keys := make([]interface{}, n)
+	values := make([]interface{}, n)
+	stringKeys := true
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.(string); !ok {
+			stringKeys = false
+		}
+		keys[i], values[i] = k, v
+	}
+	if stringKeys {
+		m := make(map[string]interface{}, n)
+		for i, k := range keys {
+			m[k.(string)] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i, k := range keys {
+		if k != nil && !reflect.TypeOf(k).Comparable() {
+			return nil, fmt.Errorf("msgpack: unhashable map key %T", k)
+		}
+		m[k] = values[i]
+	}
+	return m, nil
+}
+
+// decodeExt decodes an extension value. Only the timestamp type (-1) is
+// supported.
+func (d *decoder) decodeExt(n int) (interface{}, error) {
+	b, err := d.next(n + 1)
+	if err != nil {
+		return nil, err
+	}
+	typ, b := int8(b[0]), b[1:]
+	if typ != -1 {
+		return nil, fmt.Errorf("msgpack: unsupported extension type %d", typ)
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0), nil
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b))), nil
+	}
+	return nil, fmt.Errorf("msgpack: invalid timestamp length %d", n)
+}
+
+// assign assigns the generic value 'x' to 'v'.
+func assign(v reflect.Value, x interface{}) error {
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	xv := reflect.ValueOf(x)
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		v.Set(xv)
+		return nil
+	}
+	if v.Type() == timeType {
+		t, ok := x.(time.Time)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return assign(v.Elem(), x)
+	case reflect.Bool:
+		b, ok := x.(bool)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := x.(int64)
+		if !ok || v.OverflowInt(n) {
+			return typeError(x, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch x := x.(type) {
+		case int64:
+			if x < 0 {
+				return typeError(x, v.Type())
+			}
+			n = uint64(x)
+		case uint64:
+			n = x
+		default:
+			return typeError(x, v.Type())
+		}
+		if v.OverflowUint(n) {
+			return typeError(x, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch x := x.(type) {
+		case float64:
+			v.SetFloat(x)
+		case int64:
+			v.SetFloat(float64(x))
+		case uint64:
+			v.SetFloat(float64(x))
+		default:
+			return typeError(x, v.Type())
+		}
+	case reflect.String:
+		s, ok := x.(string)
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		v.SetString(s)
+	case reflect.Slice:
+		if b, ok := x.([]byte); ok && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(b)
+			return nil
+		}
+		a, ok := x.([]interface{})
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		s := reflect.MakeSlice(v.Type(), len(a), len(a))
+		for i := range a {
+			if err := assign(s.Index(i), a[i]); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		a, ok := x.([]interface{})
+		if !ok || len(a) != v.Len() {
+			return typeError(x, v.Type())
+		}
+		for i := range a {
+			if err := assign(v.Index(i), a[i]); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		set := func(k, e interface{}) error {
+			kv := reflect.New(v.Type().Key()).Elem()
+			if err := assign(kv, k); err != nil {
+				return err
+			}
+			ev := reflect.New(v.Type().Elem()).Elem()
+			if err := assign(ev, e); err != nil {
+				return err
+			}
+			m.SetMapIndex(kv, ev)
+			return nil
+		}
+		switch x := x.(type) {
+		case map[string]interface{}:
+			for k, e := range x {
+				if err := set(k, e); err != nil {
+					return err
+				}
+			}
+		case map[interface{}]interface{}:
+			for k, e := range x {
+				if err := set(k, e); err != nil {
+					return err
+				}
+			}
+		default:
+			return typeError(x, v.Type())
+		}
+		v.Set(m)
+	case reflect.Struct:
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return typeError(x, v.Type())
+		}
+		for _, f := range structFields(v.Type()) {
+			if e, ok := m[f.name]; ok {
+				if err := assign(v.Field(f.index), e); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func typeError(x interface{}, t reflect.Type) error {
+	return fmt.Errorf("msgpack: cannot decode %T into %s", x, t)
+}
@@ -0,0 +1,293 @@
+// Package msgpack implements the MessagePack encoding, a compact binary
+// alternative to JSON for high event volumes.
+//
+// Values are encoded following the encoding/json conventions: structs are
+// encoded as maps keyed by the field names, which can be changed with the
+// "json" struct tag, and []byte as binary. time.Time is encoded with the
+// timestamp extension type.
+package msgpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Codec is the "msgpack" payload codec, usable as a gosvcd.Codec.
+var Codec = codec{}
+
+type codec struct{}
+
+func (codec) Name() string                               { return "msgpack" }
+func (codec) Marshal(v interface{}) ([]byte, error)      { return Marshal(v) }
+func (codec) Unmarshal(data []byte, v interface{}) error { return Unmarshal(data, v) }
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Marshal returns the MessagePack encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	var e encoder
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte1(b byte) { e.buf = append(e.buf, b) }
+
+func (e *encoder) uint16(b byte, n uint16) {
+	e.buf = append(e.buf, b, 0, 0)
+	binary.BigEndian.PutUint16(e.buf[len(e.buf)-2:], n)
+}
+
+func (e *encoder) uint32(b byte, n uint32) {
+	e.buf = append(e.buf, b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], n)
+}
+
+func (e *encoder) uint64(b byte, n uint64) {
+	e.buf = append(e.buf, b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], n)
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.byte1(0xc0)
+		return nil
+	}
+	if v.Type() == timeType {
+		e.encodeTime(v.Interface().(time.Time))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.byte1(0xc3)
+		} else {
+			e.byte1(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.uint32(0xca, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.uint64(0xcb, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *encoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.byte1(byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.uint16(0xd1, uint16(n))
+	case n >= math.MinInt32:
+		e.uint32(0xd2, uint32(n))
+	default:
+		e.uint64(0xd3, uint64(n))
+	}
+}
+
+func (e *encoder) encodeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.byte1(byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(0xcd, uint16(n))
+	case n <= math.MaxUint32:
+		e.uint32(0xce, uint32(n))
+	default:
+		e.uint64(0xcf, n)
+	}
+}
+
+func (e *encoder) encodeString(s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		e.byte1(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(0xda, uint16(n))
+	default:
+		e.uint32(0xdb, uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) encodeBytes(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(0xc5, uint16(n))
+	default:
+		e.uint32(0xc6, uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) arrayHeader(n int) {
+	switch {
+	case n <= 15:
+		e.byte1(0x90 | byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(0xdc, uint16(n))
+	default:
+		e.uint32(0xdd, uint32(n))
+	}
+}
+
+func (e *encoder) mapHeader(n int) {
+	switch {
+	case n <= 15:
+		e.byte1(0x80 | byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(0xde, uint16(n))
+	default:
+		e.uint32(0xdf, uint32(n))
+	}
+}
+
+func (e *encoder) encodeArray(v reflect.Value) error {
+	e.arrayHeader(v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeMap(v reflect.Value) error {
+	keys := v.MapKeys()
+	if v.Type().Key().Kind() == reflect.String {
+		// Sort for a deterministic encoding.
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	}
+	e.mapHeader(len(keys))
+	for _, k := range keys {
+		if err := e.encode(k); err != nil {
+			return err
+		}
+		if err := e.encode(v.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) error {
+	fields := structFields(v.Type())
+	n := 0
+	for _, f := range fields {
+		if !(f.omitEmpty && v.Field(f.index).IsZero()) {
+			n++
+		}
+	}
+	e.mapHeader(n)
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		e.encodeString(f.name)
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeTime encodes the time with the timestamp extension type (-1) in
+// the 96-bit format.
+func (e *encoder) encodeTime(t time.Time) {
+	e.buf = append(e.buf, 0xc7, 12, 0xff)
+	e.buf = append(e.buf, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.buf[len(e.buf)-12:], uint32(t.Nanosecond()))
+	binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], uint64(t.Unix()))
+}
+
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// structFields returns the encoded fields of the struct: the exported
+// fields not tagged with `json:"-"`.
+func structFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		f := field{name: sf.Name, index: i}
+		if tag, ok := sf.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				f.name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				if opt == "omitempty" {
+					f.omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
@@ -0,0 +1,145 @@
+// Package natsbridge connects a gosvcd daemon to NATS: selected local
+// events are published to NATS subjects and the messages from subscribed
+// subjects are emitted as local events, allowing multiple daemons to form
+// an event mesh.
+//
+// The package does not depend on a NATS client. The bridge publishes and
+// subscribes through Conn, whose two methods map onto Publish and Subscribe
+// of *nats.Conn from github.com/nats-io/nats.go.
+package natsbridge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// DefaultPrefix is the default prefix of the subjects of forwarded events.
+const DefaultPrefix = "gosvcd.events."
+
+// Conn is the NATS connection used by the bridge.
+type Conn interface {
+	// Publish publishes the data to the subject.
+	Publish(subject string, data []byte) error
+
+	// Subscribe calls 'handler' with the messages published to the
+	// subject, which may contain wildcards. Returns a function to
+	// unsubscribe.
+	Subscribe(subject string, handler func(subject string, data []byte)) (func() error, error)
+}
+
+// Config of the bridge.
+type Config struct {
+	// Forward are the local event types published to NATS. An event
+	// of type T is published to the subject Prefix+T.
+	Forward []gosvcd.EventType
+
+	// Subjects are the NATS subjects from which the messages are emitted
+	// as local events. The messages must be wire.Envelopes and the event
+	// type is taken from the envelope.
+	Subjects []string
+
+	// Prefix of the subjects for the forwarded events. Defaults to
+	// DefaultPrefix.
+	Prefix string
+
+	// Origin identifies this process in the mesh. Messages with the
+	// same origin are not emitted locally. Defaults to hostname:pid.
+	Origin string
+
+	// OnError is called with the errors of publishing and decoding
+	// messages. Optional.
+	OnError func(error)
+}
+
+// Bridge is a service that bridges events to and from NATS.
+type Bridge struct {
+	id     gosvcd.ServiceId
+	conn   Conn
+	cfg    Config
+	handle gosvcd.ServiceHandle
+	unsubs []func() error
+}
+
+// New returns the bridge service with the given identifier.
+func New(id gosvcd.ServiceId, conn Conn, cfg Config) *Bridge {
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	if cfg.Origin == "" {
+		cfg.Origin = wire.DefaultOrigin()
+	}
+	return &Bridge{id: id, conn: conn, cfg: cfg}
+}
+
+func (b *Bridge) ID() gosvcd.ServiceId              { return b.id }
+func (b *Bridge) Name() string                      { return "nats-bridge" }
+func (b *Bridge) Dependencies() []gosvcd.ServiceId  { return nil }
+func (b *Bridge) Subscriptions() []gosvcd.EventType { return b.cfg.Forward }
+
+// Init subscribes to the configured subjects. Fails the service if a
+// subscription fails.
+func (b *Bridge) Init(handle gosvcd.ServiceHandle) {
+	b.handle = handle
+	for _, subject := range b.cfg.Subjects {
+		unsub, err := b.conn.Subscribe(subject, b.receive)
+		if err != nil {
+			b.unsubscribe()
+			panic(fmt.Errorf("subscribe %q: %w", subject, err))
+		}
+		b.unsubs = append(b.unsubs, unsub)
+	}
+}
+
+func (b *Bridge) receive(subject string, data []byte) {
+	env, err := wire.Decode(data)
+	if err != nil {
+		b.error(fmt.Errorf("decode message from %q: %w", subject, err))
+		return
+	}
+	if env.Origin == b.cfg.Origin {
+		return
+	}
+	v, err := env.Value()
+	if err != nil {
+		b.error(fmt.Errorf("decode %s data from %q: %w", env.Type, subject, err))
+		return
+	}
+	b.handle.EmitEventContext(env.Context(context.Background()), env.Type, v)
+}
+
+// HandleEvent publishes the event. Events emitted by the bridge itself
+// are not published back.
+func (b *Bridge) HandleEvent(ev gosvcd.Event) {
+	if ev.ServiceId() == b.id {
+		return
+	}
+	data, err := wire.Encode(b.cfg.Origin, ev)
+	if err != nil {
+		b.error(fmt.Errorf("encode %s: %w", ev.EventType(), err))
+		return
+	}
+	if err := b.conn.Publish(b.cfg.Prefix+string(ev.EventType()), data); err != nil {
+		b.error(fmt.Errorf("publish %s: %w", ev.EventType(), err))
+	}
+}
+
+// Shutdown unsubscribes from the subjects.
+func (b *Bridge) Shutdown() {
+	b.unsubscribe()
+}
+
+func (b *Bridge) unsubscribe() {
+	for _, unsub := range b.unsubs {
+		unsub()
+	}
+	b.unsubs = nil
+}
+
+func (b *Bridge) error(err error) {
+	if b.cfg.OnError != nil {
+		b.cfg.OnError(err)
+	}
+}
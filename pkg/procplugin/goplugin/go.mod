@@ -0,0 +1,30 @@
+module github.com/joamaki/gosvcd/pkg/procplugin/goplugin
+
+go 1.25.0
+
+require (
+	github.com/hashicorp/go-hclog v1.6.3
+	github.com/hashicorp/go-plugin v1.8.0
+	github.com/joamaki/gosvcd v0.0.0
+	github.com/joamaki/gosvcd/pkg/remotesvc/remotesvcpb v0.0.0
+	google.golang.org/grpc v1.84.0
+)
+
+require (
+	github.com/fatih/color v1.13.0 // indirect
+	github.com/golang/protobuf v1.5.4 // indirect
+	github.com/hashicorp/yamux v0.1.2 // indirect
+	github.com/mattn/go-colorable v0.1.12 // indirect
+	github.com/mattn/go-isatty v0.0.17 // indirect
+	github.com/oklog/run v1.1.0 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800 // indirect
+	google.golang.org/protobuf v1.36.11 // indirect
+)
+
+replace (
+	github.com/joamaki/gosvcd => ../../..
+	github.com/joamaki/gosvcd/pkg/remotesvc/remotesvcpb => ../../remotesvc/remotesvcpb
+)
@@ -0,0 +1,214 @@
+// Package goplugin runs a service in a child process with
+// github.com/hashicorp/go-plugin, as package procplugin does with its own
+// handshake. The plugins are go-plugin gRPC plugins serving the
+// RemoteService of package remotesvcpb, so that they are started, logged
+// and stopped as any other go-plugin plugin. It is a module of its own, so
+// that the daemon depends on go-plugin and gRPC only if it runs such
+// plugins:
+//
+//	var handshake = plugin.HandshakeConfig{
+//		ProtocolVersion:  1,
+//		MagicCookieKey:   "ALERTS_PLUGIN",
+//		MagicCookieValue: "f1c3d0c1",
+//	}
+//
+//	b.Register(goplugin.New(goplugin.Config{
+//		ID:        40,
+//		Name:      "alerts",
+//		Handshake: handshake,
+//		Command:   func() *exec.Cmd { return exec.Command("/usr/libexec/alerts") },
+//	}))
+//
+// and in the plugin:
+//
+//	func main() {
+//		goplugin.Serve(&Alerts{}, handshake)
+//	}
+package goplugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/remotesvc"
+	"github.com/joamaki/gosvcd/pkg/remotesvc/remotesvcpb"
+)
+
+// PluginName is the name the service is dispensed with.
+const PluginName = "service"
+
+// Config describes the plugin to the daemon.
+type Config struct {
+	ID            gosvcd.ServiceId
+	Name          string
+	Dependencies  []gosvcd.ServiceId
+	Subscriptions []gosvcd.EventType
+
+	Handshake plugin.HandshakeConfig
+
+	// Command returns the command starting the plugin process. It is
+	// called for each start.
+	Command func() *exec.Cmd
+
+	// Stderr receives the standard error of the plugin, in addition to
+	// the log. Optional.
+	Stderr io.Writer
+
+	// Logger is the logger of go-plugin, hclog's default logger if nil.
+	Logger hclog.Logger
+
+	// StartTimeout limits the wait for the handshake. Defaults to the
+	// timeout of go-plugin.
+	StartTimeout time.Duration
+
+	// RetryInterval is the interval of restarting the plugin after it
+	// exits. Defaults to remotesvc.DefaultRetryInterval.
+	RetryInterval time.Duration
+
+	// OnError is called with the errors of starting and communicating
+	// with the plugin. Optional.
+	OnError func(error)
+}
+
+// Plugin is the service standing in for the service run by the plugin
+// process. It reports ready when it is connected to the plugin.
+type Plugin struct {
+	*remotesvc.Proxy
+	cfg Config
+
+	mu      sync.Mutex
+	client  *plugin.Client
+	stopped bool
+}
+
+// New returns the plugin service.
+func New(cfg Config) *Plugin {
+	p := &Plugin{cfg: cfg}
+	p.Proxy = remotesvc.NewProxy(remotesvc.Config{
+		ID:            cfg.ID,
+		Name:          cfg.Name,
+		Dependencies:  cfg.Dependencies,
+		Subscriptions: cfg.Subscriptions,
+		Dial:          p.start,
+		RetryInterval: cfg.RetryInterval,
+		OnError:       p.error,
+	})
+	return p
+}
+
+// error passes the error to OnError, unless the plugin has been stopped by
+// Shutdown.
+func (p *Plugin) error(err error) {
+	p.mu.Lock()
+	stopped := p.stopped
+	p.mu.Unlock()
+	if !stopped && p.cfg.OnError != nil {
+		p.cfg.OnError(err)
+	}
+}
+
+// start starts the plugin process, killing the previous one, and opens
+// the stream to the service.
+func (p *Plugin) start(ctx context.Context) (remotesvc.Stream, error) {
+	p.mu.Lock()
+	prev := p.client
+	p.client = nil
+	p.mu.Unlock()
+	if prev != nil {
+		prev.Kill()
+	}
+
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  p.cfg.Handshake,
+		Plugins:          plugin.PluginSet{PluginName: &GRPCPlugin{}},
+		Cmd:              p.cfg.Command(),
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		StartTimeout:     p.cfg.StartTimeout,
+		Stderr:           p.cfg.Stderr,
+		Logger:           p.cfg.Logger,
+	})
+	stream, err := p.open(ctx, client)
+	if err != nil {
+		client.Kill()
+		return nil, err
+	}
+	p.mu.Lock()
+	p.client, p.stopped = client, false
+	p.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		client.Kill()
+	}()
+	return stream, nil
+}
+
+func (p *Plugin) open(ctx context.Context, client *plugin.Client) (remotesvc.Stream, error) {
+	rpc, err := client.Client()
+	if err != nil {
+		return nil, fmt.Errorf("start plugin: %w", err)
+	}
+	raw, err := rpc.Dispense(PluginName)
+	if err != nil {
+		return nil, fmt.Errorf("dispense plugin: %w", err)
+	}
+	dial, ok := raw.(func(ctx context.Context) (remotesvc.Stream, error))
+	if !ok {
+		return nil, fmt.Errorf("unexpected plugin %T", raw)
+	}
+	return dial(ctx)
+}
+
+// Shutdown stops the plugin process.
+func (p *Plugin) Shutdown() {
+	p.mu.Lock()
+	client := p.client
+	p.client, p.stopped = nil, true
+	p.mu.Unlock()
+	if client != nil {
+		client.Kill()
+	}
+}
+
+// GRPCPlugin is the go-plugin plugin of a service. In the plugin process
+// it serves Service, and in the daemon it is dispensed as the Dial function
+// of remotesvc.Config.
+type GRPCPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+
+	// Service is the service run by the plugin process.
+	Service gosvcd.Service
+}
+
+func (p *GRPCPlugin) GRPCServer(_ *plugin.GRPCBroker, s *grpc.Server) error {
+	remotesvcpb.RegisterRemoteServiceServer(s, remotesvcpb.NewServer(p.Service))
+	return nil
+}
+
+func (p *GRPCPlugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return remotesvcpb.Dial(conn), nil
+}
+
+//
+// Plugin side
+//
+
+// Serve runs the service in the plugin process for the daemon, as
+// plugin.Serve does: the process exits once the daemon has killed it, and
+// when it was not started by the daemon, i.e. without the magic cookie.
+func Serve(svc gosvcd.Service, hs plugin.HandshakeConfig) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: hs,
+		Plugins:         plugin.PluginSet{PluginName: &GRPCPlugin{Service: svc}},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
@@ -0,0 +1,329 @@
+// Package procplugin runs a service in a child process supervised by the
+// daemon, isolating the daemon from the crashes of risky components. The
+// daemon registers a Plugin in place of the service, and the child process
+// runs the service with Serve.
+//
+// The child is started with a magic cookie in its environment, listens on
+// a local socket and writes a handshake line to its standard output:
+//
+//	CORE-PROTOCOL-VERSION|APP-PROTOCOL-VERSION|NETWORK-TYPE|NETWORK-ADDR|PROTOCOL
+//
+// after which the daemon connects to the socket, which carries the event
+// stream of package remotesvc. The start-up borrows the handshake format of
+// github.com/hashicorp/go-plugin, but the protocol is not compatible with
+// it: the PROTOCOL is "gosvcd" rather than "grpc" or "netrpc", and the
+// plugins must be built with Serve. Package goplugin runs the plugins with
+// go-plugin instead. If the child exits, it is restarted after the retry
+// interval.
+package procplugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/remotesvc"
+)
+
+// CoreProtocolVersion is the version of the handshake.
+const CoreProtocolVersion = 1
+
+// Protocol is the protocol named in the handshake.
+const Protocol = "gosvcd"
+
+// Defaults of Config.
+const (
+	DefaultStartTimeout = 10 * time.Second
+	DefaultKillTimeout  = 5 * time.Second
+)
+
+// ErrNotPlugin is returned by Serve when the process was not started as a
+// plugin, i.e. without the magic cookie.
+var ErrNotPlugin = errors.New("not started as a plugin")
+
+// Handshake is shared by the daemon and the plugins. The magic cookie is not
+// a security measure, it only prevents running the plugin directly.
+type Handshake struct {
+	// ProtocolVersion is the version of the application's protocol. The
+	// daemon rejects the plugins of other versions.
+	ProtocolVersion int
+
+	MagicCookieKey   string
+	MagicCookieValue string
+}
+
+// Config describes the plugin to the daemon.
+type Config struct {
+	ID            gosvcd.ServiceId
+	Name          string
+	Dependencies  []gosvcd.ServiceId
+	Subscriptions []gosvcd.EventType
+
+	Handshake Handshake
+
+	// Command returns the command starting the plugin process. It is
+	// called for each start. The environment is extended with the magic
+	// cookie, and the standard output is used for the handshake.
+	Command func() *exec.Cmd
+
+	// Stderr receives the standard error of the plugin, os.Stderr if nil.
+	Stderr io.Writer
+
+	// StartTimeout limits the wait for the handshake. Defaults to
+	// DefaultStartTimeout.
+	StartTimeout time.Duration
+
+	// KillTimeout is the time the plugin is given to exit after its
+	// connection is closed at shutdown, before it is killed. Defaults to
+	// DefaultKillTimeout.
+	KillTimeout time.Duration
+
+	// RetryInterval is the interval of restarting the plugin after it
+	// exits. Defaults to remotesvc.DefaultRetryInterval.
+	RetryInterval time.Duration
+
+	// OnError is called with the errors of starting and communicating
+	// with the plugin. Optional.
+	OnError func(error)
+}
+
+// Plugin is the service standing in for the service run by the plugin
+// process. It reports ready when it is connected to the plugin.
+type Plugin struct {
+	*remotesvc.Proxy
+	cfg Config
+
+	mu      sync.Mutex
+	proc    *process
+	stopped bool
+}
+
+// process is a running plugin process.
+type process struct {
+	cmd    *exec.Cmd
+	conn   net.Conn
+	exited chan struct{}
+}
+
+// New returns the plugin service.
+func New(cfg Config) *Plugin {
+	if cfg.StartTimeout <= 0 {
+		cfg.StartTimeout = DefaultStartTimeout
+	}
+	if cfg.KillTimeout <= 0 {
+		cfg.KillTimeout = DefaultKillTimeout
+	}
+	if cfg.Stderr == nil {
+		cfg.Stderr = os.Stderr
+	}
+	p := &Plugin{cfg: cfg}
+	p.Proxy = remotesvc.NewProxy(remotesvc.Config{
+		ID:            cfg.ID,
+		Name:          cfg.Name,
+		Dependencies:  cfg.Dependencies,
+		Subscriptions: cfg.Subscriptions,
+		Dial:          p.start,
+		RetryInterval: cfg.RetryInterval,
+		OnError:       p.error,
+	})
+	return p
+}
+
+// error passes the error to OnError, unless the plugin has been stopped by
+// Shutdown.
+func (p *Plugin) error(err error) {
+	p.mu.Lock()
+	stopped := p.stopped
+	p.mu.Unlock()
+	if !stopped && p.cfg.OnError != nil {
+		p.cfg.OnError(err)
+	}
+}
+
+// start starts the plugin process and connects to it.
+func (p *Plugin) start(ctx context.Context) (remotesvc.Stream, error) {
+	cmd := p.cfg.Command()
+	cmd.Env = append(environ(cmd), p.cfg.Handshake.MagicCookieKey+"="+p.cfg.Handshake.MagicCookieValue)
+	cmd.Stderr = p.cfg.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start plugin: %w", err)
+	}
+	proc := &process{cmd: cmd, exited: make(chan struct{})}
+	lines := make(chan string, 1)
+	go func() {
+		r := bufio.NewReader(stdout)
+		line, err := r.ReadString('\n')
+		if err == nil {
+			lines <- strings.TrimSpace(line)
+		}
+		// Drain the output after the handshake so that the plugin does
+		// not block writing to it.
+		io.Copy(ioutil.Discard, r)
+		cmd.Wait()
+		close(proc.exited)
+	}()
+
+	var line string
+	select {
+	case line = <-lines:
+	case <-proc.exited:
+		return nil, fmt.Errorf("plugin exited before the handshake: %s", cmd.ProcessState)
+	case <-time.After(p.cfg.StartTimeout):
+		proc.kill()
+		return nil, fmt.Errorf("no handshake from plugin within %s", p.cfg.StartTimeout)
+	case <-ctx.Done():
+		proc.kill()
+		return nil, ctx.Err()
+	}
+	network, addr, err := p.parseHandshake(line)
+	if err != nil {
+		proc.kill()
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		proc.kill()
+		return nil, fmt.Errorf("connect to plugin: %w", err)
+	}
+	proc.conn = conn
+	p.mu.Lock()
+	p.proc, p.stopped = proc, false
+	p.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.stop(proc)
+		case <-proc.exited:
+			conn.Close()
+		}
+	}()
+	return remotesvc.NewConnStream(conn), nil
+}
+
+func (p *Plugin) parseHandshake(line string) (string, string, error) {
+	parts := strings.Split(line, "|")
+	if len(parts) != 5 {
+		return "", "", fmt.Errorf("invalid handshake from plugin: %q", line)
+	}
+	if parts[0] != strconv.Itoa(CoreProtocolVersion) {
+		return "", "", fmt.Errorf("plugin core protocol version %s, expected %d", parts[0], CoreProtocolVersion)
+	}
+	if parts[1] != strconv.Itoa(p.cfg.Handshake.ProtocolVersion) {
+		return "", "", fmt.Errorf("plugin protocol version %s, expected %d", parts[1], p.cfg.Handshake.ProtocolVersion)
+	}
+	if parts[4] != Protocol {
+		return "", "", fmt.Errorf("plugin protocol %q, expected %q", parts[4], Protocol)
+	}
+	return parts[2], parts[3], nil
+}
+
+// stop closes the connection, which makes the plugin exit, and kills the
+// plugin if it does not exit within the timeout.
+func (p *Plugin) stop(proc *process) {
+	proc.conn.Close()
+	select {
+	case <-proc.exited:
+	case <-time.After(p.cfg.KillTimeout):
+		proc.kill()
+		<-proc.exited
+	}
+}
+
+func (proc *process) kill() {
+	if proc.cmd.Process != nil {
+		proc.cmd.Process.Kill()
+	}
+}
+
+// Shutdown stops the plugin process.
+func (p *Plugin) Shutdown() {
+	p.mu.Lock()
+	proc := p.proc
+	p.proc, p.stopped = nil, true
+	p.mu.Unlock()
+	if proc != nil {
+		p.stop(proc)
+	}
+}
+
+func environ(cmd *exec.Cmd) []string {
+	if cmd.Env != nil {
+		return cmd.Env
+	}
+	return os.Environ()
+}
+
+//
+// Plugin side
+//
+
+// Serve runs the service in the plugin process for the daemon: it listens
+// on a local socket, writes the handshake to the standard output and runs
+// the service for the connection as remotesvc.Serve does. Returns when the
+// connection is closed, which the plugin should follow by exiting. Returns
+// ErrNotPlugin if the magic cookie is not set.
+//
+// Interrupts are ignored, as the daemon stops the plugin when it shuts
+// down.
+func Serve(svc gosvcd.Service, hs Handshake) error {
+	if os.Getenv(hs.MagicCookieKey) != hs.MagicCookieValue {
+		return ErrNotPlugin
+	}
+	signal.Ignore(os.Interrupt)
+
+	l, cleanup, err := listen()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	fmt.Printf("%d|%d|%s|%s|%s\n", CoreProtocolVersion, hs.ProtocolVersion,
+		l.Addr().Network(), l.Addr().String(), Protocol)
+	os.Stdout.Sync()
+
+	conn, err := l.Accept()
+	l.Close()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return remotesvc.Serve(context.Background(), svc, remotesvc.NewConnStream(conn))
+}
+
+// listen listens on a Unix socket in a temporary directory, or on the
+// loopback interface on Windows.
+func listen() (net.Listener, func(), error) {
+	if runtime.GOOS == "windows" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		return l, func() {}, err
+	}
+	dir, err := ioutil.TempDir("", "gosvcd-plugin")
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := net.Listen("unix", filepath.Join(dir, "plugin.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	return l, func() { os.RemoveAll(dir) }, nil
+}
@@ -0,0 +1,179 @@
+package redisbridge
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Client is a minimal Redis client implementing Conn. It speaks RESP over
+// one connection for publishing and opens a connection per subscription.
+// Lost connections are not re-established.
+type Client struct {
+	addr     string
+	password string
+
+	mu   sync.Mutex
+	conn *respConn
+}
+
+// Dial connects to the Redis server at addr, authenticating with the
+// password unless it is empty.
+func Dial(addr, password string) (*Client, error) {
+	c := &Client{addr: addr, password: password}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return c, nil
+}
+
+// Close closes the publishing connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *Client) Publish(channel string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.conn.send("PUBLISH", []byte(channel), data); err != nil {
+		return err
+	}
+	_, err := c.conn.receive()
+	return err
+}
+
+func (c *Client) Subscribe(channels []string, handler func(channel string, data []byte)) (func() error, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, len(channels))
+	for i, ch := range channels {
+		args[i] = []byte(ch)
+	}
+	if err := conn.send("SUBSCRIBE", args...); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			reply, err := conn.receive()
+			if err != nil {
+				return
+			}
+			// Pushed messages are ["message", channel, data].
+			msg, ok := reply.([]interface{})
+			if !ok || len(msg) != 3 {
+				continue
+			}
+			if kind, _ := msg[0].([]byte); string(kind) != "message" {
+				continue
+			}
+			channel, _ := msg[1].([]byte)
+			data, _ := msg[2].([]byte)
+			handler(string(channel), data)
+		}
+	}()
+	return func() error {
+		err := conn.Close()
+		<-done
+		return err
+	}, nil
+}
+
+func (c *Client) dial() (*respConn, error) {
+	nc, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &respConn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.password != "" {
+		if err := conn.send("AUTH", []byte(c.password)); err == nil {
+			_, err = conn.receive()
+		}
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// respConn is a connection speaking the Redis serialization protocol.
+type respConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// send sends the command as an array of bulk strings.
+func (c *respConn) send(cmd string, args ...[]byte) error {
+	buf := []byte("*" + strconv.Itoa(len(args)+1) + "\r\n")
+	buf = appendBulk(buf, []byte(cmd))
+	for _, arg := range args {
+		buf = appendBulk(buf, arg)
+	}
+	_, err := c.Write(buf)
+	return err
+}
+
+func appendBulk(buf, b []byte) []byte {
+	buf = append(buf, '$')
+	buf = strconv.AppendInt(buf, int64(len(b)), 10)
+	buf = append(buf, '\r', '\n')
+	buf = append(buf, b...)
+	return append(buf, '\r', '\n')
+}
+
+// receive reads a reply. Strings are returned as []byte, integers as int64
+// and arrays as []interface{}. Error replies are returned as errors.
+func (c *respConn) receive() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return []byte(rest), nil
+	case '-':
+		return nil, errors.New(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
@@ -0,0 +1,142 @@
+// Package redisbridge connects a gosvcd daemon to Redis pub/sub: selected
+// local events are published to Redis channels and the messages on the
+// channels of the injected event types are emitted as local events. The
+// messages are JSON encoded wire.Envelopes.
+//
+// The bridge works on top of a connection implementing Conn. Dial returns
+// a minimal built-in client, but e.g. a go-redis client can be adapted as
+// well.
+package redisbridge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// DefaultPrefix is the default prefix of the channel names.
+const DefaultPrefix = "gosvcd:events:"
+
+// Conn is the Redis connection used by the bridge.
+type Conn interface {
+	// Publish publishes the data to the channel.
+	Publish(channel string, data []byte) error
+
+	// Subscribe calls 'handler' with the messages published to the
+	// channels. Returns a function to unsubscribe.
+	Subscribe(channels []string, handler func(channel string, data []byte)) (func() error, error)
+}
+
+// Config of the bridge.
+type Config struct {
+	// Forward are the local event types published to Redis. An event
+	// of type T is published to the channel Prefix+T.
+	Forward []gosvcd.EventType
+
+	// Inject are the event types emitted locally from the messages
+	// on their channels.
+	Inject []gosvcd.EventType
+
+	// Prefix of the channel names. Defaults to DefaultPrefix.
+	Prefix string
+
+	// Origin identifies this process. Messages with the same origin
+	// are not emitted locally. Defaults to hostname:pid.
+	Origin string
+
+	// OnError is called with the errors of publishing and decoding
+	// messages. Optional.
+	OnError func(error)
+}
+
+// Bridge is a service that bridges events to and from Redis.
+type Bridge struct {
+	id     gosvcd.ServiceId
+	conn   Conn
+	cfg    Config
+	handle gosvcd.ServiceHandle
+	unsub  func() error
+}
+
+// New returns the bridge service with the given identifier.
+func New(id gosvcd.ServiceId, conn Conn, cfg Config) *Bridge {
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultPrefix
+	}
+	if cfg.Origin == "" {
+		cfg.Origin = wire.DefaultOrigin()
+	}
+	return &Bridge{id: id, conn: conn, cfg: cfg}
+}
+
+func (b *Bridge) ID() gosvcd.ServiceId              { return b.id }
+func (b *Bridge) Name() string                      { return "redis-bridge" }
+func (b *Bridge) Dependencies() []gosvcd.ServiceId  { return nil }
+func (b *Bridge) Subscriptions() []gosvcd.EventType { return b.cfg.Forward }
+
+// Init subscribes to the channels of the injected event types. Fails the
+// service if subscribing fails.
+func (b *Bridge) Init(handle gosvcd.ServiceHandle) {
+	b.handle = handle
+	if len(b.cfg.Inject) == 0 {
+		return
+	}
+	channels := make([]string, len(b.cfg.Inject))
+	for i, typ := range b.cfg.Inject {
+		channels[i] = b.cfg.Prefix + string(typ)
+	}
+	unsub, err := b.conn.Subscribe(channels, b.receive)
+	if err != nil {
+		panic(fmt.Errorf("subscribe: %w", err))
+	}
+	b.unsub = unsub
+}
+
+func (b *Bridge) receive(channel string, data []byte) {
+	env, err := wire.Decode(data)
+	if err != nil {
+		b.error(fmt.Errorf("decode message from %q: %w", channel, err))
+		return
+	}
+	if env.Origin == b.cfg.Origin {
+		return
+	}
+	v, err := env.Value()
+	if err != nil {
+		b.error(fmt.Errorf("decode %s data from %q: %w", env.Type, channel, err))
+		return
+	}
+	b.handle.EmitEventContext(env.Context(context.Background()), env.Type, v)
+}
+
+// HandleEvent publishes the event. Events emitted by the bridge itself
+// are not published back.
+func (b *Bridge) HandleEvent(ev gosvcd.Event) {
+	if ev.ServiceId() == b.id {
+		return
+	}
+	data, err := wire.Encode(b.cfg.Origin, ev)
+	if err != nil {
+		b.error(fmt.Errorf("encode %s: %w", ev.EventType(), err))
+		return
+	}
+	if err := b.conn.Publish(b.cfg.Prefix+string(ev.EventType()), data); err != nil {
+		b.error(fmt.Errorf("publish %s: %w", ev.EventType(), err))
+	}
+}
+
+// Shutdown unsubscribes from the channels.
+func (b *Bridge) Shutdown() {
+	if b.unsub != nil {
+		b.unsub()
+		b.unsub = nil
+	}
+}
+
+func (b *Bridge) error(err error) {
+	if b.cfg.OnError != nil {
+		b.cfg.OnError(err)
+	}
+}
@@ -0,0 +1,120 @@
+package remotesvc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// maxFrame limits the size of a frame on a connection stream.
+const maxFrame = 64 << 20
+
+// connStream carries the frames over a connection, each prefixed with its
+// big-endian 32-bit length.
+type connStream struct {
+	conn   net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewConnStream returns a stream over the connection, e.g. a TLS
+// connection, for running the remote service without gRPC.
+func NewConnStream(conn net.Conn) Stream {
+	return &connStream{conn: conn, closed: make(chan struct{})}
+}
+
+func (s *connStream) Send(frame []byte) error {
+	buf := make([]byte, 4, 4+len(frame))
+	binary.BigEndian.PutUint32(buf, uint32(len(frame)))
+	_, err := s.conn.Write(append(buf, frame...))
+	return err
+}
+
+func (s *connStream) Recv() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(s.conn, hdr[:]); err != nil {
+		s.close()
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n > maxFrame {
+		s.close()
+		return nil, fmt.Errorf("frame too large: %d bytes", n)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(s.conn, frame); err != nil {
+		s.close()
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (s *connStream) close() {
+	s.once.Do(func() {
+		s.conn.Close()
+		close(s.closed)
+	})
+}
+
+// DialTLS returns the Dial function of a Config connecting to the remote
+// service at the address with TLS. The remote service is served with
+// ServeListener on a TLS listener.
+func DialTLS(addr string, cfg *tls.Config) func(ctx context.Context) (Stream, error) {
+	return func(ctx context.Context) (Stream, error) {
+		d := &tls.Dialer{Config: cfg}
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		s := &connStream{conn: conn, closed: make(chan struct{})}
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.close()
+			case <-s.closed:
+			}
+		}()
+		return s, nil
+	}
+}
+
+// acceptRetryInterval is the interval of retrying after a temporary error
+// accepting a connection.
+const acceptRetryInterval = 100 * time.Millisecond
+
+// ServeListener runs the service for the proxies connecting to the
+// listener, e.g. one created with tls.NewListener, one connection at a
+// time, until 'ctx' is cancelled. The service is initialized and shut down
+// for each connection as by Serve.
+func ServeListener(ctx context.Context, l net.Listener, svc gosvcd.Service) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(acceptRetryInterval)
+				continue
+			}
+			return err
+		}
+		s := &connStream{conn: conn, closed: make(chan struct{})}
+		err = Serve(ctx, svc, s)
+		s.close()
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,279 @@
+// Package remotesvc allows a service graph to span processes. A Proxy is
+// registered in the local daemon in place of a service that runs in
+// another process: the events for its subscriptions are streamed to the
+// remote process and the events emitted by the remote service are emitted
+// locally with the proxy as the source. In the remote process the service
+// is run with Serve.
+//
+// Any ordered and reliable transport implementing Stream works. The gRPC
+// service defined in remotesvc.proto is generated in package remotesvcpb,
+// a module of its own, whose Dial and NewServer run the stream over its
+// bidirectional Connect call; the connection is secured with the
+// configuration of package tlsconfig passed to credentials.NewTLS. Without
+// gRPC, DialTLS and ServeListener run the stream over a TLS connection.
+package remotesvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// DefaultRetryInterval is the default interval of reconnecting to the
+// remote service.
+const DefaultRetryInterval = time.Second
+
+// ErrNotConnected is reported when an event cannot be sent to the remote
+// service as the proxy is not connected.
+var ErrNotConnected = errors.New("not connected to the remote service")
+
+// Stream carries frames, each holding a wire.Envelope, in both
+// directions. Send is not called concurrently.
+type Stream interface {
+	Send(frame []byte) error
+	Recv() ([]byte, error)
+}
+
+// Config describes the remote service to the local daemon and how to
+// connect to it.
+type Config struct {
+	ID            gosvcd.ServiceId
+	Name          string
+	Dependencies  []gosvcd.ServiceId
+	Subscriptions []gosvcd.EventType
+
+	// Dial opens the stream to the remote service. The stream must be
+	// closed when 'ctx' is cancelled.
+	Dial func(ctx context.Context) (Stream, error)
+
+	// RetryInterval is the interval of reconnecting after the stream
+	// fails. Defaults to DefaultRetryInterval.
+	RetryInterval time.Duration
+
+	// OnError is called with the errors of connecting, sending and
+	// decoding frames. Optional.
+	OnError func(error)
+}
+
+// Proxy is a local stand-in for a remote service. The proxy reports ready
+// when it is connected to the remote service.
+type Proxy struct {
+	cfg Config
+
+	mu     sync.Mutex
+	stream Stream
+}
+
+// NewProxy returns the proxy for the remote service.
+func NewProxy(cfg Config) *Proxy {
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultRetryInterval
+	}
+	return &Proxy{cfg: cfg}
+}
+
+func (p *Proxy) ID() gosvcd.ServiceId              { return p.cfg.ID }
+func (p *Proxy) Name() string                      { return p.cfg.Name }
+func (p *Proxy) Dependencies() []gosvcd.ServiceId  { return p.cfg.Dependencies }
+func (p *Proxy) Subscriptions() []gosvcd.EventType { return p.cfg.Subscriptions }
+
+// Init starts connecting to the remote service.
+func (p *Proxy) Init(handle gosvcd.ServiceHandle) {
+	handle.Go(func(ctx context.Context) { p.run(ctx, handle) })
+}
+
+func (p *Proxy) run(ctx context.Context, handle gosvcd.ServiceHandle) {
+	for {
+		stream, err := p.cfg.Dial(ctx)
+		if err == nil {
+			p.setStream(stream)
+			err = p.receive(ctx, stream, handle)
+			p.setStream(nil)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		p.error(fmt.Errorf("%s: %w", p.cfg.Name, err))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.cfg.RetryInterval):
+		}
+	}
+}
+
+// receive emits the events from the remote service until the stream fails.
+func (p *Proxy) receive(ctx context.Context, stream Stream, handle gosvcd.ServiceHandle) error {
+	for {
+		frame, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		env, err := wire.Decode(frame)
+		if err != nil {
+			p.error(fmt.Errorf("%s: decode frame: %w", p.cfg.Name, err))
+			continue
+		}
+		v, err := env.Value()
+		if err != nil {
+			p.error(fmt.Errorf("%s: decode %s data: %w", p.cfg.Name, env.Type, err))
+			continue
+		}
+		handle.EmitEventContext(env.Context(ctx), env.Type, v)
+	}
+}
+
+func (p *Proxy) setStream(stream Stream) {
+	p.mu.Lock()
+	p.stream = stream
+	p.mu.Unlock()
+}
+
+// HandleEvent sends the event to the remote service. Events are dropped
+// while disconnected.
+func (p *Proxy) HandleEvent(ev gosvcd.Event) {
+	frame, err := wire.Encode("", ev)
+	if err != nil {
+		p.error(fmt.Errorf("%s: encode %s: %w", p.cfg.Name, ev.EventType(), err))
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stream == nil {
+		p.error(fmt.Errorf("%s: dropped %s: %w", p.cfg.Name, ev.EventType(), ErrNotConnected))
+		return
+	}
+	if err := p.stream.Send(frame); err != nil {
+		p.error(fmt.Errorf("%s: send %s: %w", p.cfg.Name, ev.EventType(), err))
+	}
+}
+
+// Shutdown does nothing as the stream is closed when the service's
+// context is cancelled.
+func (p *Proxy) Shutdown() {}
+
+// Readiness implements gosvcd.ReadinessChecker.
+func (p *Proxy) Readiness() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stream == nil {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+func (p *Proxy) error(err error) {
+	if p.cfg.OnError != nil {
+		p.cfg.OnError(err)
+	}
+}
+
+//
+// Remote side
+//
+
+// Serve runs the service for a connected proxy: the service is initialized,
+// handles the events received from the stream and is shut down when the
+// stream ends, 'ctx' is cancelled or the service unregisters itself.
+// Returns nil if the stream ended with io.EOF.
+func Serve(ctx context.Context, svc gosvcd.Service, stream Stream) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	h := &remoteHandle{svc: svc, stream: stream, ctx: ctx, cancel: cancel}
+	defer func() {
+		cancel()
+		if r := recover(); r != nil && err == nil {
+			err = fmt.Errorf("%s panicked: %v", svc.Name(), r)
+		}
+		h.wg.Wait()
+	}()
+
+	svc.Init(h)
+	defer svc.Shutdown()
+
+	frames := make(chan []byte)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			frame, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case frames <- frame:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case frame := <-frames:
+			env, err := wire.Decode(frame)
+			if err != nil {
+				return fmt.Errorf("decode frame: %w", err)
+			}
+			ev, err := env.Event(ctx)
+			if err != nil {
+				return fmt.Errorf("decode %s data: %w", env.Type, err)
+			}
+			svc.HandleEvent(ev)
+		}
+	}
+}
+
+// remoteHandle is the handle of a service run with Serve. The emitted
+// events are sent to the proxy.
+type remoteHandle struct {
+	svc    gosvcd.Service
+	stream Stream
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	sendMu sync.Mutex
+}
+
+func (h *remoteHandle) EmitEvent(eventType gosvcd.EventType, data interface{}) error {
+	return h.EmitEventContext(context.Background(), eventType, data)
+}
+
+func (h *remoteHandle) EmitEventContext(ctx context.Context, eventType gosvcd.EventType, data interface{}) error {
+	if h.ctx.Err() != nil {
+		return gosvcd.ErrDaemonStopped
+	}
+	frame, err := wire.EncodeNew("", h.svc.ID(), eventType, data)
+	if err != nil {
+		return err
+	}
+	h.sendMu.Lock()
+	defer h.sendMu.Unlock()
+	return h.stream.Send(frame)
+}
+
+func (h *remoteHandle) Go(f func(ctx context.Context)) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		f(h.ctx)
+	}()
+}
+
+// Unregister ends Serve.
+func (h *remoteHandle) Unregister() {
+	h.cancel()
+}
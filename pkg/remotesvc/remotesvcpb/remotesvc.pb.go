@@ -0,0 +1,130 @@
+// The gRPC service for connecting a remotesvc.Proxy to a remote service
+// served with remotesvc.Serve. Both directions carry frames holding a
+// JSON encoded wire.Envelope.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: remotesvc.proto
+
+package remotesvcpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Frame struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Envelope      []byte                 `protobuf:"bytes,1,opt,name=envelope,proto3" json:"envelope,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Frame) Reset() {
+	*x = Frame{}
+	mi := &file_remotesvc_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Frame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Frame) ProtoMessage() {}
+
+func (x *Frame) ProtoReflect() protoreflect.Message {
+	mi := &file_remotesvc_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Frame.ProtoReflect.Descriptor instead.
+func (*Frame) Descriptor() ([]byte, []int) {
+	return file_remotesvc_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Frame) GetEnvelope() []byte {
+	if x != nil {
+		return x.Envelope
+	}
+	return nil
+}
+
+var File_remotesvc_proto protoreflect.FileDescriptor
+
+const file_remotesvc_proto_rawDesc = "" +
+	"\n" +
+	"\x0fremotesvc.proto\x12\x10gosvcd.remotesvc\"#\n" +
+	"\x05Frame\x12\x1a\n" +
+	"\benvelope\x18\x01 \x01(\fR\benvelope2P\n" +
+	"\rRemoteService\x12?\n" +
+	"\aConnect\x12\x17.gosvcd.remotesvc.Frame\x1a\x17.gosvcd.remotesvc.Frame(\x010\x01B5Z3github.com/joamaki/gosvcd/pkg/remotesvc/remotesvcpbb\x06proto3"
+
+var (
+	file_remotesvc_proto_rawDescOnce sync.Once
+	file_remotesvc_proto_rawDescData []byte
+)
+
+func file_remotesvc_proto_rawDescGZIP() []byte {
+	file_remotesvc_proto_rawDescOnce.Do(func() {
+		file_remotesvc_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_remotesvc_proto_rawDesc), len(file_remotesvc_proto_rawDesc)))
+	})
+	return file_remotesvc_proto_rawDescData
+}
+
+var file_remotesvc_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_remotesvc_proto_goTypes = []any{
+	(*Frame)(nil), // 0: gosvcd.remotesvc.Frame
+}
+var file_remotesvc_proto_depIdxs = []int32{
+	0, // 0: gosvcd.remotesvc.RemoteService.Connect:input_type -> gosvcd.remotesvc.Frame
+	0, // 1: gosvcd.remotesvc.RemoteService.Connect:output_type -> gosvcd.remotesvc.Frame
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_remotesvc_proto_init() }
+func file_remotesvc_proto_init() {
+	if File_remotesvc_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_remotesvc_proto_rawDesc), len(file_remotesvc_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_remotesvc_proto_goTypes,
+		DependencyIndexes: file_remotesvc_proto_depIdxs,
+		MessageInfos:      file_remotesvc_proto_msgTypes,
+	}.Build()
+	File_remotesvc_proto = out.File
+	file_remotesvc_proto_goTypes = nil
+	file_remotesvc_proto_depIdxs = nil
+}
@@ -0,0 +1,123 @@
+// The gRPC service for connecting a remotesvc.Proxy to a remote service
+// served with remotesvc.Serve. Both directions carry frames holding a
+// JSON encoded wire.Envelope.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: remotesvc.proto
+
+package remotesvcpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	RemoteService_Connect_FullMethodName = "/gosvcd.remotesvc.RemoteService/Connect"
+)
+
+// RemoteServiceClient is the client API for RemoteService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RemoteServiceClient interface {
+	// Connect streams the events for the service's subscriptions from the
+	// proxy to the service and the events emitted by the service back.
+	Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Frame, Frame], error)
+}
+
+type remoteServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRemoteServiceClient(cc grpc.ClientConnInterface) RemoteServiceClient {
+	return &remoteServiceClient{cc}
+}
+
+func (c *remoteServiceClient) Connect(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Frame, Frame], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RemoteService_ServiceDesc.Streams[0], RemoteService_Connect_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Frame, Frame]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RemoteService_ConnectClient = grpc.BidiStreamingClient[Frame, Frame]
+
+// RemoteServiceServer is the server API for RemoteService service.
+// All implementations must embed UnimplementedRemoteServiceServer
+// for forward compatibility.
+type RemoteServiceServer interface {
+	// Connect streams the events for the service's subscriptions from the
+	// proxy to the service and the events emitted by the service back.
+	Connect(grpc.BidiStreamingServer[Frame, Frame]) error
+	mustEmbedUnimplementedRemoteServiceServer()
+}
+
+// UnimplementedRemoteServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRemoteServiceServer struct{}
+
+func (UnimplementedRemoteServiceServer) Connect(grpc.BidiStreamingServer[Frame, Frame]) error {
+	return status.Error(codes.Unimplemented, "method Connect not implemented")
+}
+func (UnimplementedRemoteServiceServer) mustEmbedUnimplementedRemoteServiceServer() {}
+func (UnimplementedRemoteServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeRemoteServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RemoteServiceServer will
+// result in compilation errors.
+type UnsafeRemoteServiceServer interface {
+	mustEmbedUnimplementedRemoteServiceServer()
+}
+
+func RegisterRemoteServiceServer(s grpc.ServiceRegistrar, srv RemoteServiceServer) {
+	// If the following call panics, it indicates UnimplementedRemoteServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RemoteService_ServiceDesc, srv)
+}
+
+func _RemoteService_Connect_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RemoteServiceServer).Connect(&grpc.GenericServerStream[Frame, Frame]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RemoteService_ConnectServer = grpc.BidiStreamingServer[Frame, Frame]
+
+// RemoteService_ServiceDesc is the grpc.ServiceDesc for RemoteService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RemoteService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gosvcd.remotesvc.RemoteService",
+	HandlerType: (*RemoteServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Connect",
+			Handler:       _RemoteService_Connect_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "remotesvc.proto",
+}
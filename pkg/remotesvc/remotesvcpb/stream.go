@@ -0,0 +1,104 @@
+// Package remotesvcpb is the gRPC service of package remotesvc, generated
+// from remotesvc.proto, with the adapters running remotesvc over it. It is
+// a module of its own, so that the daemon depends on gRPC only if it
+// connects its remote services with it:
+//
+//	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(
+//		credentials.NewTLS(tlsCfg)))
+//	b.Register(remotesvc.NewProxy(remotesvc.Config{
+//		ID:   30,
+//		Name: "billing",
+//		Dial: remotesvcpb.Dial(conn),
+//	}))
+//
+// and in the remote process:
+//
+//	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsCfg)))
+//	remotesvcpb.RegisterRemoteServiceServer(s, remotesvcpb.NewServer(svc))
+//	s.Serve(l)
+package remotesvcpb
+
+//go:generate protoc -I .. --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ../remotesvc.proto
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/remotesvc"
+)
+
+// Dial returns the Dial function of a remotesvc.Config opening the Connect
+// stream on the connection. The stream is closed when the context passed
+// to the Dial function is cancelled.
+func Dial(conn grpc.ClientConnInterface, opts ...grpc.CallOption) func(ctx context.Context) (remotesvc.Stream, error) {
+	client := NewRemoteServiceClient(conn)
+	return func(ctx context.Context) (remotesvc.Stream, error) {
+		stream, err := client.Connect(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return clientStream{stream}, nil
+	}
+}
+
+type clientStream struct {
+	stream RemoteService_ConnectClient
+}
+
+func (s clientStream) Send(frame []byte) error {
+	return s.stream.Send(&Frame{Envelope: frame})
+}
+
+func (s clientStream) Recv() ([]byte, error) {
+	f, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetEnvelope(), nil
+}
+
+// Server serves the remote service to the proxies, one Connect stream at a
+// time as the service is initialized and shut down for each stream, see
+// remotesvc.Serve. The other streams are refused with codes.Unavailable,
+// after which the proxies retry.
+type Server struct {
+	UnimplementedRemoteServiceServer
+
+	svc  gosvcd.Service
+	busy chan struct{}
+}
+
+// NewServer returns the server of the service.
+func NewServer(svc gosvcd.Service) *Server {
+	return &Server{svc: svc, busy: make(chan struct{}, 1)}
+}
+
+func (s *Server) Connect(stream RemoteService_ConnectServer) error {
+	select {
+	case s.busy <- struct{}{}:
+	default:
+		return status.Error(codes.Unavailable, "remote service already connected")
+	}
+	defer func() { <-s.busy }()
+	return remotesvc.Serve(stream.Context(), s.svc, serverStream{stream})
+}
+
+type serverStream struct {
+	stream RemoteService_ConnectServer
+}
+
+func (s serverStream) Send(frame []byte) error {
+	return s.stream.Send(&Frame{Envelope: frame})
+}
+
+func (s serverStream) Recv() ([]byte, error) {
+	f, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return f.GetEnvelope(), nil
+}
@@ -0,0 +1,261 @@
+// Package scriptsvc provides services whose event handlers are scripts
+// loaded from the configuration, so that operators can add small glue
+// logic, such as filtering, transforming and routing events, without
+// rebuilding the daemon.
+//
+// The scripts are run by an Engine embedding the interpreter of a
+// scripting language. Package
+// github.com/joamaki/gosvcd/pkg/scriptsvc/starlark implements it with
+// go.starlark.net. It is a separate module, keeping the interpreter out of
+// the dependencies of the daemons without scripts. A Starlark script
+// defines:
+//
+//	subscriptions = ["Reading"]        # unless set in Config
+//
+//	def handle(event):                 # event is a dict: type, source, time, data
+//	    if event["data"]["value"] > 100:
+//	        emit("Alert", {"value": event["data"]["value"]})
+//
+// and optionally init() and shutdown() functions, called when the service
+// is initialized and shut down. The script calls the builtin emit(type,
+// data) to emit events, and log(message) to log.
+package scriptsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// Names of the functions and the variables of a script.
+const (
+	HandleFunc          = "handle"
+	InitFunc            = "init"
+	ShutdownFunc        = "shutdown"
+	SubscriptionsGlobal = "subscriptions"
+)
+
+// DefaultTimeout is the default limit of a call into the script.
+const DefaultTimeout = time.Second
+
+// Builtin is a function provided to the scripts.
+type Builtin func(args ...interface{}) (interface{}, error)
+
+// Engine loads the scripts. The values passed between the engine and the
+// service are nil, bool, float64, string, []interface{} and
+// map[string]interface{}, as decoded by encoding/json.
+type Engine interface {
+	// Load compiles and runs the top level of the script with the
+	// builtin functions defined.
+	Load(name string, src []byte, builtins map[string]Builtin) (Script, error)
+}
+
+// Script is a loaded script.
+type Script interface {
+	// Global returns the value of the global variable, false if it is
+	// not defined.
+	Global(name string) (interface{}, bool)
+
+	// Has returns true if the function is defined.
+	Has(fn string) bool
+
+	// Call calls the function. The call must be interrupted when 'ctx'
+	// is done, e.g. with starlark.Thread.Cancel.
+	Call(ctx context.Context, fn string, args ...interface{}) (interface{}, error)
+}
+
+// Config describes the scripted service.
+type Config struct {
+	ID           gosvcd.ServiceId
+	Name         string
+	Dependencies []gosvcd.ServiceId
+
+	// Subscriptions default to the list of strings in the script's
+	// subscriptions variable.
+	Subscriptions []gosvcd.EventType
+
+	Engine Engine
+
+	// Path is the file of the script. Source, if set, is used instead of
+	// reading the file.
+	Path   string
+	Source []byte
+
+	// Timeout limits each call into the script. Defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// Logger receives the messages logged by the script. Optional.
+	Logger gosvcd.Logger
+}
+
+// Service runs the script.
+type Service struct {
+	cfg    Config
+	subs   []gosvcd.EventType
+	script Script
+
+	// mu serializes the calls into the script, as the interpreters are
+	// not safe for concurrent use.
+	mu     sync.Mutex
+	handle gosvcd.ServiceHandle
+}
+
+// New loads the script and returns the service running it.
+func New(cfg Config) (*Service, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	src := cfg.Source
+	if src == nil {
+		var err error
+		if src, err = ioutil.ReadFile(cfg.Path); err != nil {
+			return nil, err
+		}
+	}
+	name := cfg.Path
+	if name == "" {
+		name = cfg.Name
+	}
+	s := &Service{cfg: cfg, subs: cfg.Subscriptions}
+	script, err := cfg.Engine.Load(filepath.Base(name), src, map[string]Builtin{
+		"emit": s.emit,
+		"log":  s.log,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load script %s: %w", name, err)
+	}
+	if !script.Has(HandleFunc) {
+		return nil, fmt.Errorf("script %s does not define %s", name, HandleFunc)
+	}
+	if s.subs == nil {
+		v, _ := script.Global(SubscriptionsGlobal)
+		list, ok := v.([]interface{})
+		if !ok && v != nil {
+			return nil, fmt.Errorf("script %s: %s is not a list", name, SubscriptionsGlobal)
+		}
+		for _, typ := range list {
+			str, ok := typ.(string)
+			if !ok {
+				return nil, fmt.Errorf("script %s: %s contains %v", name, SubscriptionsGlobal, typ)
+			}
+			s.subs = append(s.subs, gosvcd.EventType(str))
+		}
+	}
+	s.script = script
+	return s, nil
+}
+
+func (s *Service) ID() gosvcd.ServiceId              { return s.cfg.ID }
+func (s *Service) Name() string                      { return s.cfg.Name }
+func (s *Service) Dependencies() []gosvcd.ServiceId  { return s.cfg.Dependencies }
+func (s *Service) Subscriptions() []gosvcd.EventType { return s.subs }
+
+// Init calls the script's init function, if defined. Panics if it fails,
+// failing the service.
+func (s *Service) Init(handle gosvcd.ServiceHandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle = handle
+	if err := s.call(context.Background(), InitFunc, false); err != nil {
+		panic(err)
+	}
+}
+
+// HandleEvent calls the script's handle function with the event. Panics
+// if it fails, which the daemon handles as a failure of the handler.
+func (s *Service) HandleEvent(ev gosvcd.Event) {
+	data, err := toScript(ev.Data())
+	if err != nil {
+		panic(fmt.Errorf("%s: convert %s data: %w", s.cfg.Name, ev.EventType(), err))
+	}
+	event := map[string]interface{}{
+		"type":   string(ev.EventType()),
+		"source": float64(ev.ServiceId()),
+		"time":   ev.Timestamp().Format(time.RFC3339Nano),
+		"data":   data,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(ev.Context(), HandleFunc, true, event); err != nil {
+		panic(err)
+	}
+}
+
+// Shutdown calls the script's shutdown function, if defined.
+func (s *Service) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(context.Background(), ShutdownFunc, false); err != nil {
+		panic(err)
+	}
+}
+
+// call calls the function of the script with the timeout. Must be called
+// with 'mu' held.
+func (s *Service) call(ctx context.Context, fn string, required bool, args ...interface{}) error {
+	if !required && !s.script.Has(fn) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	if _, err := s.script.Call(ctx, fn, args...); err != nil {
+		return fmt.Errorf("%s: %s: %w", s.cfg.Name, fn, err)
+	}
+	return nil
+}
+
+// emit is the emit(type, data) builtin.
+func (s *Service) emit(args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("emit: expected 2 arguments, got %d", len(args))
+	}
+	typ, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("emit: event type is %T, expected a string", args[0])
+	}
+	data, err := fromScript(gosvcd.EventType(typ), args[1])
+	if err != nil {
+		return nil, fmt.Errorf("emit %s: %w", typ, err)
+	}
+	return nil, s.handle.EmitEvent(gosvcd.EventType(typ), data)
+}
+
+// log is the log(message) builtin.
+func (s *Service) log(args ...interface{}) (interface{}, error) {
+	if s.cfg.Logger != nil {
+		s.cfg.Logger.Info(fmt.Sprint(args...), "service", s.cfg.Name)
+	}
+	return nil, nil
+}
+
+// toScript converts the payload to the values of the scripts through its
+// JSON encoding.
+func toScript(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(b, &out)
+	return out, err
+}
+
+// fromScript converts the value from a script to the payload type
+// registered for the event type in gosvcd.Payloads, if any.
+func fromScript(typ gosvcd.EventType, v interface{}) (interface{}, error) {
+	if !gosvcd.Payloads.Registered(typ) {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return gosvcd.Payloads.Decode(typ, gosvcd.JSONCodec.Name(), b)
+}
@@ -0,0 +1,249 @@
+// Package starlark implements scriptsvc.Engine with go.starlark.net, the
+// Go implementation of Starlark, a dialect of Python designed for
+// embedding. It is a module of its own, so that the daemon does not depend
+// on the interpreter unless it runs scripted services:
+//
+//	svc, err := scriptsvc.New(scriptsvc.Config{
+//		ID:     20,
+//		Name:   "alerts",
+//		Engine: starlark.New(nil),
+//		Path:   "/etc/gosvcd/alerts.star",
+//	})
+//
+// The JSON values passed to the scripts are converted to None, bool, int
+// (the integral numbers), float, string, list and dict, and back. The
+// print statement of the scripts logs as the log builtin.
+package starlark
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+
+	"github.com/joamaki/gosvcd/pkg/scriptsvc"
+)
+
+// Engine loads the scripts as Starlark modules.
+type Engine struct {
+	opts *syntax.FileOptions
+}
+
+// New returns the engine parsing the scripts with the options, e.g. to
+// allow while loops and recursion. With nil options, the scripts follow
+// the Starlark specification.
+func New(opts *syntax.FileOptions) *Engine {
+	if opts == nil {
+		opts = &syntax.FileOptions{}
+	}
+	return &Engine{opts: opts}
+}
+
+// Load implements scriptsvc.Engine. As in Starlark, the globals of the
+// script are frozen once its top level has run, so the handlers of the
+// script cannot keep state across events.
+func (e *Engine) Load(name string, src []byte, builtins map[string]scriptsvc.Builtin) (scriptsvc.Script, error) {
+	predeclared := make(starlark.StringDict, len(builtins))
+	for bname, fn := range builtins {
+		predeclared[bname] = newBuiltin(bname, fn)
+	}
+	s := &script{name: name, log: builtins["log"]}
+	globals, err := starlark.ExecFileOptions(e.opts, s.thread(), name, src, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	s.globals = globals
+	return s, nil
+}
+
+// newBuiltin returns the Starlark builtin calling 'fn' with the converted
+// arguments.
+func newBuiltin(name string, fn scriptsvc.Builtin) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+		}
+		goArgs := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := fromStarlark(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: argument %d: %w", b.Name(), i+1, err)
+			}
+			goArgs[i] = v
+		}
+		res, err := fn(goArgs...)
+		if err != nil {
+			return nil, err
+		}
+		return toStarlark(res)
+	})
+}
+
+// script is a loaded Starlark module.
+type script struct {
+	name    string
+	log     scriptsvc.Builtin
+	globals starlark.StringDict
+}
+
+// thread returns a new thread for a call, as a cancelled thread cannot be
+// reused.
+func (s *script) thread() *starlark.Thread {
+	return &starlark.Thread{
+		Name: s.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			if s.log != nil {
+				s.log(msg)
+			}
+		},
+	}
+}
+
+func (s *script) Global(name string) (interface{}, bool) {
+	v, ok := s.globals[name]
+	if !ok {
+		return nil, false
+	}
+	goV, err := fromStarlark(v)
+	if err != nil {
+		// Not a value, e.g. a function.
+		return v.String(), true
+	}
+	return goV, true
+}
+
+func (s *script) Has(fn string) bool {
+	_, ok := s.globals[fn].(starlark.Callable)
+	return ok
+}
+
+// Call implements scriptsvc.Script. The call is cancelled when 'ctx' is
+// done, which interrupts the script at its next step.
+func (s *script) Call(ctx context.Context, fn string, args ...interface{}) (interface{}, error) {
+	f, ok := s.globals[fn].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a function", fn)
+	}
+	tuple := make(starlark.Tuple, len(args))
+	for i, arg := range args {
+		v, err := toStarlark(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		tuple[i] = v
+	}
+	thread := s.thread()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+	res, err := starlark.Call(thread, f, tuple, nil)
+	if err != nil {
+		return nil, err
+	}
+	return fromStarlark(res)
+}
+
+// toStarlark converts a JSON value to a Starlark value.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(v))
+		for i, e := range v {
+			sv, err := toStarlark(e)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = sv
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		d := starlark.NewDict(len(v))
+		for k, e := range v {
+			sv, err := toStarlark(e)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.SetKey(starlark.String(k), sv); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("cannot convert %T", v)
+}
+
+// fromStarlark converts a Starlark value to a JSON value.
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return float64(i), nil
+		}
+		return float64(v.Float()), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case *starlark.List:
+		return fromIterable(v, v.Len())
+	case starlark.Tuple:
+		return fromIterable(v, v.Len())
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict key %s is not a string", item[0])
+			}
+			e, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = e
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s", v.Type())
+}
+
+func fromIterable(v starlark.Iterable, n int) ([]interface{}, error) {
+	list := make([]interface{}, 0, n)
+	it := v.Iterate()
+	defer it.Done()
+	var e starlark.Value
+	for it.Next(&e) {
+		goE, err := fromStarlark(e)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, goE)
+	}
+	return list, nil
+}
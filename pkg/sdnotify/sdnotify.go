@@ -0,0 +1,105 @@
+// Package sdnotify integrates a gosvcd daemon with systemd's service
+// notification protocol, making it usable as a Type=notify unit with
+// an optional WatchdogSec.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// Notification states
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends the state to systemd via the socket in $NOTIFY_SOCKET.
+// Returns false if the socket is not set, i.e. the process is not run
+// by systemd.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	addr := &net.UnixAddr{Name: path, Net: "unixgram"}
+	if path[0] == '@' {
+		// Abstract namespace
+		addr.Name = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout configured for the service
+// with WatchdogSec, or zero if the watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, error) {
+	usecs := os.Getenv("WATCHDOG_USEC")
+	if usecs == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usecs, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, err
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Run notifies systemd of the daemon's lifecycle: READY=1 once all services
+// have been initialized and STOPPING=1 when the shutdown starts. If the
+// watchdog is enabled, WATCHDOG=1 is sent at half the watchdog interval
+// as long as the daemon is live, so that systemd restarts a daemon with
+// stalled event loops. Returns when the daemon has shut down.
+func Run(d gosvcd.ServiceDaemon) error {
+	interval, err := WatchdogInterval()
+	if err != nil {
+		return err
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	ready := d.Ready()
+	stopping := d.Stopping()
+	for {
+		select {
+		case <-ready:
+			ready = nil
+			if _, err := Notify(Ready); err != nil {
+				return err
+			}
+		case <-stopping:
+			stopping = nil
+			tick = nil
+			if _, err := Notify(Stopping); err != nil {
+				return err
+			}
+		case <-tick:
+			if d.Liveness() == nil {
+				if _, err := Notify(Watchdog); err != nil {
+					return err
+				}
+			}
+		case <-d.Done():
+			return nil
+		}
+	}
+}
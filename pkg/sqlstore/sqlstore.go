@@ -0,0 +1,158 @@
+// Package sqlstore implements gosvcd.EventStore on top of SQLite through
+// database/sql. The package imports no SQLite driver; the application
+// registers the one it uses, e.g. github.com/mattn/go-sqlite3 or
+// modernc.org/sqlite, and opens the database:
+//
+//	db, err := sql.Open("sqlite3", "events.db?_journal_mode=WAL")
+//	store, err := sqlstore.New(db)
+//
+// The payloads are stored encoded with the codecs of gosvcd.Payloads and
+// decoded back into the registered payload types when queried.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS events (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	type     TEXT    NOT NULL,
+	source   INTEGER NOT NULL,
+	time     INTEGER NOT NULL,
+	encoding TEXT    NOT NULL,
+	data     BLOB
+);
+CREATE INDEX IF NOT EXISTS events_type ON events (type, time);
+CREATE INDEX IF NOT EXISTS events_source ON events (source, time);
+CREATE INDEX IF NOT EXISTS events_time ON events (time);
+`
+
+// Store is an event store in a SQLite database.
+type Store struct {
+	db *sql.DB
+}
+
+var _ gosvcd.EventStore = (*Store)(nil)
+
+// New creates the events table and its indexes in the database, unless
+// they exist, and returns the store.
+func New(db *sql.DB) (*Store, error) {
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Append stores the events in a single transaction.
+func (s *Store) Append(evs ...gosvcd.Event) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO events (type, source, time, encoding, data) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, ev := range evs {
+		encoding, data, err := gosvcd.Payloads.Encode(ev.EventType(), ev.Data())
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", ev.EventType(), err)
+		}
+		_, err = stmt.Exec(string(ev.EventType()), int64(ev.ServiceId()), ev.Timestamp().UnixNano(), encoding, data)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Query implements gosvcd.EventStore.
+func (s *Store) Query(ctx context.Context, q gosvcd.EventQuery, fn func(gosvcd.StoredEvent) error) error {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if len(q.Types) > 0 {
+		where = append(where, "type IN ("+placeholders(len(q.Types))+")")
+		for _, typ := range q.Types {
+			args = append(args, string(typ))
+		}
+	}
+	if len(q.Sources) > 0 {
+		where = append(where, "source IN ("+placeholders(len(q.Sources))+")")
+		for _, src := range q.Sources {
+			args = append(args, int64(src))
+		}
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "time < ?")
+		args = append(args, q.Until.UnixNano())
+	}
+	if q.AfterID > 0 {
+		where = append(where, "id > ?")
+		args = append(args, int64(q.AfterID))
+	}
+	query := "SELECT id, type, source, time, encoding, data FROM events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id       int64
+			typ      string
+			source   int64
+			nanos    int64
+			encoding string
+			data     []byte
+		)
+		if err := rows.Scan(&id, &typ, &source, &nanos, &encoding, &data); err != nil {
+			return err
+		}
+		v, err := gosvcd.Payloads.Decode(gosvcd.EventType(typ), encoding, data)
+		if err != nil {
+			return fmt.Errorf("decode event %d: %w", id, err)
+		}
+		ev := gosvcd.NewEvent(gosvcd.ServiceId(source), gosvcd.EventType(typ), time.Unix(0, nanos), v)
+		if err := fn(gosvcd.StoredEvent{ID: uint64(id), Event: ev}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Prune removes the events with a timestamp before the given time.
+func (s *Store) Prune(before time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM events WHERE time < ?`, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
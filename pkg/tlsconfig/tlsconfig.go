@@ -0,0 +1,124 @@
+// Package tlsconfig builds the TLS configurations of the connections
+// between hosts: the cluster nodes, the remote services, and the control
+// protocol over TCP. The configurations authenticate both ends: each side
+// presents a certificate, which the other side verifies against the CA
+// certificates.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// CipherPolicy selects the protocol versions and cipher suites.
+type CipherPolicy int
+
+const (
+	// Intermediate allows TLS 1.2 with the ECDHE and AEAD cipher suites,
+	// and TLS 1.3.
+	Intermediate CipherPolicy = iota
+
+	// Modern allows only TLS 1.3.
+	Modern
+
+	// Compatible allows TLS 1.2 with the default cipher suites of
+	// crypto/tls, and TLS 1.3.
+	Compatible
+)
+
+func (p CipherPolicy) String() string {
+	switch p {
+	case Intermediate:
+		return "intermediate"
+	case Modern:
+		return "modern"
+	case Compatible:
+		return "compatible"
+	}
+	return "unknown"
+}
+
+// ParseCipherPolicy parses the name of a policy as returned by String.
+func ParseCipherPolicy(s string) (CipherPolicy, error) {
+	for _, p := range []CipherPolicy{Intermediate, Modern, Compatible} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher policy %q", s)
+}
+
+// intermediateSuites are the TLS 1.2 cipher suites of the Intermediate
+// policy. The TLS 1.3 suites are not configurable.
+var intermediateSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// Options of the configuration.
+type Options struct {
+	// CertFile and KeyFile are the PEM files of the certificate and
+	// the private key presented to the other side.
+	CertFile string
+	KeyFile  string
+
+	// CAFile is the PEM file of the CA certificates with which the
+	// certificate of the other side is verified.
+	CAFile string
+
+	// ServerName is the name verified in the certificate of the server
+	// when connecting. Defaults to the host of the dialed address.
+	ServerName string
+
+	Policy CipherPolicy
+}
+
+// New returns the configuration for both accepting and making connections:
+// the certificate is presented to the other side, and the certificate of
+// the other side, client or server, is required and verified.
+func New(opts Options) (*tls.Config, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("certificate and key required")
+	}
+	if opts.CAFile == "" {
+		return nil, errors.New("CA certificates required")
+	}
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %w", err)
+	}
+	pem, err := ioutil.ReadFile(opts.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("load CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA certificates in %s", opts.CAFile)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ServerName:   opts.ServerName,
+	}
+	switch opts.Policy {
+	case Intermediate:
+		cfg.MinVersion = tls.VersionTLS12
+		cfg.CipherSuites = intermediateSuites
+	case Modern:
+		cfg.MinVersion = tls.VersionTLS13
+	case Compatible:
+		cfg.MinVersion = tls.VersionTLS12
+	default:
+		return nil, fmt.Errorf("unknown cipher policy %d", opts.Policy)
+	}
+	return cfg, nil
+}
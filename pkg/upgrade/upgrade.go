@@ -0,0 +1,190 @@
+// Package upgrade implements the live upgrade of a gosvcd daemon to a new
+// binary. The running process starts the new binary, hands over its
+// listening sockets and the checkpoint of its daemon over a Unix socket
+// pair, and shuts down its daemon once the new process has signalled that
+// it is ready. The new process resumes from the checkpoint and accepts
+// the connections on the inherited sockets, so that none are refused
+// during the upgrade.
+//
+// A process supporting upgrades creates its Upgrader before building the
+// daemon, restores the handed over checkpoint, obtains its listeners with
+// Listen and signals Ready once the daemon is ready:
+//
+//	u, err := upgrade.New()
+//	...
+//	b := gosvcd.NewBuilder()
+//	if cp := u.Checkpoint(); cp != nil {
+//		b.SetRestore(cp)
+//	}
+//	...
+//	d := b.Start()
+//	<-d.Ready()
+//	l, err := u.Listen("control", "unix", gosvcd.DefaultControlSocket)
+//	...
+//	ctl := gosvcd.ServeControl(d, l)
+//	u.Ready()
+//
+// The upgrade is then started with Upgrade, e.g. on SIGUSR2, after which
+// the old process exits.
+package upgrade
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// ErrNotSupported is returned by Upgrade on platforms without Unix sockets.
+var ErrNotSupported = errors.New("live upgrades are not supported on this platform")
+
+// DefaultReadyTimeout is the default time to wait for the new process to
+// signal that it is ready.
+const DefaultReadyTimeout = 30 * time.Second
+
+// envUpgradeFd is the environment variable holding the file descriptor of
+// the new process' end of the socket pair.
+const envUpgradeFd = "GOSVCD_UPGRADE_FD"
+
+// Options configure an upgrade.
+type Options struct {
+	// Path is the path of the new binary. Defaults to the executable of
+	// the running process.
+	Path string
+
+	// Args are the command-line arguments of the new process, without
+	// the program name. Defaults to the arguments of the running
+	// process.
+	Args []string
+
+	// ReadyTimeout is the time to wait for the new process to signal
+	// that it is ready. Defaults to DefaultReadyTimeout.
+	ReadyTimeout time.Duration
+}
+
+// Upgrader hands over the listeners and the state of the process to the
+// new process on an upgrade, and takes them over in the new process.
+type Upgrader struct {
+	mu         sync.Mutex
+	listeners  map[string]net.Listener
+	inherited  map[string]net.Listener
+	checkpoint *gosvcd.Checkpoint
+	parent     net.Conn // nil if not started by an upgrade
+	upgrading  bool
+}
+
+// New returns the upgrader of the process. If the process was started by
+// an upgrade, the listeners and the checkpoint handed over by the old
+// process are taken over.
+func New() (*Upgrader, error) {
+	u := &Upgrader{
+		listeners: make(map[string]net.Listener),
+		inherited: make(map[string]net.Listener),
+	}
+	if err := u.inherit(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Upgraded returns true if the process was started by an upgrade.
+func (u *Upgrader) Upgraded() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.parent != nil || u.checkpoint != nil
+}
+
+// Checkpoint returns the checkpoint of the daemon handed over by the old
+// process, or nil if the process was not started by an upgrade.
+func (u *Upgrader) Checkpoint() *gosvcd.Checkpoint {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.checkpoint
+}
+
+// Listen returns the listener with the name handed over by the old
+// process, or listens on the address as by net.Listen. The listener is
+// handed over on an upgrade until it is closed. A stale Unix socket file
+// is removed.
+func (u *Upgrader) Listen(name, network, addr string) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if l, ok := u.inherited[name]; ok {
+		delete(u.inherited, name)
+		u.listeners[name] = l
+		return l, nil
+	}
+	if network == "unix" {
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	u.listeners[name] = l
+	return l, nil
+}
+
+// Ready signals the old process that the new process is ready, upon which
+// the old process shuts down its daemon. The inherited listeners not taken
+// with Listen are closed. Does nothing if the process was not started by
+// an upgrade.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for name, l := range u.inherited {
+		l.Close()
+		delete(u.inherited, name)
+	}
+	if u.parent == nil {
+		return nil
+	}
+	_, err := u.parent.Write([]byte{readyByte})
+	u.parent.Close()
+	u.parent = nil
+	return err
+}
+
+// Upgrade starts the new binary and hands over the listeners and the
+// checkpoint of the daemon to it. The dispatching of events is paused
+// while the new process starts. Once it signals that it is ready, the
+// listeners are closed and the daemon is shut down. If the new process
+// fails to start or does not become ready in time, it is killed and the
+// daemon resumes.
+func (u *Upgrader) Upgrade(d gosvcd.ServiceDaemon, opts Options) error {
+	u.mu.Lock()
+	if u.upgrading {
+		u.mu.Unlock()
+		return errors.New("upgrade in progress")
+	}
+	u.upgrading = true
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.upgrading = false
+		u.mu.Unlock()
+	}()
+
+	if opts.Path == "" {
+		path, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		opts.Path = path
+	}
+	if opts.Args == nil {
+		opts.Args = os.Args[1:]
+	}
+	if opts.ReadyTimeout <= 0 {
+		opts.ReadyTimeout = DefaultReadyTimeout
+	}
+	return u.upgrade(d, opts)
+}
+
+// readyByte is sent by the new process when it is ready.
+const readyByte = 1
@@ -0,0 +1,299 @@
+//go:build !windows
+// +build !windows
+
+package upgrade
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// The old process hands over its state to the new one in two frames, each
+// the big-endian 32-bit length of the data followed by the data: the
+// handover header with the file descriptors of the listeners attached,
+// and the checkpoint of the daemon. The new process responds with
+// readyByte once it is ready.
+
+// maxListeners limits the number of listeners handed over.
+const maxListeners = 64
+
+// maxFrame limits the size of a handover frame.
+const maxFrame = 1 << 30
+
+type handover struct {
+	// Listeners are the names of the listeners in the order of the
+	// attached file descriptors.
+	Listeners []string `json:"listeners"`
+}
+
+func (u *Upgrader) inherit() error {
+	s := os.Getenv(envUpgradeFd)
+	if s == "" {
+		return nil
+	}
+	os.Unsetenv(envUpgradeFd)
+	fd, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envUpgradeFd, err)
+	}
+	f := os.NewFile(uintptr(fd), "upgrade")
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		conn.Close()
+		return errors.New("upgrade connection is not a Unix socket")
+	}
+
+	var hdr [4]byte
+	oob := make([]byte, syscall.CmsgSpace(maxListeners*4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(hdr[:], oob)
+	if err == nil {
+		_, err = io.ReadFull(uc, hdr[n:])
+	}
+	if err != nil {
+		uc.Close()
+		return fmt.Errorf("read handover: %w", err)
+	}
+	fds, err := parseRights(oob[:oobn])
+	if err != nil {
+		uc.Close()
+		return err
+	}
+	var h handover
+	data, err := readFrameData(uc, binary.BigEndian.Uint32(hdr[:]))
+	if err == nil {
+		err = json.Unmarshal(data, &h)
+	}
+	if err == nil && len(h.Listeners) != len(fds) {
+		err = fmt.Errorf("%d listeners with %d file descriptors", len(h.Listeners), len(fds))
+	}
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "listener")
+		if err != nil {
+			f.Close()
+			continue
+		}
+		var l net.Listener
+		l, err = net.FileListener(f)
+		f.Close()
+		if err == nil {
+			u.inherited[h.Listeners[i]] = l
+		}
+	}
+	if err != nil {
+		u.closeInherited()
+		uc.Close()
+		return fmt.Errorf("read handover: %w", err)
+	}
+
+	data, err = readFrame(uc)
+	if err == nil {
+		u.checkpoint, err = gosvcd.ReadCheckpoint(bytes.NewReader(data))
+	}
+	if err != nil {
+		u.closeInherited()
+		uc.Close()
+		return fmt.Errorf("read checkpoint: %w", err)
+	}
+	u.parent = uc
+	return nil
+}
+
+func (u *Upgrader) closeInherited() {
+	for name, l := range u.inherited {
+		l.Close()
+		delete(u.inherited, name)
+	}
+}
+
+func parseRights(oob []byte) ([]int, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for i := range msgs {
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	return readFrameData(r, binary.BigEndian.Uint32(hdr[:]))
+}
+
+func readFrameData(r io.Reader, n uint32) ([]byte, error) {
+	if n > maxFrame {
+		return nil, errors.New("handover frame too large")
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func frame(data []byte) []byte {
+	b := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// filer is implemented by the listeners of package net.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// listenerFiles returns the names and the duplicated file descriptors of
+// the open listeners.
+func (u *Upgrader) listenerFiles() ([]string, []*os.File) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var names []string
+	for name := range u.listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var (
+		handed []string
+		files  []*os.File
+	)
+	for _, name := range names {
+		fl, ok := u.listeners[name].(filer)
+		if !ok {
+			continue
+		}
+		f, err := fl.File()
+		if err != nil {
+			// Closed
+			delete(u.listeners, name)
+			continue
+		}
+		handed = append(handed, name)
+		files = append(files, f)
+		if len(files) == maxListeners {
+			break
+		}
+	}
+	return handed, files
+}
+
+func (u *Upgrader) upgrade(d gosvcd.ServiceDaemon, opts Options) error {
+	names, files := u.listenerFiles()
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return err
+	}
+	childEnd := os.NewFile(uintptr(fds[1]), "upgrade")
+	parentEnd := os.NewFile(uintptr(fds[0]), "upgrade")
+	conn, err := net.FileConn(parentEnd)
+	parentEnd.Close()
+	if err != nil {
+		childEnd.Close()
+		return err
+	}
+	defer conn.Close()
+
+	cmd := exec.Command(opts.Path, opts.Args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// ExtraFiles start from file descriptor 3.
+	cmd.Env = append(os.Environ(), envUpgradeFd+"=3")
+	cmd.ExtraFiles = []*os.File{childEnd}
+	err = cmd.Start()
+	childEnd.Close()
+	if err != nil {
+		return err
+	}
+
+	// Pause the dispatching so that the events in the checkpoint are not
+	// handled by the old process meanwhile.
+	d.Pause()
+	if err := u.handover(d, conn.(*net.UnixConn), names, files, opts.ReadyTimeout); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		d.Resume()
+		return err
+	}
+	go cmd.Wait()
+
+	// The new process accepts on its copies of the listeners. Close ours
+	// without removing the socket files.
+	u.mu.Lock()
+	for _, name := range names {
+		l := u.listeners[name]
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		l.Close()
+		delete(u.listeners, name)
+	}
+	u.mu.Unlock()
+	d.Shutdown()
+	return nil
+}
+
+// handover sends the listeners and the checkpoint to the new process and
+// waits for it to signal that it is ready.
+func (u *Upgrader) handover(d gosvcd.ServiceDaemon, conn *net.UnixConn, names []string, files []*os.File, timeout time.Duration) error {
+	var cp bytes.Buffer
+	if err := d.Checkpoint(&cp); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	hdr, err := json.Marshal(&handover{Listeners: names})
+	if err != nil {
+		return err
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, _, err := conn.WriteMsgUnix(frame(hdr), syscall.UnixRights(fds...), nil); err != nil {
+		return fmt.Errorf("send handover: %w", err)
+	}
+	if _, err := conn.Write(frame(cp.Bytes())); err != nil {
+		return fmt.Errorf("send checkpoint: %w", err)
+	}
+	var ready [1]byte
+	if _, err := io.ReadFull(conn, ready[:]); err != nil {
+		return fmt.Errorf("new process not ready: %w", err)
+	}
+	if ready[0] != readyByte {
+		return fmt.Errorf("unexpected response %d from new process", ready[0])
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package upgrade
+
+import "github.com/joamaki/gosvcd/pkg/gosvcd"
+
+func (u *Upgrader) inherit() error {
+	return nil
+}
+
+func (u *Upgrader) upgrade(d gosvcd.ServiceDaemon, opts Options) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,223 @@
+// Package wasmsvc hosts services compiled to WebAssembly, so that untrusted
+// or third-party logic can take part in the event bus isolated from the
+// daemon: the module can only access its own linear memory and the
+// functions imported from the host, its memory is bounded by the runtime
+// and each call into it is bounded by a timeout.
+//
+// The modules are instantiated by a Runtime. Package
+// github.com/joamaki/gosvcd/pkg/wasmsvc/wazero implements it with
+// github.com/tetratelabs/wazero, as a module of its own so that the daemon
+// depends on wazero only if it hosts WebAssembly services.
+//
+// The ABI between the host and the module, with the events encoded as by
+// package wire:
+//
+//	export gosvcd_alloc(size i32) -> ptr i32  allocates a buffer for the host
+//	export gosvcd_init()                      optional, called by Init
+//	export gosvcd_handle(ptr i32, len i32)    handles the event in the buffer
+//	export gosvcd_shutdown()                  optional, called by Shutdown
+//	import gosvcd.emit(ptr i32, len i32)      emits the event in the buffer
+//
+// The buffers allocated with gosvcd_alloc are owned by the module after
+// the call they are passed to.
+package wasmsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// Names of the functions of the ABI.
+const (
+	HostModule   = "gosvcd"
+	EmitFunc     = "emit"
+	AllocFunc    = "gosvcd_alloc"
+	InitFunc     = "gosvcd_init"
+	HandleFunc   = "gosvcd_handle"
+	ShutdownFunc = "gosvcd_shutdown"
+)
+
+// DefaultTimeout is the default limit of a call into the module.
+const DefaultTimeout = time.Second
+
+// ErrNoFunction is returned by Module.Call for a function the module does
+// not export.
+var ErrNoFunction = errors.New("function not exported")
+
+// Module is an instantiated module.
+type Module interface {
+	// Call calls the exported function. Returns an error matching
+	// ErrNoFunction if it is not exported, and an error if the call
+	// traps or 'ctx' is done before it returns.
+	Call(ctx context.Context, name string, params ...uint64) ([]uint64, error)
+
+	// Read returns a copy of the bytes of the linear memory. Returns
+	// false if out of range.
+	Read(offset, size uint32) ([]byte, bool)
+
+	// Write writes the bytes to the linear memory. Returns false if out
+	// of range.
+	Write(offset uint32, data []byte) bool
+
+	Close(ctx context.Context) error
+}
+
+// Runtime compiles and instantiates the modules.
+type Runtime interface {
+	// Instantiate instantiates the module with the host module
+	// HostModule exporting EmitFunc, which calls 'emit' with the module
+	// and the parameters.
+	Instantiate(ctx context.Context, wasm []byte, emit func(ctx context.Context, m Module, ptr, size uint32)) (Module, error)
+}
+
+// Config describes the service hosted by the module.
+type Config struct {
+	ID            gosvcd.ServiceId
+	Name          string
+	Dependencies  []gosvcd.ServiceId
+	Subscriptions []gosvcd.EventType
+
+	Runtime Runtime
+	Wasm    []byte
+
+	// Timeout limits each call into the module. A call that does not
+	// return in time is interrupted and fails the handler. Defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// OnError is called with the errors of decoding the events emitted
+	// by the module, which are discarded. Optional.
+	OnError func(error)
+}
+
+// Service is the service hosted by a WebAssembly module. The module is
+// instantiated when the service is initialized and closed when it is shut
+// down, so a restart starts from a fresh instance.
+type Service struct {
+	cfg Config
+
+	// mu serializes the calls into the module, as a module instance
+	// is single-threaded.
+	mu     sync.Mutex
+	mod    Module
+	handle gosvcd.ServiceHandle
+}
+
+// New returns the service hosted by the module.
+func New(cfg Config) *Service {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	return &Service{cfg: cfg}
+}
+
+func (s *Service) ID() gosvcd.ServiceId              { return s.cfg.ID }
+func (s *Service) Name() string                      { return s.cfg.Name }
+func (s *Service) Dependencies() []gosvcd.ServiceId  { return s.cfg.Dependencies }
+func (s *Service) Subscriptions() []gosvcd.EventType { return s.cfg.Subscriptions }
+
+// Init instantiates the module and calls its gosvcd_init. Panics if either
+// fails, failing the service.
+func (s *Service) Init(handle gosvcd.ServiceHandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle = handle
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	mod, err := s.cfg.Runtime.Instantiate(ctx, s.cfg.Wasm, s.emit)
+	if err != nil {
+		panic(fmt.Errorf("%s: instantiate module: %w", s.cfg.Name, err))
+	}
+	s.mod = mod
+	if err := s.callOptional(InitFunc); err != nil {
+		mod.Close(context.Background())
+		s.mod = nil
+		panic(err)
+	}
+}
+
+// HandleEvent passes the event to the module's gosvcd_handle. Panics if the
+// call fails, which the daemon handles as a failure of the handler.
+func (s *Service) HandleEvent(ev gosvcd.Event) {
+	frame, err := wire.Encode("", ev)
+	if err != nil {
+		panic(fmt.Errorf("%s: encode %s: %w", s.cfg.Name, ev.EventType(), err))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ev.Context(), s.cfg.Timeout)
+	defer cancel()
+	res, err := s.mod.Call(ctx, AllocFunc, uint64(len(frame)))
+	if err != nil {
+		panic(fmt.Errorf("%s: %s: %w", s.cfg.Name, AllocFunc, err))
+	}
+	if len(res) != 1 {
+		panic(fmt.Errorf("%s: %s returned %d values", s.cfg.Name, AllocFunc, len(res)))
+	}
+	ptr := uint32(res[0])
+	if !s.mod.Write(ptr, frame) {
+		panic(fmt.Errorf("%s: %s returned buffer out of range", s.cfg.Name, AllocFunc))
+	}
+	if _, err := s.mod.Call(ctx, HandleFunc, uint64(ptr), uint64(len(frame))); err != nil {
+		panic(fmt.Errorf("%s: %s %s: %w", s.cfg.Name, HandleFunc, ev.EventType(), err))
+	}
+}
+
+// Shutdown calls the module's gosvcd_shutdown and closes the module.
+func (s *Service) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mod == nil {
+		return
+	}
+	err := s.callOptional(ShutdownFunc)
+	s.mod.Close(context.Background())
+	s.mod = nil
+	if err != nil {
+		panic(err)
+	}
+}
+
+// callOptional calls the exported function without parameters, if it is
+// exported. Must be called with 'mu' held.
+func (s *Service) callOptional(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	if _, err := s.mod.Call(ctx, name); err != nil && !errors.Is(err, ErrNoFunction) {
+		return fmt.Errorf("%s: %s: %w", s.cfg.Name, name, err)
+	}
+	return nil
+}
+
+// emit is the host function emitting the event encoded in the module's
+// memory. It is called by the module during a call holding 'mu'.
+func (s *Service) emit(ctx context.Context, m Module, ptr, size uint32) {
+	frame, ok := m.Read(ptr, size)
+	if !ok {
+		s.error(fmt.Errorf("%s: emitted buffer out of range", s.cfg.Name))
+		return
+	}
+	env, err := wire.Decode(frame)
+	if err != nil {
+		s.error(fmt.Errorf("%s: decode emitted event: %w", s.cfg.Name, err))
+		return
+	}
+	v, err := env.Value()
+	if err != nil {
+		s.error(fmt.Errorf("%s: decode %s data: %w", s.cfg.Name, env.Type, err))
+		return
+	}
+	s.handle.EmitEventContext(env.Context(context.Background()), env.Type, v)
+}
+
+func (s *Service) error(err error) {
+	if s.cfg.OnError != nil {
+		s.cfg.OnError(err)
+	}
+}
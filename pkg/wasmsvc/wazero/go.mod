@@ -0,0 +1,12 @@
+module github.com/joamaki/gosvcd/pkg/wasmsvc/wazero
+
+go 1.25.0
+
+require (
+	github.com/joamaki/gosvcd v0.0.0
+	github.com/tetratelabs/wazero v1.12.0
+)
+
+require golang.org/x/sys v0.44.0 // indirect
+
+replace github.com/joamaki/gosvcd => ../../..
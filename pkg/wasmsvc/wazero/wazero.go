@@ -0,0 +1,151 @@
+// Package wazero implements the wasmsvc.Runtime with
+// github.com/tetratelabs/wazero, a WebAssembly runtime written in Go
+// without cgo. It is a module of its own, so that the daemon does not
+// depend on wazero unless it hosts WebAssembly services:
+//
+//	rt := wazero.New(wazero.Config{MemoryLimitPages: 256, WASI: true})
+//	defer rt.Close(context.Background())
+//	b.Register(wasmsvc.New(wasmsvc.Config{
+//		ID:            10,
+//		Name:          "filter",
+//		Subscriptions: []gosvcd.EventType{"Reading"},
+//		Runtime:       rt,
+//		Wasm:          wasm,
+//	}))
+package wazero
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/joamaki/gosvcd/pkg/wasmsvc"
+)
+
+// DefaultMemoryLimitPages is the default limit of the linear memory of a
+// module, in pages of 64KiB.
+const DefaultMemoryLimitPages = 256
+
+// Config configures the runtime.
+type Config struct {
+	// MemoryLimitPages limits the linear memory of each module, in pages
+	// of 64KiB. Defaults to DefaultMemoryLimitPages.
+	MemoryLimitPages uint32
+
+	// WASI instantiates wasi_snapshot_preview1 for the modules, as
+	// needed by the modules built with e.g. TinyGo for the wasi target.
+	// The modules get no access to the file system, the environment or
+	// the arguments.
+	WASI bool
+}
+
+// Runtime instantiates each module in a wazero runtime of its own, so that
+// the host module bound to the service is not shared. The compiled modules
+// are cached across the instances, e.g. when a service is restarted.
+type Runtime struct {
+	cfg   Config
+	cache wazero.CompilationCache
+}
+
+// New returns the runtime.
+func New(cfg Config) *Runtime {
+	if cfg.MemoryLimitPages == 0 {
+		cfg.MemoryLimitPages = DefaultMemoryLimitPages
+	}
+	return &Runtime{cfg: cfg, cache: wazero.NewCompilationCache()}
+}
+
+// Instantiate implements wasmsvc.Runtime. The calls into the module are
+// interrupted when their context is done, which closes the module: after
+// a call times out, the service fails until it is restarted with a new
+// instance.
+func (rt *Runtime) Instantiate(ctx context.Context, wasm []byte, emit func(ctx context.Context, m wasmsvc.Module, ptr, size uint32)) (wasmsvc.Module, error) {
+	config := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(rt.cfg.MemoryLimitPages).
+		WithCloseOnContextDone(true).
+		WithCompilationCache(rt.cache)
+	r := wazero.NewRuntimeWithConfig(ctx, config)
+	m, err := rt.instantiate(ctx, r, wasm, emit)
+	if err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (rt *Runtime) instantiate(ctx context.Context, r wazero.Runtime, wasm []byte, emit func(ctx context.Context, m wasmsvc.Module, ptr, size uint32)) (*module, error) {
+	if rt.cfg.WASI {
+		if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+			return nil, fmt.Errorf("instantiate WASI: %w", err)
+		}
+	}
+	_, err := r.NewHostModuleBuilder(wasmsvc.HostModule).
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, caller api.Module, ptr, size uint32) {
+			emit(ctx, &module{mod: caller}, ptr, size)
+		}).
+		Export(wasmsvc.EmitFunc).
+		Instantiate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("instantiate host module: %w", err)
+	}
+	compiled, err := r.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, fmt.Errorf("compile: %w", err)
+	}
+	mod, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &module{mod: mod, r: r}, nil
+}
+
+// Close releases the compiled modules cached by the runtime. The modules
+// must have been closed.
+func (rt *Runtime) Close(ctx context.Context) error {
+	return rt.cache.Close(ctx)
+}
+
+// module is an instantiated module, or the module calling a host
+// function, in which case 'r' is nil.
+type module struct {
+	mod api.Module
+	r   wazero.Runtime
+}
+
+func (m *module) Call(ctx context.Context, name string, params ...uint64) ([]uint64, error) {
+	fn := m.mod.ExportedFunction(name)
+	if fn == nil {
+		return nil, fmt.Errorf("%s: %w", name, wasmsvc.ErrNoFunction)
+	}
+	return fn.Call(ctx, params...)
+}
+
+func (m *module) Read(offset, size uint32) ([]byte, bool) {
+	mem := m.mod.Memory()
+	if mem == nil {
+		return nil, false
+	}
+	buf, ok := mem.Read(offset, size)
+	if !ok {
+		return nil, false
+	}
+	// The slice is a view of the memory, which the module may modify
+	// or grow.
+	return append([]byte(nil), buf...), true
+}
+
+func (m *module) Write(offset uint32, data []byte) bool {
+	mem := m.mod.Memory()
+	return mem != nil && mem.Write(offset, data)
+}
+
+func (m *module) Close(ctx context.Context) error {
+	if m.r == nil {
+		return nil
+	}
+	return m.r.Close(ctx)
+}
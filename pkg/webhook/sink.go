@@ -0,0 +1,220 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/wire"
+)
+
+// Defaults for SinkConfig.
+const (
+	DefaultMaxAttempts = 5
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = 30 * time.Second
+	DefaultQueueSize   = 256
+)
+
+// Target is a webhook URL and the event types delivered to it.
+type Target struct {
+	URL   string
+	Types []gosvcd.EventType
+}
+
+// SinkConfig configures the webhook sink.
+type SinkConfig struct {
+	Targets []Target
+
+	// Client is the HTTP client used for the deliveries. Defaults to a
+	// client with a 10 second timeout.
+	Client *http.Client
+
+	// Secret, if set, is used to sign the requests.
+	Secret []byte
+
+	// MaxAttempts is the number of attempts to deliver an event before
+	// giving up. The attempts are spaced with exponential backoff from
+	// MinBackoff to MaxBackoff.
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+
+	// QueueSize is the number of events queued per target. Events
+	// are dropped when the queue is full.
+	QueueSize int
+
+	// Origin is set in the delivered envelopes. Defaults to hostname:pid.
+	Origin string
+
+	// OnError is called with the failed and dropped deliveries. Optional.
+	OnError func(error)
+}
+
+// Sink is a service that posts the events to the webhook targets as JSON
+// encoded wire.Envelopes. The events are delivered to each target in order
+// in the background, retrying on network errors and on 429 and 5xx
+// responses. The undelivered events are dropped when the service is shut
+// down.
+type Sink struct {
+	id      gosvcd.ServiceId
+	cfg     SinkConfig
+	types   []gosvcd.EventType
+	targets []*sinkTarget
+}
+
+type sinkTarget struct {
+	url   string
+	types map[gosvcd.EventType]bool
+	queue chan delivery
+}
+
+type delivery struct {
+	typ  gosvcd.EventType
+	body []byte
+}
+
+// NewSink returns the webhook sink service with the given identifier.
+func NewSink(id gosvcd.ServiceId, cfg SinkConfig) *Sink {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.Origin == "" {
+		cfg.Origin = wire.DefaultOrigin()
+	}
+	s := &Sink{id: id, cfg: cfg}
+	seen := map[gosvcd.EventType]bool{}
+	for _, t := range cfg.Targets {
+		st := &sinkTarget{
+			url:   t.URL,
+			types: map[gosvcd.EventType]bool{},
+			queue: make(chan delivery, cfg.QueueSize),
+		}
+		for _, typ := range t.Types {
+			st.types[typ] = true
+			if !seen[typ] {
+				seen[typ] = true
+				s.types = append(s.types, typ)
+			}
+		}
+		s.targets = append(s.targets, st)
+	}
+	return s
+}
+
+func (s *Sink) ID() gosvcd.ServiceId              { return s.id }
+func (s *Sink) Name() string                      { return "webhook-sink" }
+func (s *Sink) Dependencies() []gosvcd.ServiceId  { return nil }
+func (s *Sink) Subscriptions() []gosvcd.EventType { return s.types }
+func (s *Sink) Shutdown()                         {}
+
+// Init starts the delivery to each target.
+func (s *Sink) Init(handle gosvcd.ServiceHandle) {
+	for _, t := range s.targets {
+		t := t
+		handle.Go(func(ctx context.Context) { s.deliverLoop(ctx, t) })
+	}
+}
+
+// HandleEvent queues the event for delivery to the targets.
+func (s *Sink) HandleEvent(ev gosvcd.Event) {
+	body, err := wire.Encode(s.cfg.Origin, ev)
+	if err != nil {
+		s.error(fmt.Errorf("encode %s: %w", ev.EventType(), err))
+		return
+	}
+	for _, t := range s.targets {
+		if !t.types[ev.EventType()] {
+			continue
+		}
+		select {
+		case t.queue <- delivery{ev.EventType(), body}:
+		default:
+			s.error(fmt.Errorf("%s: queue full, dropped %s", t.url, ev.EventType()))
+		}
+	}
+}
+
+func (s *Sink) deliverLoop(ctx context.Context, t *sinkTarget) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-t.queue:
+			s.deliver(ctx, t, d)
+		}
+	}
+}
+
+func (s *Sink) deliver(ctx context.Context, t *sinkTarget, d delivery) {
+	backoff := s.cfg.MinBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, t.url, d)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= s.cfg.MaxAttempts {
+			s.error(fmt.Errorf("%s: %s delivery failed after %d attempts: %w", t.url, d.typ, attempt, err))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.cfg.MaxBackoff {
+			backoff = s.cfg.MaxBackoff
+		}
+	}
+}
+
+// post posts the body and returns whether a failed delivery should be
+// retried.
+func (s *Sink) post(ctx context.Context, url string, d delivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, string(d.typ))
+	if s.cfg.Secret != nil {
+		req.Header.Set(SignatureHeader, Sign(s.cfg.Secret, d.body))
+	}
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %s", resp.Status)
+	}
+	return false, fmt.Errorf("status %s", resp.Status)
+}
+
+func (s *Sink) error(err error) {
+	if s.cfg.OnError != nil {
+		s.cfg.OnError(err)
+	}
+}
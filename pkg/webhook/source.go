@@ -0,0 +1,183 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// DefaultMaxBodySize is the default limit of the request body size.
+const DefaultMaxBodySize = 1 << 20
+
+// Route maps the POSTs to a path to events.
+type Route struct {
+	Path      string
+	EventType gosvcd.EventType
+
+	// New returns a pointer to a new value into which the JSON body is
+	// decoded, making the event data typed. If nil, the body is decoded
+	// into a generic value.
+	New func() interface{}
+}
+
+// SourceConfig configures the webhook source.
+type SourceConfig struct {
+	Routes []Route
+
+	// Addr is the address on which the source listens when it is
+	// running. If empty, the source is only served when mounted on a
+	// server as an http.Handler.
+	Addr string
+
+	// Secret, if set, is required to have signed the requests.
+	Secret []byte
+
+	// MaxBodySize limits the size of the request body. Defaults to
+	// DefaultMaxBodySize.
+	MaxBodySize int64
+
+	// Listen listens on Addr, e.g. with a listener handed over on a
+	// live upgrade. Defaults to net.Listen.
+	Listen func(network, addr string) (net.Listener, error)
+}
+
+// Source is a service that emits the HTTP POSTs to the configured routes
+// as events. It responds with 202 once the event has been emitted.
+type Source struct {
+	id     gosvcd.ServiceId
+	cfg    SourceConfig
+	routes map[string]Route
+
+	mu     sync.RWMutex
+	handle gosvcd.ServiceHandle
+	server *http.Server
+}
+
+// NewSource returns the webhook source service with the given identifier.
+func NewSource(id gosvcd.ServiceId, cfg SourceConfig) *Source {
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = DefaultMaxBodySize
+	}
+	if cfg.Listen == nil {
+		cfg.Listen = net.Listen
+	}
+	s := &Source{id: id, cfg: cfg, routes: map[string]Route{}}
+	for _, r := range cfg.Routes {
+		s.routes[r.Path] = r
+	}
+	return s
+}
+
+func (s *Source) ID() gosvcd.ServiceId              { return s.id }
+func (s *Source) Name() string                      { return "webhook-source" }
+func (s *Source) Dependencies() []gosvcd.ServiceId  { return nil }
+func (s *Source) Subscriptions() []gosvcd.EventType { return nil }
+func (s *Source) HandleEvent(ev gosvcd.Event)       {}
+
+// Init starts accepting requests and listening on the configured address.
+// Fails the service if listening fails.
+func (s *Source) Init(handle gosvcd.ServiceHandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle = handle
+	if s.cfg.Addr == "" {
+		return
+	}
+	l, err := s.cfg.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		panic(err)
+	}
+	s.server = &http.Server{Handler: s}
+	server := s.server
+	handle.Go(func(context.Context) { server.Serve(l) })
+}
+
+// Shutdown stops accepting requests.
+func (s *Source) Shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle = nil
+	if s.server != nil {
+		s.server.Close()
+		s.server = nil
+	}
+}
+
+func (s *Source) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, ok := s.routes[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, s.cfg.MaxBodySize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > s.cfg.MaxBodySize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if s.cfg.Secret != nil && !Verify(s.cfg.Secret, body, r.Header.Get(SignatureHeader)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var data interface{}
+	if len(body) > 0 {
+		if route.New != nil {
+			data = route.New()
+			err = json.Unmarshal(body, data)
+		} else {
+			err = json.Unmarshal(body, &data)
+		}
+		if err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.mu.RLock()
+	handle := s.handle
+	s.mu.RUnlock()
+	if handle == nil {
+		http.Error(w, "not running", http.StatusServiceUnavailable)
+		return
+	}
+	// The request context is cancelled once the request is answered,
+	// before the event is handled.
+	ctx := detachedContext{r.Context()}
+	if err := handle.EmitEventContext(ctx, route.EventType, data); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gosvcd.ErrDaemonStopped) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// detachedContext carries the values of the request context, e.g. the
+// trace span of the request, without its deadline and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,40 @@
+// Package webhook provides services for receiving and delivering HTTP
+// callbacks: Source converts HTTP POSTs into events and Sink posts events
+// to webhook URLs.
+//
+// When a secret is configured, the request body is signed with HMAC-SHA256
+// and the signature is carried hex encoded in the SignatureHeader as
+// "sha256=<signature>".
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// SignatureHeader is the header carrying the signature of the body.
+const SignatureHeader = "X-Signature-256"
+
+// EventTypeHeader is the header carrying the event type of a delivered
+// event.
+const EventTypeHeader = "X-Gosvcd-Event"
+
+// Sign returns the value of the SignatureHeader for the body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the value of the SignatureHeader against the body.
+func Verify(secret, body []byte, signature string) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
@@ -0,0 +1,32 @@
+// Package winsvc runs a gosvcd daemon as a Windows service.
+//
+// The service control manager's start, stop, pause and continue controls are
+// mapped to starting the daemon, ServiceDaemon.Shutdown, and Pause and Resume
+// if the daemon implements them. The service stops when the daemon shuts
+// down by itself.
+package winsvc
+
+import (
+	"errors"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// ErrNotSupported is returned by Run on platforms other than Windows.
+var ErrNotSupported = errors.New("windows services are not supported on this platform")
+
+// StartFunc builds and starts the daemon when the service is started.
+type StartFunc func(args []string) gosvcd.ServiceDaemon
+
+// pauser is implemented by daemons that can pause the dispatching of events.
+type pauser interface {
+	Pause()
+	Resume()
+}
+
+// Run runs the daemon as the named Windows service and returns when the
+// service has stopped. It must be called from a process started by the
+// service control manager.
+func Run(name string, start StartFunc) error {
+	return run(name, start)
+}
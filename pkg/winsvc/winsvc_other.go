@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package winsvc
+
+func run(name string, start StartFunc) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,199 @@
+//go:build windows
+// +build windows
+
+package winsvc
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	serviceWin32OwnProcess = 0x10
+
+	// Service states
+	serviceStopped         = 1
+	serviceStartPending    = 2
+	serviceStopPending     = 3
+	serviceRunning         = 4
+	serviceContinuePending = 5
+	servicePausePending    = 6
+	servicePaused          = 7
+
+	// Controls
+	serviceControlStop        = 1
+	serviceControlPause       = 2
+	serviceControlContinue    = 3
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	// Accepted controls
+	serviceAcceptStop          = 1
+	serviceAcceptPauseContinue = 2
+	serviceAcceptShutdown      = 4
+
+	errorCallNotImplemented = 120
+)
+
+// SERVICE_STATUS
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// SERVICE_TABLE_ENTRYW
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+type service struct {
+	name    *uint16
+	start   StartFunc
+	handle  uintptr
+	accepts uint32
+	state   uint32
+	ctls    chan uint32
+	err     error
+}
+
+func run(name string, start StartFunc) error {
+	namep, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	s := &service{
+		name:  namep,
+		start: start,
+		ctls:  make(chan uint32, 16),
+	}
+	table := []serviceTableEntry{
+		{namep, syscall.NewCallback(s.serviceMain)},
+		{nil, 0},
+	}
+	// Blocks until the service has stopped.
+	r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 {
+		return err
+	}
+	return s.err
+}
+
+func (s *service) setStatus(state uint32) {
+	s.state = state
+	status := serviceStatus{
+		serviceType:      serviceWin32OwnProcess,
+		currentState:     state,
+		controlsAccepted: s.accepts,
+	}
+	if state == serviceStartPending || state == serviceStopPending {
+		status.controlsAccepted = 0
+	}
+	procSetServiceStatus.Call(s.handle, uintptr(unsafe.Pointer(&status)))
+}
+
+// ctlHandler is the HandlerEx callback, invoked on the thread of the control
+// dispatcher. The controls are processed in serviceMain.
+func (s *service) ctlHandler(ctl uint32, evtype uint32, evdata uintptr, context uintptr) uintptr {
+	switch ctl {
+	case serviceControlStop, serviceControlShutdown, serviceControlPause,
+		serviceControlContinue, serviceControlInterrogate:
+		select {
+		case s.ctls <- ctl:
+		default:
+		}
+		return 0
+	}
+	return errorCallNotImplemented
+}
+
+func (s *service) serviceMain(argc uint32, argv **uint16) uintptr {
+	h, _, err := procRegisterServiceCtrlHandlerExW.Call(
+		uintptr(unsafe.Pointer(s.name)), syscall.NewCallback(s.ctlHandler), 0)
+	if h == 0 {
+		s.err = err
+		return 0
+	}
+	s.handle = h
+
+	s.setStatus(serviceStartPending)
+	d := s.start(serviceArgs(argc, argv))
+	p, canPause := d.(pauser)
+	s.accepts = serviceAcceptStop | serviceAcceptShutdown
+	if canPause {
+		s.accepts |= serviceAcceptPauseContinue
+	}
+	<-d.Ready()
+	s.setStatus(serviceRunning)
+
+loop:
+	for {
+		select {
+		case <-d.Done():
+			break loop
+		case ctl := <-s.ctls:
+			switch ctl {
+			case serviceControlStop, serviceControlShutdown:
+				s.setStatus(serviceStopPending)
+				d.Shutdown()
+				break loop
+			case serviceControlPause:
+				if canPause && s.state == serviceRunning {
+					s.setStatus(servicePausePending)
+					p.Pause()
+					s.setStatus(servicePaused)
+				}
+			case serviceControlContinue:
+				if canPause && s.state == servicePaused {
+					s.setStatus(serviceContinuePending)
+					p.Resume()
+					s.setStatus(serviceRunning)
+				}
+			case serviceControlInterrogate:
+				s.setStatus(s.state)
+			}
+		}
+	}
+	s.setStatus(serviceStopped)
+	return 0
+}
+
+func serviceArgs(argc uint32, argv **uint16) []string {
+	if argc == 0 || argv == nil {
+		return nil
+	}
+	ptrs := (*[1 << 20]*uint16)(unsafe.Pointer(argv))[:argc:argc]
+	args := make([]string, argc)
+	for i, p := range ptrs {
+		args[i] = utf16PtrToString(p)
+	}
+	return args
+}
+
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	var s []uint16
+	for ptr := unsafe.Pointer(p); ; ptr = unsafe.Pointer(uintptr(ptr) + 2) {
+		c := *(*uint16)(ptr)
+		if c == 0 {
+			break
+		}
+		s = append(s, c)
+	}
+	return syscall.UTF16ToString(s)
+}
@@ -0,0 +1,47 @@
+package wire
+
+import (
+	"sync"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+)
+
+// local is the vector clock of the process: the clocks of the envelopes
+// encoded and decoded by it merged.
+var local struct {
+	mu sync.Mutex
+	vc gosvcd.VectorClock
+}
+
+// stamp returns the clock of an event sent from the origin: the clock of
+// the event merged into the clock of the process, advanced for the origin.
+// Returns the clock of the event if the origin is empty.
+func stamp(origin string, vc gosvcd.VectorClock) gosvcd.VectorClock {
+	if origin == "" {
+		return vc
+	}
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	next := local.vc.Merge(vc)
+	next[origin]++
+	local.vc = next
+	return next
+}
+
+// observe merges the clock of a received event into the clock of the
+// process, so that the events sent after it follow it.
+func observe(vc gosvcd.VectorClock) {
+	if len(vc) == 0 {
+		return
+	}
+	local.mu.Lock()
+	local.vc = local.vc.Merge(vc)
+	local.mu.Unlock()
+}
+
+// LocalClock returns the vector clock of the process.
+func LocalClock() gosvcd.VectorClock {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	return local.vc.Merge(nil)
+}
@@ -0,0 +1,341 @@
+// Package wire defines the encoding of events exchanged between gosvcd
+// processes by the bridges and stored by the persistence layers.
+//
+// The payload is encoded with the codec registered for the event type in
+// gosvcd.Payloads, JSON by default. The envelope is encoded in the Format
+// selected with SetFormat: in JSON the JSON payloads are embedded as is
+// and others base64 encoded, and in the compact MessagePack format the
+// payload is embedded as binary. A deployment handling high event volumes
+// can switch both to MessagePack with:
+//
+//	gosvcd.Payloads.SetDefault(msgpack.Codec)
+//	wire.SetFormat(wire.Msgpack)
+//
+// Envelopes in either format are decoded.
+//
+// The envelopes sent with an origin carry the vector clock of the event,
+// see gosvcd.VectorClock, advanced for the origin, and the clocks of the
+// decoded envelopes are merged into the clock of the process, so that the
+// consumers on any node can tell the causal order of the events. The
+// origins are the nodes of the clocks: a deployment should configure its
+// bridges with a stable origin per node, as the default one changes with
+// each process.
+package wire
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joamaki/gosvcd/pkg/gosvcd"
+	"github.com/joamaki/gosvcd/pkg/msgpack"
+)
+
+// Envelope is an event on the wire.
+type Envelope struct {
+	Type   gosvcd.EventType
+	Source gosvcd.ServiceId
+	Time   time.Time
+
+	// Origin identifies the process that emitted the event. Bridges
+	// use it to drop their own events when the broker echoes them back.
+	Origin string
+
+	// Seq is the sequence number of the event in the emitting daemon,
+	// if routed. See gosvcd.Event.Sequence.
+	Seq uint64
+
+	// Clock is the vector clock of the event, if any.
+	Clock gosvcd.VectorClock
+
+	// Key is the idempotency key of the event, if any.
+	Key string
+
+	// ReplyTo is the reply address of the event, if any, and
+	// Correlation the correlation of a reply. See gosvcd.ReplyTo.
+	ReplyTo     *gosvcd.ReplyAddress
+	Correlation string
+
+	// Encoding is the name of the codec of the payload in Data.
+	Encoding string
+	Data     []byte
+}
+
+type jsonEnvelope struct {
+	Type        gosvcd.EventType   `json:"type"`
+	Source      gosvcd.ServiceId   `json:"source"`
+	Time        time.Time          `json:"time"`
+	Origin      string             `json:"origin,omitempty"`
+	Seq         uint64             `json:"seq,omitempty"`
+	Clock       gosvcd.VectorClock `json:"clock,omitempty"`
+	Key         string             `json:"key,omitempty"`
+	ReplyTo     *jsonReplyTo       `json:"reply_to,omitempty"`
+	Correlation string             `json:"correlation,omitempty"`
+	Encoding    string             `json:"encoding,omitempty"`
+	Data        json.RawMessage    `json:"data,omitempty"`
+}
+
+type jsonReplyTo struct {
+	Service     gosvcd.ServiceId `json:"service"`
+	Type        gosvcd.EventType `json:"type"`
+	Correlation string           `json:"correlation,omitempty"`
+}
+
+func toJSONReplyTo(addr *gosvcd.ReplyAddress) *jsonReplyTo {
+	if addr == nil {
+		return nil
+	}
+	return &jsonReplyTo{Service: addr.Service, Type: addr.EventType, Correlation: addr.Correlation}
+}
+
+func (r *jsonReplyTo) address() *gosvcd.ReplyAddress {
+	if r == nil {
+		return nil
+	}
+	return &gosvcd.ReplyAddress{Service: r.Service, EventType: r.Type, Correlation: r.Correlation}
+}
+
+func (env *Envelope) MarshalJSON() ([]byte, error) {
+	je := jsonEnvelope{
+		Type:        env.Type,
+		Source:      env.Source,
+		Time:        env.Time,
+		Origin:      env.Origin,
+		Seq:         env.Seq,
+		Clock:       env.Clock,
+		Key:         env.Key,
+		ReplyTo:     toJSONReplyTo(env.ReplyTo),
+		Correlation: env.Correlation,
+		Data:        env.Data,
+	}
+	if env.Encoding != gosvcd.JSONCodec.Name() {
+		je.Encoding = env.Encoding
+		b, err := json.Marshal(env.Data)
+		if err != nil {
+			return nil, err
+		}
+		je.Data = b
+	}
+	return json.Marshal(&je)
+}
+
+func (env *Envelope) UnmarshalJSON(b []byte) error {
+	var je jsonEnvelope
+	if err := json.Unmarshal(b, &je); err != nil {
+		return err
+	}
+	*env = Envelope{
+		Type:        je.Type,
+		Source:      je.Source,
+		Time:        je.Time,
+		Origin:      je.Origin,
+		Seq:         je.Seq,
+		Clock:       je.Clock,
+		Key:         je.Key,
+		ReplyTo:     je.ReplyTo.address(),
+		Correlation: je.Correlation,
+		Encoding:    je.Encoding,
+		Data:        je.Data,
+	}
+	if env.Encoding == "" {
+		env.Encoding = gosvcd.JSONCodec.Name()
+	} else if len(je.Data) > 0 {
+		return json.Unmarshal(je.Data, &env.Data)
+	}
+	return nil
+}
+
+// Format is the encoding of the envelopes.
+type Format int
+
+const (
+	JSON Format = iota
+	Msgpack
+)
+
+var format = JSON
+
+// SetFormat sets the format of the encoded envelopes. Must be called
+// before the bridges are started.
+func SetFormat(f Format) {
+	format = f
+}
+
+// msgpackEnvelope is the MessagePack form of the envelope.
+type msgpackEnvelope struct {
+	Type        string             `json:"type"`
+	Source      int64              `json:"source"`
+	Time        time.Time          `json:"time"`
+	Origin      string             `json:"origin,omitempty"`
+	Seq         uint64             `json:"seq,omitempty"`
+	Clock       gosvcd.VectorClock `json:"clock,omitempty"`
+	Key         string             `json:"key,omitempty"`
+	ReplyTo     *jsonReplyTo       `json:"reply_to,omitempty"`
+	Correlation string             `json:"correlation,omitempty"`
+	Encoding    string             `json:"encoding"`
+	Data        []byte             `json:"data"`
+}
+
+// DefaultOrigin returns the hostname and the process id joined with ':'.
+func DefaultOrigin() string {
+	host, _ := os.Hostname()
+	return host + ":" + strconv.Itoa(os.Getpid())
+}
+
+// Encode encodes the event into an envelope from the given origin. The
+// envelope carries the clock of the event advanced for the origin, unless
+// the origin is empty.
+func Encode(origin string, ev gosvcd.Event) ([]byte, error) {
+	var replyTo *gosvcd.ReplyAddress
+	if addr, ok := gosvcd.ReplyTo(ev); ok {
+		replyTo = &addr
+	}
+	return encode(origin, ev.ServiceId(), ev.EventType(), ev.Timestamp(), ev.Sequence(), stamp(origin, gosvcd.EventClock(ev)), gosvcd.IdempotencyKey(ev), replyTo, gosvcd.Correlation(ev), ev.Data())
+}
+
+// EncodeNew encodes a new event into an envelope from the given origin.
+func EncodeNew(origin string, source gosvcd.ServiceId, typ gosvcd.EventType, data interface{}) ([]byte, error) {
+	return encode(origin, source, typ, time.Now(), 0, stamp(origin, nil), "", nil, "", data)
+}
+
+func encode(origin string, source gosvcd.ServiceId, typ gosvcd.EventType, t time.Time, seq uint64, clock gosvcd.VectorClock, key string, replyTo *gosvcd.ReplyAddress, correlation string, v interface{}) ([]byte, error) {
+	encoding, data, err := gosvcd.Payloads.Encode(typ, v)
+	if err != nil {
+		return nil, err
+	}
+	if format == Msgpack {
+		return msgpack.Marshal(&msgpackEnvelope{
+			Type:        string(typ),
+			Source:      int64(source),
+			Time:        t,
+			Origin:      origin,
+			Seq:         seq,
+			Clock:       clock,
+			Key:         key,
+			ReplyTo:     toJSONReplyTo(replyTo),
+			Correlation: correlation,
+			Encoding:    encoding,
+			Data:        data,
+		})
+	}
+	return json.Marshal(&Envelope{
+		Type:        typ,
+		Source:      source,
+		Time:        t,
+		Origin:      origin,
+		Seq:         seq,
+		Clock:       clock,
+		Key:         key,
+		ReplyTo:     replyTo,
+		Correlation: correlation,
+		Encoding:    encoding,
+		Data:        data,
+	})
+}
+
+// Decode decodes an envelope in either format. The clock of the envelope
+// is merged into the clock of the process.
+func Decode(b []byte) (*Envelope, error) {
+	env, err := decode(b)
+	if err != nil {
+		return nil, err
+	}
+	observe(env.Clock)
+	return env, nil
+}
+
+func decode(b []byte) (*Envelope, error) {
+	if len(b) > 0 && b[0] != '{' {
+		var me msgpackEnvelope
+		if err := msgpack.Unmarshal(b, &me); err != nil {
+			return nil, err
+		}
+		return &Envelope{
+			Type:        gosvcd.EventType(me.Type),
+			Source:      gosvcd.ServiceId(me.Source),
+			Time:        me.Time,
+			Origin:      me.Origin,
+			Seq:         me.Seq,
+			Clock:       me.Clock,
+			Key:         me.Key,
+			ReplyTo:     me.ReplyTo.address(),
+			Correlation: me.Correlation,
+			Encoding:    me.Encoding,
+			Data:        me.Data,
+		}, nil
+	}
+	var env Envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// Context returns the context for emitting the event of the envelope,
+// carrying its idempotency key, reply address, correlation and clock.
+func (env *Envelope) Context(ctx context.Context) context.Context {
+	if env.Key != "" {
+		ctx = gosvcd.WithIdempotencyKey(ctx, env.Key)
+	}
+	if env.ReplyTo != nil {
+		ctx = gosvcd.WithReplyTo(ctx, *env.ReplyTo)
+	}
+	if env.Correlation != "" {
+		ctx = gosvcd.WithCorrelation(ctx, env.Correlation)
+	}
+	if len(env.Clock) > 0 {
+		ctx = gosvcd.WithVectorClock(ctx, env.Clock)
+	}
+	return ctx
+}
+
+// Event returns the envelope as an event with the data decoded with Value.
+func (env *Envelope) Event(ctx context.Context) (gosvcd.Event, error) {
+	v, err := env.Value()
+	if err != nil {
+		return nil, err
+	}
+	return &event{env: env, data: v, ctx: ctx}, nil
+}
+
+type event struct {
+	env  *Envelope
+	data interface{}
+	ctx  context.Context
+}
+
+func (ev *event) ServiceId() gosvcd.ServiceId { return ev.env.Source }
+func (ev *event) EventType() gosvcd.EventType { return ev.env.Type }
+func (ev *event) Timestamp() time.Time        { return ev.env.Time }
+func (ev *event) Data() interface{}           { return ev.data }
+func (ev *event) Context() context.Context    { return ev.ctx }
+func (ev *event) Sequence() uint64            { return ev.env.Seq }
+func (ev *event) IdempotencyKey() string      { return ev.env.Key }
+func (ev *event) Correlation() string         { return ev.env.Correlation }
+
+func (ev *event) ReplyTo() (gosvcd.ReplyAddress, bool) {
+	if ev.env.ReplyTo == nil {
+		return gosvcd.ReplyAddress{}, false
+	}
+	return *ev.env.ReplyTo, true
+}
+
+// Value returns the event data decoded with gosvcd.Payloads: into the
+// registered payload type of the event type, or into a generic value, e.g.
+// a map[string]interface{} for a JSON object.
+func (env *Envelope) Value() (interface{}, error) {
+	return gosvcd.Payloads.Decode(env.Type, env.Encoding, env.Data)
+}
+
+// ValueAs returns the event data converted to the given version of the
+// event type with gosvcd.Converters, e.g. for reading events persisted by
+// an older producer.
+func (env *Envelope) ValueAs(typ gosvcd.EventType) (interface{}, error) {
+	v, err := env.Value()
+	if err != nil {
+		return nil, err
+	}
+	return gosvcd.Converters.Convert(env.Type, typ, v)
+}